@@ -22,4 +22,11 @@ kubectl create secret generic jwt-key \
     --from-literal=private-key="$PRIVATE_KEY_CONTENT" \
     --dry-run=client -o yaml | kubectl apply -f -
 
+# The passphrase of an encrypted private key is kept in a separate secret,
+# which stays empty for an unencrypted key
+echo "Creating Kubernetes secret for the private key passphrase"
+kubectl create secret generic jwt-key-passphrase \
+    --from-literal=passphrase="$JWT_SIGNATURE_KEY_PASSPHRASE" \
+    --dry-run=client -o yaml | kubectl apply -f -
+
 echo "Secret created successfully"
@@ -1,11 +1,14 @@
 // Package rsa provides the core functionality for the key management tool.
-// It implements secure generation, storage, and retrieval of RSA key pairs
-// specifically designed for JWT signing in the OAuth2 server.
+// It implements secure generation, storage, and retrieval of the key pairs
+// the OAuth2 server signs JWTs with: RSA (RS256), ECDSA P-256 (ES256) and
+// P-384 (ES384), and Ed25519 (EdDSA) keys. The package name predates the
+// support for elliptic curve keys.
 //
 // Key Features:
 //   - Secure RSA key pair generation with configurable key sizes (2048+ bits)
+//   - ECDSA and Ed25519 key pair generation for smaller tokens
 //   - PEM-encoded key storage with proper file permissions (0600 for private, 0644 for public)
-//   - Unique key identification using public key modulus
+//   - Unique key identification using the RFC 7638 JWK thumbprint of the public key
 //   - Atomic key pair operations (save/delete)
 //   - Built-in key validation using crypto/rsa.Validate()
 //
@@ -14,7 +17,7 @@
 //   - Public keys are stored with read-only permissions (0644)
 //   - Minimum key size of 2048 bits is enforced
 //   - Key operations are atomic to prevent partial writes
-//   - Keys are validated before saving
+//   - RSA keys are validated before saving
 //
 // Usage:
 //
@@ -24,7 +27,7 @@
 //	}
 //
 //	// Generate a new key pair
-//	keyPair, err := manager.generateKeyPair(2048)
+//	keyPair, err := manager.GenerateKeyPair(AlgorithmRS256, 2048)
 //	if err != nil {
 //	    // Handle error
 //	}
@@ -39,37 +42,56 @@
 package rsa
 
 import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
+	"crypto/sha256"
 	"crypto/x509"
+	"encoding/base64"
 	"encoding/pem"
 	"errors"
 	"fmt"
 	"log/slog"
+	"math/big"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 )
 
 var (
-	// ErrKeyGeneration is returned when RSA key pair generation fails.
-	ErrKeyGeneration = errors.New("failed to generate RSA key pair")
-	// ErrKeySave is returned when saving an RSA key pair to disk fails.
-	ErrKeySave = errors.New("failed to save RSA key pair")
-	// ErrKeyLoad is returned when loading an RSA key pair from disk fails.
-	ErrKeyLoad = errors.New("failed to load RSA key pair")
+	// ErrKeyGeneration is returned when key pair generation fails.
+	ErrKeyGeneration = errors.New("failed to generate key pair")
+	// ErrKeySave is returned when saving a key pair to disk fails.
+	ErrKeySave = errors.New("failed to save key pair")
+	// ErrKeyLoad is returned when loading a key pair from disk fails.
+	ErrKeyLoad = errors.New("failed to load key pair")
 	// ErrInvalidPath is returned when an invalid path is provided for key operations.
 	ErrInvalidPath = errors.New("invalid key path")
 	// ErrKeyNotFound is returned when a requested key pair cannot be found.
 	ErrKeyNotFound = errors.New("key pair not found")
 	// ErrInvalidKeySize is returned when an invalid key size is requested.
 	ErrInvalidKeySize = errors.New("invalid key size: must be at least 2048 bits")
+	// ErrUnsupportedAlgorithm is returned for algorithms and key types the server cannot sign with.
+	ErrUnsupportedAlgorithm = errors.New("unsupported algorithm")
 )
 
-// KeyPair represents an RSA key pair.
+// Signing algorithms of the generated key pairs (RFC 7518, RFC 8037).
+const (
+	AlgorithmRS256 = "RS256"
+	AlgorithmES256 = "ES256"
+	AlgorithmES384 = "ES384"
+	AlgorithmEdDSA = "EdDSA"
+)
+
+// KeyPair represents a key pair. PrivateKey is an *rsa.PrivateKey,
+// *ecdsa.PrivateKey or ed25519.PrivateKey, and PublicKey its public half.
 type KeyPair struct {
-	PrivateKey *rsa.PrivateKey
-	PublicKey  *rsa.PublicKey
+	PrivateKey crypto.Signer
+	PublicKey  crypto.PublicKey
 	KeyID      string // Unique identifier for the key pair
 }
 
@@ -99,29 +121,45 @@ func NewManager(keysDir string) (*Manager, error) {
 	}, nil
 }
 
-// GenerateKeyPair creates a new RSA key pair.
-func (m *Manager) GenerateKeyPair(bits int) (*KeyPair, error) {
-	if bits < 2048 {
-		return nil, ErrInvalidKeySize
+// GenerateKeyPair creates a new key pair for the given signing algorithm.
+// The bits parameter sets the size of RSA keys and is ignored for the other algorithms.
+func (m *Manager) GenerateKeyPair(algorithm string, bits int) (*KeyPair, error) {
+	var privateKey crypto.Signer
+	var err error
+	switch algorithm {
+	case AlgorithmRS256:
+		if bits < 2048 {
+			return nil, ErrInvalidKeySize
+		}
+		privateKey, err = rsa.GenerateKey(rand.Reader, bits)
+	case AlgorithmES256:
+		privateKey, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case AlgorithmES384:
+		privateKey, err = ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	case AlgorithmEdDSA:
+		_, privateKey, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		return nil, fmt.Errorf("%w %q", ErrUnsupportedAlgorithm, algorithm)
 	}
-
-	privateKey, err := rsa.GenerateKey(rand.Reader, bits)
 	if err != nil {
-		slog.Error("failed to generate RSA key pair", "error", err)
+		slog.Error("failed to generate key pair", "algorithm", algorithm, "error", err)
 		return nil, ErrKeyGeneration
 	}
 
-	// Generate a unique key ID (using the first 8 bytes of the public key modulus)
-	keyID := fmt.Sprintf("%x", privateKey.N.Bytes()[:8])
+	// Generate a unique key ID (the JWK thumbprint of the public key)
+	keyID := Thumbprint(privateKey.Public())
 
 	return &KeyPair{
 		PrivateKey: privateKey,
-		PublicKey:  &privateKey.PublicKey,
+		PublicKey:  privateKey.Public(),
 		KeyID:      keyID,
 	}, nil
 }
 
-// SaveKeyPair saves the RSA key pair to files.
+// SaveKeyPair saves the key pair to files. RSA keys are stored PKCS#1 encoded
+// as before; ECDSA and Ed25519 keys, which have no PKCS#1 form, are stored as
+// PKCS#8 private keys and PKIX public keys. The current time is saved as the
+// time the key was added.
 func (m *Manager) SaveKeyPair(kp *KeyPair) error {
 	if kp == nil {
 		return errors.New("key pair is nil")
@@ -132,29 +170,28 @@ func (m *Manager) SaveKeyPair(kp *KeyPair) error {
 	}
 
 	// Validate the private key
-	if err := kp.PrivateKey.Validate(); err != nil {
-		return fmt.Errorf("invalid private key: %w", err)
+	if rsaKey, ok := kp.PrivateKey.(*rsa.PrivateKey); ok {
+		if err := rsaKey.Validate(); err != nil {
+			return fmt.Errorf("invalid private key: %w", err)
+		}
 	}
 
-	// Generate key ID from public key modulus
-	keyID := fmt.Sprintf("%x", kp.PublicKey.N.Bytes()[:8])
+	// Generate key ID from the public key
+	keyID := Thumbprint(kp.PublicKey)
+	if keyID == "" {
+		return fmt.Errorf("%w: key type %T", ErrUnsupportedAlgorithm, kp.PublicKey)
+	}
 
 	// Create key directory if it doesn't exist
 	if err := os.MkdirAll(m.keysDir, 0700); err != nil {
 		return fmt.Errorf("failed to create keys directory: %w", err)
 	}
 
-	// Encode private key
-	privateKeyPEM := pem.EncodeToMemory(&pem.Block{
-		Type:  "RSA PRIVATE KEY",
-		Bytes: x509.MarshalPKCS1PrivateKey(kp.PrivateKey),
-	})
-
-	// Encode public key
-	publicKeyPEM := pem.EncodeToMemory(&pem.Block{
-		Type:  "RSA PUBLIC KEY",
-		Bytes: x509.MarshalPKCS1PublicKey(kp.PublicKey),
-	})
+	// Encode the keys
+	privateKeyPEM, publicKeyPEM, err := encodeKeyPair(kp)
+	if err != nil {
+		return err
+	}
 
 	// Save private key
 	privateKeyPath := filepath.Join(m.keysDir, fmt.Sprintf("%s.private.pem", keyID))
@@ -172,10 +209,46 @@ func (m *Manager) SaveKeyPair(kp *KeyPair) error {
 		return fmt.Errorf("failed to save public key: %w", err)
 	}
 
+	// Record when the key was added, which orders the keys of a server key directory for rotation
+	addedPath := filepath.Join(m.keysDir, fmt.Sprintf("%s.added", keyID))
+	// #nosec G306 -- The time a key was added is not secret
+	if err := os.WriteFile(addedPath, []byte(time.Now().UTC().Format(time.RFC3339)+"\n"), 0644); err != nil {
+		_ = os.Remove(privateKeyPath)
+		_ = os.Remove(publicKeyPath)
+		return fmt.Errorf("failed to save key added time: %w", err)
+	}
+
 	return nil
 }
 
-// LoadKeyPair loads an RSA key pair from files.
+// encodeKeyPair PEM encodes the private and public key of kp.
+func encodeKeyPair(kp *KeyPair) ([]byte, []byte, error) {
+	if rsaKey, ok := kp.PrivateKey.(*rsa.PrivateKey); ok {
+		privateKeyPEM := pem.EncodeToMemory(&pem.Block{
+			Type:  "RSA PRIVATE KEY",
+			Bytes: x509.MarshalPKCS1PrivateKey(rsaKey),
+		})
+		publicKeyPEM := pem.EncodeToMemory(&pem.Block{
+			Type:  "RSA PUBLIC KEY",
+			Bytes: x509.MarshalPKCS1PublicKey(&rsaKey.PublicKey),
+		})
+		return privateKeyPEM, publicKeyPEM, nil
+	}
+
+	privateDER, err := x509.MarshalPKCS8PrivateKey(kp.PrivateKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to encode private key: %w", err)
+	}
+	publicDER, err := x509.MarshalPKIXPublicKey(kp.PublicKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to encode public key: %w", err)
+	}
+	privateKeyPEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privateDER})
+	publicKeyPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER})
+	return privateKeyPEM, publicKeyPEM, nil
+}
+
+// LoadKeyPair loads a key pair from files.
 func (m *Manager) LoadKeyPair(keyID string) (*KeyPair, error) {
 	if keyID == "" {
 		return nil, fmt.Errorf("%w: key ID cannot be empty", ErrKeyLoad)
@@ -198,7 +271,7 @@ func (m *Manager) LoadKeyPair(keyID string) (*KeyPair, error) {
 		return nil, ErrKeyLoad
 	}
 
-	privateKey, err := x509.ParsePKCS1PrivateKey(privateKeyBlock.Bytes)
+	privateKey, err := parsePrivateKey(privateKeyBlock)
 	if err != nil {
 		slog.Error("failed to parse private key", "error", err)
 		return nil, ErrKeyLoad
@@ -218,7 +291,7 @@ func (m *Manager) LoadKeyPair(keyID string) (*KeyPair, error) {
 		return nil, ErrKeyLoad
 	}
 
-	publicKey, err := x509.ParsePKCS1PublicKey(publicKeyBlock.Bytes)
+	publicKey, err := parsePublicKey(publicKeyBlock)
 	if err != nil {
 		slog.Error("failed to parse public key", "error", err)
 		return nil, ErrKeyLoad
@@ -297,5 +370,207 @@ func (m *Manager) DeleteKeyPair(keyID string) error {
 		return fmt.Errorf("failed to delete public key: %w", err)
 	}
 
+	// Key pairs saved by earlier versions have no added time
+	addedPath := filepath.Join(m.keysDir, fmt.Sprintf("%s.added", keyID))
+	if err := os.Remove(addedPath); err != nil && !os.IsNotExist(err) {
+		slog.Error("failed to delete key added time", "error", err, "path", addedPath)
+		return fmt.Errorf("failed to delete key added time: %w", err)
+	}
+
 	return nil
 }
+
+// Thumbprint returns the JWK thumbprint (RFC 7638) of a public key: the
+// base64url encoded SHA-256 hash of the key's required JWK members in
+// lexicographic order. The server uses the same derivation for the kid of its
+// signing keys, so file names, JWKS entries and JWT headers agree. It returns
+// an empty string for unsupported key types.
+func Thumbprint(publicKey crypto.PublicKey) string {
+	// The members contain only base64url characters and curve names, so no JSON escaping is needed
+	var canonical string
+	switch key := publicKey.(type) {
+	case *rsa.PublicKey:
+		canonical = fmt.Sprintf(`{"e":"%s","kty":"RSA","n":"%s"}`,
+			base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		)
+	case *ecdsa.PublicKey:
+		// Coordinates are padded to the size of the curve (RFC 7518 Section 6.2.1.2)
+		size := (key.Curve.Params().BitSize + 7) / 8
+		canonical = fmt.Sprintf(`{"crv":"%s","kty":"EC","x":"%s","y":"%s"}`,
+			key.Curve.Params().Name,
+			base64.RawURLEncoding.EncodeToString(key.X.FillBytes(make([]byte, size))),
+			base64.RawURLEncoding.EncodeToString(key.Y.FillBytes(make([]byte, size))),
+		)
+	case ed25519.PublicKey:
+		canonical = fmt.Sprintf(`{"crv":"Ed25519","kty":"OKP","x":"%s"}`,
+			base64.RawURLEncoding.EncodeToString(key),
+		)
+	default:
+		return ""
+	}
+	sum := sha256.Sum256([]byte(canonical))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// rename renames key files, replaced in tests to simulate failures.
+var rename = os.Rename
+
+// Migration records a key pair renamed by MigrateKeyIDs.
+type Migration struct {
+	OldKeyID string
+	NewKeyID string
+}
+
+// MigrateKeyIDs renames key pairs whose files are named after a legacy key ID,
+// such as the first 8 bytes of the modulus used by earlier versions, to their
+// JWK thumbprint. Key pairs that already use their thumbprint are left alone.
+// The key is read from the public key file, or from the private key file if
+// the public key is missing. A key pair whose files cannot all be renamed is
+// left under its legacy key ID. Key pairs saved before added times were
+// recorded get one from the modification time of their key file, so the server
+// can load them from a key directory.
+func (m *Manager) MigrateKeyIDs() ([]Migration, error) {
+	keyIDs, err := m.ListKeyPairs()
+	if err != nil {
+		return nil, err
+	}
+
+	var migrations []Migration
+	for _, keyID := range keyIDs {
+		publicKey, err := m.loadPublicKey(keyID)
+		if err != nil {
+			return migrations, fmt.Errorf("%w: key %s: %v", ErrKeyLoad, keyID, err)
+		}
+
+		newKeyID := Thumbprint(publicKey)
+		if newKeyID == keyID {
+			if err := m.ensureAddedTime(keyID); err != nil {
+				return migrations, err
+			}
+			continue
+		}
+
+		// Check every destination before renaming, so a key pair is never split across two key IDs
+		var renames [][2]string
+		for _, suffix := range []string{".private.pem", ".public.pem", ".added"} {
+			oldPath := filepath.Join(m.keysDir, keyID+suffix)
+			newPath := filepath.Join(m.keysDir, newKeyID+suffix)
+			if _, err := os.Stat(oldPath); os.IsNotExist(err) {
+				continue
+			}
+			if _, err := os.Stat(newPath); err == nil {
+				return migrations, fmt.Errorf("%w: %s already exists", ErrKeySave, newPath)
+			}
+			renames = append(renames, [2]string{oldPath, newPath})
+		}
+		for i, paths := range renames {
+			if err := rename(paths[0], paths[1]); err != nil {
+				for _, done := range renames[:i] {
+					if err := rename(done[1], done[0]); err != nil {
+						slog.Error("failed to roll back key file rename", "error", err, "path", done[1])
+					}
+				}
+				return migrations, fmt.Errorf("%w: failed to rename %s: %v", ErrKeySave, paths[0], err)
+			}
+		}
+		migrations = append(migrations, Migration{OldKeyID: keyID, NewKeyID: newKeyID})
+		if err := m.ensureAddedTime(newKeyID); err != nil {
+			return migrations, err
+		}
+	}
+
+	return migrations, nil
+}
+
+// ensureAddedTime records the modification time of the key file of keyID as
+// the time the key was added, unless the key pair already has an added time.
+func (m *Manager) ensureAddedTime(keyID string) error {
+	addedPath := filepath.Join(m.keysDir, keyID+".added")
+	if _, err := os.Stat(addedPath); err == nil {
+		return nil
+	}
+	info, err := os.Stat(filepath.Join(m.keysDir, keyID+".private.pem"))
+	if os.IsNotExist(err) {
+		info, err = os.Stat(filepath.Join(m.keysDir, keyID+".public.pem"))
+	}
+	if err != nil {
+		return fmt.Errorf("%w: key %s: %v", ErrKeyLoad, keyID, err)
+	}
+	// #nosec G306 -- The time a key was added is not secret
+	if err := os.WriteFile(addedPath, []byte(info.ModTime().UTC().Format(time.RFC3339)+"\n"), 0644); err != nil {
+		return fmt.Errorf("%w: failed to save key added time: %v", ErrKeySave, err)
+	}
+	return nil
+}
+
+// loadPublicKey reads the public key of the key pair stored under keyID.
+func (m *Manager) loadPublicKey(keyID string) (crypto.PublicKey, error) {
+	// #nosec G304 -- File path is constructed from the keys directory and a listed key ID
+	publicKeyPEM, err := os.ReadFile(filepath.Join(m.keysDir, keyID+".public.pem"))
+	if err == nil {
+		block, _ := pem.Decode(publicKeyPEM)
+		if block == nil {
+			return nil, errors.New("failed to decode public key PEM")
+		}
+		return parsePublicKey(block)
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	// #nosec G304 -- File path is constructed from the keys directory and a listed key ID
+	privateKeyPEM, err := os.ReadFile(filepath.Join(m.keysDir, keyID+".private.pem"))
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(privateKeyPEM)
+	if block == nil {
+		return nil, errors.New("failed to decode private key PEM")
+	}
+	privateKey, err := parsePrivateKey(block)
+	if err != nil {
+		return nil, err
+	}
+	return privateKey.Public(), nil
+}
+
+// parsePrivateKey parses a PKCS#1 ("RSA PRIVATE KEY") or PKCS#8 ("PRIVATE KEY") private key.
+func parsePrivateKey(block *pem.Block) (crypto.Signer, error) {
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		return key, nil
+	case "PRIVATE KEY":
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		signer, ok := key.(crypto.Signer)
+		if !ok {
+			return nil, fmt.Errorf("%w: key type %T", ErrUnsupportedAlgorithm, key)
+		}
+		return signer, nil
+	default:
+		return nil, fmt.Errorf("unsupported PEM block type %q", block.Type)
+	}
+}
+
+// parsePublicKey parses a PKCS#1 ("RSA PUBLIC KEY") or PKIX ("PUBLIC KEY") public key.
+func parsePublicKey(block *pem.Block) (crypto.PublicKey, error) {
+	switch block.Type {
+	case "RSA PUBLIC KEY":
+		key, err := x509.ParsePKCS1PublicKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		return key, nil
+	case "PUBLIC KEY":
+		return x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block type %q", block.Type)
+	}
+}
@@ -1,9 +1,22 @@
 package rsa
 
 import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 )
 
 // setupTestDir creates a temporary directory for testing and returns its path
@@ -37,17 +50,26 @@ func createTestManager(t *testing.T) (*Manager, func()) {
 func validateKeyPair(t *testing.T, keyPair *KeyPair, wantBits int) {
 	t.Helper()
 
+	privateKey, ok := keyPair.PrivateKey.(*rsa.PrivateKey)
+	if !ok {
+		t.Fatalf("Private key type = %T, want *rsa.PrivateKey", keyPair.PrivateKey)
+	}
+	publicKey, ok := keyPair.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		t.Fatalf("Public key type = %T, want *rsa.PublicKey", keyPair.PublicKey)
+	}
+
 	// Validate private key
-	if err := keyPair.PrivateKey.Validate(); err != nil {
+	if err := privateKey.Validate(); err != nil {
 		t.Errorf("Private key validation failed: %v", err)
 	}
 
 	// Verify key sizes match requested bits
-	if keyPair.PrivateKey.N.BitLen() != wantBits {
-		t.Errorf("Private key size = %d bits, want %d bits", keyPair.PrivateKey.N.BitLen(), wantBits)
+	if privateKey.N.BitLen() != wantBits {
+		t.Errorf("Private key size = %d bits, want %d bits", privateKey.N.BitLen(), wantBits)
 	}
-	if keyPair.PublicKey.N.BitLen() != wantBits {
-		t.Errorf("Public key size = %d bits, want %d bits", keyPair.PublicKey.N.BitLen(), wantBits)
+	if publicKey.N.BitLen() != wantBits {
+		t.Errorf("Public key size = %d bits, want %d bits", publicKey.N.BitLen(), wantBits)
 	}
 }
 
@@ -67,7 +89,7 @@ func TestManager_GenerateKeyPair(t *testing.T) {
 			manager, cleanup := createTestManager(t)
 			defer cleanup()
 
-			keyPair, err := manager.GenerateKeyPair(tt.bits)
+			keyPair, err := manager.GenerateKeyPair(AlgorithmRS256, tt.bits)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("generateKeyPair() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -78,8 +100,8 @@ func TestManager_GenerateKeyPair(t *testing.T) {
 					t.Error("generateKeyPair() returned nil keyPair")
 					return
 				}
-				if keyPair.KeyID == "" {
-					t.Error("generateKeyPair() returned empty KeyID")
+				if keyPair.KeyID != Thumbprint(keyPair.PublicKey) {
+					t.Errorf("generateKeyPair() KeyID = %s, want the JWK thumbprint %s", keyPair.KeyID, Thumbprint(keyPair.PublicKey))
 				}
 				if keyPair.PrivateKey == nil {
 					t.Error("generateKeyPair() returned nil PrivateKey")
@@ -95,12 +117,75 @@ func TestManager_GenerateKeyPair(t *testing.T) {
 	}
 }
 
+func TestManager_GenerateKeyPair_Algorithms(t *testing.T) {
+	tests := []struct {
+		algorithm     string
+		wantPublicKey crypto.PublicKey
+	}{
+		{AlgorithmES256, &ecdsa.PublicKey{Curve: elliptic.P256()}},
+		{AlgorithmES384, &ecdsa.PublicKey{Curve: elliptic.P384()}},
+		{AlgorithmEdDSA, ed25519.PublicKey(nil)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.algorithm, func(t *testing.T) {
+			manager, cleanup := createTestManager(t)
+			defer cleanup()
+
+			keyPair, err := manager.GenerateKeyPair(tt.algorithm, 0)
+			if err != nil {
+				t.Fatalf("GenerateKeyPair() error = %v", err)
+			}
+			switch want := tt.wantPublicKey.(type) {
+			case *ecdsa.PublicKey:
+				got, ok := keyPair.PublicKey.(*ecdsa.PublicKey)
+				if !ok || got.Curve != want.Curve {
+					t.Errorf("GenerateKeyPair() public key = %T, want %s key", keyPair.PublicKey, want.Curve.Params().Name)
+				}
+			case ed25519.PublicKey:
+				if _, ok := keyPair.PublicKey.(ed25519.PublicKey); !ok {
+					t.Errorf("GenerateKeyPair() public key = %T, want ed25519.PublicKey", keyPair.PublicKey)
+				}
+			}
+			if keyPair.KeyID == "" || keyPair.KeyID != Thumbprint(keyPair.PublicKey) {
+				t.Errorf("GenerateKeyPair() KeyID = %s, want the JWK thumbprint", keyPair.KeyID)
+			}
+
+			// The key pair survives a round trip through the key files
+			if err := manager.SaveKeyPair(keyPair); err != nil {
+				t.Fatalf("SaveKeyPair() error = %v", err)
+			}
+			loaded, err := manager.LoadKeyPair(keyPair.KeyID)
+			if err != nil {
+				t.Fatalf("LoadKeyPair() error = %v", err)
+			}
+			if Thumbprint(loaded.PublicKey) != keyPair.KeyID || Thumbprint(loaded.PrivateKey.Public()) != keyPair.KeyID {
+				t.Error("LoadKeyPair() returned a different key pair")
+			}
+
+			// Key pairs named by their thumbprint are left alone by migrate
+			if migrations, err := manager.MigrateKeyIDs(); err != nil || len(migrations) != 0 {
+				t.Errorf("MigrateKeyIDs() = %v, %v; want no migrations", migrations, err)
+			}
+		})
+	}
+
+	t.Run("unsupported algorithm", func(t *testing.T) {
+		manager, cleanup := createTestManager(t)
+		defer cleanup()
+
+		if _, err := manager.GenerateKeyPair("HS256", 0); !errors.Is(err, ErrUnsupportedAlgorithm) {
+			t.Errorf("GenerateKeyPair() error = %v, want %v", err, ErrUnsupportedAlgorithm)
+		}
+	})
+}
+
 func TestManager_SaveKeyPair(t *testing.T) {
 	manager, cleanup := createTestManager(t)
 	defer cleanup()
 
 	// Generate a valid key pair for testing
-	keyPair, err := manager.GenerateKeyPair(2048)
+	keyPair, err := manager.GenerateKeyPair(AlgorithmRS256, 2048)
 	if err != nil {
 		t.Fatalf("Failed to generate test key pair: %v", err)
 	}
@@ -141,6 +226,14 @@ func TestManager_SaveKeyPair(t *testing.T) {
 				} else if info.Mode() != 0644 {
 					t.Errorf("Public key file has wrong permissions: %v", info.Mode())
 				}
+
+				// Check the time the key was added
+				added, err := os.ReadFile(filepath.Join(manager.KeysDir(), tt.keyPair.KeyID+".added"))
+				if err != nil {
+					t.Errorf("Added time file not found: %v", err)
+				} else if _, err := time.Parse(time.RFC3339, strings.TrimSpace(string(added))); err != nil {
+					t.Errorf("Added time is not in RFC 3339 format: %v", err)
+				}
 			}
 		})
 	}
@@ -151,7 +244,7 @@ func TestManager_ListKeyPairs_WithKeys(t *testing.T) {
 	defer cleanup()
 
 	// Generate and save two key pairs
-	keyPair1, err := manager.GenerateKeyPair(2048)
+	keyPair1, err := manager.GenerateKeyPair(AlgorithmRS256, 2048)
 	if err != nil {
 		t.Fatalf("Failed to generate first test key pair: %v", err)
 	}
@@ -159,7 +252,7 @@ func TestManager_ListKeyPairs_WithKeys(t *testing.T) {
 		t.Fatalf("Failed to save first test key pair: %v", err)
 	}
 
-	keyPair2, err := manager.GenerateKeyPair(2048)
+	keyPair2, err := manager.GenerateKeyPair(AlgorithmRS256, 2048)
 	if err != nil {
 		t.Fatalf("Failed to generate second test key pair: %v", err)
 	}
@@ -214,7 +307,7 @@ func TestManager_DeleteKeyPair(t *testing.T) {
 	defer cleanup()
 
 	// Generate and save a test key pair
-	keyPair, err := manager.GenerateKeyPair(2048)
+	keyPair, err := manager.GenerateKeyPair(AlgorithmRS256, 2048)
 	if err != nil {
 		t.Fatalf("Failed to generate test key pair: %v", err)
 	}
@@ -251,7 +344,264 @@ func TestManager_DeleteKeyPair(t *testing.T) {
 				if _, err := os.Stat(publicKeyPath); !os.IsNotExist(err) {
 					t.Error("Public key file still exists after deletion")
 				}
+				if _, err := os.Stat(filepath.Join(manager.KeysDir(), tt.keyID+".added")); !os.IsNotExist(err) {
+					t.Error("Added time file still exists after deletion")
+				}
 			}
 		})
 	}
 }
+
+func TestThumbprint(t *testing.T) {
+	// Example key and thumbprint from RFC 7638 Section 3.1
+	n, err := base64.RawURLEncoding.DecodeString("0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc_BJECPebWKRXjBZCiFV4n3oknjhMstn64tZ_2W-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_FDW2QvzqY368QQMicAtaSqzs8KJZgnYb9c7d0zgdAZHzu6qMQvRL5hajrn1n91CbOpbISD08qNLyrdkt-bFTWhAI4vMQFh6WeZu0fM4lFd2NcRwr3XPksINHaQ-G_xBniIqbw0Ls1jF44-csFCur-kEgU8awapJzKnqDKgw")
+	if err != nil {
+		t.Fatalf("Failed to decode modulus: %v", err)
+	}
+	publicKey := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: 65537}
+
+	if got, want := Thumbprint(publicKey), "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs"; got != want {
+		t.Errorf("Thumbprint() = %s, want %s", got, want)
+	}
+
+	// Example key and thumbprint from RFC 8037 Appendix A.3
+	x, err := base64.RawURLEncoding.DecodeString("11qYAYKxCrfVS_7TyWQHOg7hcvPapiMlrwIaaPcHURo")
+	if err != nil {
+		t.Fatalf("Failed to decode public key: %v", err)
+	}
+	if got, want := Thumbprint(ed25519.PublicKey(x)), "kPrK_qmxVWaYVA9wwBF6Iuo3vVzz7TxHCTwXBygrS4k"; got != want {
+		t.Errorf("Thumbprint() = %s, want %s", got, want)
+	}
+}
+
+// saveLegacyKeyPair saves a key pair under the modulus based key ID of earlier versions.
+func saveLegacyKeyPair(t *testing.T, manager *Manager, withPublicKey bool) *KeyPair {
+	t.Helper()
+	keyPair, err := manager.GenerateKeyPair(AlgorithmRS256, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate test key pair: %v", err)
+	}
+	privateKey := keyPair.PrivateKey.(*rsa.PrivateKey)
+	legacyID := fmt.Sprintf("%x", privateKey.N.Bytes()[:8])
+	files := map[string][]byte{
+		legacyID + ".private.pem": pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(privateKey)}),
+	}
+	if withPublicKey {
+		files[legacyID+".public.pem"] = pem.EncodeToMemory(&pem.Block{Type: "RSA PUBLIC KEY", Bytes: x509.MarshalPKCS1PublicKey(&privateKey.PublicKey)})
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(manager.KeysDir(), name), content, 0600); err != nil {
+			t.Fatalf("Failed to write legacy key file: %v", err)
+		}
+	}
+	keyPair.KeyID = legacyID
+	return keyPair
+}
+
+// assertKeyFiles checks which files of the key pair keyID exist.
+func assertKeyFiles(t *testing.T, manager *Manager, keyID string, wantPrivate, wantPublic bool) {
+	t.Helper()
+	for suffix, want := range map[string]bool{".private.pem": wantPrivate, ".public.pem": wantPublic} {
+		_, err := os.Stat(filepath.Join(manager.KeysDir(), keyID+suffix))
+		if exists := err == nil; exists != want {
+			t.Errorf("%s%s exists = %v, want %v", keyID, suffix, exists, want)
+		}
+	}
+}
+
+func TestManager_MigrateKeyIDs(t *testing.T) {
+	manager, cleanup := createTestManager(t)
+	defer cleanup()
+
+	legacy := saveLegacyKeyPair(t, manager, true)
+	privateOnly := saveLegacyKeyPair(t, manager, false)
+	current, err := manager.GenerateKeyPair(AlgorithmRS256, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate test key pair: %v", err)
+	}
+	if err := manager.SaveKeyPair(current); err != nil {
+		t.Fatalf("Failed to save test key pair: %v", err)
+	}
+
+	migrations, err := manager.MigrateKeyIDs()
+	if err != nil {
+		t.Fatalf("MigrateKeyIDs() error = %v", err)
+	}
+	if len(migrations) != 2 {
+		t.Fatalf("MigrateKeyIDs() returned %d migrations, want 2", len(migrations))
+	}
+
+	for _, keyPair := range []*KeyPair{legacy, privateOnly} {
+		newID := Thumbprint(keyPair.PublicKey)
+		if _, err := os.Stat(filepath.Join(manager.KeysDir(), keyPair.KeyID+".private.pem")); !os.IsNotExist(err) {
+			t.Errorf("Legacy private key %s still exists after migration", keyPair.KeyID)
+		}
+		loaded, err := os.ReadFile(filepath.Join(manager.KeysDir(), newID+".private.pem"))
+		if err != nil {
+			t.Errorf("Migrated private key %s not found: %v", newID, err)
+			continue
+		}
+		block, _ := pem.Decode(loaded)
+		if block == nil {
+			t.Errorf("Migrated private key %s is not PEM encoded", newID)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(manager.KeysDir(), Thumbprint(legacy.PublicKey)+".public.pem")); err != nil {
+		t.Errorf("Migrated public key not found: %v", err)
+	}
+
+	t.Run("second run is a no-op", func(t *testing.T) {
+		migrations, err := manager.MigrateKeyIDs()
+		if err != nil || len(migrations) != 0 {
+			t.Errorf("MigrateKeyIDs() = %v, %v; want no migrations", migrations, err)
+		}
+	})
+
+	t.Run("malformed key file", func(t *testing.T) {
+		if err := os.WriteFile(filepath.Join(manager.KeysDir(), "broken.public.pem"), []byte("not a key"), 0600); err != nil {
+			t.Fatalf("Failed to write key file: %v", err)
+		}
+		if _, err := manager.MigrateKeyIDs(); err == nil {
+			t.Error("Expected error for malformed key file")
+		}
+	})
+}
+
+func TestManager_MigrateKeyIDsRecordsAddedTime(t *testing.T) {
+	manager, cleanup := createTestManager(t)
+	defer cleanup()
+
+	legacy := saveLegacyKeyPair(t, manager, true)
+	modified := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	if err := os.Chtimes(filepath.Join(manager.KeysDir(), legacy.KeyID+".private.pem"), modified, modified); err != nil {
+		t.Fatalf("Failed to set key file time: %v", err)
+	}
+	current, err := manager.GenerateKeyPair(AlgorithmRS256, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate key pair: %v", err)
+	}
+	if err := manager.SaveKeyPair(current); err != nil {
+		t.Fatalf("Failed to save key pair: %v", err)
+	}
+	saved, err := os.ReadFile(filepath.Join(manager.KeysDir(), current.KeyID+".added"))
+	if err != nil {
+		t.Fatalf("Failed to read added time: %v", err)
+	}
+	if err := os.Remove(filepath.Join(manager.KeysDir(), current.KeyID+".added")); err != nil {
+		t.Fatalf("Failed to remove added time: %v", err)
+	}
+
+	if _, err := manager.MigrateKeyIDs(); err != nil {
+		t.Fatalf("MigrateKeyIDs() error = %v", err)
+	}
+
+	added, err := os.ReadFile(filepath.Join(manager.KeysDir(), Thumbprint(legacy.PublicKey)+".added"))
+	if err != nil {
+		t.Fatalf("Migrated key pair has no added time: %v", err)
+	}
+	if got, want := strings.TrimSpace(string(added)), modified.Format(time.RFC3339); got != want {
+		t.Errorf("Migrated key pair added time = %q, want %q", got, want)
+	}
+	if _, err := os.Stat(filepath.Join(manager.KeysDir(), current.KeyID+".added")); err != nil {
+		t.Errorf("Key pair named by thumbprint has no added time: %v", err)
+	}
+
+	t.Run("existing added time is kept", func(t *testing.T) {
+		if err := os.WriteFile(filepath.Join(manager.KeysDir(), current.KeyID+".added"), saved, 0644); err != nil {
+			t.Fatalf("Failed to write added time: %v", err)
+		}
+		if _, err := manager.MigrateKeyIDs(); err != nil {
+			t.Fatalf("MigrateKeyIDs() error = %v", err)
+		}
+		kept, err := os.ReadFile(filepath.Join(manager.KeysDir(), current.KeyID+".added"))
+		if err != nil || string(kept) != string(saved) {
+			t.Errorf("Added time = %q, %v; want %q", kept, err, saved)
+		}
+	})
+}
+
+func TestManager_MigrateKeyIDsLeavesKeyPairsWhole(t *testing.T) {
+	t.Run("existing destination", func(t *testing.T) {
+		manager, cleanup := createTestManager(t)
+		defer cleanup()
+		legacy := saveLegacyKeyPair(t, manager, true)
+		newID := Thumbprint(legacy.PublicKey)
+
+		// Only the public key exists under the new key ID, so renaming the private key first would split the pair
+		publicKeyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PUBLIC KEY", Bytes: x509.MarshalPKCS1PublicKey(legacy.PublicKey.(*rsa.PublicKey))})
+		if err := os.WriteFile(filepath.Join(manager.KeysDir(), newID+".public.pem"), publicKeyPEM, 0600); err != nil {
+			t.Fatalf("Failed to write key file: %v", err)
+		}
+		if _, err := manager.MigrateKeyIDs(); !errors.Is(err, ErrKeySave) {
+			t.Errorf("MigrateKeyIDs() error = %v, want %v", err, ErrKeySave)
+		}
+		assertKeyFiles(t, manager, legacy.KeyID, true, true)
+		assertKeyFiles(t, manager, newID, false, true)
+	})
+
+	t.Run("failed rename is rolled back", func(t *testing.T) {
+		manager, cleanup := createTestManager(t)
+		defer cleanup()
+		legacy := saveLegacyKeyPair(t, manager, true)
+		addedPath := filepath.Join(manager.KeysDir(), legacy.KeyID+".added")
+		if err := os.WriteFile(addedPath, []byte("2024-03-01T12:00:00Z\n"), 0644); err != nil {
+			t.Fatalf("Failed to write added time: %v", err)
+		}
+
+		// The added time is renamed last, so failing on it rolls back both key files
+		rename = func(oldPath, newPath string) error {
+			if strings.HasSuffix(oldPath, ".added") {
+				return errors.New("simulated failure")
+			}
+			return os.Rename(oldPath, newPath)
+		}
+		defer func() { rename = os.Rename }()
+
+		if _, err := manager.MigrateKeyIDs(); !errors.Is(err, ErrKeySave) {
+			t.Errorf("MigrateKeyIDs() error = %v, want %v", err, ErrKeySave)
+		}
+		assertKeyFiles(t, manager, legacy.KeyID, true, true)
+		assertKeyFiles(t, manager, Thumbprint(legacy.PublicKey), false, false)
+		if _, err := os.Stat(addedPath); err != nil {
+			t.Errorf("Legacy added time was not kept: %v", err)
+		}
+	})
+
+	t.Run("added time moves with the key pair", func(t *testing.T) {
+		manager, cleanup := createTestManager(t)
+		defer cleanup()
+		legacy := saveLegacyKeyPair(t, manager, true)
+		if err := os.WriteFile(filepath.Join(manager.KeysDir(), legacy.KeyID+".added"), []byte("2024-03-01T12:00:00Z\n"), 0644); err != nil {
+			t.Fatalf("Failed to write added time: %v", err)
+		}
+
+		if _, err := manager.MigrateKeyIDs(); err != nil {
+			t.Fatalf("MigrateKeyIDs() error = %v", err)
+		}
+		if _, err := os.Stat(filepath.Join(manager.KeysDir(), legacy.KeyID+".added")); !os.IsNotExist(err) {
+			t.Errorf("Legacy added time still exists: %v", err)
+		}
+		added, err := os.ReadFile(filepath.Join(manager.KeysDir(), Thumbprint(legacy.PublicKey)+".added"))
+		if err != nil || string(added) != "2024-03-01T12:00:00Z\n" {
+			t.Errorf("Migrated added time = %q, %v; want the legacy added time", added, err)
+		}
+	})
+
+	t.Run("existing added time at destination", func(t *testing.T) {
+		manager, cleanup := createTestManager(t)
+		defer cleanup()
+		legacy := saveLegacyKeyPair(t, manager, true)
+		newID := Thumbprint(legacy.PublicKey)
+		for _, keyID := range []string{legacy.KeyID, newID} {
+			if err := os.WriteFile(filepath.Join(manager.KeysDir(), keyID+".added"), []byte("2024-03-01T12:00:00Z\n"), 0644); err != nil {
+				t.Fatalf("Failed to write added time: %v", err)
+			}
+		}
+
+		if _, err := manager.MigrateKeyIDs(); !errors.Is(err, ErrKeySave) {
+			t.Errorf("MigrateKeyIDs() error = %v, want %v", err, ErrKeySave)
+		}
+		assertKeyFiles(t, manager, legacy.KeyID, true, true)
+		assertKeyFiles(t, manager, newID, false, false)
+	})
+}
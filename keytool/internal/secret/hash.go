@@ -0,0 +1,89 @@
+// Package secret provides hashing of OAuth2 client secrets for the key management tool.
+// It produces the hash formats accepted by the server's client store:
+//   - argon2id in PHC string format ($argon2id$v=19$m=<m>,t=<t>,p=<p>$<salt>$<hash>)
+//   - bcrypt in modular crypt format ($2a$<cost>$...)
+//
+// The hashing code intentionally duplicates the server's userpool package to keep
+// the tool independent of the main application.
+package secret
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Supported secret hashing algorithms.
+const (
+	AlgorithmArgon2id = "argon2id"
+	AlgorithmBcrypt   = "bcrypt"
+)
+
+var (
+	// ErrEmptySecret is returned when attempting to hash an empty secret.
+	ErrEmptySecret = errors.New("secret cannot be empty")
+	// ErrUnknownAlgorithm is returned when an unsupported hashing algorithm is requested.
+	ErrUnknownAlgorithm = errors.New("unknown hashing algorithm")
+	// ErrInvalidParameters is returned when the cost parameters are out of range.
+	ErrInvalidParameters = errors.New("invalid hashing parameters")
+)
+
+// Params holds the cost parameters for secret hashing.
+type Params struct {
+	// Memory is the argon2id memory cost in KiB.
+	Memory uint32
+	// Iterations is the number of argon2id passes.
+	Iterations uint32
+	// Parallelism is the number of argon2id lanes.
+	Parallelism uint8
+	// Cost is the bcrypt cost.
+	Cost int
+}
+
+// DefaultParams returns the server's default cost parameters
+// (argon2id as recommended by RFC 9106, bcrypt cost 12).
+func DefaultParams() Params {
+	return Params{
+		Memory:      64 * 1024,
+		Iterations:  3,
+		Parallelism: 4,
+		Cost:        12,
+	}
+}
+
+// Hash hashes secret with the given algorithm and parameters.
+func Hash(secret, algorithm string, params Params) (string, error) {
+	if secret == "" {
+		return "", ErrEmptySecret
+	}
+
+	switch algorithm {
+	case AlgorithmArgon2id:
+		if params.Memory == 0 || params.Iterations == 0 || params.Parallelism == 0 {
+			return "", fmt.Errorf("%w: argon2id parameters must be positive", ErrInvalidParameters)
+		}
+		salt := make([]byte, 16)
+		if _, err := rand.Read(salt); err != nil {
+			return "", err
+		}
+		key := argon2.IDKey([]byte(secret), salt, params.Iterations, params.Memory, params.Parallelism, 32)
+		return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+			argon2.Version, params.Memory, params.Iterations, params.Parallelism,
+			base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+	case AlgorithmBcrypt:
+		if params.Cost < bcrypt.MinCost || params.Cost > bcrypt.MaxCost {
+			return "", fmt.Errorf("%w: bcrypt cost must be between %d and %d", ErrInvalidParameters, bcrypt.MinCost, bcrypt.MaxCost)
+		}
+		hash, err := bcrypt.GenerateFromPassword([]byte(secret), params.Cost)
+		if err != nil {
+			return "", err
+		}
+		return string(hash), nil
+	default:
+		return "", fmt.Errorf("%w: %s", ErrUnknownAlgorithm, algorithm)
+	}
+}
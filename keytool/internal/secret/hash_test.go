@@ -0,0 +1,104 @@
+package secret
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// fastParams returns minimal cost parameters to keep tests fast.
+func fastParams() Params {
+	return Params{Memory: 1024, Iterations: 1, Parallelism: 1, Cost: bcrypt.MinCost}
+}
+
+func TestHash_Argon2id(t *testing.T) {
+	hash, err := Hash("test123", AlgorithmArgon2id, fastParams())
+	if err != nil {
+		t.Fatalf("Hash() error = %v", err)
+	}
+
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 {
+		t.Fatalf("Expected 6 PHC segments, got %d in %s", len(parts), hash)
+	}
+	if parts[1] != "argon2id" || parts[2] != fmt.Sprintf("v=%d", argon2.Version) || parts[3] != "m=1024,t=1,p=1" {
+		t.Fatalf("Unexpected PHC header in %s", hash)
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		t.Fatalf("Failed to decode salt: %v", err)
+	}
+	want, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		t.Fatalf("Failed to decode key: %v", err)
+	}
+	got := argon2.IDKey([]byte("test123"), salt, 1, 1024, 1, uint32(len(want)))
+	if subtle.ConstantTimeCompare(got, want) != 1 {
+		t.Error("Hash does not verify against the original secret")
+	}
+}
+
+func TestHash_Bcrypt(t *testing.T) {
+	hash, err := Hash("test123", AlgorithmBcrypt, fastParams())
+	if err != nil {
+		t.Fatalf("Hash() error = %v", err)
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(hash), []byte("test123")); err != nil {
+		t.Errorf("Hash does not verify against the original secret: %v", err)
+	}
+	if cost, _ := bcrypt.Cost([]byte(hash)); cost != bcrypt.MinCost {
+		t.Errorf("Expected cost %d, got %d", bcrypt.MinCost, cost)
+	}
+}
+
+func TestHash_Errors(t *testing.T) {
+	tests := []struct {
+		name      string
+		secret    string
+		algorithm string
+		params    Params
+		wantErr   error
+	}{
+		{"empty secret", "", AlgorithmArgon2id, fastParams(), ErrEmptySecret},
+		{"unknown algorithm", "test123", "md5", fastParams(), ErrUnknownAlgorithm},
+		{"zero argon2id memory", "test123", AlgorithmArgon2id, Params{Iterations: 1, Parallelism: 1}, ErrInvalidParameters},
+		{"bcrypt cost too low", "test123", AlgorithmBcrypt, Params{Cost: 1}, ErrInvalidParameters},
+		{"bcrypt cost too high", "test123", AlgorithmBcrypt, Params{Cost: 40}, ErrInvalidParameters},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Hash(tt.secret, tt.algorithm, tt.params); !errors.Is(err, tt.wantErr) {
+				t.Errorf("Hash() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestHash_UniqueSalts(t *testing.T) {
+	first, err := Hash("test123", AlgorithmArgon2id, fastParams())
+	if err != nil {
+		t.Fatalf("Hash() error = %v", err)
+	}
+	second, err := Hash("test123", AlgorithmArgon2id, fastParams())
+	if err != nil {
+		t.Fatalf("Hash() error = %v", err)
+	}
+	if first == second {
+		t.Error("Expected hashes of the same secret to differ")
+	}
+}
+
+func TestDefaultParams(t *testing.T) {
+	params := DefaultParams()
+	if params.Memory != 65536 || params.Iterations != 3 || params.Parallelism != 4 || params.Cost != 12 {
+		t.Errorf("Unexpected default parameters %+v", params)
+	}
+}
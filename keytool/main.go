@@ -1,16 +1,22 @@
-// Package main implements a command-line tool for managing RSA key pairs
-// used by the OAuth2 server for JWT signing. It provides functionality to
-// generate, list, and delete key pairs, with proper file permissions and
-// PEM encoding.
+// Package main implements a command-line tool for managing the RSA, ECDSA and
+// Ed25519 key pairs used by the OAuth2 server for JWT signing. It provides
+// functionality to generate, list, delete and migrate key pairs, with proper
+// file permissions and PEM encoding, and to hash client secrets for the
+// server's client store.
 package main
 
 import (
+	"bufio"
 	"flag"
 	"fmt"
+	"io"
 	rsa "keytool/internal"
+	"keytool/internal/secret"
 	"log/slog"
+	"math"
 	"os"
 	"path/filepath"
+	"strings"
 )
 
 func main() {
@@ -24,15 +30,24 @@ func main() {
 	generateCmd := flag.NewFlagSet("generate", flag.ExitOnError)
 	listCmd := flag.NewFlagSet("list", flag.ExitOnError)
 	deleteCmd := flag.NewFlagSet("delete", flag.ExitOnError)
+	migrateCmd := flag.NewFlagSet("migrate", flag.ExitOnError)
+	hashSecretCmd := flag.NewFlagSet("hash-secret", flag.ExitOnError)
 
 	// Define flags
 	keysDir := flag.String("dir", "keys", "Directory to store keys")
+	keyAlgorithm := generateCmd.String("alg", rsa.AlgorithmRS256, "Signing algorithm of the key pair (RS256, ES256, ES384 or EdDSA)")
 	bits := generateCmd.Int("bits", 2048, "Number of bits for RSA key pair")
 	keyID := deleteCmd.String("id", "", "Key ID to delete")
+	defaults := secret.DefaultParams()
+	algorithm := hashSecretCmd.String("algorithm", secret.AlgorithmArgon2id, "Hashing algorithm (argon2id or bcrypt)")
+	memory := hashSecretCmd.Uint("memory", uint(defaults.Memory), "argon2id memory cost in KiB")
+	iterations := hashSecretCmd.Uint("iterations", uint(defaults.Iterations), "argon2id number of passes")
+	parallelism := hashSecretCmd.Uint("parallelism", uint(defaults.Parallelism), "argon2id number of lanes")
+	cost := hashSecretCmd.Int("cost", defaults.Cost, "bcrypt cost")
 
 	// Parse command
 	if len(os.Args) < 2 {
-		fmt.Println("expected 'generate', 'list', or 'delete' command")
+		fmt.Println("expected 'generate', 'list', 'delete', 'migrate', or 'hash-secret' command")
 		os.Exit(1)
 	}
 
@@ -50,7 +65,7 @@ func main() {
 			slog.Error("Failed to parse args for keypair to generate", "error", err)
 			os.Exit(1)
 		}
-		if err := handleGenerate(manager, *bits); err != nil {
+		if err := handleGenerate(manager, *keyAlgorithm, *bits); err != nil {
 			slog.Error("Failed to generate key pair", "error", err)
 			os.Exit(1)
 		}
@@ -82,15 +97,48 @@ func main() {
 			os.Exit(1)
 		}
 
+	case "migrate":
+		err := migrateCmd.Parse(os.Args[2:])
+		if err != nil {
+			slog.Error("Failed to parse args for key pairs to migrate", "error", err)
+			os.Exit(1)
+		}
+		if err := handleMigrate(manager); err != nil {
+			slog.Error("Failed to migrate key pairs", "error", err)
+			os.Exit(1)
+		}
+
+	case "hash-secret":
+		err := hashSecretCmd.Parse(os.Args[2:])
+		if err != nil {
+			slog.Error("Failed to parse args for secret to hash", "error", err)
+			os.Exit(1)
+		}
+		if *memory > math.MaxUint32 || *iterations > math.MaxUint32 || *parallelism > math.MaxUint8 {
+			fmt.Println("error: argon2id parameters out of range")
+			hashSecretCmd.PrintDefaults()
+			os.Exit(1)
+		}
+		params := secret.Params{
+			Memory:      uint32(*memory),     // #nosec G115 -- range checked above
+			Iterations:  uint32(*iterations), // #nosec G115 -- range checked above
+			Parallelism: uint8(*parallelism), // #nosec G115 -- range checked above
+			Cost:        *cost,
+		}
+		if err := handleHashSecret(os.Stdin, os.Stdout, *algorithm, params); err != nil {
+			slog.Error("Failed to hash secret", "error", err)
+			os.Exit(1)
+		}
+
 	default:
 		fmt.Printf("unknown command: %s\n", os.Args[1])
 		os.Exit(1)
 	}
 }
 
-func handleGenerate(manager *rsa.Manager, bits int) error {
+func handleGenerate(manager *rsa.Manager, algorithm string, bits int) error {
 	// Generate a new key pair
-	keyPair, err := manager.GenerateKeyPair(bits)
+	keyPair, err := manager.GenerateKeyPair(algorithm, bits)
 	if err != nil {
 		return fmt.Errorf("failed to generate key pair: %w", err)
 	}
@@ -102,9 +150,10 @@ func handleGenerate(manager *rsa.Manager, bits int) error {
 
 	slog.Info("Generated new key pair",
 		"keyID", keyPair.KeyID,
-		"bits", bits,
+		"alg", algorithm,
 		"privateKey", filepath.Join(manager.KeysDir(), fmt.Sprintf("%s.private.pem", keyPair.KeyID)),
 		"publicKey", filepath.Join(manager.KeysDir(), fmt.Sprintf("%s.public.pem", keyPair.KeyID)),
+		"added", filepath.Join(manager.KeysDir(), fmt.Sprintf("%s.added", keyPair.KeyID)),
 	)
 
 	return nil
@@ -140,3 +189,36 @@ func handleDelete(manager *rsa.Manager, keyID string) error {
 	slog.Info("Deleted key pair", "keyID", keyID)
 	return nil
 }
+
+func handleMigrate(manager *rsa.Manager) error {
+	migrations, err := manager.MigrateKeyIDs()
+	for _, migration := range migrations {
+		slog.Info("Renamed key pair", "oldKeyID", migration.OldKeyID, "newKeyID", migration.NewKeyID)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to migrate key pairs: %w", err)
+	}
+	if len(migrations) == 0 {
+		slog.Info("All key pairs already use their JWK thumbprint as key ID")
+	}
+	return nil
+}
+
+// handleHashSecret reads a client secret from the first line of in and writes its hash to out.
+// The secret is read from stdin rather than a flag to keep it out of the shell history.
+func handleHashSecret(in io.Reader, out io.Writer, algorithm string, params secret.Params) error {
+	line, err := bufio.NewReader(in).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return fmt.Errorf("failed to read secret: %w", err)
+	}
+
+	hash, err := secret.Hash(strings.TrimRight(line, "\r\n"), algorithm, params)
+	if err != nil {
+		return fmt.Errorf("failed to hash secret: %w", err)
+	}
+
+	if _, err := fmt.Fprintln(out, hash); err != nil {
+		return fmt.Errorf("failed to write hash: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,205 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"oauth2-task/internal/userpool"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// ClientAssertionTypeJWTBearer is the client_assertion_type of JWT client assertions (RFC 7523 Section 2.2).
+const ClientAssertionTypeJWTBearer = "urn:ietf:params:oauth:client-assertion-type:jwt-bearer"
+
+const (
+	// maxAssertionLifetime bounds how far in the future the exp claim of a client
+	// assertion may lie. It also bounds how long a jti has to be remembered.
+	maxAssertionLifetime = time.Hour
+	// assertionLeeway tolerates clock skew between the client and the server.
+	assertionLeeway = 30 * time.Second
+)
+
+// ErrInvalidAssertion is returned when a client assertion is malformed, not signed by
+// a key registered for the client, expired, replayed or issued for another audience.
+var ErrInvalidAssertion = errors.New("invalid client assertion")
+
+// assertionSigningMethods are the asymmetric JWS algorithms accepted for client assertions.
+// Symmetric algorithms are rejected as the client has no shared key registered.
+var assertionSigningMethods = []string{
+	"RS256", "RS384", "RS512",
+	"PS256", "PS384", "PS512",
+	"ES256", "ES384", "ES512",
+	"EdDSA",
+}
+
+// AssertionVerifier verifies JWT client assertions used for private_key_jwt
+// client authentication as defined in RFC 7523 Section 3.
+type AssertionVerifier struct {
+	audience string
+	replay   *ReplayCache
+	now      func() time.Time
+}
+
+// NewAssertionVerifier creates an AssertionVerifier that requires assertions to
+// be issued for audience, the URL of the token endpoint.
+func NewAssertionVerifier(audience string) *AssertionVerifier {
+	return &AssertionVerifier{
+		audience: audience,
+		replay:   NewReplayCache(),
+		now:      time.Now,
+	}
+}
+
+// Verify checks the client assertion and returns the authenticated client.
+// clientID is the optional client_id parameter of the request; if present it
+// must match the issuer of the assertion.
+func (v *AssertionVerifier) Verify(ctx context.Context, store userpool.ClientStore, clientID, assertion string) (userpool.Client, error) {
+	// The issuer identifies the client whose keys verify the signature
+	unverified := &jwt.RegisteredClaims{}
+	if _, _, err := jwt.NewParser().ParseUnverified(assertion, unverified); err != nil {
+		slog.Error(ErrInvalidAssertion.Error(), "error", err)
+		return userpool.Client{}, ErrInvalidAssertion
+	}
+	issuer := unverified.Issuer
+	if issuer == "" || (clientID != "" && clientID != issuer) {
+		slog.Error(ErrInvalidAssertion.Error(), "reason", "issuer does not match client_id", "iss", issuer, "client_id", clientID)
+		return userpool.Client{}, ErrInvalidAssertion
+	}
+
+	client, err := store.Lookup(ctx, issuer)
+	if errors.Is(err, userpool.ErrClientNotFound) {
+		slog.Error(ErrInvalidCredentials.Error(), "username", issuer)
+		return userpool.Client{}, ErrInvalidCredentials
+	}
+	if err != nil {
+		slog.Error(ErrClientStore.Error(), "username", issuer, "error", err)
+		return userpool.Client{}, fmt.Errorf("%w: %v", ErrClientStore, err)
+	}
+	if !client.Enabled {
+		slog.Error(ErrInvalidCredentials.Error(), "username", issuer, "reason", "client disabled")
+		return userpool.Client{}, ErrInvalidCredentials
+	}
+	if !client.AllowsAuthMethod(userpool.AuthMethodPrivateKeyJWT) {
+		slog.Error(ErrAuthMethodNotAllowed.Error(), "username", issuer, "method", userpool.AuthMethodPrivateKeyJWT)
+		return userpool.Client{}, ErrAuthMethodNotAllowed
+	}
+
+	keys, err := client.AssertionKeys()
+	if err != nil {
+		slog.Error(ErrInvalidAssertion.Error(), "username", issuer, "error", err)
+		return userpool.Client{}, ErrInvalidAssertion
+	}
+
+	claims := &jwt.RegisteredClaims{}
+	_, err = jwt.ParseWithClaims(assertion, claims, func(token *jwt.Token) (interface{}, error) {
+		return verificationKeys(token, keys)
+	},
+		jwt.WithValidMethods(assertionSigningMethods),
+		jwt.WithAudience(v.audience),
+		jwt.WithIssuer(client.ID),
+		jwt.WithSubject(client.ID),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(assertionLeeway),
+		jwt.WithTimeFunc(v.now),
+	)
+	if err != nil {
+		slog.Error(ErrInvalidAssertion.Error(), "username", issuer, "error", err)
+		return userpool.Client{}, ErrInvalidAssertion
+	}
+
+	if claims.ID == "" {
+		slog.Error(ErrInvalidAssertion.Error(), "username", issuer, "reason", "missing jti")
+		return userpool.Client{}, ErrInvalidAssertion
+	}
+	expiry := claims.ExpiresAt.Time
+	if expiry.After(v.now().Add(maxAssertionLifetime)) {
+		slog.Error(ErrInvalidAssertion.Error(), "username", issuer, "reason", "exp too far in the future")
+		return userpool.Client{}, ErrInvalidAssertion
+	}
+	if !v.replay.Add(client.ID+" "+claims.ID, expiry.Add(assertionLeeway)) {
+		slog.Error(ErrInvalidAssertion.Error(), "username", issuer, "reason", "jti already used", "jti", claims.ID)
+		return userpool.Client{}, ErrInvalidAssertion
+	}
+
+	return client, nil
+}
+
+// verificationKeys selects the registered keys that may have signed token.
+// If the assertion names a key ID only keys with that ID are tried.
+func verificationKeys(token *jwt.Token, keys []userpool.AssertionKey) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+
+	set := jwt.VerificationKeySet{}
+	for _, key := range keys {
+		if kid == "" || key.KeyID == "" || key.KeyID == kid {
+			set.Keys = append(set.Keys, key.Key)
+		}
+	}
+	if len(set.Keys) == 0 {
+		return nil, fmt.Errorf("no registered key matches kid %q", kid)
+	}
+	return set, nil
+}
+
+// ParseAssertionAuth authenticates a client using the private_key_jwt method,
+// i.e. a signed JWT in the client_assertion parameter of the form encoded
+// request body (RFC 7523 Section 2.2).
+func (ba *BasicAuth) ParseAssertionAuth(ctx context.Context, form url.Values, verifier *AssertionVerifier) error {
+	if form.Get("client_assertion_type") != ClientAssertionTypeJWTBearer || form.Get("client_assertion") == "" {
+		slog.Error(ErrInvalidAssertion.Error(), "client_assertion_type", form.Get("client_assertion_type"))
+		return ErrInvalidAssertion
+	}
+
+	client, err := verifier.Verify(ctx, ba.store, form.Get("client_id"), form.Get("client_assertion"))
+	if err != nil {
+		return err
+	}
+
+	ba.Username = client.ID
+	ba.Password = ""
+	ba.Client = client
+	ba.Method = userpool.AuthMethodPrivateKeyJWT
+	return nil
+}
+
+// ReplayCache remembers identifiers until they expire to detect replayed
+// client assertions. It is kept in memory, so every server instance detects
+// replays of assertions it has seen itself.
+type ReplayCache struct {
+	mu        sync.Mutex
+	seen      map[string]time.Time
+	lastPurge time.Time
+	now       func() time.Time
+}
+
+// NewReplayCache creates an empty ReplayCache.
+func NewReplayCache() *ReplayCache {
+	return &ReplayCache{seen: make(map[string]time.Time), now: time.Now}
+}
+
+// Add records id until expiry. It returns false if id was already recorded and has not expired yet.
+func (c *ReplayCache) Add(id string, expiry time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	if now.Sub(c.lastPurge) > time.Minute {
+		for seenID, seenExpiry := range c.seen {
+			if now.After(seenExpiry) {
+				delete(c.seen, seenID)
+			}
+		}
+		c.lastPurge = now
+	}
+
+	if seenExpiry, ok := c.seen[id]; ok && !now.After(seenExpiry) {
+		return false
+	}
+	c.seen[id] = expiry
+	return true
+}
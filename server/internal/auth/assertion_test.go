@@ -0,0 +1,323 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"net/url"
+	"oauth2-task/internal/userpool"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// publicKeyPEM encodes key as a PKIX PEM block.
+func publicKeyPEM(t *testing.T, key crypto.PublicKey) string {
+	t.Helper()
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		t.Fatalf("Failed to marshal public key: %v", err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+}
+
+// ecJWKS encodes a P-256 key as a JSON Web Key Set with the given key ID.
+func ecJWKS(t *testing.T, kid string, key *ecdsa.PublicKey) string {
+	t.Helper()
+	encoded, err := json.Marshal(map[string]interface{}{"keys": []map[string]string{{
+		"kty": "EC", "crv": "P-256", "kid": kid,
+		"x": base64.RawURLEncoding.EncodeToString(key.X.FillBytes(make([]byte, 32))),
+		"y": base64.RawURLEncoding.EncodeToString(key.Y.FillBytes(make([]byte, 32))),
+	}}})
+	if err != nil {
+		t.Fatalf("Failed to encode JWKS: %v", err)
+	}
+	return string(encoded)
+}
+
+// signAssertion signs claims as a client assertion with the given method, key and key ID.
+func signAssertion(t *testing.T, method jwt.SigningMethod, key crypto.Signer, kid string, claims jwt.RegisteredClaims) string {
+	t.Helper()
+	token := jwt.NewWithClaims(method, claims)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatalf("Failed to sign assertion: %v", err)
+	}
+	return signed
+}
+
+// assertionClaims returns valid client assertion claims for clientID.
+func assertionClaims(clientID, jti string) jwt.RegisteredClaims {
+	now := time.Now()
+	return jwt.RegisteredClaims{
+		Issuer:    clientID,
+		Subject:   clientID,
+		Audience:  jwt.ClaimStrings{testTokenEndpoint},
+		ExpiresAt: jwt.NewNumericDate(now.Add(5 * time.Minute)),
+		IssuedAt:  jwt.NewNumericDate(now),
+		ID:        jti,
+	}
+}
+
+func TestAssertionVerifier(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate RSA key: %v", err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate EC key: %v", err)
+	}
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate RSA key: %v", err)
+	}
+
+	hasher := testHasher(t)
+	store := userpool.NewMemoryStore(hasher,
+		userpool.Client{ID: "rsa-client", Enabled: true, AuthMethods: []string{userpool.AuthMethodPrivateKeyJWT}, PublicKey: publicKeyPEM(t, &rsaKey.PublicKey)},
+		userpool.Client{ID: "ec-client", Enabled: true, AuthMethods: []string{userpool.AuthMethodPrivateKeyJWT}, JWKS: ecJWKS(t, "ec-1", &ecKey.PublicKey)},
+		userpool.Client{ID: "secret-client", Enabled: true, SecretHash: mustHash(t, hasher, "secret"), PublicKey: publicKeyPEM(t, &rsaKey.PublicKey)},
+		userpool.Client{ID: "disabled-client", AuthMethods: []string{userpool.AuthMethodPrivateKeyJWT}, PublicKey: publicKeyPEM(t, &rsaKey.PublicKey)},
+	)
+
+	expired := assertionClaims("rsa-client", "expired")
+	expired.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-time.Hour))
+	noExpiry := assertionClaims("rsa-client", "no-exp")
+	noExpiry.ExpiresAt = nil
+	longLived := assertionClaims("rsa-client", "long-lived")
+	longLived.ExpiresAt = jwt.NewNumericDate(time.Now().Add(24 * time.Hour))
+	wrongAudience := assertionClaims("rsa-client", "wrong-aud")
+	wrongAudience.Audience = jwt.ClaimStrings{"https://other.example.com/token"}
+	wrongSubject := assertionClaims("rsa-client", "wrong-sub")
+	wrongSubject.Subject = "ec-client"
+
+	tests := []struct {
+		name      string
+		clientID  string
+		assertion string
+		wantID    string
+		wantErr   error
+	}{
+		{
+			name:      "RSA key registered as PEM",
+			assertion: signAssertion(t, jwt.SigningMethodRS256, rsaKey, "", assertionClaims("rsa-client", "jti-1")),
+			wantID:    "rsa-client",
+		},
+		{
+			name:      "EC key registered in JWKS",
+			assertion: signAssertion(t, jwt.SigningMethodES256, ecKey, "ec-1", assertionClaims("ec-client", "jti-1")),
+			wantID:    "ec-client",
+		},
+		{
+			name:      "matching client_id parameter",
+			clientID:  "rsa-client",
+			assertion: signAssertion(t, jwt.SigningMethodPS256, rsaKey, "", assertionClaims("rsa-client", "jti-2")),
+			wantID:    "rsa-client",
+		},
+		{
+			name:      "client_id parameter does not match issuer",
+			clientID:  "ec-client",
+			assertion: signAssertion(t, jwt.SigningMethodRS256, rsaKey, "", assertionClaims("rsa-client", "jti-3")),
+			wantErr:   ErrInvalidAssertion,
+		},
+		{
+			name:      "signed with unregistered key",
+			assertion: signAssertion(t, jwt.SigningMethodRS256, otherKey, "", assertionClaims("rsa-client", "jti-4")),
+			wantErr:   ErrInvalidAssertion,
+		},
+		{
+			name:      "unknown kid",
+			assertion: signAssertion(t, jwt.SigningMethodES256, ecKey, "ec-2", assertionClaims("ec-client", "jti-2")),
+			wantErr:   ErrInvalidAssertion,
+		},
+		{
+			name:      "expired",
+			assertion: signAssertion(t, jwt.SigningMethodRS256, rsaKey, "", expired),
+			wantErr:   ErrInvalidAssertion,
+		},
+		{
+			name:      "missing exp",
+			assertion: signAssertion(t, jwt.SigningMethodRS256, rsaKey, "", noExpiry),
+			wantErr:   ErrInvalidAssertion,
+		},
+		{
+			name:      "exp too far in the future",
+			assertion: signAssertion(t, jwt.SigningMethodRS256, rsaKey, "", longLived),
+			wantErr:   ErrInvalidAssertion,
+		},
+		{
+			name:      "wrong audience",
+			assertion: signAssertion(t, jwt.SigningMethodRS256, rsaKey, "", wrongAudience),
+			wantErr:   ErrInvalidAssertion,
+		},
+		{
+			name:      "subject does not match issuer",
+			assertion: signAssertion(t, jwt.SigningMethodRS256, rsaKey, "", wrongSubject),
+			wantErr:   ErrInvalidAssertion,
+		},
+		{
+			name:      "missing jti",
+			assertion: signAssertion(t, jwt.SigningMethodRS256, rsaKey, "", assertionClaims("rsa-client", "")),
+			wantErr:   ErrInvalidAssertion,
+		},
+		{
+			name: "HMAC signed",
+			assertion: func() string {
+				s, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, assertionClaims("rsa-client", "hmac")).SignedString([]byte("secret"))
+				return s
+			}(),
+			wantErr: ErrInvalidAssertion,
+		},
+		{
+			name: "unsigned",
+			assertion: func() string {
+				s, _ := jwt.NewWithClaims(jwt.SigningMethodNone, assertionClaims("rsa-client", "none")).SignedString(jwt.UnsafeAllowNoneSignatureType)
+				return s
+			}(),
+			wantErr: ErrInvalidAssertion,
+		},
+		{
+			name:      "malformed",
+			assertion: "not-a-jwt",
+			wantErr:   ErrInvalidAssertion,
+		},
+		{
+			name:      "unknown client",
+			assertion: signAssertion(t, jwt.SigningMethodRS256, rsaKey, "", assertionClaims("unknown", "jti-1")),
+			wantErr:   ErrInvalidCredentials,
+		},
+		{
+			name:      "disabled client",
+			assertion: signAssertion(t, jwt.SigningMethodRS256, rsaKey, "", assertionClaims("disabled-client", "jti-1")),
+			wantErr:   ErrInvalidCredentials,
+		},
+		{
+			name:      "client not registered for private_key_jwt",
+			assertion: signAssertion(t, jwt.SigningMethodRS256, rsaKey, "", assertionClaims("secret-client", "jti-1")),
+			wantErr:   ErrAuthMethodNotAllowed,
+		},
+	}
+
+	verifier := NewAssertionVerifier(testTokenEndpoint)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, err := verifier.Verify(context.Background(), store, tt.clientID, tt.assertion)
+			if err != tt.wantErr {
+				t.Fatalf("Verify() error = %v, want %v", err, tt.wantErr)
+			}
+			if client.ID != tt.wantID {
+				t.Errorf("Verify() client = %q, want %q", client.ID, tt.wantID)
+			}
+		})
+	}
+
+	t.Run("replayed assertion", func(t *testing.T) {
+		assertion := signAssertion(t, jwt.SigningMethodRS256, rsaKey, "", assertionClaims("rsa-client", "replay"))
+		if _, err := verifier.Verify(context.Background(), store, "", assertion); err != nil {
+			t.Fatalf("Verify() first use error = %v", err)
+		}
+		if _, err := verifier.Verify(context.Background(), store, "", assertion); err != ErrInvalidAssertion {
+			t.Errorf("Verify() replay error = %v, want %v", err, ErrInvalidAssertion)
+		}
+	})
+
+	t.Run("same jti for different clients", func(t *testing.T) {
+		first := signAssertion(t, jwt.SigningMethodRS256, rsaKey, "", assertionClaims("rsa-client", "shared"))
+		second := signAssertion(t, jwt.SigningMethodES256, ecKey, "ec-1", assertionClaims("ec-client", "shared"))
+		if _, err := verifier.Verify(context.Background(), store, "", first); err != nil {
+			t.Fatalf("Verify() error = %v", err)
+		}
+		if _, err := verifier.Verify(context.Background(), store, "", second); err != nil {
+			t.Errorf("Verify() error = %v, want jti to be scoped to the client", err)
+		}
+	})
+
+	t.Run("client store failure", func(t *testing.T) {
+		assertion := signAssertion(t, jwt.SigningMethodRS256, rsaKey, "", assertionClaims("rsa-client", "store"))
+		if _, err := verifier.Verify(context.Background(), failingStore{}, "", assertion); !errors.Is(err, ErrClientStore) {
+			t.Errorf("Verify() error = %v, want %v", err, ErrClientStore)
+		}
+	})
+}
+
+func TestParseAssertionAuth(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate RSA key: %v", err)
+	}
+	store := userpool.NewMemoryStore(testHasher(t),
+		userpool.Client{ID: "rsa-client", Enabled: true, AuthMethods: []string{userpool.AuthMethodPrivateKeyJWT}, PublicKey: publicKeyPEM(t, &rsaKey.PublicKey)},
+	)
+	verifier := NewAssertionVerifier(testTokenEndpoint)
+
+	t.Run("valid assertion", func(t *testing.T) {
+		ba := NewBasicAuth(store)
+		form := url.Values{
+			"client_assertion_type": {ClientAssertionTypeJWTBearer},
+			"client_assertion":      {signAssertion(t, jwt.SigningMethodRS256, rsaKey, "", assertionClaims("rsa-client", "jti-1"))},
+		}
+		if err := ba.ParseAssertionAuth(context.Background(), form, verifier); err != nil {
+			t.Fatalf("ParseAssertionAuth() error = %v", err)
+		}
+		if ba.Username != "rsa-client" || ba.Client.ID != "rsa-client" {
+			t.Errorf("ParseAssertionAuth() client = %q, want rsa-client", ba.Client.ID)
+		}
+		if ba.Method != userpool.AuthMethodPrivateKeyJWT {
+			t.Errorf("ParseAssertionAuth() method = %q, want %q", ba.Method, userpool.AuthMethodPrivateKeyJWT)
+		}
+	})
+
+	invalidForms := []struct {
+		name string
+		form url.Values
+	}{
+		{name: "missing assertion type", form: url.Values{"client_assertion": {"x.y.z"}}},
+		{name: "wrong assertion type", form: url.Values{"client_assertion_type": {"urn:ietf:params:oauth:client-assertion-type:saml2-bearer"}, "client_assertion": {"x.y.z"}}},
+		{name: "missing assertion", form: url.Values{"client_assertion_type": {ClientAssertionTypeJWTBearer}}},
+	}
+
+	for _, tt := range invalidForms {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := NewBasicAuth(store).ParseAssertionAuth(context.Background(), tt.form, verifier); err != ErrInvalidAssertion {
+				t.Errorf("ParseAssertionAuth() error = %v, want %v", err, ErrInvalidAssertion)
+			}
+		})
+	}
+}
+
+func TestReplayCache(t *testing.T) {
+	now := time.Now()
+	cache := NewReplayCache()
+	cache.now = func() time.Time { return now }
+
+	if !cache.Add("a", now.Add(time.Minute)) {
+		t.Fatal("Expected first use to be accepted")
+	}
+	if cache.Add("a", now.Add(time.Minute)) {
+		t.Error("Expected second use before expiry to be rejected")
+	}
+	if !cache.Add("b", now.Add(time.Minute)) {
+		t.Error("Expected different id to be accepted")
+	}
+
+	now = now.Add(2 * time.Minute)
+	if !cache.Add("a", now.Add(time.Minute)) {
+		t.Error("Expected id to be accepted again after expiry")
+	}
+	if _, ok := cache.seen["b"]; ok {
+		t.Error("Expected expired entries to be purged")
+	}
+}
@@ -1,9 +1,12 @@
 package auth
 
 import (
+	"context"
 	"encoding/base64"
 	"errors"
+	"fmt"
 	"log/slog"
+	"oauth2-task/internal/userpool"
 	"strings"
 )
 
@@ -14,21 +17,28 @@ var (
 	ErrInvalidBase64      = errors.New("invalid base64 encoding in credentials")
 	ErrInvalidAuthScheme  = errors.New("invalid authorization scheme")
 	ErrInvalidCredentials = errors.New("invalid username or password")
+	ErrClientStore        = errors.New("client store unavailable")
+	// ErrAuthMethodNotAllowed is returned when a client authenticates with a method it is not registered for.
+	ErrAuthMethodNotAllowed = errors.New("client authentication method not allowed")
+	// ErrMultipleAuthMethods is returned when a request carries credentials for more than one
+	// authentication method, which RFC 6749 Section 2.3 forbids.
+	ErrMultipleAuthMethods = errors.New("multiple client authentication methods used")
 )
 
-// userPool represents a collection of users and their credentials.
-type userPool map[string]string
-
 // BasicAuth represents basic authentication credentials.
 type BasicAuth struct {
 	Username string
 	Password string
-	pool     userPool
+	// Client is the client record of the authenticated client.
+	Client userpool.Client
+	// Method is the authentication method the client used, e.g. userpool.AuthMethodClientSecretBasic.
+	Method string
+	store  userpool.ClientStore
 }
 
-// NewBasicAuth creates a new BasicAuth instance with a user pool.
-func NewBasicAuth(pool userPool) *BasicAuth {
-	return &BasicAuth{pool: pool}
+// NewBasicAuth creates a new BasicAuth instance backed by a client store.
+func NewBasicAuth(store userpool.ClientStore) *BasicAuth {
+	return &BasicAuth{store: store}
 }
 
 // ErrorResponse represents an authentication error response.
@@ -60,6 +70,26 @@ func GetErrorResponse(err error) ErrorResponse {
 			Error:            "invalid_client",
 			ErrorDescription: "Invalid username or password",
 		}
+	case ErrAuthMethodNotAllowed:
+		return ErrorResponse{
+			Error:            "invalid_client",
+			ErrorDescription: "Client authentication method not allowed for this client",
+		}
+	case ErrInvalidAssertion:
+		return ErrorResponse{
+			Error:            "invalid_client",
+			ErrorDescription: "Invalid client assertion",
+		}
+	case ErrInvalidClientCertificate:
+		return ErrorResponse{
+			Error:            "invalid_client",
+			ErrorDescription: "Invalid client certificate",
+		}
+	case ErrMultipleAuthMethods:
+		return ErrorResponse{
+			Error:            "invalid_request",
+			ErrorDescription: "Only one client authentication method may be used per request",
+		}
 	default:
 		return ErrorResponse{
 			Error:            "invalid_client",
@@ -69,7 +99,9 @@ func GetErrorResponse(err error) ErrorResponse {
 }
 
 // ParseBasicAuth validates the Authorization header for Basic Auth.
-func (ba *BasicAuth) ParseBasicAuth(authHeader string) error {
+// Failures of the client store other than unknown clients or wrong secrets are
+// reported as ErrClientStore.
+func (ba *BasicAuth) ParseBasicAuth(ctx context.Context, authHeader string) error {
 	if authHeader == "" {
 		slog.Error(ErrMissingHeader.Error())
 		return ErrMissingHeader
@@ -95,16 +127,31 @@ func (ba *BasicAuth) ParseBasicAuth(authHeader string) error {
 		return ErrInvalidFormat
 	}
 
-	// Validate credentials against the user pool
-	storedPassword, exists := ba.pool[credentials[0]]
-	if !exists || storedPassword != credentials[1] {
-		slog.Error(ErrInvalidCredentials.Error(), "username", credentials[0])
+	return ba.verify(ctx, credentials[0], credentials[1], userpool.AuthMethodClientSecretBasic)
+}
+
+// verify checks the credentials against the client store and makes sure the
+// client is registered for the authentication method used.
+func (ba *BasicAuth) verify(ctx context.Context, clientID, secret, method string) error {
+	client, err := ba.store.VerifySecret(ctx, clientID, secret)
+	if errors.Is(err, userpool.ErrClientNotFound) || errors.Is(err, userpool.ErrInvalidSecret) || errors.Is(err, userpool.ErrClientDisabled) {
+		slog.Error(ErrInvalidCredentials.Error(), "username", clientID)
 		return ErrInvalidCredentials
 	}
+	if err != nil {
+		slog.Error(ErrClientStore.Error(), "username", clientID, "error", err)
+		return fmt.Errorf("%w: %v", ErrClientStore, err)
+	}
+	if !client.AllowsAuthMethod(method) {
+		slog.Error(ErrAuthMethodNotAllowed.Error(), "username", clientID, "method", method)
+		return ErrAuthMethodNotAllowed
+	}
 
 	// Store the validated credentials
-	ba.Username = credentials[0]
-	ba.Password = credentials[1]
+	ba.Username = clientID
+	ba.Password = secret
+	ba.Client = client
+	ba.Method = method
 
 	return nil
 }
@@ -1,16 +1,42 @@
 package auth
 
 import (
+	"context"
 	"encoding/base64"
+	"errors"
+	"oauth2-task/internal/userpool"
 	"testing"
 )
 
+// testHasher returns an argon2id hasher with minimal cost parameters to keep tests fast.
+func testHasher(t *testing.T) userpool.Hasher {
+	t.Helper()
+	hasher, err := userpool.ParseHasher("argon2id$m=1024,t=1,p=1")
+	if err != nil {
+		t.Fatalf("Failed to create hasher: %v", err)
+	}
+	return hasher
+}
+
+// mustHash hashes secret with hasher and fails the test on error.
+func mustHash(t *testing.T, hasher userpool.Hasher, secret string) string {
+	t.Helper()
+	hash, err := hasher.Hash(secret)
+	if err != nil {
+		t.Fatalf("Failed to hash secret: %v", err)
+	}
+	return hash
+}
+
 func TestParseBasicAuth(t *testing.T) {
 	// Setup test user pool
-	pool := map[string]string{
-		"testuser": "testpass",
-		"admin":    "adminpass",
-	}
+	hasher := testHasher(t)
+	pool := userpool.NewMemoryStore(hasher,
+		userpool.Client{ID: "testuser", SecretHash: mustHash(t, hasher, "testpass"), Enabled: true},
+		userpool.Client{ID: "admin", SecretHash: mustHash(t, hasher, "adminpass"), Enabled: true},
+		userpool.Client{ID: "disabled", SecretHash: mustHash(t, hasher, "disabledpass")},
+		userpool.Client{ID: "legacy", SecretHash: mustHash(t, hasher, "legacypass"), Enabled: true, AuthMethods: []string{userpool.AuthMethodClientSecretPost}},
+	)
 	ba := NewBasicAuth(pool)
 
 	tests := []struct {
@@ -57,6 +83,16 @@ func TestParseBasicAuth(t *testing.T) {
 			authHeader: "Basic " + base64.StdEncoding.EncodeToString([]byte("testuser:wrongpass")),
 			wantErr:    ErrInvalidCredentials,
 		},
+		{
+			name:       "Invalid credentials - disabled user",
+			authHeader: "Basic " + base64.StdEncoding.EncodeToString([]byte("disabled:disabledpass")),
+			wantErr:    ErrInvalidCredentials,
+		},
+		{
+			name:       "Client not registered for client_secret_basic",
+			authHeader: "Basic " + base64.StdEncoding.EncodeToString([]byte("legacy:legacypass")),
+			wantErr:    ErrAuthMethodNotAllowed,
+		},
 		{
 			name:       "Invalid credentials - non-existent user",
 			authHeader: "Basic " + base64.StdEncoding.EncodeToString([]byte("nonexistent:pass")),
@@ -66,7 +102,7 @@ func TestParseBasicAuth(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			err := ba.ParseBasicAuth(tt.authHeader)
+			err := ba.ParseBasicAuth(context.Background(), tt.authHeader)
 
 			// Check error
 			if err != tt.wantErr {
@@ -82,11 +118,41 @@ func TestParseBasicAuth(t *testing.T) {
 				if ba.Password != tt.wantPassword {
 					t.Errorf("ParseBasicAuth() password = %v, want %v", ba.Password, tt.wantPassword)
 				}
+				if ba.Client.ID != tt.wantUsername {
+					t.Errorf("ParseBasicAuth() client = %v, want %v", ba.Client.ID, tt.wantUsername)
+				}
+				if ba.Method != userpool.AuthMethodClientSecretBasic {
+					t.Errorf("ParseBasicAuth() method = %v, want %v", ba.Method, userpool.AuthMethodClientSecretBasic)
+				}
 			}
 		})
 	}
 }
 
+// failingStore is a ClientStore whose backend is unavailable.
+type failingStore struct{}
+
+func (failingStore) Lookup(context.Context, string) (userpool.Client, error) {
+	return userpool.Client{}, errors.New("connection refused")
+}
+
+func (failingStore) VerifySecret(context.Context, string, string) (userpool.Client, error) {
+	return userpool.Client{}, errors.New("connection refused")
+}
+
+func TestParseBasicAuthStoreFailure(t *testing.T) {
+	ba := NewBasicAuth(failingStore{})
+	authHeader := "Basic " + base64.StdEncoding.EncodeToString([]byte("testuser:testpass"))
+
+	err := ba.ParseBasicAuth(context.Background(), authHeader)
+	if !errors.Is(err, ErrClientStore) {
+		t.Errorf("ParseBasicAuth() error = %v, want %v", err, ErrClientStore)
+	}
+	if ba.Username != "" {
+		t.Errorf("ParseBasicAuth() username = %v, want empty", ba.Username)
+	}
+}
+
 func TestGetErrorResponse(t *testing.T) {
 	tests := []struct {
 		name      string
@@ -118,6 +184,24 @@ func TestGetErrorResponse(t *testing.T) {
 			wantError: "invalid_client",
 			wantDesc:  "Invalid username or password",
 		},
+		{
+			name:      "Auth method not allowed error",
+			err:       ErrAuthMethodNotAllowed,
+			wantError: "invalid_client",
+			wantDesc:  "Client authentication method not allowed for this client",
+		},
+		{
+			name:      "Multiple auth methods error",
+			err:       ErrMultipleAuthMethods,
+			wantError: "invalid_request",
+			wantDesc:  "Only one client authentication method may be used per request",
+		},
+		{
+			name:      "Invalid client certificate error",
+			err:       ErrInvalidClientCertificate,
+			wantError: "invalid_client",
+			wantDesc:  "Invalid client certificate",
+		},
 		{
 			name:      "Unknown error",
 			err:       ErrInvalidAuthScheme,
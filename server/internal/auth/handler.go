@@ -1,73 +1,227 @@
 package auth
 
 import (
+	"crypto/x509"
 	"encoding/json"
+	"errors"
 	"log/slog"
 	"net/http"
 	"oauth2-task/internal/request"
 	"oauth2-task/internal/token"
+	"oauth2-task/internal/userpool"
+	"strings"
+	"time"
 )
 
+// grantTypeClientCredentials is the only grant type supported by the token endpoint (RFC 6749 Section 4.4).
+const grantTypeClientCredentials = "client_credentials"
+
+// TokenLifetime configures the lifetime of issued access tokens.
+type TokenLifetime struct {
+	// Default applies to clients without a token TTL override.
+	Default time.Duration
+	// Max caps the token TTL overrides of clients. Zero means no cap.
+	Max time.Duration
+}
+
 // TokenResponse represents the OAuth2 token response.
 type TokenResponse struct {
 	AccessToken string `json:"access_token"`
 	TokenType   string `json:"token_type"`
 	ExpiresIn   int    `json:"expires_in"`
+	Scope       string `json:"scope,omitempty"`
+}
+
+// writeErrorResponse writes an OAuth2 error response with the given status code.
+func writeErrorResponse(w http.ResponseWriter, status int, errorResponse ErrorResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(errorResponse); err != nil {
+		slog.Error("Failed to encode error response", "error", err)
+	}
+}
+
+// parseTokenRequest parses the form encoded token request body as defined in RFC 6749 Section 4.4.2.
+// It returns the requested scopes or writes an error response and returns false.
+func parseTokenRequest(w http.ResponseWriter, r *http.Request) ([]string, bool) {
+	if err := r.ParseForm(); err != nil {
+		slog.Error("Failed to parse token request", "error", err)
+		writeErrorResponse(w, http.StatusBadRequest, ErrorResponse{
+			Error:            "invalid_request",
+			ErrorDescription: "Malformed request body",
+		})
+		return nil, false
+	}
+
+	grantType := r.PostForm.Get("grant_type")
+	if grantType == "" {
+		slog.Error("Missing grant_type in token request")
+		writeErrorResponse(w, http.StatusBadRequest, ErrorResponse{
+			Error:            "invalid_request",
+			ErrorDescription: "Missing grant_type parameter",
+		})
+		return nil, false
+	}
+	if grantType != grantTypeClientCredentials {
+		slog.Error("Unsupported grant type", "grant_type", grantType)
+		writeErrorResponse(w, http.StatusBadRequest, ErrorResponse{
+			Error:            "unsupported_grant_type",
+			ErrorDescription: "Only the client_credentials grant type is supported",
+		})
+		return nil, false
+	}
+
+	scopes, err := parseScope(r.PostForm.Get("scope"))
+	if err != nil {
+		slog.Error("Invalid scope in token request", "scope", r.PostForm.Get("scope"))
+		writeErrorResponse(w, http.StatusBadRequest, ErrorResponse{
+			Error:            "invalid_scope",
+			ErrorDescription: "Malformed scope parameter",
+		})
+		return nil, false
+	}
+
+	return scopes, true
+}
+
+// authenticateClient authenticates the client of a token request using the
+// credentials from the Basic Authorization header (client_secret_basic), the
+// client_secret (client_secret_post) or the client_assertion (private_key_jwt)
+// request body parameters. Requests carrying credentials for more than one
+// method are rejected as RFC 6749 Section 2.3 allows only one method per request.
+// Requests without any of these credentials that name a client_id and were sent
+// with a TLS client certificate use tls_client_auth or self_signed_tls_client_auth.
+// It writes an error response and returns false if authentication fails.
+func authenticateClient(w http.ResponseWriter, r *http.Request, basicAuth *BasicAuth, assertions *AssertionVerifier, clientCAs *x509.CertPool) bool {
+	if err := r.ParseForm(); err != nil {
+		slog.Error("Failed to parse token request", "error", err)
+		writeErrorResponse(w, http.StatusBadRequest, ErrorResponse{
+			Error:            "invalid_request",
+			ErrorDescription: "Malformed request body",
+		})
+		return false
+	}
+
+	hasHeader := r.Header.Get("Authorization") != ""
+	hasBodySecret := r.PostForm.Has("client_secret")
+	hasAssertion := r.PostForm.Has("client_assertion") || r.PostForm.Has("client_assertion_type")
+
+	methods := 0
+	for _, used := range []bool{hasHeader, hasBodySecret, hasAssertion} {
+		if used {
+			methods++
+		}
+	}
+
+	var err error
+	switch {
+	case methods > 1:
+		slog.Error(ErrMultipleAuthMethods.Error())
+		writeErrorResponse(w, http.StatusBadRequest, GetErrorResponse(ErrMultipleAuthMethods))
+		return false
+	case hasAssertion:
+		err = basicAuth.ParseAssertionAuth(r.Context(), r.PostForm, assertions)
+	case hasBodySecret:
+		err = basicAuth.ParsePostAuth(r.Context(), r.PostForm)
+	case methods == 0 && r.PostForm.Get("client_id") != "" && len(peerCertificates(r)) > 0:
+		err = basicAuth.ParseTLSClientAuth(r.Context(), r.PostForm, peerCertificates(r), clientCAs)
+	default:
+		if !request.ValidateAuthorization(w, r, "Basic") {
+			return false
+		}
+		err = basicAuth.ParseBasicAuth(r.Context(), r.Header.Get("Authorization"))
+	}
+
+	if errors.Is(err, ErrClientStore) {
+		writeErrorResponse(w, http.StatusInternalServerError, ErrorResponse{
+			Error:            "server_error",
+			ErrorDescription: "Failed to authenticate client",
+		})
+		slog.Error("Authentication failed", "error", err)
+		return false
+	}
+	if err != nil {
+		writeErrorResponse(w, http.StatusUnauthorized, GetErrorResponse(err))
+		slog.Error("Authentication failed", "error", err)
+		return false
+	}
+	return true
 }
 
 // HandleToken processes OAuth2 token requests.
-func HandleToken(keyPair token.KeyPair, userPool map[string]string) http.HandlerFunc {
+// Tokens are signed with the current signing key of keys.
+// Client assertions for private_key_jwt authentication are verified by assertions.
+// Client certificates for tls_client_auth must chain to one of clientCAs, which may be nil
+// if only self-signed certificates are accepted.
+// Tokens expire after the client's token TTL, bounded by lifetime.
+func HandleToken(keys token.KeySource, clientStore userpool.ClientStore, assertions *AssertionVerifier, clientCAs *x509.CertPool, lifetime TokenLifetime) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if !request.ValidateMethod(w, r, http.MethodPost) {
 			return
 		}
 
-		// Create BasicAuth instance with user pool
-		basicAuth := NewBasicAuth(userPool)
+		// Create BasicAuth instance with the client store
+		basicAuth := NewBasicAuth(clientStore)
 
-		// Validate Basic Auth
-		if !request.ValidateAuthorization(w, r, "Basic") {
+		// Authenticate the client with client_secret_basic, client_secret_post, private_key_jwt or mutual TLS
+		if !authenticateClient(w, r, basicAuth, assertions, clientCAs) {
 			return
 		}
 
-		// Parse Basic Auth credentials
-		if err := basicAuth.ParseBasicAuth(r.Header.Get("Authorization")); err != nil {
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusUnauthorized)
-			errorResponse := GetErrorResponse(err)
-			if err := json.NewEncoder(w).Encode(errorResponse); err != nil {
-				slog.Error("Failed to encode error response", "error", err)
-				return
-			}
-			slog.Error("Authentication failed", "error", err)
+		// Parse grant type and scope from the request body
+		requestedScopes, ok := parseTokenRequest(w, r)
+		if !ok {
 			return
 		}
 
-		// Create token generator
-		generator := token.NewGenerator(keyPair.PrivateKey())
+		// Restrict the grant to the scopes the client is allowed to request
+		scopes, err := basicAuth.Client.ResolveScopes(requestedScopes)
+		if err != nil {
+			writeErrorResponse(w, http.StatusBadRequest, ErrorResponse{
+				Error:            "invalid_scope",
+				ErrorDescription: "Requested scope is not allowed for this client",
+			})
+			slog.Error("Scope not allowed", "client_id", basicAuth.Username, "scope", requestedScopes)
+			return
+		}
+		scope := strings.Join(scopes, " ")
+
+		// Create token generator for the current signing key
+		keySet := keys.KeySet()
+		keyID, signingKey := keySet.SigningKey()
+		generator := token.NewGenerator(signingKey.Signer(), keyID, keySet.SigningMethod())
+
+		// Bind the token to the client certificate presented on the connection (RFC 8705 Section 3)
+		var confirmation *token.Confirmation
+		if certs := peerCertificates(r); len(certs) > 0 {
+			confirmation = token.NewCertificateConfirmation(certs[0])
+		}
 
-		// Generate a real JWT token
-		tokenString, err := generator.GenerateToken(basicAuth.Username)
+		// Issue an RFC 9068 access token carrying the client's audiences and static claims
+		tokenString, expiresAt, err := generator.GenerateToken(token.Grant{
+			Username:     basicAuth.Username,
+			ClientID:     basicAuth.Client.ID,
+			Scope:        scope,
+			Audience:     basicAuth.Client.Audiences,
+			Claims:       basicAuth.Client.Claims,
+			Confirmation: confirmation,
+			Lifetime:     basicAuth.Client.ResolveTokenTTL(lifetime.Default, lifetime.Max),
+		})
 		if err != nil {
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusInternalServerError)
-			err := json.NewEncoder(w).Encode(ErrorResponse{
+			writeErrorResponse(w, http.StatusInternalServerError, ErrorResponse{
 				Error:            "server_error",
 				ErrorDescription: "Failed to generate token",
 			})
-			if err != nil {
-				slog.Error("Failed to generate Error Response for token generation", "error", err)
-				return
-			}
 			slog.Error("Failed to generate token", "error", err)
 			return
 		}
 
-		// Return the token response
+		// Return the token response with expires_in derived from the exp claim of the token
 		response := TokenResponse{
 			AccessToken: tokenString,
 			TokenType:   "Bearer",
-			ExpiresIn:   3600,
+			ExpiresIn:   int(time.Until(expiresAt).Round(time.Second) / time.Second),
+			Scope:       scope,
 		}
 
 		w.Header().Set("Content-Type", "application/json")
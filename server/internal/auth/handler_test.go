@@ -0,0 +1,487 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"oauth2-task/internal/token"
+	"oauth2-task/internal/userpool"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// testTokenEndpoint is the token endpoint URL client assertions are issued for in tests.
+const testTokenEndpoint = "https://auth.example.com/token"
+
+// testTokenLifetime is the token lifetime configuration of the token endpoint in tests.
+var testTokenLifetime = TokenLifetime{Default: time.Hour, Max: 24 * time.Hour}
+
+// setupTestKeyPair creates a test RSA key pair for testing.
+func setupTestKeyPair(t *testing.T) token.KeyPair {
+	t.Helper()
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate test key pair: %v", err)
+	}
+	keyPair, err := token.ParsePrivateKey(x509.MarshalPKCS1PrivateKey(privateKey))
+	if err != nil {
+		t.Fatalf("Failed to parse private key: %v", err)
+	}
+	return keyPair
+}
+
+// setupTestKeySet creates a key set signing with keyPair.
+func setupTestKeySet(t *testing.T, keyPair token.KeyPair, previous ...token.PublicKey) *token.KeySet {
+	t.Helper()
+	keys, err := token.NewKeySet(keyPair, previous...)
+	if err != nil {
+		t.Fatalf("Failed to create key set: %v", err)
+	}
+	return keys
+}
+
+// newTokenRequest creates a form encoded token request authenticated with the given credentials.
+func newTokenRequest(t *testing.T, credentials string, form url.Values) *http.Request {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodPost, "/token", strings.NewReader(form.Encode()))
+	if err != nil {
+		t.Fatalf("Failed to create test request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if credentials != "" {
+		req.Header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(credentials)))
+	}
+	return req
+}
+
+func TestHandleToken(t *testing.T) {
+	keyPair := setupTestKeyPair(t)
+	hasher := testHasher(t)
+	clientKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate client key: %v", err)
+	}
+	pool := userpool.NewMemoryStore(hasher, userpool.Client{
+		ID:            "testuser",
+		SecretHash:    mustHash(t, hasher, "testpass"),
+		Enabled:       true,
+		AllowedScopes: []string{"read", "write", "admin"},
+		DefaultScopes: []string{"read"},
+		Audiences:     []string{"https://api.example.com"},
+		Claims:        map[string]any{"tenant": "acme"},
+	}, userpool.Client{
+		ID:            "legacy",
+		SecretHash:    mustHash(t, hasher, "legacypass"),
+		Enabled:       true,
+		AllowedScopes: []string{"read"},
+		DefaultScopes: []string{"read"},
+		AuthMethods:   []string{userpool.AuthMethodClientSecretPost},
+	}, userpool.Client{
+		ID:         "short-lived",
+		SecretHash: mustHash(t, hasher, "shortpass"),
+		Enabled:    true,
+		TokenTTL:   5 * time.Minute,
+	}, userpool.Client{
+		ID:         "long-lived",
+		SecretHash: mustHash(t, hasher, "longpass"),
+		Enabled:    true,
+		TokenTTL:   48 * time.Hour,
+	}, userpool.Client{
+		ID:            "jwt-client",
+		Enabled:       true,
+		AllowedScopes: []string{"read"},
+		AuthMethods:   []string{userpool.AuthMethodPrivateKeyJWT},
+		PublicKey:     publicKeyPEM(t, &clientKey.PublicKey),
+	})
+	handler := HandleToken(setupTestKeySet(t, keyPair), pool, NewAssertionVerifier(testTokenEndpoint), nil, testTokenLifetime)
+
+	t.Run("rejects non-POST requests", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodGet, "/token", nil)
+		if err != nil {
+			t.Fatalf("Failed to create test request: %v", err)
+		}
+
+		w := newMockResponseWriter()
+		handler(w, req)
+
+		if w.statusCode != http.StatusMethodNotAllowed {
+			t.Errorf("Expected status %d, got %d", http.StatusMethodNotAllowed, w.statusCode)
+		}
+	})
+
+	t.Run("rejects invalid credentials", func(t *testing.T) {
+		req := newTokenRequest(t, "testuser:wrongpass", url.Values{"grant_type": {"client_credentials"}})
+
+		w := newMockResponseWriter()
+		handler(w, req)
+
+		if w.statusCode != http.StatusUnauthorized {
+			t.Errorf("Expected status %d, got %d", http.StatusUnauthorized, w.statusCode)
+		}
+	})
+
+	t.Run("reports client store failures as server error", func(t *testing.T) {
+		req := newTokenRequest(t, "testuser:testpass", url.Values{"grant_type": {"client_credentials"}})
+
+		w := newMockResponseWriter()
+		HandleToken(setupTestKeySet(t, keyPair), failingStore{}, NewAssertionVerifier(testTokenEndpoint), nil, testTokenLifetime)(w, req)
+
+		if w.statusCode != http.StatusInternalServerError {
+			t.Errorf("Expected status %d, got %d", http.StatusInternalServerError, w.statusCode)
+		}
+		var errorResponse ErrorResponse
+		if err := json.Unmarshal(w.body, &errorResponse); err != nil {
+			t.Fatalf("Failed to decode error response: %v", err)
+		}
+		if errorResponse.Error != "server_error" {
+			t.Errorf("Expected error server_error, got %q", errorResponse.Error)
+		}
+	})
+
+	errorTests := []struct {
+		name      string
+		form      url.Values
+		wantError string
+	}{
+		{
+			name:      "missing grant_type",
+			form:      url.Values{},
+			wantError: "invalid_request",
+		},
+		{
+			name:      "unsupported grant_type",
+			form:      url.Values{"grant_type": {"password"}},
+			wantError: "unsupported_grant_type",
+		},
+		{
+			name:      "malformed scope",
+			form:      url.Values{"grant_type": {"client_credentials"}, "scope": {`read "write"`}},
+			wantError: "invalid_scope",
+		},
+		{
+			name:      "scope not allowed for client",
+			form:      url.Values{"grant_type": {"client_credentials"}, "scope": {"read delete"}},
+			wantError: "invalid_scope",
+		},
+	}
+
+	for _, tt := range errorTests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := newTokenRequest(t, "testuser:testpass", tt.form)
+
+			w := newMockResponseWriter()
+			handler(w, req)
+
+			if w.statusCode != http.StatusBadRequest {
+				t.Errorf("Expected status %d, got %d", http.StatusBadRequest, w.statusCode)
+			}
+
+			var errorResponse ErrorResponse
+			if err := json.Unmarshal(w.body, &errorResponse); err != nil {
+				t.Fatalf("Failed to decode error response: %v", err)
+			}
+			if errorResponse.Error != tt.wantError {
+				t.Errorf("Expected error %q, got %q", tt.wantError, errorResponse.Error)
+			}
+		})
+	}
+
+	authMethodTests := []struct {
+		name        string
+		credentials string
+		form        url.Values
+		wantStatus  int
+		wantError   string
+		wantSubject string
+	}{
+		{
+			name:        "client_secret_post issues token",
+			form:        url.Values{"grant_type": {"client_credentials"}, "client_id": {"legacy"}, "client_secret": {"legacypass"}},
+			wantStatus:  http.StatusOK,
+			wantSubject: "legacy",
+		},
+		{
+			name:       "client_secret_post with wrong secret",
+			form:       url.Values{"grant_type": {"client_credentials"}, "client_id": {"legacy"}, "client_secret": {"wrong"}},
+			wantStatus: http.StatusUnauthorized,
+			wantError:  "invalid_client",
+		},
+		{
+			name:       "client_secret_post for client registered for client_secret_basic",
+			form:       url.Values{"grant_type": {"client_credentials"}, "client_id": {"testuser"}, "client_secret": {"testpass"}},
+			wantStatus: http.StatusUnauthorized,
+			wantError:  "invalid_client",
+		},
+		{
+			name:        "client_secret_basic for client registered for client_secret_post",
+			credentials: "legacy:legacypass",
+			form:        url.Values{"grant_type": {"client_credentials"}},
+			wantStatus:  http.StatusUnauthorized,
+			wantError:   "invalid_client",
+		},
+		{
+			name:        "both authentication methods at once",
+			credentials: "testuser:testpass",
+			form:        url.Values{"grant_type": {"client_credentials"}, "client_id": {"testuser"}, "client_secret": {"testpass"}},
+			wantStatus:  http.StatusBadRequest,
+			wantError:   "invalid_request",
+		},
+		{
+			name: "private_key_jwt issues token",
+			form: url.Values{
+				"grant_type":            {"client_credentials"},
+				"client_assertion_type": {ClientAssertionTypeJWTBearer},
+				"client_assertion":      {signAssertion(t, jwt.SigningMethodRS256, clientKey, "", assertionClaims("jwt-client", "handler-1"))},
+			},
+			wantStatus:  http.StatusOK,
+			wantSubject: "jwt-client",
+		},
+		{
+			name: "private_key_jwt with assertion for another audience",
+			form: url.Values{
+				"grant_type":            {"client_credentials"},
+				"client_assertion_type": {ClientAssertionTypeJWTBearer},
+				"client_assertion": {signAssertion(t, jwt.SigningMethodRS256, clientKey, "", func() jwt.RegisteredClaims {
+					claims := assertionClaims("jwt-client", "handler-2")
+					claims.Audience = jwt.ClaimStrings{"https://other.example.com/token"}
+					return claims
+				}())},
+			},
+			wantStatus: http.StatusUnauthorized,
+			wantError:  "invalid_client",
+		},
+		{
+			name: "private_key_jwt and client_secret_post at once",
+			form: url.Values{
+				"grant_type":            {"client_credentials"},
+				"client_id":             {"legacy"},
+				"client_secret":         {"legacypass"},
+				"client_assertion_type": {ClientAssertionTypeJWTBearer},
+				"client_assertion":      {signAssertion(t, jwt.SigningMethodRS256, clientKey, "", assertionClaims("jwt-client", "handler-3"))},
+			},
+			wantStatus: http.StatusBadRequest,
+			wantError:  "invalid_request",
+		},
+		{
+			name:        "private_key_jwt and Basic header at once",
+			credentials: "testuser:testpass",
+			form: url.Values{
+				"grant_type":            {"client_credentials"},
+				"client_assertion_type": {ClientAssertionTypeJWTBearer},
+				"client_assertion":      {signAssertion(t, jwt.SigningMethodRS256, clientKey, "", assertionClaims("jwt-client", "handler-4"))},
+			},
+			wantStatus: http.StatusBadRequest,
+			wantError:  "invalid_request",
+		},
+		{
+			name:        "client_id in body alongside Basic header",
+			credentials: "testuser:testpass",
+			form:        url.Values{"grant_type": {"client_credentials"}, "client_id": {"testuser"}},
+			wantStatus:  http.StatusOK,
+			wantSubject: "testuser",
+		},
+	}
+
+	for _, tt := range authMethodTests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := newTokenRequest(t, tt.credentials, tt.form)
+
+			w := newMockResponseWriter()
+			handler(w, req)
+
+			if tt.wantStatus == http.StatusOK {
+				if w.statusCode != 0 && w.statusCode != http.StatusOK {
+					t.Fatalf("Expected status %d, got %d", http.StatusOK, w.statusCode)
+				}
+				var response TokenResponse
+				if err := json.Unmarshal(w.body, &response); err != nil {
+					t.Fatalf("Failed to decode token response: %v", err)
+				}
+				claims := &token.Claims{}
+				if _, err := jwt.ParseWithClaims(response.AccessToken, claims, func(_ *jwt.Token) (interface{}, error) {
+					return keyPair.PublicKey(), nil
+				}); err != nil {
+					t.Fatalf("Failed to parse access token: %v", err)
+				}
+				if claims.Subject != tt.wantSubject {
+					t.Errorf("Expected subject %s, got %s", tt.wantSubject, claims.Subject)
+				}
+				return
+			}
+
+			if w.statusCode != tt.wantStatus {
+				t.Errorf("Expected status %d, got %d", tt.wantStatus, w.statusCode)
+			}
+			var errorResponse ErrorResponse
+			if err := json.Unmarshal(w.body, &errorResponse); err != nil {
+				t.Fatalf("Failed to decode error response: %v", err)
+			}
+			if errorResponse.Error != tt.wantError {
+				t.Errorf("Expected error %q, got %q", tt.wantError, errorResponse.Error)
+			}
+		})
+	}
+
+	t.Run("rejects requests without credentials", func(t *testing.T) {
+		req := newTokenRequest(t, "", url.Values{"grant_type": {"client_credentials"}})
+
+		w := newMockResponseWriter()
+		handler(w, req)
+
+		if w.statusCode != http.StatusUnauthorized {
+			t.Errorf("Expected status %d, got %d", http.StatusUnauthorized, w.statusCode)
+		}
+	})
+
+	t.Run("ignores grant_type in query string", func(t *testing.T) {
+		req := newTokenRequest(t, "testuser:testpass", url.Values{})
+		req.URL.RawQuery = "grant_type=client_credentials"
+
+		w := newMockResponseWriter()
+		handler(w, req)
+
+		if w.statusCode != http.StatusBadRequest {
+			t.Errorf("Expected status %d, got %d", http.StatusBadRequest, w.statusCode)
+		}
+	})
+
+	t.Run("signs with the algorithm of the key set", func(t *testing.T) {
+		pssKeys, err := setupTestKeySet(t, keyPair).WithRSAAlgorithm("PS384")
+		if err != nil {
+			t.Fatalf("WithRSAAlgorithm() error = %v", err)
+		}
+		req := newTokenRequest(t, "testuser:testpass", url.Values{"grant_type": {"client_credentials"}})
+
+		w := newMockResponseWriter()
+		HandleToken(pssKeys, pool, NewAssertionVerifier(testTokenEndpoint), nil, testTokenLifetime)(w, req)
+
+		var response TokenResponse
+		if err := json.Unmarshal(w.body, &response); err != nil {
+			t.Fatalf("Failed to decode token response: %v", err)
+		}
+		if _, err := jwt.Parse(response.AccessToken, func(_ *jwt.Token) (interface{}, error) {
+			return keyPair.PublicKey(), nil
+		}, jwt.WithValidMethods([]string{"PS384"})); err != nil {
+			t.Errorf("Expected a PS384 signed token: %v", err)
+		}
+	})
+
+	successTests := []struct {
+		name      string
+		scope     string
+		wantScope string
+	}{
+		{name: "issues token with default scope", scope: "", wantScope: "read"},
+		{name: "issues token with requested scope", scope: "read  write read", wantScope: "read write"},
+		{name: "issues token with downscoped scope", scope: "admin", wantScope: "admin"},
+	}
+
+	for _, tt := range successTests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{"grant_type": {"client_credentials"}}
+			if tt.scope != "" {
+				form.Set("scope", tt.scope)
+			}
+			req := newTokenRequest(t, "testuser:testpass", form)
+
+			w := newMockResponseWriter()
+			handler(w, req)
+
+			if w.statusCode != 0 && w.statusCode != http.StatusOK {
+				t.Fatalf("Expected status %d, got %d", http.StatusOK, w.statusCode)
+			}
+
+			var response TokenResponse
+			if err := json.Unmarshal(w.body, &response); err != nil {
+				t.Fatalf("Failed to decode token response: %v", err)
+			}
+			if response.TokenType != "Bearer" {
+				t.Errorf("Expected token type Bearer, got %s", response.TokenType)
+			}
+			if response.Scope != tt.wantScope {
+				t.Errorf("Expected response scope %q, got %q", tt.wantScope, response.Scope)
+			}
+
+			claims := &token.Claims{}
+			parsedToken, err := jwt.ParseWithClaims(response.AccessToken, claims, func(_ *jwt.Token) (interface{}, error) {
+				return keyPair.PublicKey(), nil
+			})
+			if err != nil {
+				t.Fatalf("Failed to parse access token: %v", err)
+			}
+			if parsedToken.Header["kid"] != token.KeyID(keyPair.PublicKey()) {
+				t.Errorf("Expected kid header %s, got %v", token.KeyID(keyPair.PublicKey()), parsedToken.Header["kid"])
+			}
+			if claims.Subject != "testuser" {
+				t.Errorf("Expected subject testuser, got %s", claims.Subject)
+			}
+			if claims.Scope != tt.wantScope {
+				t.Errorf("Expected token scope %q, got %q", tt.wantScope, claims.Scope)
+			}
+			if parsedToken.Header["typ"] != token.TokenTypeAccessToken {
+				t.Errorf("Expected typ header %s, got %v", token.TokenTypeAccessToken, parsedToken.Header["typ"])
+			}
+			if claims.ClientID != "testuser" {
+				t.Errorf("Expected client_id testuser, got %s", claims.ClientID)
+			}
+			if len(claims.Audience) != 1 || claims.Audience[0] != "https://api.example.com" {
+				t.Errorf("Expected audience https://api.example.com, got %v", claims.Audience)
+			}
+			if claims.ID == "" {
+				t.Error("Expected jti claim to be set")
+			}
+			if claims.Extra["tenant"] != "acme" {
+				t.Errorf("Expected tenant claim acme, got %v", claims.Extra["tenant"])
+			}
+		})
+	}
+
+	lifetimeTests := []struct {
+		name        string
+		credentials string
+		want        time.Duration
+	}{
+		{name: "default lifetime", credentials: "testuser:testpass", want: time.Hour},
+		{name: "client lifetime override", credentials: "short-lived:shortpass", want: 5 * time.Minute},
+		{name: "client lifetime capped to maximum", credentials: "long-lived:longpass", want: 24 * time.Hour},
+	}
+
+	for _, tt := range lifetimeTests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := newTokenRequest(t, tt.credentials, url.Values{"grant_type": {"client_credentials"}})
+
+			w := newMockResponseWriter()
+			handler(w, req)
+
+			if w.statusCode != 0 && w.statusCode != http.StatusOK {
+				t.Fatalf("Expected status %d, got %d", http.StatusOK, w.statusCode)
+			}
+			var response TokenResponse
+			if err := json.Unmarshal(w.body, &response); err != nil {
+				t.Fatalf("Failed to decode token response: %v", err)
+			}
+			claims := &token.Claims{}
+			if _, err := jwt.ParseWithClaims(response.AccessToken, claims, func(_ *jwt.Token) (interface{}, error) {
+				return keyPair.PublicKey(), nil
+			}); err != nil {
+				t.Fatalf("Failed to parse access token: %v", err)
+			}
+
+			if got := claims.ExpiresAt.Sub(claims.IssuedAt.Time); got != tt.want {
+				t.Errorf("Expected token lifetime %v, got %v", tt.want, got)
+			}
+			// expires_in counts from the response, so it may be a second short of the lifetime
+			wantExpiresIn := int(tt.want / time.Second)
+			if response.ExpiresIn > wantExpiresIn || response.ExpiresIn < wantExpiresIn-1 {
+				t.Errorf("Expected expires_in %d, got %d", wantExpiresIn, response.ExpiresIn)
+			}
+		})
+	}
+}
@@ -0,0 +1,140 @@
+package auth
+
+import (
+	"crypto/x509"
+	"errors"
+	"log/slog"
+	"net/http"
+	"oauth2-task/internal/revocation"
+	"oauth2-task/internal/token"
+	"oauth2-task/internal/userpool"
+	"strings"
+)
+
+// ScopeIntrospect is the scope an access token needs to authenticate a caller of the introspection endpoint.
+const ScopeIntrospect = "introspect"
+
+// IntrospectionAuthenticator authenticates callers of the introspection
+// endpoint (RFC 7662 Section 2.1). Callers either authenticate as a client
+// with the methods of the token endpoint, or present an access token with the
+// introspect scope in a Bearer Authorization header (RFC 6750 Section 2.1).
+type IntrospectionAuthenticator struct {
+	keys        token.KeySource
+	clientStore userpool.ClientStore
+	assertions  *AssertionVerifier
+	clientCAs   *x509.CertPool
+	revocations revocation.Store
+}
+
+// NewIntrospectionAuthenticator creates an IntrospectionAuthenticator that
+// authenticates clients against clientStore like HandleToken, and verifies
+// bearer tokens with keys unless they are recorded in revocations.
+func NewIntrospectionAuthenticator(keys token.KeySource, clientStore userpool.ClientStore, assertions *AssertionVerifier,
+	clientCAs *x509.CertPool, revocations revocation.Store) *IntrospectionAuthenticator {
+	return &IntrospectionAuthenticator{
+		keys:        keys,
+		clientStore: clientStore,
+		assertions:  assertions,
+		clientCAs:   clientCAs,
+		revocations: revocations,
+	}
+}
+
+// AuthenticateCaller returns the caller of r with the audiences it may introspect.
+// It writes an error response and returns false if authentication fails.
+func (a *IntrospectionAuthenticator) AuthenticateCaller(w http.ResponseWriter, r *http.Request) (token.Caller, bool) {
+	if scheme, credentials, found := strings.Cut(r.Header.Get("Authorization"), " "); found && strings.EqualFold(scheme, "Bearer") {
+		return a.authenticateBearer(w, r, credentials)
+	}
+
+	basicAuth := NewBasicAuth(a.clientStore)
+	if !authenticateClient(w, r, basicAuth, a.assertions, a.clientCAs) {
+		return token.Caller{}, false
+	}
+	return introspectionCaller(w, basicAuth.Client)
+}
+
+// authenticateBearer authenticates the caller with an access token issued by
+// this server. The token must be valid, not revoked and carry the introspect scope.
+func (a *IntrospectionAuthenticator) authenticateBearer(w http.ResponseWriter, r *http.Request, accessToken string) (token.Caller, bool) {
+	claims, err := token.VerifyToken(accessToken, a.keys.KeySet())
+	if err != nil {
+		slog.Error("Invalid bearer token for introspection", "error", err)
+		writeBearerError(w, http.StatusUnauthorized, "invalid_token", "Invalid access token")
+		return token.Caller{}, false
+	}
+
+	if claims.ID != "" {
+		revoked, err := a.revocations.IsRevoked(r.Context(), claims.ID)
+		if err != nil {
+			slog.Error("Failed to check bearer token revocation", "error", err)
+			writeErrorResponse(w, http.StatusInternalServerError, ErrorResponse{
+				Error:            "server_error",
+				ErrorDescription: "Failed to authenticate caller",
+			})
+			return token.Caller{}, false
+		}
+		if revoked {
+			slog.Error("Revoked bearer token for introspection", "client_id", claims.IssuedTo())
+			writeBearerError(w, http.StatusUnauthorized, "invalid_token", "Invalid access token")
+			return token.Caller{}, false
+		}
+	}
+
+	if !hasScope(claims.Scope, ScopeIntrospect) {
+		slog.Error("Bearer token lacks the introspect scope", "client_id", claims.IssuedTo(), "scope", claims.Scope)
+		writeBearerError(w, http.StatusForbidden, "insufficient_scope", "Access token lacks the introspect scope")
+		return token.Caller{}, false
+	}
+
+	// The client record decides which tokens the caller may introspect
+	client, err := a.clientStore.Lookup(r.Context(), claims.IssuedTo())
+	if errors.Is(err, userpool.ErrClientNotFound) || (err == nil && !client.Enabled) {
+		slog.Error("Bearer token of unknown or disabled client", "client_id", claims.IssuedTo())
+		writeBearerError(w, http.StatusUnauthorized, "invalid_token", "Invalid access token")
+		return token.Caller{}, false
+	}
+	if err != nil {
+		slog.Error("Failed to look up introspection caller", "error", err)
+		writeErrorResponse(w, http.StatusInternalServerError, ErrorResponse{
+			Error:            "server_error",
+			ErrorDescription: "Failed to authenticate caller",
+		})
+		return token.Caller{}, false
+	}
+	return introspectionCaller(w, client)
+}
+
+// introspectionCaller returns the introspection caller for client, with the key
+// JWT introspection responses are encrypted to if the client asked for encryption.
+// It writes a server error and returns false if the client's key cannot be used.
+func introspectionCaller(w http.ResponseWriter, client userpool.Client) (token.Caller, bool) {
+	caller := token.Caller{ClientID: client.ID, Audiences: client.IntrospectAudiences}
+	if client.IntrospectionEncryptedResponseAlg == "" {
+		return caller, true
+	}
+
+	key, err := client.EncryptionKey()
+	if err != nil {
+		slog.Error("Failed to load introspection response encryption key", "client_id", client.ID, "error", err)
+		writeErrorResponse(w, http.StatusInternalServerError, ErrorResponse{
+			Error:            "server_error",
+			ErrorDescription: "Failed to authenticate caller",
+		})
+		return token.Caller{}, false
+	}
+	caller.Encryption = &token.Encryption{
+		KeyID: key.KeyID,
+		Key:   key.Key,
+		Alg:   client.IntrospectionEncryptedResponseAlg,
+		Enc:   client.IntrospectionEncryptionEnc(),
+	}
+	return caller, true
+}
+
+// writeBearerError writes an error response for a rejected bearer token
+// together with the WWW-Authenticate challenge of RFC 6750 Section 3.
+func writeBearerError(w http.ResponseWriter, status int, code, description string) {
+	w.Header().Set("WWW-Authenticate", `Bearer error="`+code+`", error_description="`+description+`"`)
+	writeErrorResponse(w, status, ErrorResponse{Error: code, ErrorDescription: description})
+}
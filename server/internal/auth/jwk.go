@@ -1,11 +1,14 @@
 // Package auth implements OAuth2 authentication endpoints and JWKS functionality.
-// It provides handlers for token issuance (RFC 6749), token introspection (RFC 7662),
-// and JSON Web Key Set (JWKS) endpoints (RFC 7517). The package handles Basic
+// It provides handlers for token issuance (RFC 6749), token revocation (RFC 7009),
+// token introspection (RFC 7662), and JSON Web Key Set (JWKS) endpoints (RFC 7517). The package handles Basic
 // Authentication, JWT token generation, token validation, and exposes public keys
 // in JWKS format.
 package auth
 
 import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
 	"encoding/base64"
 	"encoding/json"
 	"log/slog"
@@ -16,13 +19,18 @@ import (
 )
 
 // JWK represents a JSON Web Key as defined in RFC 7517.
+// RSA keys carry n and e, EC keys crv, x and y (RFC 7518 Section 6), and
+// Ed25519 keys crv and x (RFC 8037 Section 2).
 type JWK struct {
 	Kty string `json:"kty"`
 	Use string `json:"use"`
 	Kid string `json:"kid"`
 	Alg string `json:"alg"`
-	N   string `json:"n"`
-	E   string `json:"e"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
 }
 
 // JWKS represents a JSON Web Key Set as defined in RFC 7517.
@@ -31,7 +39,8 @@ type JWKS struct {
 }
 
 // HandleJWKS returns the JSON Web Key Set for the server.
-func HandleJWKS(keyPair token.KeyPair) http.HandlerFunc {
+// Every verification key of keys is published so tokens signed with previous keys stay verifiable.
+func HandleJWKS(keys token.KeySource) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		slog.Info("Received JWKS request", "method", r.Method, "path", r.URL.Path)
 
@@ -39,13 +48,17 @@ func HandleJWKS(keyPair token.KeyPair) http.HandlerFunc {
 			return
 		}
 
-		writeJWKSResponse(w, keyPair)
+		if keys == nil {
+			writeJWKSResponse(w, nil)
+			return
+		}
+		writeJWKSResponse(w, keys.KeySet())
 	}
 }
 
 // writeJWKSResponse writes the JWKS response to the given http.ResponseWriter.
-func writeJWKSResponse(w http.ResponseWriter, keyPair token.KeyPair) {
-	if keyPair == nil {
+func writeJWKSResponse(w http.ResponseWriter, keys *token.KeySet) {
+	if keys == nil {
 		slog.Error("Invalid key pair")
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusBadRequest)
@@ -57,8 +70,9 @@ func writeJWKSResponse(w http.ResponseWriter, keyPair token.KeyPair) {
 		return
 	}
 
-	jwks := JWKS{
-		Keys: []JWK{convertToJWK(keyPair)},
+	jwks := JWKS{Keys: []JWK{}}
+	for _, key := range keys.VerificationKeys() {
+		jwks.Keys = append(jwks.Keys, convertToJWK(key))
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -71,14 +85,30 @@ func writeJWKSResponse(w http.ResponseWriter, keyPair token.KeyPair) {
 	slog.Info("Successfully sent JWKS response")
 }
 
-// convertToJWK converts a KeyPair to JWK format.
-func convertToJWK(keyPair token.KeyPair) JWK {
-	return JWK{
-		Kty: "RSA",
-		Use: "sig",
-		Kid: "1", // TODO: Implement proper key ID generation
-		Alg: "RS256",
-		N:   base64.RawURLEncoding.EncodeToString(keyPair.PublicKey().N.Bytes()),
-		E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(keyPair.PublicKey().E)).Bytes()),
+// convertToJWK converts a verification key to JWK format.
+// The alg member is the algorithm registered for the key, so verifiers can pin it.
+func convertToJWK(key token.VerificationKey) JWK {
+	jwk := JWK{Use: "sig", Kid: key.KeyID}
+	if key.Method != nil {
+		jwk.Alg = key.Method.Alg()
+	}
+
+	switch publicKey := key.PublicKey.(type) {
+	case *rsa.PublicKey:
+		jwk.Kty = "RSA"
+		jwk.N = base64.RawURLEncoding.EncodeToString(publicKey.N.Bytes())
+		jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(publicKey.E)).Bytes())
+	case *ecdsa.PublicKey:
+		// Coordinates are padded to the size of the curve (RFC 7518 Section 6.2.1.2)
+		size := (publicKey.Curve.Params().BitSize + 7) / 8
+		jwk.Kty = "EC"
+		jwk.Crv = publicKey.Curve.Params().Name
+		jwk.X = base64.RawURLEncoding.EncodeToString(publicKey.X.FillBytes(make([]byte, size)))
+		jwk.Y = base64.RawURLEncoding.EncodeToString(publicKey.Y.FillBytes(make([]byte, size)))
+	case ed25519.PublicKey:
+		jwk.Kty = "OKP"
+		jwk.Crv = "Ed25519"
+		jwk.X = base64.RawURLEncoding.EncodeToString(publicKey)
 	}
+	return jwk
 }
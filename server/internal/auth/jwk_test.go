@@ -1,6 +1,9 @@
 package auth
 
 import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
@@ -50,7 +53,16 @@ func TestHandleJWKS(t *testing.T) {
 		t.Fatalf("Failed to parse private key: %v", err)
 	}
 
-	handler := HandleJWKS(keyPair)
+	previousKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate test key pair: %v", err)
+	}
+	keys, err := token.NewKeySet(keyPair, &previousKey.PublicKey)
+	if err != nil {
+		t.Fatalf("Failed to create key set: %v", err)
+	}
+
+	handler := HandleJWKS(keys)
 
 	t.Run("rejects non-GET requests", func(t *testing.T) {
 		methods := []string{http.MethodPost, http.MethodPut, http.MethodDelete, http.MethodPatch}
@@ -93,8 +105,11 @@ func TestHandleJWKS(t *testing.T) {
 			t.Fatalf("Failed to decode JWKS response: %v", err)
 		}
 
-		if len(jwks.Keys) != 1 {
-			t.Fatalf("Expected 1 key in JWKS, got %d", len(jwks.Keys))
+		if len(jwks.Keys) != 2 {
+			t.Fatalf("Expected 2 keys in JWKS, got %d", len(jwks.Keys))
+		}
+		if jwks.Keys[1].Kid != token.KeyID(&previousKey.PublicKey) {
+			t.Errorf("Expected previous key with kid %s, got %s", token.KeyID(&previousKey.PublicKey), jwks.Keys[1].Kid)
 		}
 
 		jwk := jwks.Keys[0]
@@ -104,8 +119,8 @@ func TestHandleJWKS(t *testing.T) {
 		if jwk.Use != "sig" {
 			t.Errorf("Expected use sig, got %s", jwk.Use)
 		}
-		if jwk.Kid != "1" {
-			t.Errorf("Expected kid 1, got %s", jwk.Kid)
+		if jwk.Kid != token.KeyID(keyPair.PublicKey()) {
+			t.Errorf("Expected kid %s, got %s", token.KeyID(keyPair.PublicKey()), jwk.Kid)
 		}
 		if jwk.Alg != "RS256" {
 			t.Errorf("Expected alg RS256, got %s", jwk.Alg)
@@ -159,8 +174,13 @@ func TestConvertToJWK(t *testing.T) {
 		t.Fatalf("Failed to parse private key: %v", err)
 	}
 
+	keys, err := token.NewKeySet(keyPair)
+	if err != nil {
+		t.Fatalf("Failed to create key set: %v", err)
+	}
+
 	t.Run("converts RSA public key to JWK format", func(t *testing.T) {
-		jwk := convertToJWK(keyPair)
+		jwk := convertToJWK(keys.VerificationKeys()[0])
 
 		if jwk.Kty != "RSA" {
 			t.Errorf("Expected kty RSA, got %s", jwk.Kty)
@@ -168,8 +188,8 @@ func TestConvertToJWK(t *testing.T) {
 		if jwk.Use != "sig" {
 			t.Errorf("Expected use sig, got %s", jwk.Use)
 		}
-		if jwk.Kid != "1" {
-			t.Errorf("Expected kid 1, got %s", jwk.Kid)
+		if jwk.Kid != token.KeyID(keyPair.PublicKey()) {
+			t.Errorf("Expected kid %s, got %s", token.KeyID(keyPair.PublicKey()), jwk.Kid)
 		}
 		if jwk.Alg != "RS256" {
 			t.Errorf("Expected alg RS256, got %s", jwk.Alg)
@@ -182,9 +202,19 @@ func TestConvertToJWK(t *testing.T) {
 		}
 	})
 
+	t.Run("alg reflects the configured RSA algorithm", func(t *testing.T) {
+		pssKeys, err := keys.WithRSAAlgorithm("PS256")
+		if err != nil {
+			t.Fatalf("WithRSAAlgorithm() error = %v", err)
+		}
+		if jwk := convertToJWK(pssKeys.VerificationKeys()[0]); jwk.Alg != "PS256" {
+			t.Errorf("Expected alg PS256, got %s", jwk.Alg)
+		}
+	})
+
 	t.Run("returns consistent JWK for same key", func(t *testing.T) {
-		jwk1 := convertToJWK(keyPair)
-		jwk2 := convertToJWK(keyPair)
+		jwk1 := convertToJWK(keys.VerificationKeys()[0])
+		jwk2 := convertToJWK(keys.VerificationKeys()[0])
 
 		if jwk1.N != jwk2.N {
 			t.Error("Expected same N value for same key")
@@ -194,3 +224,63 @@ func TestConvertToJWK(t *testing.T) {
 		}
 	})
 }
+
+func TestConvertToJWKKeyTypes(t *testing.T) {
+	p256Key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate EC key: %v", err)
+	}
+	p384Key, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate EC key: %v", err)
+	}
+	edPublicKey, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate Ed25519 key: %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		publicKey token.PublicKey
+		wantKty   string
+		wantCrv   string
+		wantAlg   string
+		wantY     bool
+	}{
+		{name: "P-256", publicKey: &p256Key.PublicKey, wantKty: "EC", wantCrv: "P-256", wantAlg: "ES256", wantY: true},
+		{name: "P-384", publicKey: &p384Key.PublicKey, wantKty: "EC", wantCrv: "P-384", wantAlg: "ES384", wantY: true},
+		{name: "Ed25519", publicKey: edPublicKey, wantKty: "OKP", wantCrv: "Ed25519", wantAlg: "EdDSA"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			method, err := token.SigningMethod(tt.publicKey)
+			if err != nil {
+				t.Fatalf("SigningMethod() error = %v", err)
+			}
+			jwk := convertToJWK(token.VerificationKey{KeyID: token.KeyID(tt.publicKey), PublicKey: tt.publicKey, Method: method})
+			if jwk.Kty != tt.wantKty || jwk.Crv != tt.wantCrv || jwk.Alg != tt.wantAlg {
+				t.Errorf("convertToJWK() = %+v, want kty %s, crv %s, alg %s", jwk, tt.wantKty, tt.wantCrv, tt.wantAlg)
+			}
+			if jwk.X == "" || (jwk.Y != "") != tt.wantY {
+				t.Errorf("convertToJWK() coordinates x=%q y=%q", jwk.X, jwk.Y)
+			}
+			if jwk.N != "" || jwk.E != "" {
+				t.Errorf("Expected no RSA members, got n=%q e=%q", jwk.N, jwk.E)
+			}
+
+			// The published JWK must be usable by a verifier: it serializes without RSA members
+			encoded, err := json.Marshal(jwk)
+			if err != nil {
+				t.Fatalf("Failed to encode JWK: %v", err)
+			}
+			var members map[string]string
+			if err := json.Unmarshal(encoded, &members); err != nil {
+				t.Fatalf("Failed to decode JWK: %v", err)
+			}
+			if _, ok := members["n"]; ok {
+				t.Errorf("Expected n to be omitted, got %s", encoded)
+			}
+		})
+	}
+}
@@ -0,0 +1,133 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"oauth2-task/internal/userpool"
+	"slices"
+)
+
+// ErrInvalidClientCertificate is returned when the TLS client certificate does not
+// authenticate the client named in the request (RFC 8705 Section 2).
+var ErrInvalidClientCertificate = errors.New("invalid client certificate")
+
+// peerCertificates returns the certificate chain the client presented during
+// the TLS handshake, leaf first. It is empty for plain HTTP requests and TLS
+// connections without a client certificate.
+func peerCertificates(r *http.Request) []*x509.Certificate {
+	if r.TLS == nil {
+		return nil
+	}
+	return r.TLS.PeerCertificates
+}
+
+// ParseTLSClientAuth authenticates a client using mutual TLS as defined in
+// RFC 8705 Section 2. The client is identified by the client_id request
+// parameter and authenticated by the certificate chain it presented:
+//   - tls_client_auth: the chain must verify against clientCAs and the leaf
+//     certificate must match the registered subject DN or SAN.
+//   - self_signed_tls_client_auth: the leaf certificate's public key must be
+//     one of the keys registered for the client.
+func (ba *BasicAuth) ParseTLSClientAuth(ctx context.Context, form url.Values, certs []*x509.Certificate, clientCAs *x509.CertPool) error {
+	clientID := form.Get("client_id")
+	if clientID == "" || len(certs) == 0 {
+		slog.Error(ErrInvalidCredentials.Error(), "method", userpool.AuthMethodTLSClientAuth)
+		return ErrInvalidCredentials
+	}
+
+	client, err := ba.store.Lookup(ctx, clientID)
+	if errors.Is(err, userpool.ErrClientNotFound) {
+		slog.Error(ErrInvalidCredentials.Error(), "username", clientID)
+		return ErrInvalidCredentials
+	}
+	if err != nil {
+		slog.Error(ErrClientStore.Error(), "username", clientID, "error", err)
+		return fmt.Errorf("%w: %v", ErrClientStore, err)
+	}
+	if !client.Enabled {
+		slog.Error(ErrInvalidCredentials.Error(), "username", clientID, "reason", "client disabled")
+		return ErrInvalidCredentials
+	}
+
+	pki := client.AllowsAuthMethod(userpool.AuthMethodTLSClientAuth)
+	selfSigned := client.AllowsAuthMethod(userpool.AuthMethodSelfSignedTLSClientAuth)
+	if !pki && !selfSigned {
+		slog.Error(ErrAuthMethodNotAllowed.Error(), "username", clientID, "method", userpool.AuthMethodTLSClientAuth)
+		return ErrAuthMethodNotAllowed
+	}
+
+	var method string
+	switch {
+	case pki && verifyPKICertificate(client, certs, clientCAs):
+		method = userpool.AuthMethodTLSClientAuth
+	case selfSigned && verifySelfSignedCertificate(client, certs[0]):
+		method = userpool.AuthMethodSelfSignedTLSClientAuth
+	default:
+		slog.Error(ErrInvalidClientCertificate.Error(), "username", clientID, "subject", certs[0].Subject.String())
+		return ErrInvalidClientCertificate
+	}
+
+	ba.Username = client.ID
+	ba.Password = ""
+	ba.Client = client
+	ba.Method = method
+	return nil
+}
+
+// verifyPKICertificate reports whether the certificate chain verifies against
+// clientCAs and the leaf certificate matches the subject registered for client.
+func verifyPKICertificate(client userpool.Client, certs []*x509.Certificate, clientCAs *x509.CertPool) bool {
+	if clientCAs == nil {
+		return false
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+	leaf := certs[0]
+	if _, err := leaf.Verify(x509.VerifyOptions{
+		Roots:         clientCAs,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}); err != nil {
+		slog.Error("Client certificate chain verification failed", "username", client.ID, "error", err)
+		return false
+	}
+
+	switch {
+	case client.TLSClientAuthSubjectDN != "":
+		return leaf.Subject.String() == client.TLSClientAuthSubjectDN
+	case client.TLSClientAuthSANDNS != "":
+		return slices.Contains(leaf.DNSNames, client.TLSClientAuthSANDNS)
+	default:
+		return false
+	}
+}
+
+// verifySelfSignedCertificate reports whether the public key of cert is registered for client.
+// As defined in RFC 8705 Section 2.2 the certificate chain is not validated.
+func verifySelfSignedCertificate(client userpool.Client, cert *x509.Certificate) bool {
+	keys, err := client.AssertionKeys()
+	if err != nil {
+		slog.Error("Failed to parse registered client keys", "username", client.ID, "error", err)
+		return false
+	}
+
+	certKey, ok := cert.PublicKey.(interface{ Equal(crypto.PublicKey) bool })
+	if !ok {
+		return false
+	}
+	for _, key := range keys {
+		if certKey.Equal(key.Key) {
+			return true
+		}
+	}
+	return false
+}
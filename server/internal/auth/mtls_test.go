@@ -0,0 +1,262 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/url"
+	"oauth2-task/internal/token"
+	"oauth2-task/internal/userpool"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// testCertificate holds a generated certificate and its private key.
+type testCertificate struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+// newTestCertificate creates a certificate for template signed by parent, or a
+// self-signed certificate if parent is nil.
+func newTestCertificate(t *testing.T, template *x509.Certificate, parent *testCertificate) testCertificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate certificate key: %v", err)
+	}
+	template.SerialNumber = big.NewInt(time.Now().UnixNano())
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+
+	issuer, signer := template, crypto.Signer(key)
+	if parent != nil {
+		issuer, signer = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, issuer, &key.PublicKey, signer)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("Failed to parse certificate: %v", err)
+	}
+	return testCertificate{cert: cert, key: key}
+}
+
+// newTestCA creates a self-signed CA certificate.
+func newTestCA(t *testing.T) testCertificate {
+	t.Helper()
+	return newTestCertificate(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, nil)
+}
+
+// newClientCertificate creates a client certificate for commonName and dnsNames signed by ca.
+func newClientCertificate(t *testing.T, ca testCertificate, commonName string, dnsNames ...string) testCertificate {
+	t.Helper()
+	return newTestCertificate(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: commonName, Organization: []string{"Example"}},
+		DNSNames:    dnsNames,
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, &ca)
+}
+
+func TestParseTLSClientAuth(t *testing.T) {
+	ca := newTestCA(t)
+	otherCA := newTestCA(t)
+	dnCert := newClientCertificate(t, ca, "dn-client")
+	sanCert := newClientCertificate(t, ca, "san-client", "san.example.com")
+	untrustedCert := newClientCertificate(t, otherCA, "dn-client")
+	selfSigned := newTestCertificate(t, &x509.Certificate{Subject: pkix.Name{CommonName: "self-signed"}}, nil)
+	otherSelfSigned := newTestCertificate(t, &x509.Certificate{Subject: pkix.Name{CommonName: "self-signed"}}, nil)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+
+	store := userpool.NewMemoryStore(testHasher(t), userpool.Client{
+		ID:                     "dn-client",
+		Enabled:                true,
+		AuthMethods:            []string{userpool.AuthMethodTLSClientAuth},
+		TLSClientAuthSubjectDN: dnCert.cert.Subject.String(),
+	}, userpool.Client{
+		ID:                  "san-client",
+		Enabled:             true,
+		AuthMethods:         []string{userpool.AuthMethodTLSClientAuth},
+		TLSClientAuthSANDNS: "san.example.com",
+	}, userpool.Client{
+		ID:          "self-signed-client",
+		Enabled:     true,
+		AuthMethods: []string{userpool.AuthMethodSelfSignedTLSClientAuth},
+		PublicKey:   publicKeyPEM(t, &selfSigned.key.PublicKey),
+	}, userpool.Client{
+		ID:                     "disabled",
+		AuthMethods:            []string{userpool.AuthMethodTLSClientAuth},
+		TLSClientAuthSubjectDN: dnCert.cert.Subject.String(),
+	}, userpool.Client{
+		ID:         "secret-client",
+		SecretHash: mustHash(t, testHasher(t), "secret"),
+		Enabled:    true,
+	})
+
+	tests := []struct {
+		name       string
+		clientID   string
+		certs      []*x509.Certificate
+		roots      *x509.CertPool
+		wantErr    error
+		wantMethod string
+	}{
+		{name: "subject DN", clientID: "dn-client", certs: []*x509.Certificate{dnCert.cert}, roots: roots, wantMethod: userpool.AuthMethodTLSClientAuth},
+		{name: "SAN DNS", clientID: "san-client", certs: []*x509.Certificate{sanCert.cert}, roots: roots, wantMethod: userpool.AuthMethodTLSClientAuth},
+		{name: "self-signed", clientID: "self-signed-client", certs: []*x509.Certificate{selfSigned.cert}, wantMethod: userpool.AuthMethodSelfSignedTLSClientAuth},
+		{name: "subject DN mismatch", clientID: "dn-client", certs: []*x509.Certificate{sanCert.cert}, roots: roots, wantErr: ErrInvalidClientCertificate},
+		{name: "SAN DNS mismatch", clientID: "san-client", certs: []*x509.Certificate{dnCert.cert}, roots: roots, wantErr: ErrInvalidClientCertificate},
+		{name: "untrusted CA", clientID: "dn-client", certs: []*x509.Certificate{untrustedCert.cert}, roots: roots, wantErr: ErrInvalidClientCertificate},
+		{name: "no client CAs configured", clientID: "dn-client", certs: []*x509.Certificate{dnCert.cert}, wantErr: ErrInvalidClientCertificate},
+		{name: "self-signed with unregistered key", clientID: "self-signed-client", certs: []*x509.Certificate{otherSelfSigned.cert}, wantErr: ErrInvalidClientCertificate},
+		{name: "disabled client", clientID: "disabled", certs: []*x509.Certificate{dnCert.cert}, roots: roots, wantErr: ErrInvalidCredentials},
+		{name: "unknown client", clientID: "unknown", certs: []*x509.Certificate{dnCert.cert}, roots: roots, wantErr: ErrInvalidCredentials},
+		{name: "client without mutual TLS method", clientID: "secret-client", certs: []*x509.Certificate{dnCert.cert}, roots: roots, wantErr: ErrAuthMethodNotAllowed},
+		{name: "missing client_id", certs: []*x509.Certificate{dnCert.cert}, roots: roots, wantErr: ErrInvalidCredentials},
+		{name: "missing certificate", clientID: "dn-client", roots: roots, wantErr: ErrInvalidCredentials},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ba := NewBasicAuth(store)
+			err := ba.ParseTLSClientAuth(context.Background(), url.Values{"client_id": {tt.clientID}}, tt.certs, tt.roots)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ParseTLSClientAuth() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+			if ba.Username != tt.clientID {
+				t.Errorf("ParseTLSClientAuth() username = %v, want %v", ba.Username, tt.clientID)
+			}
+			if ba.Method != tt.wantMethod {
+				t.Errorf("ParseTLSClientAuth() method = %v, want %v", ba.Method, tt.wantMethod)
+			}
+		})
+	}
+
+	t.Run("store failure", func(t *testing.T) {
+		ba := NewBasicAuth(failingStore{})
+		err := ba.ParseTLSClientAuth(context.Background(), url.Values{"client_id": {"dn-client"}}, []*x509.Certificate{dnCert.cert}, roots)
+		if !errors.Is(err, ErrClientStore) {
+			t.Errorf("ParseTLSClientAuth() error = %v, want %v", err, ErrClientStore)
+		}
+	})
+}
+
+func TestHandleTokenMutualTLS(t *testing.T) {
+	keyPair := setupTestKeyPair(t)
+	hasher := testHasher(t)
+	ca := newTestCA(t)
+	clientCert := newClientCertificate(t, ca, "mtls-client")
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+
+	pool := userpool.NewMemoryStore(hasher, userpool.Client{
+		ID:                     "mtls-client",
+		Enabled:                true,
+		AllowedScopes:          []string{"read"},
+		AuthMethods:            []string{userpool.AuthMethodTLSClientAuth},
+		TLSClientAuthSubjectDN: clientCert.cert.Subject.String(),
+	}, userpool.Client{
+		ID:            "testuser",
+		SecretHash:    mustHash(t, hasher, "testpass"),
+		Enabled:       true,
+		AllowedScopes: []string{"read"},
+	})
+	handler := HandleToken(setupTestKeySet(t, keyPair), pool, NewAssertionVerifier(testTokenEndpoint), roots, testTokenLifetime)
+
+	// issue sends a token request over a mutual TLS connection and returns the access token claims.
+	issue := func(t *testing.T, credentials string, form url.Values, certs ...*x509.Certificate) (*mockResponseWriter, *token.Claims) {
+		t.Helper()
+		req := newTokenRequest(t, credentials, form)
+		req.TLS = &tls.ConnectionState{PeerCertificates: certs}
+
+		w := newMockResponseWriter()
+		handler(w, req)
+		if w.statusCode != 0 && w.statusCode != http.StatusOK {
+			return w, nil
+		}
+
+		var response TokenResponse
+		if err := json.Unmarshal(w.body, &response); err != nil {
+			t.Fatalf("Failed to decode token response: %v", err)
+		}
+		claims := &token.Claims{}
+		if _, err := jwt.ParseWithClaims(response.AccessToken, claims, func(_ *jwt.Token) (interface{}, error) {
+			return keyPair.PublicKey(), nil
+		}); err != nil {
+			t.Fatalf("Failed to parse access token: %v", err)
+		}
+		return w, claims
+	}
+
+	t.Run("tls_client_auth issues certificate-bound token", func(t *testing.T) {
+		_, claims := issue(t, "", url.Values{"grant_type": {"client_credentials"}, "client_id": {"mtls-client"}}, clientCert.cert)
+		if claims == nil {
+			t.Fatal("Expected token to be issued")
+		}
+		if claims.Subject != "mtls-client" {
+			t.Errorf("Expected subject mtls-client, got %s", claims.Subject)
+		}
+		if claims.Confirmation == nil || claims.Confirmation.X5tS256 != token.CertificateThumbprint(clientCert.cert) {
+			t.Errorf("Expected cnf claim with certificate thumbprint, got %+v", claims.Confirmation)
+		}
+	})
+
+	t.Run("secret client presenting a certificate gets certificate-bound token", func(t *testing.T) {
+		_, claims := issue(t, "testuser:testpass", url.Values{"grant_type": {"client_credentials"}}, clientCert.cert)
+		if claims == nil {
+			t.Fatal("Expected token to be issued")
+		}
+		if claims.Confirmation == nil || claims.Confirmation.X5tS256 != token.CertificateThumbprint(clientCert.cert) {
+			t.Errorf("Expected cnf claim with certificate thumbprint, got %+v", claims.Confirmation)
+		}
+	})
+
+	t.Run("token without client certificate is not bound", func(t *testing.T) {
+		_, claims := issue(t, "testuser:testpass", url.Values{"grant_type": {"client_credentials"}})
+		if claims == nil {
+			t.Fatal("Expected token to be issued")
+		}
+		if claims.Confirmation != nil {
+			t.Errorf("Expected no cnf claim, got %+v", claims.Confirmation)
+		}
+	})
+
+	t.Run("rejects certificate of another subject", func(t *testing.T) {
+		otherCert := newClientCertificate(t, ca, "other-client")
+		w, _ := issue(t, "", url.Values{"grant_type": {"client_credentials"}, "client_id": {"mtls-client"}}, otherCert.cert)
+		if w.statusCode != http.StatusUnauthorized {
+			t.Errorf("Expected status %d, got %d", http.StatusUnauthorized, w.statusCode)
+		}
+		var errorResponse ErrorResponse
+		if err := json.Unmarshal(w.body, &errorResponse); err != nil {
+			t.Fatalf("Failed to decode error response: %v", err)
+		}
+		if errorResponse.Error != "invalid_client" {
+			t.Errorf("Expected error invalid_client, got %q", errorResponse.Error)
+		}
+	})
+}
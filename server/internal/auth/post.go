@@ -0,0 +1,22 @@
+package auth
+
+import (
+	"context"
+	"log/slog"
+	"net/url"
+	"oauth2-task/internal/userpool"
+)
+
+// ParsePostAuth authenticates a client using the client_secret_post method,
+// i.e. the client_id and client_secret parameters of the form encoded request
+// body (RFC 6749 Section 2.3.1). The client must be registered for
+// userpool.AuthMethodClientSecretPost.
+func (ba *BasicAuth) ParsePostAuth(ctx context.Context, form url.Values) error {
+	clientID := form.Get("client_id")
+	if clientID == "" || !form.Has("client_secret") {
+		slog.Error(ErrInvalidCredentials.Error(), "method", userpool.AuthMethodClientSecretPost)
+		return ErrInvalidCredentials
+	}
+
+	return ba.verify(ctx, clientID, form.Get("client_secret"), userpool.AuthMethodClientSecretPost)
+}
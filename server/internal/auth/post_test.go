@@ -0,0 +1,75 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"oauth2-task/internal/userpool"
+	"testing"
+)
+
+func TestParsePostAuth(t *testing.T) {
+	hasher := testHasher(t)
+	pool := userpool.NewMemoryStore(hasher,
+		userpool.Client{ID: "legacy", SecretHash: mustHash(t, hasher, "legacypass"), Enabled: true, AuthMethods: []string{userpool.AuthMethodClientSecretPost}},
+		userpool.Client{ID: "basic-only", SecretHash: mustHash(t, hasher, "basicpass"), Enabled: true},
+	)
+
+	tests := []struct {
+		name    string
+		form    url.Values
+		wantErr error
+	}{
+		{
+			name: "Valid credentials",
+			form: url.Values{"client_id": {"legacy"}, "client_secret": {"legacypass"}},
+		},
+		{
+			name:    "Wrong secret",
+			form:    url.Values{"client_id": {"legacy"}, "client_secret": {"wrong"}},
+			wantErr: ErrInvalidCredentials,
+		},
+		{
+			name:    "Missing client_id",
+			form:    url.Values{"client_secret": {"legacypass"}},
+			wantErr: ErrInvalidCredentials,
+		},
+		{
+			name:    "Missing client_secret",
+			form:    url.Values{"client_id": {"legacy"}},
+			wantErr: ErrInvalidCredentials,
+		},
+		{
+			name:    "Client not registered for client_secret_post",
+			form:    url.Values{"client_id": {"basic-only"}, "client_secret": {"basicpass"}},
+			wantErr: ErrAuthMethodNotAllowed,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ba := NewBasicAuth(pool)
+			err := ba.ParsePostAuth(context.Background(), tt.form)
+			if err != tt.wantErr {
+				t.Fatalf("ParsePostAuth() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil {
+				if ba.Username != "legacy" || ba.Client.ID != "legacy" {
+					t.Errorf("ParsePostAuth() client = %v, want legacy", ba.Client.ID)
+				}
+				if ba.Method != userpool.AuthMethodClientSecretPost {
+					t.Errorf("ParsePostAuth() method = %v, want %v", ba.Method, userpool.AuthMethodClientSecretPost)
+				}
+			} else if ba.Username != "" {
+				t.Errorf("ParsePostAuth() username = %v, want empty", ba.Username)
+			}
+		})
+	}
+
+	t.Run("Client store failure", func(t *testing.T) {
+		err := NewBasicAuth(failingStore{}).ParsePostAuth(context.Background(), url.Values{"client_id": {"legacy"}, "client_secret": {"legacypass"}})
+		if !errors.Is(err, ErrClientStore) {
+			t.Errorf("ParsePostAuth() error = %v, want %v", err, ErrClientStore)
+		}
+	})
+}
@@ -0,0 +1,77 @@
+package auth
+
+import (
+	"crypto/x509"
+	"log/slog"
+	"net/http"
+	"oauth2-task/internal/request"
+	"oauth2-task/internal/revocation"
+	"oauth2-task/internal/token"
+	"oauth2-task/internal/userpool"
+)
+
+// HandleRevoke processes token revocation requests as defined in RFC 7009.
+// Clients authenticate the same way as at the token endpoint and may only
+// revoke tokens issued to themselves. Tokens are verified with keys and
+// recorded in revocations by their jti until they expire.
+func HandleRevoke(keys token.KeySource, clientStore userpool.ClientStore, assertions *AssertionVerifier, clientCAs *x509.CertPool, revocations revocation.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !request.ValidateMethod(w, r, http.MethodPost) {
+			return
+		}
+
+		basicAuth := NewBasicAuth(clientStore)
+		if !authenticateClient(w, r, basicAuth, assertions, clientCAs) {
+			return
+		}
+
+		tokenString := r.PostForm.Get("token")
+		if tokenString == "" {
+			slog.Error("Missing token in revocation request", "client_id", basicAuth.Client.ID)
+			writeErrorResponse(w, http.StatusBadRequest, ErrorResponse{
+				Error:            "invalid_request",
+				ErrorDescription: "Missing token parameter",
+			})
+			return
+		}
+
+		// Invalid and expired tokens need no revocation, and RFC 7009 Section 2.2
+		// answers them like a successful revocation.
+		claims, err := token.VerifyToken(tokenString, keys.KeySet())
+		if err != nil {
+			slog.Info("Ignoring revocation of invalid token", "client_id", basicAuth.Client.ID, "error", err)
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+
+		owner := claims.IssuedTo()
+		if owner != basicAuth.Client.ID {
+			slog.Error("Client attempted to revoke a token issued to another client", "client_id", basicAuth.Client.ID, "owner", owner)
+			writeErrorResponse(w, http.StatusBadRequest, ErrorResponse{
+				Error:            "unauthorized_client",
+				ErrorDescription: "Token was not issued to this client",
+			})
+			return
+		}
+		if claims.ID == "" {
+			slog.Error("Token without jti cannot be revoked", "client_id", basicAuth.Client.ID)
+			writeErrorResponse(w, http.StatusBadRequest, ErrorResponse{
+				Error:            "unsupported_token_type",
+				ErrorDescription: "Token cannot be revoked",
+			})
+			return
+		}
+
+		if err := revocations.Revoke(r.Context(), claims.ID, claims.ExpiresAt.Time); err != nil {
+			slog.Error("Failed to revoke token", "client_id", basicAuth.Client.ID, "error", err)
+			writeErrorResponse(w, http.StatusServiceUnavailable, ErrorResponse{
+				Error:            "server_error",
+				ErrorDescription: "Failed to revoke token",
+			})
+			return
+		}
+
+		slog.Info("Revoked token", "client_id", basicAuth.Client.ID, "jti", claims.ID)
+		w.WriteHeader(http.StatusOK)
+	}
+}
@@ -0,0 +1,117 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"oauth2-task/internal/revocation"
+	"oauth2-task/internal/token"
+	"oauth2-task/internal/userpool"
+	"testing"
+)
+
+func TestHandleRevoke(t *testing.T) {
+	keyPair := setupTestKeyPair(t)
+	keys := setupTestKeySet(t, keyPair)
+	hasher := testHasher(t)
+	pool := userpool.NewMemoryStore(hasher, userpool.Client{
+		ID:         "testuser",
+		SecretHash: mustHash(t, hasher, "testpass"),
+		Enabled:    true,
+	}, userpool.Client{
+		ID:         "other",
+		SecretHash: mustHash(t, hasher, "otherpass"),
+		Enabled:    true,
+	})
+	revocations := revocation.NewMemoryStore()
+	handler := HandleRevoke(keys, pool, NewAssertionVerifier(testTokenEndpoint), nil, revocations)
+
+	// issueToken obtains an access token for testuser from the token endpoint.
+	issueToken := func(t *testing.T) (string, *token.Claims) {
+		t.Helper()
+		w := newMockResponseWriter()
+		HandleToken(keys, pool, NewAssertionVerifier(testTokenEndpoint), nil, testTokenLifetime)(w,
+			newTokenRequest(t, "testuser:testpass", url.Values{"grant_type": {"client_credentials"}}))
+		var response TokenResponse
+		if err := json.Unmarshal(w.body, &response); err != nil {
+			t.Fatalf("Failed to decode token response: %v", err)
+		}
+		claims, err := token.VerifyToken(response.AccessToken, keys)
+		if err != nil {
+			t.Fatalf("VerifyToken() error = %v", err)
+		}
+		return response.AccessToken, claims
+	}
+
+	t.Run("revokes token of the client", func(t *testing.T) {
+		accessToken, claims := issueToken(t)
+
+		w := newMockResponseWriter()
+		handler(w, newTokenRequest(t, "testuser:testpass", url.Values{"token": {accessToken}, "token_type_hint": {"access_token"}}))
+
+		if w.statusCode != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d", http.StatusOK, w.statusCode)
+		}
+		if revoked, err := revocations.IsRevoked(context.Background(), claims.ID); err != nil || !revoked {
+			t.Errorf("IsRevoked() = %v, %v; want true", revoked, err)
+		}
+	})
+
+	t.Run("rejects token of another client", func(t *testing.T) {
+		accessToken, claims := issueToken(t)
+
+		w := newMockResponseWriter()
+		handler(w, newTokenRequest(t, "other:otherpass", url.Values{"token": {accessToken}}))
+
+		if w.statusCode != http.StatusBadRequest {
+			t.Fatalf("Expected status %d, got %d", http.StatusBadRequest, w.statusCode)
+		}
+		var response ErrorResponse
+		if err := json.Unmarshal(w.body, &response); err != nil {
+			t.Fatalf("Failed to decode error response: %v", err)
+		}
+		if response.Error != "unauthorized_client" {
+			t.Errorf("Expected error unauthorized_client, got %q", response.Error)
+		}
+		if revoked, _ := revocations.IsRevoked(context.Background(), claims.ID); revoked {
+			t.Error("Expected token of another client to stay active")
+		}
+	})
+
+	t.Run("accepts invalid token", func(t *testing.T) {
+		w := newMockResponseWriter()
+		handler(w, newTokenRequest(t, "testuser:testpass", url.Values{"token": {"invalid.token.string"}}))
+
+		if w.statusCode != http.StatusOK {
+			t.Errorf("Expected status %d, got %d", http.StatusOK, w.statusCode)
+		}
+	})
+
+	errorTests := []struct {
+		name        string
+		method      string
+		credentials string
+		form        url.Values
+		wantStatus  int
+	}{
+		{name: "rejects non-POST requests", method: http.MethodGet, credentials: "testuser:testpass", wantStatus: http.StatusMethodNotAllowed},
+		{name: "rejects unauthenticated requests", method: http.MethodPost, form: url.Values{"token": {"invalid.token.string"}}, wantStatus: http.StatusUnauthorized},
+		{name: "rejects invalid credentials", method: http.MethodPost, credentials: "testuser:wrong", form: url.Values{"token": {"invalid.token.string"}}, wantStatus: http.StatusUnauthorized},
+		{name: "rejects missing token", method: http.MethodPost, credentials: "testuser:testpass", form: url.Values{}, wantStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range errorTests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := newTokenRequest(t, tt.credentials, tt.form)
+			req.Method = tt.method
+
+			w := newMockResponseWriter()
+			handler(w, req)
+
+			if w.statusCode != tt.wantStatus {
+				t.Errorf("Expected status %d, got %d", tt.wantStatus, w.statusCode)
+			}
+		})
+	}
+}
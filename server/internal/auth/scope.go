@@ -0,0 +1,52 @@
+package auth
+
+import (
+	"errors"
+	"strings"
+)
+
+// ErrInvalidScope is returned when a scope parameter violates the syntax of RFC 6749 Section 3.3.
+var ErrInvalidScope = errors.New("invalid scope")
+
+// parseScope splits a space-delimited scope parameter into its scope tokens.
+// Each token must consist of the characters allowed by RFC 6749 Section 3.3
+// (%x21 / %x23-5B / %x5D-7E). Duplicate tokens are dropped while preserving
+// the order in which they were requested.
+func parseScope(raw string) ([]string, error) {
+	var scopes []string
+	seen := make(map[string]bool)
+	for _, s := range strings.Split(raw, " ") {
+		if s == "" {
+			continue
+		}
+		if !isValidScopeToken(s) {
+			return nil, ErrInvalidScope
+		}
+		if !seen[s] {
+			scopes = append(scopes, s)
+			seen[s] = true
+		}
+	}
+	return scopes, nil
+}
+
+// isValidScopeToken reports whether s only contains characters permitted in a scope-token.
+func isValidScopeToken(s string) bool {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c < 0x21 || c == 0x22 || c == 0x5C || c > 0x7E {
+			return false
+		}
+	}
+	return true
+}
+
+// hasScope reports whether the space-delimited scope contains want.
+func hasScope(scope, want string) bool {
+	for _, granted := range strings.Fields(scope) {
+		if granted == want {
+			return true
+		}
+	}
+	return false
+}
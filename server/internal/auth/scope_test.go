@@ -0,0 +1,78 @@
+package auth
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseScope(t *testing.T) {
+	tests := []struct {
+		name    string
+		raw     string
+		want    []string
+		wantErr error
+	}{
+		{
+			name: "Empty scope",
+			raw:  "",
+			want: nil,
+		},
+		{
+			name: "Single scope",
+			raw:  "read",
+			want: []string{"read"},
+		},
+		{
+			name: "Multiple scopes",
+			raw:  "read write",
+			want: []string{"read", "write"},
+		},
+		{
+			name: "Extra spaces are ignored",
+			raw:  "  read   write ",
+			want: []string{"read", "write"},
+		},
+		{
+			name: "Duplicates are removed",
+			raw:  "read write read",
+			want: []string{"read", "write"},
+		},
+		{
+			name: "URI style scope",
+			raw:  "https://api.example.com/orders.read",
+			want: []string{"https://api.example.com/orders.read"},
+		},
+		{
+			name:    "Double quote is not allowed",
+			raw:     `read "write"`,
+			wantErr: ErrInvalidScope,
+		},
+		{
+			name:    "Backslash is not allowed",
+			raw:     `read\write`,
+			wantErr: ErrInvalidScope,
+		},
+		{
+			name:    "Tab is not a delimiter",
+			raw:     "read\twrite",
+			wantErr: ErrInvalidScope,
+		},
+		{
+			name:    "Non-ASCII is not allowed",
+			raw:     "lesen schreiben überall",
+			wantErr: ErrInvalidScope,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseScope(tt.raw)
+			if err != tt.wantErr {
+				t.Fatalf("parseScope() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseScope() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,115 @@
+// Package hsm provides signing keys held in a hardware security module or any
+// other PKCS#11 token, such as SoftHSM for development and tests. The private
+// key never leaves the token: tokens are signed by the module and only the
+// public half is read, so the JWKS can publish it.
+//
+// PKCS#11 modules are shared libraries loaded at runtime, which requires a cgo
+// build. Binaries built with CGO_ENABLED=0 report ErrUnavailable from Open.
+package hsm
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+var (
+	// ErrUnavailable is returned by Open in builds without cgo, which cannot load PKCS#11 modules.
+	ErrUnavailable = errors.New("PKCS#11 support requires a cgo build")
+	// ErrKeyNotFound is returned when the token holds no key with the configured label or ID.
+	ErrKeyNotFound = errors.New("key not found")
+	// ErrTokenNotFound is returned when no slot holds a token with the configured label.
+	ErrTokenNotFound = errors.New("token not found")
+)
+
+// Config identifies a private key on a PKCS#11 token.
+type Config struct {
+	// Module is the path of the PKCS#11 module, e.g. /usr/lib/softhsm/libsofthsm2.so.
+	Module string
+	// TokenLabel is the label of the token holding the key.
+	TokenLabel string
+	// PIN is the user PIN of the token.
+	PIN string
+	// KeyLabel is the CKA_LABEL of the key pair. Optional if KeyID is set.
+	KeyLabel string
+	// KeyID is the CKA_ID of the key pair. Optional if KeyLabel is set.
+	KeyID []byte
+}
+
+// validate checks that cfg identifies a key.
+func (cfg Config) validate() error {
+	switch {
+	case cfg.Module == "":
+		return errors.New("PKCS#11 module path is required")
+	case cfg.TokenLabel == "":
+		return errors.New("PKCS#11 token label is required")
+	case cfg.KeyLabel == "" && len(cfg.KeyID) == 0:
+		return errors.New("PKCS#11 key label or key ID is required")
+	}
+	return nil
+}
+
+// digestInfoPrefixes are the DER encoded DigestInfo prefixes the digest is
+// appended to for RSASSA-PKCS1-v1_5 signatures (RFC 8017 Section 9.2), as
+// CKM_RSA_PKCS only pads its input.
+var digestInfoPrefixes = map[crypto.Hash][]byte{
+	crypto.SHA256: {0x30, 0x31, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x01, 0x05, 0x00, 0x04, 0x20},
+	crypto.SHA384: {0x30, 0x41, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x02, 0x05, 0x00, 0x04, 0x30},
+	crypto.SHA512: {0x30, 0x51, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x03, 0x05, 0x00, 0x04, 0x40},
+}
+
+// digestInfo returns the DigestInfo encoding of digest.
+func digestInfo(hash crypto.Hash, digest []byte) ([]byte, error) {
+	prefix, ok := digestInfoPrefixes[hash]
+	if !ok {
+		return nil, fmt.Errorf("unsupported hash function %v", hash)
+	}
+	if len(digest) != hash.Size() {
+		return nil, fmt.Errorf("digest length %d does not match %v", len(digest), hash)
+	}
+	return append(append([]byte{}, prefix...), digest...), nil
+}
+
+// algorithmHash returns the hash function of a JWS RSA or ECDSA algorithm (RFC 7518 Section 3.1).
+func algorithmHash(alg string) (crypto.Hash, error) {
+	if !strings.HasPrefix(alg, "RS") && !strings.HasPrefix(alg, "PS") && !strings.HasPrefix(alg, "ES") {
+		return 0, fmt.Errorf("unsupported algorithm %s", alg)
+	}
+	switch alg[2:] {
+	case "256":
+		return crypto.SHA256, nil
+	case "384":
+		return crypto.SHA384, nil
+	case "512":
+		return crypto.SHA512, nil
+	default:
+		return 0, fmt.Errorf("unsupported algorithm %s", alg)
+	}
+}
+
+// ecdsaSignature is the ASN.1 encoding of an ECDSA signature used by crypto.Signer.
+type ecdsaSignature struct {
+	R, S *big.Int
+}
+
+// asn1ECDSASignature converts the fixed-length r||s signature CKM_ECDSA returns,
+// which is also the JWS encoding (RFC 7518 Section 3.4), to ASN.1.
+func asn1ECDSASignature(raw []byte) ([]byte, error) {
+	if len(raw) == 0 || len(raw)%2 != 0 {
+		return nil, fmt.Errorf("malformed ECDSA signature of %d bytes", len(raw))
+	}
+	half := len(raw) / 2
+	return asn1.Marshal(ecdsaSignature{
+		R: new(big.Int).SetBytes(raw[:half]),
+		S: new(big.Int).SetBytes(raw[half:]),
+	})
+}
+
+// curveSize returns the byte length of the coordinates of publicKey's curve.
+func curveSize(publicKey *ecdsa.PublicKey) int {
+	return (publicKey.Curve.Params().BitSize + 7) / 8
+}
@@ -0,0 +1,138 @@
+package hsm
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"testing"
+)
+
+func TestDigestInfo(t *testing.T) {
+	oids := map[crypto.Hash]asn1.ObjectIdentifier{
+		crypto.SHA256: {2, 16, 840, 1, 101, 3, 4, 2, 1},
+		crypto.SHA384: {2, 16, 840, 1, 101, 3, 4, 2, 2},
+		crypto.SHA512: {2, 16, 840, 1, 101, 3, 4, 2, 3},
+	}
+	for hash, oid := range oids {
+		digest := make([]byte, hash.Size())
+		got, err := digestInfo(hash, digest)
+		if err != nil {
+			t.Fatalf("digestInfo(%v) error = %v", hash, err)
+		}
+		want, err := asn1.Marshal(struct {
+			Algorithm pkix.AlgorithmIdentifier
+			Digest    []byte
+		}{pkix.AlgorithmIdentifier{Algorithm: oid, Parameters: asn1.NullRawValue}, digest})
+		if err != nil {
+			t.Fatalf("Failed to marshal DigestInfo: %v", err)
+		}
+		if string(got) != string(want) {
+			t.Errorf("digestInfo(%v) = %x, want %x", hash, got, want)
+		}
+	}
+
+	t.Run("signature verifies as PKCS#1 v1.5", func(t *testing.T) {
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			t.Fatalf("Failed to generate RSA key: %v", err)
+		}
+		digest := sha256.Sum256([]byte("signing input"))
+		input, err := digestInfo(crypto.SHA256, digest[:])
+		if err != nil {
+			t.Fatalf("digestInfo() error = %v", err)
+		}
+		// Unhashed PKCS#1 v1.5 signing is what CKM_RSA_PKCS performs on the token
+		signature, err := rsa.SignPKCS1v15(nil, key, crypto.Hash(0), input)
+		if err != nil {
+			t.Fatalf("Failed to sign: %v", err)
+		}
+		if err := rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA256, digest[:], signature); err != nil {
+			t.Errorf("Signature does not verify: %v", err)
+		}
+	})
+
+	t.Run("digest length mismatch", func(t *testing.T) {
+		if _, err := digestInfo(crypto.SHA256, make([]byte, 20)); err == nil {
+			t.Error("Expected error for short digest")
+		}
+	})
+}
+
+func TestAlgorithmHash(t *testing.T) {
+	tests := []struct {
+		alg     string
+		want    crypto.Hash
+		wantErr bool
+	}{
+		{alg: "RS256", want: crypto.SHA256},
+		{alg: "PS384", want: crypto.SHA384},
+		{alg: "ES512", want: crypto.SHA512},
+		{alg: "HS256", wantErr: true},
+		{alg: "EdDSA", wantErr: true},
+		{alg: "RS1", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := algorithmHash(tt.alg)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("algorithmHash(%q) = %v, %v; want %v, error %v", tt.alg, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestASN1ECDSASignature(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate EC key: %v", err)
+	}
+	digest := sha256.Sum256([]byte("signing input"))
+	r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+	if err != nil {
+		t.Fatalf("Failed to sign: %v", err)
+	}
+
+	// CKM_ECDSA returns r and s left-padded to the curve size
+	size := curveSize(&key.PublicKey)
+	raw := make([]byte, 2*size)
+	r.FillBytes(raw[:size])
+	s.FillBytes(raw[size:])
+
+	signature, err := asn1ECDSASignature(raw)
+	if err != nil {
+		t.Fatalf("asn1ECDSASignature() error = %v", err)
+	}
+	if !ecdsa.VerifyASN1(&key.PublicKey, digest[:], signature) {
+		t.Error("Converted signature does not verify")
+	}
+
+	if _, err := asn1ECDSASignature(raw[1:]); err == nil {
+		t.Error("Expected error for odd signature length")
+	}
+	if _, err := asn1ECDSASignature(nil); err == nil {
+		t.Error("Expected error for empty signature")
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	valid := Config{Module: "/usr/lib/softhsm/libsofthsm2.so", TokenLabel: "oauth2", KeyLabel: "signing"}
+	if err := valid.validate(); err != nil {
+		t.Errorf("validate() error = %v", err)
+	}
+	byID := Config{Module: valid.Module, TokenLabel: valid.TokenLabel, KeyID: []byte{1}}
+	if err := byID.validate(); err != nil {
+		t.Errorf("validate() error = %v", err)
+	}
+	for _, cfg := range []Config{
+		{TokenLabel: "oauth2", KeyLabel: "signing"},
+		{Module: valid.Module, KeyLabel: "signing"},
+		{Module: valid.Module, TokenLabel: "oauth2"},
+	} {
+		if err := cfg.validate(); err == nil {
+			t.Errorf("validate(%+v) expected error", cfg)
+		}
+	}
+}
@@ -0,0 +1,362 @@
+//go:build cgo
+
+package hsm
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"sync"
+
+	"oauth2-task/internal/token"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/miekg/pkcs11"
+)
+
+// PKCS#11 v3.0 constants for Ed25519 keys, which the module bindings predate.
+const (
+	ckkECEdwards = 0x00000040
+	ckmEdDSA     = 0x00001057
+)
+
+// Object identifiers of the public key algorithms in a SubjectPublicKeyInfo.
+var (
+	oidPublicKeyECDSA   = asn1.ObjectIdentifier{1, 2, 840, 10045, 2, 1}
+	oidPublicKeyEd25519 = asn1.ObjectIdentifier{1, 3, 101, 112}
+)
+
+// pssMechanisms maps a hash function to its CKM_SHA* mechanism and CKG_MGF1_* function for RSASSA-PSS.
+var pssMechanisms = map[crypto.Hash][2]uint{
+	crypto.SHA256: {pkcs11.CKM_SHA256, pkcs11.CKG_MGF1_SHA256},
+	crypto.SHA384: {pkcs11.CKM_SHA384, pkcs11.CKG_MGF1_SHA384},
+	crypto.SHA512: {pkcs11.CKM_SHA512, pkcs11.CKG_MGF1_SHA512},
+}
+
+// Key is a private key held on a PKCS#11 token. It implements crypto.Signer
+// and token.Signer; signing operations are serialized over a single session.
+type Key struct {
+	ctx        *pkcs11.Ctx
+	mu         sync.Mutex
+	session    pkcs11.SessionHandle
+	privateKey pkcs11.ObjectHandle
+	publicKey  token.PublicKey
+}
+
+// Open logs into the token configured by cfg and returns a key pair whose
+// private key is the configured key on the token.
+func Open(cfg Config) (token.KeyPair, error) {
+	key, err := openKey(cfg)
+	if err != nil {
+		return nil, err
+	}
+	keyPair, err := token.NewKeyPair(key)
+	if err != nil {
+		_ = key.Close()
+		return nil, err
+	}
+	return keyPair, nil
+}
+
+// openKey loads the module, opens a session on the configured token and looks up the key.
+func openKey(cfg Config) (*Key, error) {
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	ctx := pkcs11.New(cfg.Module)
+	if ctx == nil {
+		return nil, fmt.Errorf("failed to load PKCS#11 module %s", cfg.Module)
+	}
+	// Modules are initialized once per process, further keys share the initialization
+	if err := ctx.Initialize(); err != nil && !errors.Is(err, pkcs11.Error(pkcs11.CKR_CRYPTOKI_ALREADY_INITIALIZED)) {
+		return nil, fmt.Errorf("failed to initialize PKCS#11 module: %w", err)
+	}
+
+	slot, err := findSlot(ctx, cfg.TokenLabel)
+	if err != nil {
+		return nil, err
+	}
+	session, err := ctx.OpenSession(slot, pkcs11.CKF_SERIAL_SESSION)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open PKCS#11 session: %w", err)
+	}
+	key := &Key{ctx: ctx, session: session}
+	if err := ctx.Login(session, pkcs11.CKU_USER, cfg.PIN); err != nil && !errors.Is(err, pkcs11.Error(pkcs11.CKR_USER_ALREADY_LOGGED_IN)) {
+		_ = key.Close()
+		return nil, fmt.Errorf("failed to log into token %s: %w", cfg.TokenLabel, err)
+	}
+
+	if key.privateKey, err = key.findObject(pkcs11.CKO_PRIVATE_KEY, cfg); err != nil {
+		_ = key.Close()
+		return nil, err
+	}
+	// Prefer the public key object; RSA private key objects also carry the public components
+	publicKey, err := key.findObject(pkcs11.CKO_PUBLIC_KEY, cfg)
+	if errors.Is(err, ErrKeyNotFound) {
+		publicKey, err = key.privateKey, nil
+	}
+	if err == nil {
+		key.publicKey, err = key.readPublicKey(publicKey)
+	}
+	if err != nil {
+		_ = key.Close()
+		return nil, err
+	}
+	if _, err := token.SigningMethod(key.publicKey); err != nil {
+		_ = key.Close()
+		return nil, err
+	}
+	return key, nil
+}
+
+// findSlot returns the slot holding the token with the given label.
+func findSlot(ctx *pkcs11.Ctx, label string) (uint, error) {
+	slots, err := ctx.GetSlotList(true)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list PKCS#11 slots: %w", err)
+	}
+	for _, slot := range slots {
+		info, err := ctx.GetTokenInfo(slot)
+		if err != nil {
+			return 0, fmt.Errorf("failed to read PKCS#11 token info: %w", err)
+		}
+		if info.Label == label {
+			return slot, nil
+		}
+	}
+	return 0, fmt.Errorf("%w: %s", ErrTokenNotFound, label)
+}
+
+// findObject returns the single object of class matching the key label and ID of cfg.
+func (k *Key) findObject(class uint, cfg Config) (pkcs11.ObjectHandle, error) {
+	template := []*pkcs11.Attribute{pkcs11.NewAttribute(pkcs11.CKA_CLASS, class)}
+	if cfg.KeyLabel != "" {
+		template = append(template, pkcs11.NewAttribute(pkcs11.CKA_LABEL, cfg.KeyLabel))
+	}
+	if len(cfg.KeyID) > 0 {
+		template = append(template, pkcs11.NewAttribute(pkcs11.CKA_ID, cfg.KeyID))
+	}
+	if err := k.ctx.FindObjectsInit(k.session, template); err != nil {
+		return 0, fmt.Errorf("failed to search PKCS#11 objects: %w", err)
+	}
+	objects, _, err := k.ctx.FindObjects(k.session, 2)
+	if finalErr := k.ctx.FindObjectsFinal(k.session); err == nil {
+		err = finalErr
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to search PKCS#11 objects: %w", err)
+	}
+	switch len(objects) {
+	case 0:
+		return 0, fmt.Errorf("%w: label %q, ID %x", ErrKeyNotFound, cfg.KeyLabel, cfg.KeyID)
+	case 1:
+		return objects[0], nil
+	default:
+		return 0, fmt.Errorf("key label %q and ID %x match several keys", cfg.KeyLabel, cfg.KeyID)
+	}
+}
+
+// readPublicKey reads the public key from the attributes of object.
+func (k *Key) readPublicKey(object pkcs11.ObjectHandle) (token.PublicKey, error) {
+	attributes, err := k.ctx.GetAttributeValue(k.session, object, []*pkcs11.Attribute{pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, nil)})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read PKCS#11 key type: %w", err)
+	}
+
+	var spki struct {
+		Algorithm pkix.AlgorithmIdentifier
+		PublicKey asn1.BitString
+	}
+	switch keyType := attributeUint(attributes[0].Value); keyType {
+	case pkcs11.CKK_RSA:
+		attributes, err := k.ctx.GetAttributeValue(k.session, object, []*pkcs11.Attribute{
+			pkcs11.NewAttribute(pkcs11.CKA_MODULUS, nil),
+			pkcs11.NewAttribute(pkcs11.CKA_PUBLIC_EXPONENT, nil),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to read RSA public key: %w", err)
+		}
+		exponent := new(big.Int).SetBytes(attributes[1].Value)
+		if !exponent.IsInt64() || exponent.Int64() > 1<<31-1 {
+			return nil, errors.New("RSA public exponent out of range")
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(attributes[0].Value), E: int(exponent.Int64())}, nil
+	case pkcs11.CKK_EC, ckkECEdwards:
+		attributes, err := k.ctx.GetAttributeValue(k.session, object, []*pkcs11.Attribute{
+			pkcs11.NewAttribute(pkcs11.CKA_EC_PARAMS, nil),
+			pkcs11.NewAttribute(pkcs11.CKA_EC_POINT, nil),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to read EC public key: %w", err)
+		}
+		// CKA_EC_POINT is a DER encoded OCTET STRING, though some modules return the bare point
+		point := attributes[1].Value
+		var octets []byte
+		if rest, err := asn1.Unmarshal(point, &octets); err == nil && len(rest) == 0 {
+			point = octets
+		}
+		if keyType == pkcs11.CKK_EC {
+			spki.Algorithm = pkix.AlgorithmIdentifier{Algorithm: oidPublicKeyECDSA, Parameters: asn1.RawValue{FullBytes: attributes[0].Value}}
+		} else {
+			spki.Algorithm = pkix.AlgorithmIdentifier{Algorithm: oidPublicKeyEd25519}
+		}
+		spki.PublicKey = asn1.BitString{Bytes: point, BitLength: 8 * len(point)}
+	default:
+		return nil, fmt.Errorf("%w: PKCS#11 key type %#x", token.ErrUnsupportedKey, keyType)
+	}
+
+	// Let x509 validate the point by parsing it as a SubjectPublicKeyInfo
+	der, err := asn1.Marshal(spki)
+	if err != nil {
+		return nil, err
+	}
+	parsed, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return nil, fmt.Errorf("invalid EC public key: %w", err)
+	}
+	publicKey, ok := parsed.(token.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("%w: key type %T", token.ErrUnsupportedKey, parsed)
+	}
+	return publicKey, nil
+}
+
+// attributeUint decodes a CK_ULONG attribute value, which is in native byte order.
+func attributeUint(value []byte) uint {
+	switch len(value) {
+	case 8:
+		return uint(binary.NativeEndian.Uint64(value))
+	case 4:
+		return uint(binary.NativeEndian.Uint32(value))
+	default:
+		return 0
+	}
+}
+
+// Public returns the public key of the key pair.
+func (k *Key) Public() crypto.PublicKey {
+	return k.publicKey
+}
+
+// PublicKey returns the public key of the key pair.
+func (k *Key) PublicKey() token.PublicKey {
+	return k.publicKey
+}
+
+// Sign implements crypto.Signer. RSA keys sign with RSASSA-PSS if opts is an
+// *rsa.PSSOptions and RSASSA-PKCS1-v1_5 otherwise, ECDSA signatures are ASN.1
+// encoded, and Ed25519 keys sign the unhashed message.
+func (k *Key) Sign(_ io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	switch k.publicKey.(type) {
+	case *rsa.PublicKey:
+		if pssOpts, ok := opts.(*rsa.PSSOptions); ok {
+			saltLength := pssOpts.SaltLength
+			if saltLength == rsa.PSSSaltLengthAuto || saltLength == rsa.PSSSaltLengthEqualsHash {
+				saltLength = opts.HashFunc().Size()
+			}
+			return k.signPSS(opts.HashFunc(), digest, saltLength)
+		}
+		return k.signPKCS1v15(opts.HashFunc(), digest)
+	case *ecdsa.PublicKey:
+		signature, err := k.sign(pkcs11.NewMechanism(pkcs11.CKM_ECDSA, nil), digest)
+		if err != nil {
+			return nil, err
+		}
+		return asn1ECDSASignature(signature)
+	case ed25519.PublicKey:
+		if opts.HashFunc() != crypto.Hash(0) {
+			return nil, errors.New("ed25519: cannot sign hashed message")
+		}
+		return k.sign(pkcs11.NewMechanism(ckmEdDSA, nil), digest)
+	default:
+		return nil, fmt.Errorf("%w: key type %T", token.ErrUnsupportedKey, k.publicKey)
+	}
+}
+
+// SignJWS implements token.Signer. The signing input is hashed in process and
+// only the digest is sent to the token, except for EdDSA, which signs the
+// message itself.
+func (k *Key) SignJWS(signingInput string, method jwt.SigningMethod) ([]byte, error) {
+	if method.Alg() == jwt.SigningMethodEdDSA.Alg() {
+		return k.Sign(nil, []byte(signingInput), crypto.Hash(0))
+	}
+	hash, err := algorithmHash(method.Alg())
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", token.ErrUnsupportedAlgorithm, err)
+	}
+	hasher := hash.New()
+	hasher.Write([]byte(signingInput))
+	digest := hasher.Sum(nil)
+
+	switch method.Alg()[:2] {
+	case "RS":
+		return k.signPKCS1v15(hash, digest)
+	case "PS":
+		return k.signPSS(hash, digest, hash.Size())
+	default:
+		// CKM_ECDSA already returns the fixed-length r||s encoding of JWS
+		publicKey, ok := k.publicKey.(*ecdsa.PublicKey)
+		if !ok {
+			return nil, fmt.Errorf("%w: %s for key type %T", token.ErrUnsupportedAlgorithm, method.Alg(), k.publicKey)
+		}
+		signature, err := k.sign(pkcs11.NewMechanism(pkcs11.CKM_ECDSA, nil), digest)
+		if err != nil {
+			return nil, err
+		}
+		if len(signature) != 2*curveSize(publicKey) {
+			return nil, fmt.Errorf("malformed ECDSA signature of %d bytes", len(signature))
+		}
+		return signature, nil
+	}
+}
+
+// signPKCS1v15 signs digest with RSASSA-PKCS1-v1_5.
+func (k *Key) signPKCS1v15(hash crypto.Hash, digest []byte) ([]byte, error) {
+	input, err := digestInfo(hash, digest)
+	if err != nil {
+		return nil, err
+	}
+	return k.sign(pkcs11.NewMechanism(pkcs11.CKM_RSA_PKCS, nil), input)
+}
+
+// signPSS signs digest with RSASSA-PSS using MGF1 with the same hash function.
+func (k *Key) signPSS(hash crypto.Hash, digest []byte, saltLength int) ([]byte, error) {
+	mechanisms, ok := pssMechanisms[hash]
+	if !ok {
+		return nil, fmt.Errorf("unsupported hash function %v", hash)
+	}
+	params := pkcs11.NewPSSParams(mechanisms[0], mechanisms[1], uint(saltLength))
+	return k.sign(pkcs11.NewMechanism(pkcs11.CKM_RSA_PKCS_PSS, params), digest)
+}
+
+// sign signs input on the token with mechanism.
+func (k *Key) sign(mechanism *pkcs11.Mechanism, input []byte) ([]byte, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if err := k.ctx.SignInit(k.session, []*pkcs11.Mechanism{mechanism}, k.privateKey); err != nil {
+		return nil, fmt.Errorf("failed to initialize PKCS#11 signing: %w", err)
+	}
+	signature, err := k.ctx.Sign(k.session, input)
+	if err != nil {
+		return nil, fmt.Errorf("PKCS#11 signing failed: %w", err)
+	}
+	return bytes.Clone(signature), nil
+}
+
+// Close closes the session of the key. The login state is shared by all
+// sessions on the token and ends with the last of them.
+func (k *Key) Close() error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return k.ctx.CloseSession(k.session)
+}
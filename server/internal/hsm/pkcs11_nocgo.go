@@ -0,0 +1,13 @@
+//go:build !cgo
+
+package hsm
+
+import "oauth2-task/internal/token"
+
+// Open reports ErrUnavailable, as PKCS#11 modules cannot be loaded without cgo.
+func Open(cfg Config) (token.KeyPair, error) {
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	return nil, ErrUnavailable
+}
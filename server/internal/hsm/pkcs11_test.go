@@ -0,0 +1,353 @@
+//go:build cgo
+
+package hsm
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"oauth2-task/internal/token"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/miekg/pkcs11"
+)
+
+// ckmECEdwardsKeyPairGen is the PKCS#11 v3.0 mechanism generating Ed25519 keys.
+const ckmECEdwardsKeyPairGen = 0x00001055
+
+// softHSMModules are the usual install locations of the SoftHSM v2 module.
+var softHSMModules = []string{
+	"/usr/lib/softhsm/libsofthsm2.so",
+	"/usr/lib/x86_64-linux-gnu/softhsm/libsofthsm2.so",
+	"/usr/local/lib/softhsm/libsofthsm2.so",
+	"/opt/homebrew/lib/softhsm/libsofthsm2.so",
+}
+
+// testToken is the SoftHSM token initialized by TestMain, without a key label.
+var testToken Config
+
+// TestMain initializes a SoftHSM token in a temporary directory for the
+// PKCS#11 tests. SOFTHSM2_MODULE overrides the module path; without SoftHSM
+// the tests needing a token are skipped.
+func TestMain(m *testing.M) {
+	os.Exit(runWithSoftHSM(m))
+}
+
+func runWithSoftHSM(m *testing.M) int {
+	module := os.Getenv("SOFTHSM2_MODULE")
+	for _, path := range softHSMModules {
+		if module != "" {
+			break
+		}
+		if _, err := os.Stat(path); err == nil {
+			module = path
+		}
+	}
+	if module == "" {
+		return m.Run()
+	}
+
+	dir, err := os.MkdirTemp("", "softhsm")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer os.RemoveAll(dir)
+	conf := filepath.Join(dir, "softhsm2.conf")
+	if err := os.WriteFile(conf, []byte("directories.tokendir = "+dir+"\nobjectstore.backend = file\n"), 0o600); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	// SoftHSM reads its configuration once when the module is initialized
+	if err := os.Setenv("SOFTHSM2_CONF", conf); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	testToken = Config{Module: module, TokenLabel: "oauth2-test", PIN: "1234"}
+	if err := initToken(testToken); err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to initialize SoftHSM token:", err)
+		return 1
+	}
+	return m.Run()
+}
+
+// initToken initializes the first free slot as a token with the label and user PIN of cfg.
+func initToken(cfg Config) error {
+	ctx := pkcs11.New(cfg.Module)
+	if ctx == nil {
+		return fmt.Errorf("failed to load %s", cfg.Module)
+	}
+	if err := ctx.Initialize(); err != nil {
+		return err
+	}
+	slots, err := ctx.GetSlotList(true)
+	if err != nil {
+		return err
+	}
+	const soPIN = "5678"
+	for _, slot := range slots {
+		info, err := ctx.GetTokenInfo(slot)
+		if err != nil {
+			return err
+		}
+		if info.Flags&pkcs11.CKF_TOKEN_INITIALIZED == 0 {
+			if err := ctx.InitToken(slot, soPIN, cfg.TokenLabel); err != nil {
+				return err
+			}
+			break
+		}
+	}
+
+	// SoftHSM assigns the initialized token a new slot
+	slot, err := findSlot(ctx, cfg.TokenLabel)
+	if err != nil {
+		return err
+	}
+	session, err := ctx.OpenSession(slot, pkcs11.CKF_SERIAL_SESSION|pkcs11.CKF_RW_SESSION)
+	if err != nil {
+		return err
+	}
+	defer ctx.CloseSession(session) //nolint:errcheck // best effort cleanup
+	if err := ctx.Login(session, pkcs11.CKU_SO, soPIN); err != nil {
+		return err
+	}
+	if err := ctx.InitPIN(session, cfg.PIN); err != nil {
+		return err
+	}
+	return ctx.Logout(session)
+}
+
+// generateKeyPair generates a key pair labeled label on the test token with
+// mechanism and the key type specific public key attributes.
+func generateKeyPair(t *testing.T, label string, mechanism uint, publicAttributes ...*pkcs11.Attribute) Config {
+	t.Helper()
+	if testToken.Module == "" {
+		t.Skip("SoftHSM is not installed, set SOFTHSM2_MODULE to run the PKCS#11 tests")
+	}
+
+	ctx := pkcs11.New(testToken.Module)
+	if err := ctx.Initialize(); err != nil && !errors.Is(err, pkcs11.Error(pkcs11.CKR_CRYPTOKI_ALREADY_INITIALIZED)) {
+		t.Fatalf("Failed to initialize module: %v", err)
+	}
+	slot, err := findSlot(ctx, testToken.TokenLabel)
+	if err != nil {
+		t.Fatalf("Failed to find token: %v", err)
+	}
+	session, err := ctx.OpenSession(slot, pkcs11.CKF_SERIAL_SESSION|pkcs11.CKF_RW_SESSION)
+	if err != nil {
+		t.Fatalf("Failed to open session: %v", err)
+	}
+	defer ctx.CloseSession(session) //nolint:errcheck // best effort cleanup
+	if err := ctx.Login(session, pkcs11.CKU_USER, testToken.PIN); err != nil && !errors.Is(err, pkcs11.Error(pkcs11.CKR_USER_ALREADY_LOGGED_IN)) {
+		t.Fatalf("Failed to log in: %v", err)
+	}
+
+	id := []byte(label)
+	publicTemplate := append([]*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_TOKEN, true),
+		pkcs11.NewAttribute(pkcs11.CKA_VERIFY, true),
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, label),
+		pkcs11.NewAttribute(pkcs11.CKA_ID, id),
+	}, publicAttributes...)
+	privateTemplate := []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_TOKEN, true),
+		pkcs11.NewAttribute(pkcs11.CKA_PRIVATE, true),
+		pkcs11.NewAttribute(pkcs11.CKA_SIGN, true),
+		pkcs11.NewAttribute(pkcs11.CKA_SENSITIVE, true),
+		pkcs11.NewAttribute(pkcs11.CKA_EXTRACTABLE, false),
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, label),
+		pkcs11.NewAttribute(pkcs11.CKA_ID, id),
+	}
+	_, _, err = ctx.GenerateKeyPair(session, []*pkcs11.Mechanism{pkcs11.NewMechanism(mechanism, nil)}, publicTemplate, privateTemplate)
+	if errors.Is(err, pkcs11.Error(pkcs11.CKR_MECHANISM_INVALID)) {
+		// Ed25519 needs SoftHSM 2.6 built against OpenSSL 1.1.1 or later
+		t.Skipf("Token does not support key generation mechanism %#x", mechanism)
+	}
+	if err != nil {
+		t.Fatalf("Failed to generate key pair: %v", err)
+	}
+
+	cfg := testToken
+	cfg.KeyLabel = label
+	return cfg
+}
+
+// curveParams returns the DER encoded named curve for CKA_EC_PARAMS.
+func curveParams(t *testing.T, oid asn1.ObjectIdentifier) *pkcs11.Attribute {
+	t.Helper()
+	params, err := asn1.Marshal(oid)
+	if err != nil {
+		t.Fatalf("Failed to marshal curve: %v", err)
+	}
+	return pkcs11.NewAttribute(pkcs11.CKA_EC_PARAMS, params)
+}
+
+func TestOpenSignsTokens(t *testing.T) {
+	tests := []struct {
+		name      string
+		mechanism uint
+		attribute func(t *testing.T) []*pkcs11.Attribute
+		methods   []jwt.SigningMethod
+	}{
+		{
+			name:      "RSA",
+			mechanism: pkcs11.CKM_RSA_PKCS_KEY_PAIR_GEN,
+			attribute: func(_ *testing.T) []*pkcs11.Attribute {
+				return []*pkcs11.Attribute{
+					pkcs11.NewAttribute(pkcs11.CKA_MODULUS_BITS, 2048),
+					pkcs11.NewAttribute(pkcs11.CKA_PUBLIC_EXPONENT, []byte{1, 0, 1}),
+				}
+			},
+			methods: []jwt.SigningMethod{jwt.SigningMethodRS256, jwt.SigningMethodRS512, jwt.SigningMethodPS256, jwt.SigningMethodPS384},
+		},
+		{
+			name:      "ECDSA P-256",
+			mechanism: pkcs11.CKM_EC_KEY_PAIR_GEN,
+			attribute: func(t *testing.T) []*pkcs11.Attribute {
+				return []*pkcs11.Attribute{curveParams(t, asn1.ObjectIdentifier{1, 2, 840, 10045, 3, 1, 7})}
+			},
+			methods: []jwt.SigningMethod{jwt.SigningMethodES256},
+		},
+		{
+			name:      "ECDSA P-384",
+			mechanism: pkcs11.CKM_EC_KEY_PAIR_GEN,
+			attribute: func(t *testing.T) []*pkcs11.Attribute {
+				return []*pkcs11.Attribute{curveParams(t, asn1.ObjectIdentifier{1, 3, 132, 0, 34})}
+			},
+			methods: []jwt.SigningMethod{jwt.SigningMethodES384},
+		},
+		{
+			name:      "Ed25519",
+			mechanism: ckmECEdwardsKeyPairGen,
+			attribute: func(t *testing.T) []*pkcs11.Attribute {
+				return []*pkcs11.Attribute{curveParams(t, oidPublicKeyEd25519)}
+			},
+			methods: []jwt.SigningMethod{jwt.SigningMethodEdDSA},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := generateKeyPair(t, tt.name, tt.mechanism, tt.attribute(t)...)
+			keyPair, err := Open(cfg)
+			if err != nil {
+				t.Fatalf("Open() error = %v", err)
+			}
+			if _, ok := keyPair.Signer().(*Key); !ok {
+				t.Fatalf("Expected the token key to sign, got %T", keyPair.Signer())
+			}
+
+			for _, method := range tt.methods {
+				kid := token.KeyID(keyPair.PublicKey())
+				signed, _, err := token.NewGenerator(keyPair.Signer(), kid, method).GenerateToken(token.Grant{Username: "testuser"})
+				if err != nil {
+					t.Fatalf("GenerateToken(%s) error = %v", method.Alg(), err)
+				}
+				parsed, err := jwt.Parse(signed, func(_ *jwt.Token) (interface{}, error) {
+					return keyPair.PublicKey(), nil
+				}, jwt.WithValidMethods([]string{method.Alg()}))
+				if err != nil {
+					t.Fatalf("Token signed with %s does not verify: %v", method.Alg(), err)
+				}
+				if parsed.Header["kid"] != kid {
+					t.Errorf("kid = %v, want %s", parsed.Header["kid"], kid)
+				}
+			}
+		})
+	}
+}
+
+func TestKeyCryptoSigner(t *testing.T) {
+	digest := sha256.Sum256([]byte("message"))
+
+	t.Run("RSA", func(t *testing.T) {
+		cfg := generateKeyPair(t, "crypto.Signer RSA", pkcs11.CKM_RSA_PKCS_KEY_PAIR_GEN,
+			pkcs11.NewAttribute(pkcs11.CKA_MODULUS_BITS, 2048),
+			pkcs11.NewAttribute(pkcs11.CKA_PUBLIC_EXPONENT, []byte{1, 0, 1}))
+		key, err := openKey(cfg)
+		if err != nil {
+			t.Fatalf("openKey() error = %v", err)
+		}
+		defer key.Close()
+		publicKey := key.Public().(*rsa.PublicKey)
+
+		signature, err := key.Sign(nil, digest[:], crypto.SHA256)
+		if err != nil {
+			t.Fatalf("Sign() error = %v", err)
+		}
+		if err := rsa.VerifyPKCS1v15(publicKey, crypto.SHA256, digest[:], signature); err != nil {
+			t.Errorf("PKCS#1 v1.5 signature does not verify: %v", err)
+		}
+
+		signature, err = key.Sign(nil, digest[:], &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: crypto.SHA256})
+		if err != nil {
+			t.Fatalf("Sign() error = %v", err)
+		}
+		if err := rsa.VerifyPSS(publicKey, crypto.SHA256, digest[:], signature, nil); err != nil {
+			t.Errorf("PSS signature does not verify: %v", err)
+		}
+	})
+
+	t.Run("ECDSA", func(t *testing.T) {
+		cfg := generateKeyPair(t, "crypto.Signer ECDSA", pkcs11.CKM_EC_KEY_PAIR_GEN, curveParams(t, asn1.ObjectIdentifier{1, 2, 840, 10045, 3, 1, 7}))
+		key, err := openKey(cfg)
+		if err != nil {
+			t.Fatalf("openKey() error = %v", err)
+		}
+		defer key.Close()
+
+		signature, err := key.Sign(nil, digest[:], crypto.SHA256)
+		if err != nil {
+			t.Fatalf("Sign() error = %v", err)
+		}
+		if !ecdsa.VerifyASN1(key.Public().(*ecdsa.PublicKey), digest[:], signature) {
+			t.Error("ECDSA signature does not verify")
+		}
+	})
+}
+
+func TestOpenErrors(t *testing.T) {
+	cfg := generateKeyPair(t, "errors", pkcs11.CKM_EC_KEY_PAIR_GEN, curveParams(t, asn1.ObjectIdentifier{1, 2, 840, 10045, 3, 1, 7}))
+
+	t.Run("unknown token", func(t *testing.T) {
+		unknown := cfg
+		unknown.TokenLabel = "unknown"
+		if _, err := Open(unknown); !errors.Is(err, ErrTokenNotFound) {
+			t.Errorf("Open() error = %v, want %v", err, ErrTokenNotFound)
+		}
+	})
+
+	t.Run("unknown key", func(t *testing.T) {
+		unknown := cfg
+		unknown.KeyLabel = "unknown"
+		if _, err := Open(unknown); !errors.Is(err, ErrKeyNotFound) {
+			t.Errorf("Open() error = %v, want %v", err, ErrKeyNotFound)
+		}
+	})
+
+	t.Run("key ID", func(t *testing.T) {
+		byID := cfg
+		byID.KeyLabel = ""
+		byID.KeyID = []byte("errors")
+		if _, err := Open(byID); err != nil {
+			t.Errorf("Open() error = %v", err)
+		}
+	})
+
+	t.Run("missing module", func(t *testing.T) {
+		missing := cfg
+		missing.Module = filepath.Join(t.TempDir(), "missing.so")
+		if _, err := Open(missing); err == nil {
+			t.Error("Expected error for missing module")
+		}
+	})
+}
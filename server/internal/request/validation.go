@@ -10,12 +10,16 @@
 //
 // Each validation function writes appropriate error responses to the http.ResponseWriter
 // when validation fails, following OAuth2 error response format.
+//
+// AcceptsMediaType supports content negotiation, e.g. for JWT introspection responses.
 package request
 
 import (
 	"fmt"
 	"log/slog"
+	"mime"
 	"net/http"
+	"strconv"
 	"strings"
 )
 
@@ -32,11 +36,13 @@ func ValidateMethod(w http.ResponseWriter, r *http.Request, expectedMethod strin
 }
 
 // ValidateContentType checks if the request has the expected Content-Type header.
+// Media type parameters such as charset are ignored and the media type is compared case-insensitively.
 // If the Content-Type doesn't match, it writes a BadRequest error response and returns false.
 // Returns true if the Content-Type is valid.
 func ValidateContentType(w http.ResponseWriter, r *http.Request, expectedContentType string) bool {
 	contentType := r.Header.Get("Content-Type")
-	if contentType != expectedContentType {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil || !strings.EqualFold(mediaType, expectedContentType) {
 		slog.Error("Invalid Content-Type", "got", contentType, "expected", expectedContentType, "status", http.StatusBadRequest)
 		http.Error(w, fmt.Sprintf("Invalid Content-Type: %s", contentType), http.StatusBadRequest)
 		return false
@@ -63,3 +69,24 @@ func ValidateAuthorization(w http.ResponseWriter, r *http.Request, expectedSchem
 
 	return true
 }
+
+// AcceptsMediaType reports whether the Accept header of the request lists mediaType
+// with a non-zero quality value. Wildcards such as */* do not match, so clients
+// have to ask for mediaType explicitly.
+func AcceptsMediaType(r *http.Request, mediaType string) bool {
+	for _, value := range r.Header.Values("Accept") {
+		for _, accepted := range strings.Split(value, ",") {
+			acceptedType, params, err := mime.ParseMediaType(strings.TrimSpace(accepted))
+			if err != nil || !strings.EqualFold(acceptedType, mediaType) {
+				continue
+			}
+			if q, ok := params["q"]; ok {
+				if quality, err := strconv.ParseFloat(q, 64); err != nil || quality <= 0 {
+					continue
+				}
+			}
+			return true
+		}
+	}
+	return false
+}
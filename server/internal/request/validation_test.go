@@ -81,6 +81,25 @@ func TestValidateContentType(t *testing.T) {
 			expectedContentType: "application/json",
 			wantValid:           true,
 		},
+		{
+			name:                "content type with parameters",
+			contentType:         "application/x-www-form-urlencoded; charset=UTF-8",
+			expectedContentType: "application/x-www-form-urlencoded",
+			wantValid:           true,
+		},
+		{
+			name:                "content type with different case",
+			contentType:         "Application/JSON",
+			expectedContentType: "application/json",
+			wantValid:           true,
+		},
+		{
+			name:                "malformed content type",
+			contentType:         "application/json; charset",
+			expectedContentType: "application/json",
+			wantValid:           false,
+			wantStatus:          http.StatusBadRequest,
+		},
 		{
 			name:                "invalid content type",
 			contentType:         "text/plain",
@@ -173,3 +192,35 @@ func TestValidateAuthorization(t *testing.T) {
 		})
 	}
 }
+
+func TestAcceptsMediaType(t *testing.T) {
+	const mediaType = "application/token-introspection+jwt"
+
+	tests := []struct {
+		name   string
+		accept []string
+		want   bool
+	}{
+		{name: "exact match", accept: []string{mediaType}, want: true},
+		{name: "one of several types", accept: []string{"application/json;q=0.5, " + mediaType}, want: true},
+		{name: "several headers", accept: []string{"application/json", mediaType}, want: true},
+		{name: "different case", accept: []string{"Application/Token-Introspection+JWT"}, want: true},
+		{name: "positive quality", accept: []string{mediaType + ";q=0.1"}, want: true},
+		{name: "zero quality", accept: []string{mediaType + ";q=0"}, want: false},
+		{name: "wildcard", accept: []string{"*/*"}, want: false},
+		{name: "other type", accept: []string{"application/json"}, want: false},
+		{name: "missing header", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := &http.Request{Header: make(http.Header)}
+			for _, accept := range tt.accept {
+				req.Header.Add("Accept", accept)
+			}
+			if got := AcceptsMediaType(req, mediaType); got != tt.want {
+				t.Errorf("AcceptsMediaType() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
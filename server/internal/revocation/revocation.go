@@ -0,0 +1,70 @@
+// Package revocation keeps track of access tokens revoked before their expiry
+// as defined in RFC 7009. Tokens are identified by their jti claim and only
+// remembered until they expire, since expired tokens are rejected anyway.
+package revocation
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Store records revoked tokens by their jti claim.
+// Implementations must be safe for concurrent use.
+type Store interface {
+	// Revoke records the token identified by jti as revoked until expiresAt.
+	Revoke(ctx context.Context, jti string, expiresAt time.Time) error
+	// IsRevoked reports whether the token identified by jti was revoked.
+	IsRevoked(ctx context.Context, jti string) (bool, error)
+}
+
+// MemoryStore is an in-memory Store. Revocations are lost on restart and
+// not shared between server instances; use SQLStore for deployments with
+// more than one replica.
+type MemoryStore struct {
+	mu        sync.RWMutex
+	revoked   map[string]time.Time
+	lastPrune time.Time
+	now       func() time.Time
+}
+
+// NewMemoryStore creates an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{revoked: make(map[string]time.Time), now: time.Now}
+}
+
+// Revoke records the token identified by jti as revoked until expiresAt.
+// Expired revocations are pruned at most once a minute.
+func (s *MemoryStore) Revoke(ctx context.Context, jti string, expiresAt time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	if now.Sub(s.lastPrune) > time.Minute {
+		for revokedID, revokedUntil := range s.revoked {
+			if now.After(revokedUntil) {
+				delete(s.revoked, revokedID)
+			}
+		}
+		s.lastPrune = now
+	}
+
+	if revokedUntil, ok := s.revoked[jti]; !ok || expiresAt.After(revokedUntil) {
+		s.revoked[jti] = expiresAt
+	}
+	return nil
+}
+
+// IsRevoked reports whether the token identified by jti was revoked and has not expired yet.
+func (s *MemoryStore) IsRevoked(ctx context.Context, jti string) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	revokedUntil, ok := s.revoked[jti]
+	return ok && !s.now().After(revokedUntil), nil
+}
@@ -0,0 +1,72 @@
+package revocation
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// Compile-time check that MemoryStore implements Store.
+var _ Store = (*MemoryStore)(nil)
+
+// testStore runs the behaviour every Store must provide against store,
+// whose clock is controlled through now.
+func testStore(t *testing.T, store Store, now *time.Time) {
+	t.Helper()
+	ctx := context.Background()
+
+	if revoked, err := store.IsRevoked(ctx, "token-a"); err != nil || revoked {
+		t.Fatalf("IsRevoked() = %v, %v; want false for unknown token", revoked, err)
+	}
+
+	if err := store.Revoke(ctx, "token-a", now.Add(time.Hour)); err != nil {
+		t.Fatalf("Revoke() error = %v", err)
+	}
+	if err := store.Revoke(ctx, "token-b", now.Add(5*time.Minute)); err != nil {
+		t.Fatalf("Revoke() error = %v", err)
+	}
+	// Revoking a token again must not shorten its revocation
+	if err := store.Revoke(ctx, "token-a", now.Add(time.Minute)); err != nil {
+		t.Fatalf("Revoke() error = %v", err)
+	}
+	for _, jti := range []string{"token-a", "token-b"} {
+		if revoked, err := store.IsRevoked(ctx, jti); err != nil || !revoked {
+			t.Errorf("IsRevoked(%q) = %v, %v; want true", jti, revoked, err)
+		}
+	}
+
+	// Once a token has expired its revocation is no longer needed
+	*now = now.Add(10 * time.Minute)
+	if err := store.Revoke(ctx, "token-c", now.Add(time.Hour)); err != nil {
+		t.Fatalf("Revoke() error = %v", err)
+	}
+	if revoked, err := store.IsRevoked(ctx, "token-b"); err != nil || revoked {
+		t.Errorf("IsRevoked(token-b) = %v, %v; want false after expiry", revoked, err)
+	}
+	if revoked, err := store.IsRevoked(ctx, "token-a"); err != nil || !revoked {
+		t.Errorf("IsRevoked(token-a) = %v, %v; want true until expiry", revoked, err)
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	now := time.Now()
+	store := NewMemoryStore()
+	store.now = func() time.Time { return now }
+
+	testStore(t, store, &now)
+
+	if _, ok := store.revoked["token-b"]; ok {
+		t.Error("Expected expired revocation to be pruned")
+	}
+
+	t.Run("cancelled context", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		if err := store.Revoke(ctx, "token-d", now.Add(time.Hour)); err == nil {
+			t.Error("Expected Revoke() to fail with a cancelled context")
+		}
+		if _, err := store.IsRevoked(ctx, "token-a"); err == nil {
+			t.Error("Expected IsRevoked() to fail with a cancelled context")
+		}
+	})
+}
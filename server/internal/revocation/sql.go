@@ -0,0 +1,114 @@
+package revocation
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	// Register the Postgres driver as "pgx".
+	_ "github.com/jackc/pgx/v5/stdlib"
+	// Register the pure Go SQLite driver as "sqlite".
+	_ "modernc.org/sqlite"
+)
+
+// Supported SQL drivers.
+const (
+	DriverSQLite   = "sqlite"
+	DriverPostgres = "postgres"
+)
+
+// ErrUnsupportedDriver is returned when an unknown SQL driver is configured.
+var ErrUnsupportedDriver = errors.New("unsupported SQL driver")
+
+// SQLStore is a Store backed by a SQL database, so revocations survive
+// restarts and are shared between replicas. SQLite and Postgres are supported.
+type SQLStore struct {
+	db       *sql.DB
+	postgres bool
+	now      func() time.Time
+}
+
+// OpenSQLStore connects to the database identified by driver and dsn and
+// creates the revoked_tokens table if it does not exist yet.
+func OpenSQLStore(ctx context.Context, driver, dsn string) (*SQLStore, error) {
+	var driverName string
+	switch driver {
+	case DriverSQLite:
+		driverName = "sqlite"
+	case DriverPostgres:
+		driverName = "pgx"
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnsupportedDriver, driver)
+	}
+
+	db, err := sql.Open(driverName, dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open revocation database: %w", err)
+	}
+	store := &SQLStore{db: db, postgres: driver == DriverPostgres, now: time.Now}
+	if err := db.PingContext(ctx); err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("failed to connect to revocation database: %w", err)
+	}
+
+	// Expiry is stored in Unix seconds, which both drivers compare the same way
+	if _, err := db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS revoked_tokens (
+		jti        TEXT PRIMARY KEY,
+		expires_at BIGINT NOT NULL
+	)`); err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("failed to create revoked_tokens table: %w", err)
+	}
+	return store, nil
+}
+
+// Close closes the underlying database connection pool.
+func (s *SQLStore) Close() error {
+	return s.db.Close()
+}
+
+// Revoke records the token identified by jti as revoked until expiresAt and
+// prunes revocations of tokens that have expired meanwhile.
+func (s *SQLStore) Revoke(ctx context.Context, jti string, expiresAt time.Time) error {
+	if _, err := s.db.ExecContext(ctx, s.rebind(`INSERT INTO revoked_tokens (jti, expires_at) VALUES (?, ?)
+		ON CONFLICT (jti) DO UPDATE SET expires_at = excluded.expires_at
+		WHERE excluded.expires_at > revoked_tokens.expires_at`), jti, expiresAt.Unix()); err != nil {
+		return fmt.Errorf("failed to revoke token: %w", err)
+	}
+	if _, err := s.db.ExecContext(ctx, s.rebind("DELETE FROM revoked_tokens WHERE expires_at < ?"), s.now().Unix()); err != nil {
+		return fmt.Errorf("failed to prune revoked tokens: %w", err)
+	}
+	return nil
+}
+
+// IsRevoked reports whether the token identified by jti was revoked and has not expired yet.
+func (s *SQLStore) IsRevoked(ctx context.Context, jti string) (bool, error) {
+	var count int
+	if err := s.db.QueryRowContext(ctx, s.rebind("SELECT COUNT(*) FROM revoked_tokens WHERE jti = ? AND expires_at >= ?"),
+		jti, s.now().Unix()).Scan(&count); err != nil {
+		return false, fmt.Errorf("failed to look up revoked token: %w", err)
+	}
+	return count > 0, nil
+}
+
+// rebind rewrites ? placeholders into the $n form expected by Postgres.
+func (s *SQLStore) rebind(query string) string {
+	if !s.postgres {
+		return query
+	}
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
@@ -0,0 +1,80 @@
+package revocation
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Compile-time check that SQLStore implements Store.
+var _ Store = (*SQLStore)(nil)
+
+// openTestSQLStore opens a SQLStore on a fresh SQLite database in a temporary directory.
+// Setting POSTGRES_TEST_DSN runs the tests against Postgres instead.
+func openTestSQLStore(t *testing.T) *SQLStore {
+	t.Helper()
+	driver, dsn := DriverSQLite, filepath.Join(t.TempDir(), "revocations.db")
+	if postgresDSN := os.Getenv("POSTGRES_TEST_DSN"); postgresDSN != "" {
+		driver, dsn = DriverPostgres, postgresDSN
+	}
+
+	store, err := OpenSQLStore(context.Background(), driver, dsn)
+	if err != nil {
+		t.Fatalf("OpenSQLStore() error = %v", err)
+	}
+	t.Cleanup(func() {
+		if driver == DriverPostgres {
+			_, _ = store.db.Exec("DELETE FROM revoked_tokens")
+		}
+		_ = store.Close()
+	})
+	return store
+}
+
+func TestSQLStore(t *testing.T) {
+	now := time.Now()
+	store := openTestSQLStore(t)
+	store.now = func() time.Time { return now }
+
+	testStore(t, store, &now)
+
+	var count int
+	if err := store.db.QueryRow(store.rebind("SELECT COUNT(*) FROM revoked_tokens WHERE jti = ?"), "token-b").Scan(&count); err != nil {
+		t.Fatalf("Failed to count revoked tokens: %v", err)
+	}
+	if count != 0 {
+		t.Error("Expected expired revocation to be pruned")
+	}
+}
+
+func TestOpenSQLStore(t *testing.T) {
+	t.Run("unsupported driver", func(t *testing.T) {
+		if _, err := OpenSQLStore(context.Background(), "mysql", ""); !errors.Is(err, ErrUnsupportedDriver) {
+			t.Errorf("OpenSQLStore() error = %v, want %v", err, ErrUnsupportedDriver)
+		}
+	})
+
+	t.Run("revocations survive reopening", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "revocations.db")
+		store, err := OpenSQLStore(context.Background(), DriverSQLite, path)
+		if err != nil {
+			t.Fatalf("OpenSQLStore() error = %v", err)
+		}
+		if err := store.Revoke(context.Background(), "token-a", time.Now().Add(time.Hour)); err != nil {
+			t.Fatalf("Revoke() error = %v", err)
+		}
+		_ = store.Close()
+
+		reopened, err := OpenSQLStore(context.Background(), DriverSQLite, path)
+		if err != nil {
+			t.Fatalf("OpenSQLStore() error = %v", err)
+		}
+		defer reopened.Close()
+		if revoked, err := reopened.IsRevoked(context.Background(), "token-a"); err != nil || !revoked {
+			t.Errorf("IsRevoked() after reopen = %v, %v; want true", revoked, err)
+		}
+	})
+}
@@ -0,0 +1,96 @@
+package tlsconfig
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	// ErrInvalidMinVersion is returned for a minimum TLS version other than 1.2 or 1.3.
+	ErrInvalidMinVersion = errors.New("invalid minimum TLS version")
+	// ErrInvalidCipherSuite is returned for unknown or insecure cipher suite names.
+	ErrInvalidCipherSuite = errors.New("invalid cipher suite")
+)
+
+// Config describes the TLS settings of the server.
+type Config struct {
+	// MinVersion is the minimum accepted TLS version, tls.VersionTLS12 if zero.
+	MinVersion uint16
+	// CipherSuites restricts the cipher suites negotiated for TLS 1.2. The
+	// cipher suites of TLS 1.3 are not configurable. Go's secure defaults are
+	// used if empty.
+	CipherSuites []uint16
+}
+
+// ServerConfig returns a tls.Config that serves the certificate of reloader.
+// Clients may present a certificate for mutual TLS client authentication
+// (RFC 8705); certificates are not verified during the handshake as
+// self-signed certificates are accepted for self_signed_tls_client_auth.
+// Certificates for tls_client_auth are verified by the token endpoint against
+// the CAs loaded with LoadClientCAs instead.
+func ServerConfig(cfg Config, reloader *CertificateReloader) *tls.Config {
+	minVersion := cfg.MinVersion
+	if minVersion == 0 {
+		minVersion = tls.VersionTLS12
+	}
+	return &tls.Config{
+		GetCertificate: reloader.GetCertificate,
+		ClientAuth:     tls.RequestClientCert,
+		MinVersion:     minVersion,
+		CipherSuites:   cfg.CipherSuites,
+	}
+}
+
+// ParseMinVersion parses a minimum TLS version, "1.2" or "1.3".
+func ParseMinVersion(version string) (uint16, error) {
+	switch version {
+	case "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	default:
+		return 0, fmt.Errorf("%w %q: must be 1.2 or 1.3", ErrInvalidMinVersion, version)
+	}
+}
+
+// ParseCipherSuites parses a comma separated list of cipher suite names as
+// returned by tls.CipherSuiteName, e.g. "TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256".
+// Only suites Go considers secure are accepted.
+func ParseCipherSuites(names string) ([]uint16, error) {
+	secure := make(map[string]uint16)
+	for _, suite := range tls.CipherSuites() {
+		secure[suite.Name] = suite.ID
+	}
+
+	var ids []uint16
+	for _, name := range strings.Split(names, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		id, ok := secure[name]
+		if !ok {
+			return nil, fmt.Errorf("%w %q", ErrInvalidCipherSuite, name)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// LoadClientCAs reads the PEM encoded CA certificates in path.
+func LoadClientCAs(path string) (*x509.CertPool, error) {
+	caPEM, err := os.ReadFile(filepath.Clean(path))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read client CA file: %w", err)
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(caPEM) {
+		return nil, fmt.Errorf("no certificates found in client CA file %s", path)
+	}
+	return roots, nil
+}
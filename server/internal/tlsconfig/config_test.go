@@ -0,0 +1,125 @@
+package tlsconfig
+
+import (
+	"crypto/tls"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestParseMinVersion(t *testing.T) {
+	tests := []struct {
+		version string
+		want    uint16
+		wantErr bool
+	}{
+		{version: "1.2", want: tls.VersionTLS12},
+		{version: "1.3", want: tls.VersionTLS13},
+		{version: "1.1", wantErr: true},
+		{version: "TLS1.3", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.version, func(t *testing.T) {
+			got, err := ParseMinVersion(tt.version)
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidMinVersion) {
+					t.Errorf("ParseMinVersion() error = %v, want %v", err, ErrInvalidMinVersion)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("ParseMinVersion() = %v, %v; want %v, nil", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseCipherSuites(t *testing.T) {
+	tests := []struct {
+		name    string
+		names   string
+		want    []uint16
+		wantErr bool
+	}{
+		{
+			name:  "secure suites",
+			names: "TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256, TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256",
+			want:  []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256, tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256},
+		},
+		{name: "trailing comma", names: "TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,", want: []uint16{tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384}},
+		{name: "insecure suite", names: "TLS_RSA_WITH_RC4_128_SHA", wantErr: true},
+		{name: "unknown suite", names: "TLS_NOT_A_SUITE", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseCipherSuites(tt.names)
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidCipherSuite) {
+					t.Errorf("ParseCipherSuites() error = %v, want %v", err, ErrInvalidCipherSuite)
+				}
+				return
+			}
+			if err != nil || !slices.Equal(got, tt.want) {
+				t.Errorf("ParseCipherSuites() = %v, %v; want %v, nil", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestServerConfig(t *testing.T) {
+	certFile, keyFile := writeCertificate(t, t.TempDir(), "server")
+	reloader, err := NewCertificateReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("NewCertificateReloader() error = %v", err)
+	}
+
+	t.Run("defaults", func(t *testing.T) {
+		config := ServerConfig(Config{}, reloader)
+		if config.MinVersion != tls.VersionTLS12 {
+			t.Errorf("Expected minimum version TLS 1.2, got %x", config.MinVersion)
+		}
+		if config.ClientAuth != tls.RequestClientCert {
+			t.Errorf("Expected optional client certificates, got %v", config.ClientAuth)
+		}
+		cert, err := config.GetCertificate(&tls.ClientHelloInfo{})
+		if err != nil || cert.Leaf.Subject.CommonName != "server" {
+			t.Errorf("Expected GetCertificate to serve the reloader's certificate, got %v", err)
+		}
+	})
+
+	t.Run("configured policy", func(t *testing.T) {
+		suites := []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256}
+		config := ServerConfig(Config{MinVersion: tls.VersionTLS13, CipherSuites: suites}, reloader)
+		if config.MinVersion != tls.VersionTLS13 {
+			t.Errorf("Expected minimum version TLS 1.3, got %x", config.MinVersion)
+		}
+		if !slices.Equal(config.CipherSuites, suites) {
+			t.Errorf("Expected cipher suites %v, got %v", suites, config.CipherSuites)
+		}
+	})
+}
+
+func TestLoadClientCAs(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := writeCertificate(t, dir, "ca")
+
+	if _, err := LoadClientCAs(certFile); err != nil {
+		t.Errorf("LoadClientCAs() error = %v", err)
+	}
+	if _, err := LoadClientCAs(keyFile); err == nil {
+		t.Error("Expected error for file without certificates")
+	}
+	if _, err := LoadClientCAs(filepath.Join(dir, "missing.pem")); err == nil {
+		t.Error("Expected error for missing file")
+	}
+	if err := os.WriteFile(filepath.Join(dir, "empty.pem"), nil, 0o600); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	if _, err := LoadClientCAs(filepath.Join(dir, "empty.pem")); err == nil {
+		t.Error("Expected error for empty file")
+	}
+}
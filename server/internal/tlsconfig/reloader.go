@@ -0,0 +1,105 @@
+// Package tlsconfig builds the TLS configuration of the server and reloads its
+// certificate when the certificate files change.
+package tlsconfig
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrInvalidCertificate is returned when the certificate or key file cannot be loaded.
+var ErrInvalidCertificate = errors.New("invalid server certificate")
+
+// CertificateReloader serves the server certificate from a certificate and key
+// file through tls.Config.GetCertificate. The files are re-read by Watch and
+// the certificate is swapped atomically, so rotated certificates, for example
+// issued by cert-manager into a mounted Secret, are picked up without a restart.
+type CertificateReloader struct {
+	certFile string
+	keyFile  string
+	current  atomic.Pointer[tls.Certificate]
+
+	mu     sync.Mutex
+	digest [sha256.Size]byte
+}
+
+// NewCertificateReloader loads the PEM encoded certificate chain and private key from certFile and keyFile.
+func NewCertificateReloader(certFile, keyFile string) (*CertificateReloader, error) {
+	reloader := &CertificateReloader{certFile: certFile, keyFile: keyFile}
+	if _, err := reloader.Reload(); err != nil {
+		return nil, err
+	}
+	return reloader, nil
+}
+
+// GetCertificate returns the current certificate. It implements tls.Config.GetCertificate.
+func (r *CertificateReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return r.current.Load(), nil
+}
+
+// Reload re-reads the certificate and key files and swaps in the new
+// certificate if their content changed. It reports whether a swap happened.
+// On error the current certificate stays in place.
+func (r *CertificateReloader) Reload() (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	certPEM, err := os.ReadFile(filepath.Clean(r.certFile))
+	if err != nil {
+		return false, fmt.Errorf("failed to read certificate file: %w", err)
+	}
+	keyPEM, err := os.ReadFile(filepath.Clean(r.keyFile))
+	if err != nil {
+		return false, fmt.Errorf("failed to read key file: %w", err)
+	}
+
+	hash := sha256.New()
+	hash.Write(certPEM)
+	hash.Write(keyPEM)
+	var digest [sha256.Size]byte
+	copy(digest[:], hash.Sum(nil))
+	if r.current.Load() != nil && digest == r.digest {
+		return false, nil
+	}
+
+	// Certificate and key are written separately, so a mismatching pair is
+	// rejected until both files have been updated
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return false, fmt.Errorf("%w: %v", ErrInvalidCertificate, err)
+	}
+
+	r.current.Store(&cert)
+	r.digest = digest
+	slog.Info("Loaded server certificate", "path", r.certFile, "subject", cert.Leaf.Subject.String(), "not_after", cert.Leaf.NotAfter)
+	return true, nil
+}
+
+// Watch polls the certificate and key files every interval and reloads them on
+// change until ctx is cancelled. Polling the content rather than relying on
+// file system events keeps it working with the symlink swaps Kubernetes uses
+// to update mounted Secrets.
+func (r *CertificateReloader) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := r.Reload(); err != nil {
+				slog.Error("Failed to reload server certificate, keeping previous certificate", "path", r.certFile, "error", err)
+			}
+		}
+	}
+}
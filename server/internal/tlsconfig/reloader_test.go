@@ -0,0 +1,183 @@
+package tlsconfig
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeCertificate writes a self-signed certificate for commonName and its key
+// to cert.pem and key.pem in dir and returns their paths.
+func writeCertificate(t *testing.T, dir, commonName string) (string, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatalf("Failed to marshal key: %v", err)
+	}
+
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatalf("Failed to write certificate: %v", err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatalf("Failed to write key: %v", err)
+	}
+	return certFile, keyFile
+}
+
+// servedCommonName returns the common name of the certificate currently served by reloader.
+func servedCommonName(t *testing.T, reloader *CertificateReloader) string {
+	t.Helper()
+	cert, err := reloader.GetCertificate(nil)
+	if err != nil {
+		t.Fatalf("GetCertificate() error = %v", err)
+	}
+	return cert.Leaf.Subject.CommonName
+}
+
+func TestNewCertificateReloader(t *testing.T) {
+	t.Run("loads certificate", func(t *testing.T) {
+		certFile, keyFile := writeCertificate(t, t.TempDir(), "server")
+		reloader, err := NewCertificateReloader(certFile, keyFile)
+		if err != nil {
+			t.Fatalf("NewCertificateReloader() error = %v", err)
+		}
+		if got := servedCommonName(t, reloader); got != "server" {
+			t.Errorf("Expected certificate for server, got %s", got)
+		}
+	})
+
+	t.Run("missing file", func(t *testing.T) {
+		dir := t.TempDir()
+		if _, err := NewCertificateReloader(filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")); err == nil {
+			t.Error("Expected error for missing certificate file")
+		}
+	})
+
+	t.Run("mismatching key", func(t *testing.T) {
+		certFile, _ := writeCertificate(t, t.TempDir(), "server")
+		_, keyFile := writeCertificate(t, t.TempDir(), "other")
+		if _, err := NewCertificateReloader(certFile, keyFile); !errors.Is(err, ErrInvalidCertificate) {
+			t.Errorf("NewCertificateReloader() error = %v, want %v", err, ErrInvalidCertificate)
+		}
+	})
+}
+
+func TestCertificateReloaderReload(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := writeCertificate(t, dir, "old")
+
+	reloader, err := NewCertificateReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("NewCertificateReloader() error = %v", err)
+	}
+
+	t.Run("unchanged files are not swapped", func(t *testing.T) {
+		swapped, err := reloader.Reload()
+		if err != nil || swapped {
+			t.Errorf("Reload() = %v, %v; want false, nil", swapped, err)
+		}
+	})
+
+	t.Run("changed files are swapped", func(t *testing.T) {
+		writeCertificate(t, dir, "new")
+
+		swapped, err := reloader.Reload()
+		if err != nil || !swapped {
+			t.Fatalf("Reload() = %v, %v; want true, nil", swapped, err)
+		}
+		if got := servedCommonName(t, reloader); got != "new" {
+			t.Errorf("Expected certificate for new, got %s", got)
+		}
+	})
+
+	t.Run("half-written rotation keeps previous certificate", func(t *testing.T) {
+		otherCert, _ := writeCertificate(t, t.TempDir(), "other")
+		content, err := os.ReadFile(otherCert)
+		if err != nil {
+			t.Fatalf("Failed to read certificate: %v", err)
+		}
+		if err := os.WriteFile(certFile, content, 0o600); err != nil {
+			t.Fatalf("Failed to write certificate: %v", err)
+		}
+
+		if _, err := reloader.Reload(); !errors.Is(err, ErrInvalidCertificate) {
+			t.Errorf("Reload() error = %v, want %v", err, ErrInvalidCertificate)
+		}
+		if got := servedCommonName(t, reloader); got != "new" {
+			t.Errorf("Expected previous certificate to remain, got %s", got)
+		}
+	})
+
+	t.Run("removed file keeps previous certificate", func(t *testing.T) {
+		if err := os.Remove(keyFile); err != nil {
+			t.Fatalf("Failed to remove key file: %v", err)
+		}
+
+		if _, err := reloader.Reload(); err == nil {
+			t.Error("Expected error for removed key file")
+		}
+		if got := servedCommonName(t, reloader); got != "new" {
+			t.Errorf("Expected previous certificate to remain, got %s", got)
+		}
+	})
+}
+
+func TestCertificateReloaderWatch(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := writeCertificate(t, dir, "old")
+
+	reloader, err := NewCertificateReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("NewCertificateReloader() error = %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		reloader.Watch(ctx, 10*time.Millisecond)
+		close(done)
+	}()
+
+	writeCertificate(t, dir, "new")
+
+	deadline := time.Now().Add(2 * time.Second)
+	for servedCommonName(t, reloader) != "new" {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected watcher to pick up the rotated certificate")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected watcher to stop after context cancellation")
+	}
+}
@@ -0,0 +1,98 @@
+package token
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var (
+	// ErrUnsupportedKey is returned for keys that cannot sign tokens issued by this server.
+	ErrUnsupportedKey = errors.New("unsupported key")
+	// ErrUnsupportedAlgorithm is returned for JWS algorithms keys cannot be configured to sign with.
+	ErrUnsupportedAlgorithm = errors.New("unsupported signing algorithm")
+)
+
+// rsaSigningMethods are the JWS algorithms RSA keys can be configured to sign
+// with: RSASSA-PKCS1-v1_5 (RFC 7518 Section 3.3) and RSASSA-PSS (RFC 7518 Section 3.5).
+var rsaSigningMethods = map[string]jwt.SigningMethod{
+	jwt.SigningMethodRS256.Alg(): jwt.SigningMethodRS256,
+	jwt.SigningMethodRS384.Alg(): jwt.SigningMethodRS384,
+	jwt.SigningMethodRS512.Alg(): jwt.SigningMethodRS512,
+	jwt.SigningMethodPS256.Alg(): jwt.SigningMethodPS256,
+	jwt.SigningMethodPS384.Alg(): jwt.SigningMethodPS384,
+	jwt.SigningMethodPS512.Alg(): jwt.SigningMethodPS512,
+}
+
+// PublicKey is a public key of a supported type: *rsa.PublicKey,
+// *ecdsa.PublicKey on the P-256 or P-384 curve, or ed25519.PublicKey.
+type PublicKey interface {
+	Equal(x crypto.PublicKey) bool
+}
+
+// SigningMethod returns the default JWS algorithm tokens signed with the
+// private half of publicKey use: RS256 for RSA keys, ES256 or ES384 for ECDSA
+// keys depending on the curve (RFC 7518 Section 3.4), and EdDSA for Ed25519
+// keys (RFC 8037). Key sets can select another algorithm for RSA keys, see
+// KeySet.WithRSAAlgorithm.
+func SigningMethod(publicKey crypto.PublicKey) (jwt.SigningMethod, error) {
+	switch key := publicKey.(type) {
+	case *rsa.PublicKey:
+		return jwt.SigningMethodRS256, nil
+	case *ecdsa.PublicKey:
+		switch key.Curve {
+		case elliptic.P256():
+			return jwt.SigningMethodES256, nil
+		case elliptic.P384():
+			return jwt.SigningMethodES384, nil
+		default:
+			return nil, fmt.Errorf("%w: ECDSA curve %s", ErrUnsupportedKey, key.Curve.Params().Name)
+		}
+	case ed25519.PublicKey:
+		return jwt.SigningMethodEdDSA, nil
+	default:
+		return nil, fmt.Errorf("%w: key type %T", ErrUnsupportedKey, publicKey)
+	}
+}
+
+// toPublicKey checks that key is of a supported type.
+func toPublicKey(key crypto.PublicKey) (PublicKey, error) {
+	if _, err := SigningMethod(key); err != nil {
+		return nil, err
+	}
+	return key.(PublicKey), nil
+}
+
+// ParseRSASigningMethod returns the signing method of the JWS algorithm alg
+// for RSA keys: RS256, RS384, RS512, PS256, PS384 or PS512.
+func ParseRSASigningMethod(alg string) (jwt.SigningMethod, error) {
+	method, ok := rsaSigningMethods[alg]
+	if !ok {
+		return nil, fmt.Errorf("%w %q for RSA keys", ErrUnsupportedAlgorithm, alg)
+	}
+	return method, nil
+}
+
+// checkSigningMethod checks that the private half of publicKey can sign with
+// method. RSA keys sign with any RSA algorithm; the algorithm of ECDSA and
+// Ed25519 keys is fixed by the key.
+func checkSigningMethod(publicKey crypto.PublicKey, method jwt.SigningMethod) error {
+	defaultMethod, err := SigningMethod(publicKey)
+	if err != nil {
+		return err
+	}
+	if _, isRSA := publicKey.(*rsa.PublicKey); isRSA {
+		if _, ok := rsaSigningMethods[method.Alg()]; ok {
+			return nil
+		}
+	} else if method.Alg() == defaultMethod.Alg() {
+		return nil
+	}
+	return fmt.Errorf("%w %s for key type %T", ErrUnsupportedAlgorithm, method.Alg(), publicKey)
+}
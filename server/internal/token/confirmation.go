@@ -0,0 +1,26 @@
+package token
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+)
+
+// Confirmation is the confirmation ("cnf") claim defined in RFC 7800 Section 3.1.
+// It binds an access token to the key of the client it was issued to.
+type Confirmation struct {
+	// X5tS256 is the base64url encoded SHA-256 thumbprint of the DER encoded
+	// client certificate used for mutual TLS (RFC 8705 Section 3.1).
+	X5tS256 string `json:"x5t#S256,omitempty"`
+}
+
+// NewCertificateConfirmation returns the confirmation claim binding a token to cert.
+func NewCertificateConfirmation(cert *x509.Certificate) *Confirmation {
+	return &Confirmation{X5tS256: CertificateThumbprint(cert)}
+}
+
+// CertificateThumbprint returns the x5t#S256 thumbprint of cert as defined in RFC 8705 Section 3.1.
+func CertificateThumbprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
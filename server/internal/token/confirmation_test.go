@@ -0,0 +1,24 @@
+package token
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"testing"
+)
+
+func TestCertificateThumbprint(t *testing.T) {
+	cert := &x509.Certificate{Raw: []byte("certificate DER bytes")}
+	sum := sha256.Sum256(cert.Raw)
+	want := base64.RawURLEncoding.EncodeToString(sum[:])
+
+	if got := CertificateThumbprint(cert); got != want {
+		t.Errorf("CertificateThumbprint() = %q, want %q", got, want)
+	}
+	if got := NewCertificateConfirmation(cert); got.X5tS256 != want {
+		t.Errorf("NewCertificateConfirmation() = %+v, want x5t#S256 %q", got, want)
+	}
+	if other := CertificateThumbprint(&x509.Certificate{Raw: []byte("other")}); other == want {
+		t.Error("Expected different certificates to have different thumbprints")
+	}
+}
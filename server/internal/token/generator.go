@@ -1,13 +1,18 @@
 // Package token provides JWT token generation, validation, and introspection functionality.
-// It implements token operations using RSA private keys and handles key loading
-// from files. The package follows JWT standards for token creation, signing,
-// and introspection as defined in RFC 7519 and RFC 7662.
+// It implements token operations using RSA, ECDSA and Ed25519 private keys and handles key loading
+// from files. Tokens are signed through a Signer, so keys may also stay in an HSM. The package
+// follows JWT standards for token creation, signing, and introspection as defined in RFC 7519 and
+// RFC 7662, with JWT introspection responses as defined in RFC 9701.
 package token
 
 import (
-	"crypto/rsa"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
 	"errors"
 	"log/slog"
+	"maps"
+	"slices"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
@@ -16,56 +21,221 @@ import (
 // issuerName is the name of the token issuer as defined in RFC 7662.
 const issuerName = "oauth2-server"
 
+// DefaultTokenLifetime is the lifetime of access tokens whose grant does not set one.
+const DefaultTokenLifetime = time.Hour
+
+// TokenTypeAccessToken is the typ header of access tokens issued by this server
+// as defined by the JWT profile for OAuth 2.0 access tokens (RFC 9068 Section 2.1).
+const TokenTypeAccessToken = "at+jwt"
+
+// RegisteredClaimNames are the claims the generator sets on access tokens (RFC 9068
+// Section 2.2). Extension claims with one of these names are ignored so a client
+// record cannot forge them.
+var RegisteredClaimNames = []string{"iss", "sub", "aud", "exp", "nbf", "iat", "jti", "client_id", "scope", "cnf"}
+
 var (
-	// ErrNilPrivateKey is returned when attempting to generate a token with a nil private key.
+	// ErrNilPrivateKey is returned when attempting to generate a token without a signer.
 	ErrNilPrivateKey = errors.New("private key cannot be nil")
 	// ErrEmptyUsername is returned when attempting to generate a token with an empty username.
 	// RFC 7519 Section 4.1.2 requires the subject to be locally or globally unique.
 	ErrEmptyUsername = errors.New("username cannot be empty")
 )
 
+// Claims represents the claims carried by access tokens issued by this server
+// following the JWT access token profile of RFC 9068 Section 2.2.
+// The scope claim follows RFC 8693 Section 4.2 and holds a space-delimited list
+// of the scopes granted to the client.
+//
+// Tokens issued over mutual TLS carry a cnf claim with the thumbprint of the
+// client certificate so resource servers can enforce the binding (RFC 8705 Section 3).
+//
+// Extra holds extension claims such as a tenant or roles configured on the client.
+// They are written next to the other claims at the top level of the payload.
+type Claims struct {
+	ClientID     string         `json:"client_id,omitempty"`
+	Scope        string         `json:"scope,omitempty"`
+	Confirmation *Confirmation  `json:"cnf,omitempty"`
+	Extra        map[string]any `json:"-"`
+	jwt.RegisteredClaims
+}
+
+// MarshalJSON encodes the claims with the extension claims merged into the
+// payload. Extension claims named like a registered claim are dropped, also
+// when the registered claim is omitted because it is empty.
+func (c Claims) MarshalJSON() ([]byte, error) {
+	type plain Claims
+	encoded, err := json.Marshal(plain(c))
+	if err != nil {
+		return nil, err
+	}
+	extra := maps.Clone(c.Extra)
+	maps.DeleteFunc(extra, func(name string, _ any) bool {
+		return slices.Contains(RegisteredClaimNames, name)
+	})
+	return mergeExtraMembers(encoded, extra)
+}
+
+// IssuedTo returns the ID of the client the token was issued to. Tokens issued
+// before the client_id claim was introduced name the client in sub.
+func (c *Claims) IssuedTo() string {
+	if c.ClientID != "" {
+		return c.ClientID
+	}
+	return c.Subject
+}
+
+// mergeExtraMembers adds the members of extra to the JSON object encoded
+// unless the object already has a member of the same name.
+func mergeExtraMembers(encoded []byte, extra map[string]any) ([]byte, error) {
+	if len(extra) == 0 {
+		return encoded, nil
+	}
+
+	var members map[string]json.RawMessage
+	if err := json.Unmarshal(encoded, &members); err != nil {
+		return nil, err
+	}
+	merged := make(map[string]any, len(extra)+len(members))
+	for name, value := range extra {
+		merged[name] = value
+	}
+	for name, value := range members {
+		merged[name] = value
+	}
+	return json.Marshal(merged)
+}
+
+// UnmarshalJSON decodes the claims and collects every claim that is not
+// registered by the generator into Extra.
+func (c *Claims) UnmarshalJSON(data []byte) error {
+	type plain Claims
+	if err := json.Unmarshal(data, (*plain)(c)); err != nil {
+		return err
+	}
+
+	var extra map[string]any
+	if err := json.Unmarshal(data, &extra); err != nil {
+		return err
+	}
+	for _, name := range RegisteredClaimNames {
+		delete(extra, name)
+	}
+	c.Extra = nil
+	if len(extra) > 0 {
+		c.Extra = extra
+	}
+	return nil
+}
+
+// Grant describes an access token to issue.
+type Grant struct {
+	// Username is the subject of the token. For the client credentials grant
+	// it is the client ID (RFC 9068 Section 2.2).
+	Username string
+	// ClientID is the client the token is issued to.
+	ClientID string
+	// Scope is the space-delimited list of granted scopes. Empty omits the claim.
+	Scope string
+	// Audience lists the resource servers the token is intended for.
+	Audience []string
+	// Claims are extension claims of the client, e.g. a tenant or roles.
+	// Claims named in RegisteredClaimNames are never written to the token.
+	Claims map[string]any
+	// Confirmation binds the token to the client's certificate if non-nil.
+	Confirmation *Confirmation
+	// Lifetime is the time until the token expires. Zero selects DefaultTokenLifetime.
+	Lifetime time.Duration
+}
+
 // Generator handles JWT token generation.
 type Generator struct {
-	privateKey *rsa.PrivateKey
+	signer Signer
+	keyID  string
+	method jwt.SigningMethod
 }
 
-// NewGenerator creates a new token generator.
-func NewGenerator(privateKey *rsa.PrivateKey) *Generator {
-	return &Generator{privateKey: privateKey}
+// NewGenerator creates a new token generator that signs with signer using method,
+// usually the signing method of the key set the key belongs to. A nil method
+// selects the default algorithm of the key type, see SigningMethod.
+// The keyID is stamped into the kid header of issued tokens so verifiers can
+// select the matching key from the JWKS; an empty keyID omits the header.
+func NewGenerator(signer Signer, keyID string, method jwt.SigningMethod) *Generator {
+	return &Generator{signer: signer, keyID: keyID, method: method}
 }
 
-// GenerateToken creates a new JWT token for the given username.
-func (g *Generator) GenerateToken(username string) (string, error) {
-	if g.privateKey == nil {
+// GenerateToken creates a new JWT access token for grant and returns it with
+// the expiry recorded in its exp claim. The token carries the at+jwt type
+// header and a random jti so it can be told apart from other JWTs and
+// referenced individually (RFC 9068 Section 2).
+func (g *Generator) GenerateToken(grant Grant) (string, time.Time, error) {
+	if g.signer == nil || g.signer.PublicKey() == nil {
 		slog.Error("Failed to validate private key", "error", ErrNilPrivateKey)
-		return "", ErrNilPrivateKey
+		return "", time.Time{}, ErrNilPrivateKey
 	}
 
-	if err := g.privateKey.Validate(); err != nil {
-		slog.Error("Failed to validate private key", "error", err)
-		return "", err
+	method := g.method
+	if method == nil {
+		var err error
+		if method, err = SigningMethod(g.signer.PublicKey()); err != nil {
+			slog.Error("Failed to validate private key", "error", err)
+			return "", time.Time{}, err
+		}
+	} else if err := checkSigningMethod(g.signer.PublicKey(), method); err != nil {
+		slog.Error("Failed to validate signing method", "error", err)
+		return "", time.Time{}, err
 	}
 
-	if username == "" {
+	if grant.Username == "" {
 		slog.Error("Failed to generate token", "error", ErrEmptyUsername)
-		return "", ErrEmptyUsername
+		return "", time.Time{}, ErrEmptyUsername
+	}
+
+	jti, err := newTokenID()
+	if err != nil {
+		slog.Error("Failed to generate token ID", "error", err)
+		return "", time.Time{}, err
+	}
+
+	lifetime := grant.Lifetime
+	if lifetime <= 0 {
+		lifetime = DefaultTokenLifetime
 	}
 
 	now := time.Now()
-	claims := jwt.RegisteredClaims{
-		Issuer:    issuerName,
-		Subject:   username,
-		IssuedAt:  jwt.NewNumericDate(now),
-		NotBefore: jwt.NewNumericDate(now),
-		ExpiresAt: jwt.NewNumericDate(now.Add(1 * time.Hour)),
+	claims := Claims{
+		ClientID:     grant.ClientID,
+		Scope:        grant.Scope,
+		Confirmation: grant.Confirmation,
+		Extra:        grant.Claims,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    issuerName,
+			Subject:   grant.Username,
+			Audience:  grant.Audience,
+			ID:        jti,
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(lifetime)),
+		},
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
-	tokenString, err := token.SignedString(g.privateKey)
+	token := jwt.NewWithClaims(method, claims)
+	token.Header["typ"] = TokenTypeAccessToken
+	if g.keyID != "" {
+		token.Header["kid"] = g.keyID
+	}
+	signed, err := signToken(token, g.signer)
 	if err != nil {
 		slog.Error("Failed to sign token", "error", err)
-		return "", err
+		return "", time.Time{}, err
 	}
+	return signed, claims.ExpiresAt.Time, nil
+}
 
-	return tokenString, nil
+// newTokenID returns a random 128 bit token identifier for the jti claim.
+func newTokenID() (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(id), nil
 }
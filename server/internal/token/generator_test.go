@@ -1,9 +1,15 @@
 package token
 
 import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
+	"errors"
 	"math/big"
+	"reflect"
 	"testing"
 	"time"
 
@@ -27,11 +33,11 @@ func TestGenerateToken(t *testing.T) {
 		t.Fatalf("Failed to generate key pair: %v", err)
 	}
 
-	generator := NewGenerator(privateKey)
+	generator := NewGenerator(NewLocalSigner(privateKey), "test-kid", nil)
 
 	t.Run("successful token generation", func(t *testing.T) {
 		username := "testuser"
-		token, err := generator.GenerateToken(username)
+		token, _, err := generator.GenerateToken(Grant{Username: username})
 		if err != nil {
 			t.Fatalf("Failed to generate token: %v", err)
 		}
@@ -101,11 +107,37 @@ func TestGenerateToken(t *testing.T) {
 		}
 	})
 
+	t.Run("kid header", func(t *testing.T) {
+		token, _, err := generator.GenerateToken(Grant{Username: "testuser"})
+		if err != nil {
+			t.Fatalf("Failed to generate token: %v", err)
+		}
+		parsedToken, _, err := jwt.NewParser().ParseUnverified(token, jwt.MapClaims{})
+		if err != nil {
+			t.Fatalf("Failed to parse token: %v", err)
+		}
+		if parsedToken.Header["kid"] != "test-kid" {
+			t.Errorf("Expected kid header test-kid, got %v", parsedToken.Header["kid"])
+		}
+
+		unnamed, _, err := NewGenerator(NewLocalSigner(privateKey), "", nil).GenerateToken(Grant{Username: "testuser"})
+		if err != nil {
+			t.Fatalf("Failed to generate token: %v", err)
+		}
+		parsedToken, _, err = jwt.NewParser().ParseUnverified(unnamed, jwt.MapClaims{})
+		if err != nil {
+			t.Fatalf("Failed to parse token: %v", err)
+		}
+		if _, ok := parsedToken.Header["kid"]; ok {
+			t.Error("Expected kid header to be omitted without key ID")
+		}
+	})
+
 	t.Run("empty token and error on failed signing", func(t *testing.T) {
 		// Create a generator with nil private key
-		invalidGenerator := NewGenerator(nil)
+		invalidGenerator := NewGenerator(nil, "", nil)
 
-		token, err := invalidGenerator.GenerateToken("testuser")
+		token, _, err := invalidGenerator.GenerateToken(Grant{Username: "testuser"})
 		if err == nil {
 			t.Error("Expected error for invalid private key")
 		}
@@ -128,8 +160,8 @@ func TestGenerateToken(t *testing.T) {
 			Primes:    []*big.Int{},       // Empty primes
 		}
 
-		invalidGenerator := NewGenerator(invalidKey)
-		token, err := invalidGenerator.GenerateToken("testuser")
+		invalidGenerator := NewGenerator(NewLocalSigner(invalidKey), "", nil)
+		token, _, err := invalidGenerator.GenerateToken(Grant{Username: "testuser"})
 		if err == nil {
 			t.Error("Expected error for invalid private key parameters")
 		}
@@ -141,8 +173,22 @@ func TestGenerateToken(t *testing.T) {
 	// RFC 7519 Section 4.1.2 defines 'sub' (subject) as a case-sensitive string
 	// that is locally unique in the context of the issuer or globally unique.
 	// An empty subject would violate the uniqueness requirement.
+	t.Run("error on unsupported key type", func(t *testing.T) {
+		p521Key, err := ecdsa.GenerateKey(elliptic.P521(), rand.Reader)
+		if err != nil {
+			t.Fatalf("Failed to generate EC key: %v", err)
+		}
+		token, _, err := NewGenerator(NewLocalSigner(p521Key), "", nil).GenerateToken(Grant{Username: "testuser"})
+		if !errors.Is(err, ErrUnsupportedKey) {
+			t.Errorf("Expected %v, got %v", ErrUnsupportedKey, err)
+		}
+		if token != "" {
+			t.Error("Expected empty token for unsupported key")
+		}
+	})
+
 	t.Run("empty username validation", func(t *testing.T) {
-		token, err := generator.GenerateToken("")
+		token, _, err := generator.GenerateToken(Grant{})
 		if err == nil {
 			t.Error("Expected error for empty username")
 		}
@@ -151,8 +197,159 @@ func TestGenerateToken(t *testing.T) {
 		}
 	})
 
+	t.Run("scope claim", func(t *testing.T) {
+		tests := []struct {
+			name      string
+			scope     string
+			wantScope interface{}
+		}{
+			{name: "granted scope is recorded", scope: "read write", wantScope: "read write"},
+			{name: "empty scope is omitted", scope: "", wantScope: nil},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				token, _, err := generator.GenerateToken(Grant{Username: "testuser", Scope: tt.scope})
+				if err != nil {
+					t.Fatalf("Failed to generate token: %v", err)
+				}
+
+				parsedToken, err := jwt.Parse(token, func(_ *jwt.Token) (interface{}, error) {
+					return &privateKey.PublicKey, nil
+				})
+				if err != nil {
+					t.Fatalf("Failed to parse token: %v", err)
+				}
+
+				claims, maybeok := parsedToken.Claims.(jwt.MapClaims)
+				if !maybeok {
+					t.Fatal("Failed to parse claims")
+				}
+				if claims["scope"] != tt.wantScope {
+					t.Errorf("Expected scope %v, got %v", tt.wantScope, claims["scope"])
+				}
+			})
+		}
+	})
+
+	t.Run("confirmation claim", func(t *testing.T) {
+		token, _, err := generator.GenerateToken(Grant{Username: "testuser", Confirmation: &Confirmation{X5tS256: "thumbprint"}})
+		if err != nil {
+			t.Fatalf("Failed to generate token: %v", err)
+		}
+
+		claims := &Claims{}
+		if _, err := jwt.ParseWithClaims(token, claims, func(_ *jwt.Token) (interface{}, error) {
+			return &privateKey.PublicKey, nil
+		}); err != nil {
+			t.Fatalf("Failed to parse token: %v", err)
+		}
+		if claims.Confirmation == nil || claims.Confirmation.X5tS256 != "thumbprint" {
+			t.Errorf("Expected cnf claim with x5t#S256 thumbprint, got %+v", claims.Confirmation)
+		}
+
+		unbound, _, err := generator.GenerateToken(Grant{Username: "testuser"})
+		if err != nil {
+			t.Fatalf("Failed to generate token: %v", err)
+		}
+		parsed, err := jwt.Parse(unbound, func(_ *jwt.Token) (interface{}, error) {
+			return &privateKey.PublicKey, nil
+		})
+		if err != nil {
+			t.Fatalf("Failed to parse token: %v", err)
+		}
+		if _, ok := parsed.Claims.(jwt.MapClaims)["cnf"]; ok {
+			t.Error("Expected cnf claim to be omitted for unbound tokens")
+		}
+	})
+
+	t.Run("access token profile claims", func(t *testing.T) {
+		grant := Grant{
+			Username: "billing",
+			ClientID: "billing",
+			Scope:    "read",
+			Audience: []string{"https://api.example.com"},
+			Claims:   map[string]any{"tenant": "acme", "roles": []string{"admin", "auditor"}, "iss": "forged"},
+		}
+		token, _, err := generator.GenerateToken(grant)
+		if err != nil {
+			t.Fatalf("Failed to generate token: %v", err)
+		}
+
+		claims := &Claims{}
+		parsed, err := jwt.ParseWithClaims(token, claims, func(_ *jwt.Token) (interface{}, error) {
+			return &privateKey.PublicKey, nil
+		})
+		if err != nil {
+			t.Fatalf("Failed to parse token: %v", err)
+		}
+		if typ := parsed.Header["typ"]; typ != TokenTypeAccessToken {
+			t.Errorf("Expected typ header %q, got %v", TokenTypeAccessToken, typ)
+		}
+		if claims.ClientID != "billing" {
+			t.Errorf("Expected client_id billing, got %q", claims.ClientID)
+		}
+		if len(claims.Audience) != 1 || claims.Audience[0] != "https://api.example.com" {
+			t.Errorf("Expected aud https://api.example.com, got %v", claims.Audience)
+		}
+		if claims.ID == "" {
+			t.Error("Expected jti claim to be set")
+		}
+		if claims.Issuer != issuerName {
+			t.Errorf("Expected extension claims not to override iss, got %q", claims.Issuer)
+		}
+		wantExtra := map[string]any{"tenant": "acme", "roles": []any{"admin", "auditor"}}
+		if !reflect.DeepEqual(claims.Extra, wantExtra) {
+			t.Errorf("Expected extension claims %v, got %v", wantExtra, claims.Extra)
+		}
+
+		other, _, err := generator.GenerateToken(grant)
+		if err != nil {
+			t.Fatalf("Failed to generate token: %v", err)
+		}
+		otherClaims := &Claims{}
+		if _, _, err := jwt.NewParser().ParseUnverified(other, otherClaims); err != nil {
+			t.Fatalf("Failed to parse token: %v", err)
+		}
+		if otherClaims.ID == claims.ID {
+			t.Error("Expected every token to get a unique jti")
+		}
+	})
+
+	t.Run("extension claims cannot set omitted registered claims", func(t *testing.T) {
+		// scope, client_id, aud and cnf are omitted when empty, so only the
+		// registered names keep extension claims from filling them in
+		grant := Grant{
+			Username: "billing",
+			Claims: map[string]any{
+				"tenant": "acme", "scope": "admin", "client_id": "victim",
+				"aud": "https://admin.example.com", "cnf": map[string]any{"x5t#S256": "forged"},
+			},
+		}
+		token, _, err := generator.GenerateToken(grant)
+		if err != nil {
+			t.Fatalf("Failed to generate token: %v", err)
+		}
+
+		parsed, err := jwt.Parse(token, func(_ *jwt.Token) (interface{}, error) {
+			return &privateKey.PublicKey, nil
+		})
+		if err != nil {
+			t.Fatalf("Failed to parse token: %v", err)
+		}
+		claims := parsed.Claims.(jwt.MapClaims)
+		for _, name := range []string{"scope", "client_id", "aud", "cnf"} {
+			if value, ok := claims[name]; ok {
+				t.Errorf("Expected no %s claim, got %v", name, value)
+			}
+		}
+		if claims["tenant"] != "acme" {
+			t.Errorf("Expected tenant claim acme, got %v", claims["tenant"])
+		}
+	})
+
 	t.Run("token timestamps are sequential", func(t *testing.T) {
-		token, err := generator.GenerateToken("testuser")
+		token, _, err := generator.GenerateToken(Grant{Username: "testuser"})
 		if err != nil {
 			t.Fatalf("Failed to generate token: %v", err)
 		}
@@ -193,4 +390,107 @@ func TestGenerateToken(t *testing.T) {
 			t.Errorf("Expected 1 hour between iat and exp, got %v seconds", exp.Unix()-iat.Unix())
 		}
 	})
+
+	t.Run("token lifetime", func(t *testing.T) {
+		token, expiresAt, err := generator.GenerateToken(Grant{Username: "testuser", Lifetime: 5 * time.Minute})
+		if err != nil {
+			t.Fatalf("Failed to generate token: %v", err)
+		}
+
+		claims := &Claims{}
+		if _, err := jwt.ParseWithClaims(token, claims, func(_ *jwt.Token) (interface{}, error) {
+			return &privateKey.PublicKey, nil
+		}); err != nil {
+			t.Fatalf("Failed to parse token: %v", err)
+		}
+		if got := claims.ExpiresAt.Unix() - claims.IssuedAt.Unix(); got != 300 {
+			t.Errorf("Expected 5 minutes between iat and exp, got %v seconds", got)
+		}
+		if !expiresAt.Equal(claims.ExpiresAt.Time) {
+			t.Errorf("Expected returned expiry %v to match the exp claim %v", expiresAt, claims.ExpiresAt.Time)
+		}
+	})
+}
+
+func TestGenerateTokenAlgorithms(t *testing.T) {
+	p256Key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate EC key: %v", err)
+	}
+	p384Key, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate EC key: %v", err)
+	}
+	_, ed25519Key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate Ed25519 key: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		privateKey crypto.Signer
+		wantAlg    string
+	}{
+		{name: "ECDSA P-256", privateKey: p256Key, wantAlg: "ES256"},
+		{name: "ECDSA P-384", privateKey: p384Key, wantAlg: "ES384"},
+		{name: "Ed25519", privateKey: ed25519Key, wantAlg: "EdDSA"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token, _, err := NewGenerator(NewLocalSigner(tt.privateKey), "test-kid", nil).GenerateToken(Grant{Username: "testuser"})
+			if err != nil {
+				t.Fatalf("Failed to generate token: %v", err)
+			}
+
+			parsedToken, err := jwt.Parse(token, func(_ *jwt.Token) (interface{}, error) {
+				return tt.privateKey.Public(), nil
+			}, jwt.WithValidMethods([]string{tt.wantAlg}))
+			if err != nil {
+				t.Fatalf("Failed to parse token: %v", err)
+			}
+			if parsedToken.Method.Alg() != tt.wantAlg {
+				t.Errorf("Expected alg %s, got %s", tt.wantAlg, parsedToken.Method.Alg())
+			}
+		})
+	}
+}
+
+func TestGenerateTokenSigningMethod(t *testing.T) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate key pair: %v", err)
+	}
+
+	for _, method := range []jwt.SigningMethod{jwt.SigningMethodRS384, jwt.SigningMethodRS512, jwt.SigningMethodPS256, jwt.SigningMethodPS384, jwt.SigningMethodPS512} {
+		t.Run(method.Alg(), func(t *testing.T) {
+			token, _, err := NewGenerator(NewLocalSigner(privateKey), "test-kid", method).GenerateToken(Grant{Username: "testuser"})
+			if err != nil {
+				t.Fatalf("Failed to generate token: %v", err)
+			}
+			if _, err := jwt.Parse(token, func(_ *jwt.Token) (interface{}, error) {
+				return &privateKey.PublicKey, nil
+			}, jwt.WithValidMethods([]string{method.Alg()})); err != nil {
+				t.Errorf("Failed to parse token: %v", err)
+			}
+		})
+	}
+
+	t.Run("method not matching the key", func(t *testing.T) {
+		ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatalf("Failed to generate EC key: %v", err)
+		}
+		for _, generator := range []*Generator{
+			NewGenerator(NewLocalSigner(ecKey), "", jwt.SigningMethodPS256),
+			NewGenerator(NewLocalSigner(ecKey), "", jwt.SigningMethodES384),
+			NewGenerator(NewLocalSigner(privateKey), "", jwt.SigningMethodES256),
+			NewGenerator(NewLocalSigner(privateKey), "", jwt.SigningMethodHS256),
+		} {
+			token, _, err := generator.GenerateToken(Grant{Username: "testuser"})
+			if !errors.Is(err, ErrUnsupportedAlgorithm) || token != "" {
+				t.Errorf("GenerateToken() = %q, %v; want %v", token, err, ErrUnsupportedAlgorithm)
+			}
+		}
+	})
 }
@@ -1,15 +1,22 @@
 package token
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
 	"log/slog"
 	"net/http"
 	"oauth2-task/internal/request"
+	"oauth2-task/internal/revocation"
 	"strings"
 
 	"github.com/golang-jwt/jwt/v5"
 )
 
+// TokenTypeHintAccessToken is the token_type_hint value for access tokens
+// (RFC 7009 Section 2.1), which RFC 7662 Section 2.1 reuses.
+const TokenTypeHintAccessToken = "access_token"
+
 // ErrorResponse represents an OAuth2 error response.
 type ErrorResponse struct {
 	Error            string `json:"error"`
@@ -19,51 +26,222 @@ type ErrorResponse struct {
 // IntrospectionResponse represents the OAuth2 token introspection response
 // as defined in RFC 7662 Section 2.2.
 type IntrospectionResponse struct {
-	Active    bool   `json:"active"`
-	Scope     string `json:"scope,omitempty"`
-	ClientID  string `json:"client_id,omitempty"`
-	Username  string `json:"username,omitempty"`
-	TokenType string `json:"token_type,omitempty"`
-	Exp       int64  `json:"exp,omitempty"`
-	Iat       int64  `json:"iat,omitempty"`
-	Nbf       int64  `json:"nbf,omitempty"`
-	Sub       string `json:"sub,omitempty"`
-	Aud       string `json:"aud,omitempty"`
-	Iss       string `json:"iss,omitempty"`
-	Jti       string `json:"jti,omitempty"`
-}
-
-// validateSigningMethod validates that the token uses RSA signing method and returns the public key for verification.
-func validateSigningMethod(token *jwt.Token, keyPair KeyPair) (interface{}, error) {
-	// Validate the signing method
-	if _, ok := token.Method.(*jwt.SigningMethodRSA); !ok {
+	Active   bool   `json:"active"`
+	Scope    string `json:"scope,omitempty"`
+	ClientID string `json:"client_id,omitempty"`
+	// Username is the subject of the token, the client itself for the client credentials grant.
+	Username  string   `json:"username,omitempty"`
+	TokenType string   `json:"token_type,omitempty"`
+	Exp       int64    `json:"exp,omitempty"`
+	Iat       int64    `json:"iat,omitempty"`
+	Nbf       int64    `json:"nbf,omitempty"`
+	Sub       string   `json:"sub,omitempty"`
+	Aud       Audience `json:"aud,omitempty"`
+	Iss       string   `json:"iss,omitempty"`
+	Jti       string   `json:"jti,omitempty"`
+	// Cnf reports the certificate the token is bound to (RFC 8705 Section 3.2).
+	Cnf *Confirmation `json:"cnf,omitempty"`
+	// Extra holds the extension claims of the token, which RFC 7662 Section 2.2
+	// allows as additional top-level members of the response.
+	Extra map[string]any `json:"-"`
+}
+
+// MarshalJSON encodes the response with the extension claims of the token as
+// top-level members. Members defined by RFC 7662 take precedence.
+func (r IntrospectionResponse) MarshalJSON() ([]byte, error) {
+	type plain IntrospectionResponse
+	encoded, err := json.Marshal(plain(r))
+	if err != nil {
+		return nil, err
+	}
+	return mergeExtraMembers(encoded, r.Extra)
+}
+
+// introspectionMemberNames are the members of IntrospectionResponse. Other
+// members of an encoded response are extension claims.
+var introspectionMemberNames = []string{"active", "scope", "client_id", "username", "token_type", "exp", "iat", "nbf", "sub", "aud", "iss", "jti", "cnf"}
+
+// UnmarshalJSON decodes the response and collects the extension claims into Extra.
+func (r *IntrospectionResponse) UnmarshalJSON(data []byte) error {
+	type plain IntrospectionResponse
+	if err := json.Unmarshal(data, (*plain)(r)); err != nil {
+		return err
+	}
+
+	var extra map[string]any
+	if err := json.Unmarshal(data, &extra); err != nil {
+		return err
+	}
+	for _, name := range introspectionMemberNames {
+		delete(extra, name)
+	}
+	r.Extra = nil
+	if len(extra) > 0 {
+		r.Extra = extra
+	}
+	return nil
+}
+
+// Audience is the aud member of an introspection response. It is encoded as a
+// single string for one audience and as an array otherwise, and decoded from
+// either form (RFC 7519 Section 4.1.3).
+type Audience []string
+
+// MarshalJSON encodes a single audience as a string and several as an array.
+func (a Audience) MarshalJSON() ([]byte, error) {
+	if len(a) == 1 {
+		return json.Marshal(a[0])
+	}
+	return json.Marshal([]string(a))
+}
+
+// UnmarshalJSON decodes an audience given as a string or an array of strings.
+func (a *Audience) UnmarshalJSON(data []byte) error {
+	var audience jwt.ClaimStrings
+	if err := json.Unmarshal(data, &audience); err != nil {
+		return err
+	}
+	*a = Audience(audience)
+	return nil
+}
+
+// validateSigningMethod returns the public key to verify the token with after checking
+// that the token is signed with the algorithm registered for that key.
+// The key is selected by the kid header of the token. Tokens without a kid, issued before
+// key IDs were introduced, are verified with the current signing key.
+func validateSigningMethod(token *jwt.Token, keys *KeySet) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	key := keys.VerificationKeys()[0]
+	if kid != "" {
+		var ok bool
+		if key, ok = keys.Lookup(kid); !ok {
+			return nil, fmt.Errorf("%w: unknown key ID %q", jwt.ErrTokenUnverifiable, kid)
+		}
+	}
+
+	// Validate the signing method against the key rather than trusting the alg header
+	if token.Method == nil || token.Method.Alg() != key.Method.Alg() {
 		return nil, jwt.ErrSignatureInvalid
 	}
-	return keyPair.PublicKey(), nil
+	return key.PublicKey, nil
 }
 
-// validateToken parses and validates a JWT token using the provided key pair.
-func validateToken(tokenString string, keyPair KeyPair) (*jwt.Token, error) {
-	return jwt.ParseWithClaims(tokenString, &jwt.RegisteredClaims{}, func(token *jwt.Token) (interface{}, error) {
-		return validateSigningMethod(token, keyPair)
-	})
+// validateToken parses and validates an access token using the keys of the
+// provided key set. Other JWTs signed with the same keys, such as JWT
+// introspection responses, are rejected by their typ header (RFC 9068 Section 4),
+// and access tokens must expire.
+func validateToken(tokenString string, keys *KeySet) (*jwt.Token, error) {
+	return jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
+		if typ, _ := token.Header["typ"].(string); !isAccessTokenType(typ) {
+			return nil, fmt.Errorf("%w: typ %q is not %s", jwt.ErrTokenUnverifiable, typ, TokenTypeAccessToken)
+		}
+		return validateSigningMethod(token, keys)
+	}, jwt.WithExpirationRequired())
 }
 
-// extractTokenFromRequest extracts the token from either the form data or Authorization header.
-func extractTokenFromRequest(r *http.Request) string {
-	// Try form value first
-	token := r.FormValue("token")
-	if token != "" {
-		return token
+// isAccessTokenType reports whether typ is the type of access tokens, which
+// RFC 9068 Section 4 allows with or without the application/ prefix.
+func isAccessTokenType(typ string) bool {
+	return strings.EqualFold(typ, TokenTypeAccessToken) || strings.EqualFold(typ, "application/"+TokenTypeAccessToken)
+}
+
+// VerifyToken verifies the signature and validity period of tokenString with
+// the keys of the provided key set and returns its claims.
+func VerifyToken(tokenString string, keys *KeySet) (*Claims, error) {
+	parsedToken, err := validateToken(tokenString, keys)
+	if err != nil {
+		return nil, err
+	}
+	claims, ok := parsedToken.Claims.(*Claims)
+	if !ok || !parsedToken.Valid {
+		return nil, jwt.ErrTokenInvalidClaims
 	}
+	return claims, nil
+}
+
+// Caller is the authenticated resource server calling the introspection endpoint.
+type Caller struct {
+	// ClientID identifies the caller. Callers may always introspect tokens issued to themselves.
+	ClientID string
+	// Audiences lists the audiences of the other tokens the caller may introspect.
+	Audiences []string
+	// Encryption encrypts JWT introspection responses to the caller if non-nil (RFC 9701 Section 5).
+	Encryption *Encryption
+}
 
-	// Try Authorization header
-	auth := r.Header.Get("Authorization")
-	if auth != "" && strings.HasPrefix(auth, "Bearer ") {
-		return strings.TrimPrefix(auth, "Bearer ")
+// mayIntrospect reports whether the caller may learn about the token with claims:
+// the token was issued to the caller or for one of the caller's audiences.
+func (c Caller) mayIntrospect(claims *Claims) bool {
+	if claims.IssuedTo() == c.ClientID {
+		return true
 	}
+	for _, audience := range claims.Audience {
+		for _, allowed := range c.Audiences {
+			if audience == allowed {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// CallerAuthenticator authenticates callers of the introspection endpoint,
+// which RFC 7662 Section 2.1 requires to prevent token scanning.
+type CallerAuthenticator interface {
+	// AuthenticateCaller returns the authenticated caller of r. It writes an
+	// error response and returns false if authentication fails.
+	AuthenticateCaller(w http.ResponseWriter, r *http.Request) (Caller, bool)
+}
+
+// extractTokenFromRequest extracts the token to introspect from the form data.
+// The Authorization header carries the credentials of the caller, never the
+// token to introspect (RFC 7662 Section 2.1).
+func extractTokenFromRequest(r *http.Request) string {
+	return r.PostFormValue("token")
+}
+
+// tokenLookup resolves tokens of one token type the server issues.
+type tokenLookup struct {
+	// hint is the token_type_hint value naming the token type.
+	hint   string
+	lookup func(tokenString string, keys *KeySet) (*jwt.Token, error)
+}
 
-	return ""
+// tokenLookups lists the token types that can be introspected. Refresh or
+// opaque tokens are added here once the server issues them.
+var tokenLookups = []tokenLookup{
+	{hint: TokenTypeHintAccessToken, lookup: validateToken},
+}
+
+// searchOrder returns tokenLookups with the lookup for the hinted token type first.
+// The other token types follow because the hint may be wrong, and unknown hints
+// leave the order unchanged (RFC 7662 Section 2.1).
+func searchOrder(hint string) []tokenLookup {
+	order := make([]tokenLookup, 0, len(tokenLookups))
+	for _, l := range tokenLookups {
+		if l.hint == hint {
+			order = append(order, l)
+		}
+	}
+	for _, l := range tokenLookups {
+		if l.hint != hint {
+			order = append(order, l)
+		}
+	}
+	return order
+}
+
+// lookupToken resolves tokenString by trying the token types in the search order
+// for hint. It returns the error of the last lookup if no token type matches.
+func lookupToken(tokenString, hint string, keys *KeySet) (*jwt.Token, error) {
+	var err error
+	for _, l := range searchOrder(hint) {
+		var parsedToken *jwt.Token
+		if parsedToken, err = l.lookup(tokenString, keys); err == nil {
+			return parsedToken, nil
+		}
+	}
+	return nil, err
 }
 
 // introspectToken analyzes a validated token and returns the introspection response.
@@ -72,63 +250,136 @@ func introspectToken(parsedToken *jwt.Token) IntrospectionResponse {
 	if !parsedToken.Valid {
 		return IntrospectionResponse{Active: false}
 	}
-	claims, ok := parsedToken.Claims.(*jwt.RegisteredClaims)
+	claims, ok := parsedToken.Claims.(*Claims)
 	if !ok {
 		return IntrospectionResponse{Active: false}
 	}
 
 	return IntrospectionResponse{
 		Active:    true,
+		Scope:     claims.Scope,
+		ClientID:  claims.ClientID,
+		Username:  claims.Subject,
 		TokenType: "Bearer",
 		Sub:       claims.Subject,
+		Aud:       Audience(claims.Audience),
 		Iss:       claims.Issuer,
-		Exp:       claims.ExpiresAt.Unix(),
-		Iat:       claims.IssuedAt.Unix(),
-		Nbf:       claims.NotBefore.Unix(),
+		Jti:       claims.ID,
+		Exp:       unixTime(claims.ExpiresAt),
+		Iat:       unixTime(claims.IssuedAt),
+		Nbf:       unixTime(claims.NotBefore),
+		Cnf:       claims.Confirmation,
+		Extra:     claims.Extra,
 	}
 }
 
-func writeIntrospectionError(w http.ResponseWriter, status int, message string) {
+// unixTime returns date as seconds since the epoch, or 0 for a claim the token
+// does not carry, which the response then omits.
+func unixTime(date *jwt.NumericDate) int64 {
+	if date == nil {
+		return 0
+	}
+	return date.Unix()
+}
+
+// writeIntrospectionError writes an OAuth2 error response with the error code
+// and description (RFC 6749 Section 5.2). Invalid tokens are not errors but
+// inactive (RFC 7662 Section 2.2).
+func writeIntrospectionError(w http.ResponseWriter, status int, code, description string) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(status)
 
-	// For token validation failures, return active=false as per RFC 7662
-	if message == "Token validation failed" {
-		if err := json.NewEncoder(w).Encode(IntrospectionResponse{Active: false}); err != nil {
-			slog.Error("Error encoding response", "error", err)
-		}
-		return
-	}
-
-	// For other errors, return the error response
 	if err := json.NewEncoder(w).Encode(ErrorResponse{
-		Error: message,
+		Error:            code,
+		ErrorDescription: description,
 	}); err != nil {
 		slog.Error("Error encoding response", "error", err)
 	}
 }
 
+// isRevoked reports whether the token was revoked (RFC 7009). Tokens without
+// a jti, issued before tokens carried one, cannot be revoked.
+func isRevoked(ctx context.Context, parsedToken *jwt.Token, revocations revocation.Store) (bool, error) {
+	claims, ok := parsedToken.Claims.(*Claims)
+	if !ok || claims.ID == "" {
+		return false, nil
+	}
+	return revocations.IsRevoked(ctx, claims.ID)
+}
+
 // HandleIntrospection processes token introspection requests as defined in RFC 7662 Section 2.1.
-func HandleIntrospection(keyPair KeyPair) http.HandlerFunc {
+// Parameters are only accepted from an application/x-www-form-urlencoded body; tokens
+// in the URL are rejected since they end up in access logs.
+// Callers are authenticated by callers and only learn about tokens they may introspect.
+// The token_type_hint parameter selects the token type that is looked up first.
+// Tokens are verified with the key of the current key set named in their kid header.
+// Tokens recorded in revocations are reported as inactive.
+// Callers accepting application/token-introspection+jwt receive the response as a
+// JWT signed with the current signing key (RFC 9701).
+func HandleIntrospection(keys KeySource, revocations revocation.Store, callers CallerAuthenticator) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		// Technical: HTTP method validation
 		if !request.ValidateMethod(w, r, http.MethodPost) {
 			return // Stop if invalid method
 		}
 
+		// Technical: Form validation (RFC 7662 Section 2.1)
+		if !request.ValidateContentType(w, r, "application/x-www-form-urlencoded") {
+			return
+		}
+		if r.URL.Query().Has("token") {
+			slog.Error("Token sent in the URL of an introspection request")
+			writeIntrospectionError(w, http.StatusBadRequest, "invalid_request", "Token must be sent in the request body")
+			return
+		}
+		if err := r.ParseForm(); err != nil {
+			slog.Error("Failed to parse introspection request", "error", err)
+			writeIntrospectionError(w, http.StatusBadRequest, "invalid_request", "Malformed request body")
+			return
+		}
+
+		// Technical: Caller authentication
+		caller, ok := callers.AuthenticateCaller(w, r)
+		if !ok {
+			return
+		}
+
+		keySet := keys.KeySet()
+		asJWT := request.AcceptsMediaType(r, ContentTypeIntrospectionJWT)
+
 		// Technical: Token extraction
 		tokenString := extractTokenFromRequest(r)
 		if tokenString == "" {
-			writeIntrospectionError(w, http.StatusBadRequest, "No token provided")
+			writeIntrospectionError(w, http.StatusBadRequest, "invalid_request", "Missing token parameter")
 			slog.Error("No token provided for introspection")
 			return
 		}
 
 		// Technical: Token validation
-		parsedToken, err := validateToken(tokenString, keyPair)
+		parsedToken, err := lookupToken(tokenString, r.PostForm.Get("token_type_hint"), keySet)
 		if err != nil {
 			slog.Error("Token validation failed", "error", err)
-			writeIntrospectionError(w, http.StatusOK, "Token validation failed")
+			writeIntrospectionResponse(w, keySet, caller, asJWT, IntrospectionResponse{Active: false})
+			return
+		}
+
+		// Business Logic: Revoked tokens are no longer active (RFC 7009 Section 2)
+		revoked, err := isRevoked(r.Context(), parsedToken, revocations)
+		if err != nil {
+			slog.Error("Failed to check token revocation", "error", err)
+			writeIntrospectionError(w, http.StatusInternalServerError, "server_error", "Failed to check token revocation")
+			return
+		}
+		if revoked {
+			slog.Info("Introspected revoked token")
+			writeIntrospectionResponse(w, keySet, caller, asJWT, IntrospectionResponse{Active: false})
+			return
+		}
+
+		// Business Logic: Callers only learn about tokens meant for them (RFC 7662 Section 4)
+		if claims, ok := parsedToken.Claims.(*Claims); ok && !caller.mayIntrospect(claims) {
+			slog.Info("Caller is not allowed to introspect token", "client_id", caller.ClientID, "aud", claims.Audience)
+			writeIntrospectionResponse(w, keySet, caller, asJWT, IntrospectionResponse{Active: false})
 			return
 		}
 
@@ -136,12 +387,6 @@ func HandleIntrospection(keyPair KeyPair) http.HandlerFunc {
 		response := introspectToken(parsedToken)
 
 		// Technical: Response handling
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusOK)
-		if err := json.NewEncoder(w).Encode(response); err != nil {
-			slog.Error("Failed to encode introspection response", "error", err)
-			writeIntrospectionError(w, http.StatusInternalServerError, "Failed to encode introspection response")
-			return
-		}
+		writeIntrospectionResponse(w, keySet, caller, asJWT, response)
 	}
 }
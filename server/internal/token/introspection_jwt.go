@@ -0,0 +1,64 @@
+package token
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// ContentTypeIntrospectionJWT is the media type callers accept to receive
+// introspection responses as a signed JWT (RFC 9701 Section 4).
+const ContentTypeIntrospectionJWT = "application/token-introspection+jwt"
+
+// TokenTypeIntrospectionJWT is the typ header of JWT introspection responses (RFC 9701 Section 5).
+const TokenTypeIntrospectionJWT = "token-introspection+jwt"
+
+// signIntrospectionResponse returns response as a JWT for caller (RFC 9701 Section 5).
+// The JWT is signed with the signing key of keys and carries the response in its
+// token_introspection claim. It is encrypted as a nested JWT if the caller
+// registered an encryption key.
+func signIntrospectionResponse(keys *KeySet, caller Caller, response IntrospectionResponse, now time.Time) (string, error) {
+	keyID, signingKey := keys.SigningKey()
+	token := jwt.NewWithClaims(keys.SigningMethod(), jwt.MapClaims{
+		"iss":                 issuerName,
+		"aud":                 caller.ClientID,
+		"iat":                 now.Unix(),
+		"token_introspection": response,
+	})
+	token.Header["typ"] = TokenTypeIntrospectionJWT
+	token.Header["kid"] = keyID
+
+	signed, err := signToken(token, signingKey.Signer())
+	if err != nil || caller.Encryption == nil {
+		return signed, err
+	}
+	return encryptJWE([]byte(signed), *caller.Encryption, "JWT")
+}
+
+// writeIntrospectionResponse writes response as JSON, or as a JWT for caller
+// if asJWT is set. Signing failures are reported as a server error.
+func writeIntrospectionResponse(w http.ResponseWriter, keys *KeySet, caller Caller, asJWT bool, response IntrospectionResponse) {
+	if !asJWT {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		if err := json.NewEncoder(w).Encode(response); err != nil {
+			slog.Error("Failed to encode introspection response", "error", err)
+		}
+		return
+	}
+
+	signed, err := signIntrospectionResponse(keys, caller, response, time.Now())
+	if err != nil {
+		slog.Error("Failed to sign introspection response", "client_id", caller.ClientID, "error", err)
+		writeIntrospectionError(w, http.StatusInternalServerError, "server_error", "Failed to sign introspection response")
+		return
+	}
+	w.Header().Set("Content-Type", ContentTypeIntrospectionJWT)
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write([]byte(signed)); err != nil {
+		slog.Error("Failed to write introspection response", "error", err)
+	}
+}
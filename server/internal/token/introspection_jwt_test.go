@@ -0,0 +1,140 @@
+package token
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"oauth2-task/internal/revocation"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// parseIntrospectionJWT verifies a JWT introspection response with keys and returns its claims.
+func parseIntrospectionJWT(t *testing.T, signed string, keys *KeySet) jwt.MapClaims {
+	t.Helper()
+	parsed, err := jwt.Parse(signed, func(token *jwt.Token) (interface{}, error) {
+		return validateSigningMethod(token, keys)
+	})
+	if err != nil {
+		t.Fatalf("Failed to verify introspection response: %v", err)
+	}
+	if typ := parsed.Header["typ"]; typ != TokenTypeIntrospectionJWT {
+		t.Errorf("Expected typ %q, got %v", TokenTypeIntrospectionJWT, typ)
+	}
+	return parsed.Claims.(jwt.MapClaims)
+}
+
+// TestHandleIntrospectionJWTResponse tests the JWT introspection responses of
+// RFC 9701 for callers accepting application/token-introspection+jwt.
+func TestHandleIntrospectionJWTResponse(t *testing.T) {
+	keyPair := setupTestKeyPair(t)
+	keys := setupTestKeySet(t, keyPair)
+	generator := NewGenerator(keyPair.Signer(), KeyID(keyPair.PublicKey()), nil)
+	accessToken, _, err := generator.GenerateToken(Grant{Username: "billing", ClientID: "billing", Audience: []string{"https://api.example.com"}})
+	if err != nil {
+		t.Fatalf("Failed to generate token: %v", err)
+	}
+	gateway := staticCaller{ClientID: "gateway", Audiences: []string{"https://api.example.com"}}
+
+	introspect := func(t *testing.T, caller staticCaller, tokenString, accept string) *mockResponseWriter {
+		t.Helper()
+		req := newIntrospectionRequest(t, tokenString)
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		w := newMockResponseWriter()
+		HandleIntrospection(keys, revocation.NewMemoryStore(), caller)(w, req)
+		if w.statusCode != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, w.statusCode, w.body)
+		}
+		return w
+	}
+
+	t.Run("active token", func(t *testing.T) {
+		before := time.Now().Unix()
+		w := introspect(t, gateway, accessToken, ContentTypeIntrospectionJWT)
+
+		if got := w.headers.Get("Content-Type"); got != ContentTypeIntrospectionJWT {
+			t.Errorf("Expected content type %q, got %q", ContentTypeIntrospectionJWT, got)
+		}
+		claims := parseIntrospectionJWT(t, string(w.body), keys)
+		if claims["iss"] != issuerName {
+			t.Errorf("Expected iss %q, got %v", issuerName, claims["iss"])
+		}
+		if claims["aud"] != "gateway" {
+			t.Errorf("Expected aud %q, got %v", "gateway", claims["aud"])
+		}
+		if iat, _ := claims["iat"].(float64); int64(iat) < before {
+			t.Errorf("Expected iat not before %d, got %v", before, claims["iat"])
+		}
+		introspection, ok := claims["token_introspection"].(map[string]any)
+		if !ok {
+			t.Fatalf("Expected token_introspection object, got %v", claims["token_introspection"])
+		}
+		if introspection["active"] != true || introspection["client_id"] != "billing" || introspection["aud"] != "https://api.example.com" {
+			t.Errorf("Unexpected token_introspection %v", introspection)
+		}
+	})
+
+	t.Run("inactive token", func(t *testing.T) {
+		w := introspect(t, staticCaller{ClientID: "reports"}, accessToken, ContentTypeIntrospectionJWT)
+
+		claims := parseIntrospectionJWT(t, string(w.body), keys)
+		introspection, _ := claims["token_introspection"].(map[string]any)
+		if len(introspection) != 1 || introspection["active"] != false {
+			t.Errorf("Expected token_introspection {active: false}, got %v", claims["token_introspection"])
+		}
+	})
+
+	t.Run("JSON unless requested", func(t *testing.T) {
+		for _, accept := range []string{"", "application/json", "*/*"} {
+			w := introspect(t, gateway, accessToken, accept)
+			if got := w.headers.Get("Content-Type"); got != "application/json" {
+				t.Errorf("Accept %q: expected content type application/json, got %q", accept, got)
+			}
+		}
+	})
+
+	t.Run("introspection response as token", func(t *testing.T) {
+		// A response is signed with the access token key but must not pass as an
+		// access token, even for a caller whose audiences include its aud
+		response := introspect(t, gateway, accessToken, ContentTypeIntrospectionJWT)
+		caller := staticCaller{ClientID: "gateway", Audiences: []string{"gateway"}}
+		w := introspect(t, caller, string(response.body), "")
+
+		var got IntrospectionResponse
+		if err := json.Unmarshal(w.body, &got); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		if got.Active {
+			t.Errorf("Expected inactive token, got %s", w.body)
+		}
+	})
+
+	t.Run("encrypted to the caller", func(t *testing.T) {
+		encryptionKey, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			t.Fatalf("Failed to generate RSA key: %v", err)
+		}
+		caller := gateway
+		caller.Encryption = &Encryption{KeyID: "enc-1", Key: &encryptionKey.PublicKey, Alg: KeyEncryptionRSAOAEP256, Enc: ContentEncryptionA256GCM}
+		w := introspect(t, caller, accessToken, ContentTypeIntrospectionJWT)
+
+		if parts := strings.Count(string(w.body), "."); parts != 4 {
+			t.Fatalf("Expected a JWE with 5 parts, got %d", parts+1)
+		}
+		header, signed := decryptJWE(t, string(w.body), encryptionKey)
+		if header["cty"] != "JWT" || header["kid"] != "enc-1" {
+			t.Errorf("Unexpected JWE header %v", header)
+		}
+		claims := parseIntrospectionJWT(t, string(signed), keys)
+		if introspection, _ := claims["token_introspection"].(map[string]any); introspection["active"] != true {
+			t.Errorf("Expected active token_introspection, got %v", claims["token_introspection"])
+		}
+	})
+}
@@ -1,15 +1,24 @@
 package token
 
 import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"net/url"
+	"reflect"
+	"strings"
 	"testing"
 	"time"
 
 	"oauth2-task/internal/request"
+	"oauth2-task/internal/revocation"
 
 	"github.com/golang-jwt/jwt/v5"
 )
@@ -20,15 +29,33 @@ func setupTestKeyPair(t *testing.T) KeyPair {
 	if err != nil {
 		t.Fatalf("Failed to generate test key pair: %v", err)
 	}
-	return &rsaKeyPair{
-		privateKey: privateKey,
-		publicKey:  &privateKey.PublicKey,
+	keyPair, err := NewKeyPair(privateKey)
+	if err != nil {
+		t.Fatalf("Failed to create test key pair: %v", err)
+	}
+	return keyPair
+}
+
+// setupTestKeySet creates a key set signing with keyPair.
+func setupTestKeySet(t *testing.T, keyPair KeyPair, previous ...PublicKey) *KeySet {
+	t.Helper()
+	keys, err := NewKeySet(keyPair, previous...)
+	if err != nil {
+		t.Fatalf("Failed to create key set: %v", err)
 	}
+	return keys
 }
 
-// createTestToken creates a JWT token with the given claims.
+// createTestToken creates a JWT token with the given claims, signed with the algorithm of keyPair.
+// The kid header is set to the key ID of keyPair.
 func createTestToken(t *testing.T, keyPair KeyPair, claims jwt.Claims) string {
-	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	method, err := SigningMethod(keyPair.PublicKey())
+	if err != nil {
+		t.Fatalf("Failed to select signing method: %v", err)
+	}
+	token := jwt.NewWithClaims(method, claims)
+	token.Header["typ"] = TokenTypeAccessToken
+	token.Header["kid"] = KeyID(keyPair.PublicKey())
 	tokenString, err := token.SignedString(keyPair.PrivateKey())
 	if err != nil {
 		t.Fatalf("Failed to sign test token: %v", err)
@@ -48,30 +75,99 @@ func TestIntrospectToken(t *testing.T) {
 		{
 			name: "Valid token",
 			token: &jwt.Token{
-				Claims: &jwt.RegisteredClaims{
-					Issuer:    "test-issuer",
-					Subject:   "test-subject",
-					IssuedAt:  jwt.NewNumericDate(now),
-					NotBefore: jwt.NewNumericDate(now),
-					ExpiresAt: jwt.NewNumericDate(now.Add(time.Hour)),
+				Claims: &Claims{
+					Scope: "read write",
+					RegisteredClaims: jwt.RegisteredClaims{
+						Issuer:    "test-issuer",
+						Subject:   "test-subject",
+						IssuedAt:  jwt.NewNumericDate(now),
+						NotBefore: jwt.NewNumericDate(now),
+						ExpiresAt: jwt.NewNumericDate(now.Add(time.Hour)),
+					},
+				},
+				Valid: true,
+			},
+			want: IntrospectionResponse{
+				Active:    true,
+				Scope:     "read write",
+				Username:  "test-subject",
+				TokenType: "Bearer",
+				Sub:       "test-subject",
+				Iss:       "test-issuer",
+				Exp:       now.Add(time.Hour).Unix(),
+				Iat:       now.Unix(),
+				Nbf:       now.Unix(),
+			},
+			wantErr: false,
+		},
+		{
+			name: "Certificate-bound token",
+			token: &jwt.Token{
+				Claims: &Claims{
+					Confirmation: &Confirmation{X5tS256: "bwcK0esc3ACC3DB2Y5_lESsXE8o9ltc05O89jdN-dg2"},
+					RegisteredClaims: jwt.RegisteredClaims{
+						Issuer:    "test-issuer",
+						Subject:   "test-subject",
+						IssuedAt:  jwt.NewNumericDate(now),
+						NotBefore: jwt.NewNumericDate(now),
+						ExpiresAt: jwt.NewNumericDate(now.Add(time.Hour)),
+					},
 				},
 				Valid: true,
 			},
 			want: IntrospectionResponse{
 				Active:    true,
+				Username:  "test-subject",
 				TokenType: "Bearer",
 				Sub:       "test-subject",
 				Iss:       "test-issuer",
 				Exp:       now.Add(time.Hour).Unix(),
 				Iat:       now.Unix(),
 				Nbf:       now.Unix(),
+				Cnf:       &Confirmation{X5tS256: "bwcK0esc3ACC3DB2Y5_lESsXE8o9ltc05O89jdN-dg2"},
+			},
+			wantErr: false,
+		},
+		{
+			name: "Access token with client and extension claims",
+			token: &jwt.Token{
+				Claims: &Claims{
+					ClientID: "billing",
+					Scope:    "read",
+					Extra:    map[string]any{"tenant": "acme", "roles": []any{"admin"}},
+					RegisteredClaims: jwt.RegisteredClaims{
+						Issuer:    "test-issuer",
+						Subject:   "billing",
+						Audience:  jwt.ClaimStrings{"https://api.example.com"},
+						ID:        "token-id",
+						IssuedAt:  jwt.NewNumericDate(now),
+						NotBefore: jwt.NewNumericDate(now),
+						ExpiresAt: jwt.NewNumericDate(now.Add(time.Hour)),
+					},
+				},
+				Valid: true,
+			},
+			want: IntrospectionResponse{
+				Active:    true,
+				Scope:     "read",
+				ClientID:  "billing",
+				Username:  "billing",
+				TokenType: "Bearer",
+				Sub:       "billing",
+				Aud:       Audience{"https://api.example.com"},
+				Iss:       "test-issuer",
+				Jti:       "token-id",
+				Exp:       now.Add(time.Hour).Unix(),
+				Iat:       now.Unix(),
+				Nbf:       now.Unix(),
+				Extra:     map[string]any{"tenant": "acme", "roles": []any{"admin"}},
 			},
 			wantErr: false,
 		},
 		{
 			name: "Invalid token - not valid",
 			token: &jwt.Token{
-				Claims: &jwt.RegisteredClaims{},
+				Claims: &Claims{},
 				Valid:  false,
 			},
 			want:    IntrospectionResponse{Active: false},
@@ -99,9 +195,15 @@ func TestIntrospectToken(t *testing.T) {
 
 			// For active tokens, check other fields
 			if got.Active {
+				if got.Scope != tt.want.Scope {
+					t.Errorf("introspectToken() scope = %v, want %v", got.Scope, tt.want.Scope)
+				}
 				if got.TokenType != tt.want.TokenType {
 					t.Errorf("introspectToken() tokenType = %v, want %v", got.TokenType, tt.want.TokenType)
 				}
+				if got.Username != tt.want.Username {
+					t.Errorf("introspectToken() username = %v, want %v", got.Username, tt.want.Username)
+				}
 				if got.Sub != tt.want.Sub {
 					t.Errorf("introspectToken() sub = %v, want %v", got.Sub, tt.want.Sub)
 				}
@@ -117,11 +219,133 @@ func TestIntrospectToken(t *testing.T) {
 				if got.Nbf != tt.want.Nbf {
 					t.Errorf("introspectToken() nbf = %v, want %v", got.Nbf, tt.want.Nbf)
 				}
+				if !reflect.DeepEqual(got.Cnf, tt.want.Cnf) {
+					t.Errorf("introspectToken() cnf = %v, want %v", got.Cnf, tt.want.Cnf)
+				}
+				if got.ClientID != tt.want.ClientID {
+					t.Errorf("introspectToken() client_id = %v, want %v", got.ClientID, tt.want.ClientID)
+				}
+				if !reflect.DeepEqual(got.Aud, tt.want.Aud) {
+					t.Errorf("introspectToken() aud = %v, want %v", got.Aud, tt.want.Aud)
+				}
+				if got.Jti != tt.want.Jti {
+					t.Errorf("introspectToken() jti = %v, want %v", got.Jti, tt.want.Jti)
+				}
+				if !reflect.DeepEqual(got.Extra, tt.want.Extra) {
+					t.Errorf("introspectToken() extension claims = %v, want %v", got.Extra, tt.want.Extra)
+				}
 			}
 		})
 	}
 }
 
+// TestIntrospectionResponseExtensionClaims tests that extension claims are
+// returned as top-level members without replacing the members of RFC 7662.
+func TestIntrospectionResponseExtensionClaims(t *testing.T) {
+	response := IntrospectionResponse{
+		Active: true,
+		Sub:    "billing",
+		Aud:    Audience{"https://api.example.com", "https://reports.example.com"},
+		Extra:  map[string]any{"tenant": "acme", "sub": "forged"},
+	}
+
+	encoded, err := json.Marshal(response)
+	if err != nil {
+		t.Fatalf("Failed to encode response: %v", err)
+	}
+	var got map[string]any
+	if err := json.Unmarshal(encoded, &got); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+
+	want := map[string]any{
+		"active": true,
+		"sub":    "billing",
+		"aud":    []any{"https://api.example.com", "https://reports.example.com"},
+		"tenant": "acme",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Marshal() = %v, want %v", got, want)
+	}
+}
+
+// TestIntrospectionResponseRoundTrip tests that decoding an encoded response
+// restores its members and collects the extension claims into Extra.
+func TestIntrospectionResponseRoundTrip(t *testing.T) {
+	response := IntrospectionResponse{
+		Active:   true,
+		ClientID: "billing",
+		Username: "billing",
+		Sub:      "billing",
+		Aud:      Audience{"https://api.example.com"},
+		Jti:      "token-id",
+		Extra:    map[string]any{"tenant": "acme", "roles": []any{"billing-admin"}},
+	}
+
+	encoded, err := json.Marshal(response)
+	if err != nil {
+		t.Fatalf("Failed to encode response: %v", err)
+	}
+	var got IntrospectionResponse
+	if err := json.Unmarshal(encoded, &got); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if !reflect.DeepEqual(got, response) {
+		t.Errorf("Unmarshal() = %+v, want %+v", got, response)
+	}
+}
+
+func TestAudienceJSON(t *testing.T) {
+	tests := []struct {
+		name     string
+		audience Audience
+		encoded  string
+	}{
+		{name: "single audience", audience: Audience{"https://api.example.com"}, encoded: `"https://api.example.com"`},
+		{name: "several audiences", audience: Audience{"https://api.example.com", "https://reports.example.com"}, encoded: `["https://api.example.com","https://reports.example.com"]`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			encoded, err := json.Marshal(tt.audience)
+			if err != nil {
+				t.Fatalf("Marshal() error = %v", err)
+			}
+			if string(encoded) != tt.encoded {
+				t.Errorf("Marshal() = %s, want %s", encoded, tt.encoded)
+			}
+
+			var got Audience
+			if err := json.Unmarshal(encoded, &got); err != nil {
+				t.Fatalf("Unmarshal() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.audience) {
+				t.Errorf("Unmarshal() = %v, want %v", got, tt.audience)
+			}
+		})
+	}
+
+	t.Run("single audience as array", func(t *testing.T) {
+		var got Audience
+		if err := json.Unmarshal([]byte(`["https://api.example.com"]`), &got); err != nil {
+			t.Fatalf("Unmarshal() error = %v", err)
+		}
+		if want := (Audience{"https://api.example.com"}); !reflect.DeepEqual(got, want) {
+			t.Errorf("Unmarshal() = %v, want %v", got, want)
+		}
+	})
+
+	t.Run("no audience is omitted", func(t *testing.T) {
+		encoded, err := json.Marshal(IntrospectionResponse{Active: true})
+		if err != nil {
+			t.Fatalf("Marshal() error = %v", err)
+		}
+		if string(encoded) != `{"active":true}` {
+			t.Errorf("Marshal() = %s, want {\"active\":true}", encoded)
+		}
+	})
+}
+
 func TestExtractTokenFromRequest(t *testing.T) {
 	tests := []struct {
 		name        string
@@ -141,15 +365,15 @@ func TestExtractTokenFromRequest(t *testing.T) {
 			name:        "Token in Authorization header",
 			formToken:   "",
 			authHeader:  "Bearer header.token.here",
-			wantToken:   "header.token.here",
-			description: "Should extract token from Authorization header",
+			wantToken:   "",
+			description: "The Authorization header holds the caller's credentials, not the token to introspect",
 		},
 		{
-			name:        "Form data takes precedence",
+			name:        "Form data next to caller credentials",
 			formToken:   "form.token.here",
 			authHeader:  "Bearer header.token.here",
 			wantToken:   "form.token.here",
-			description: "Form data should take precedence over Authorization header",
+			description: "Should extract token from form data regardless of the caller's credentials",
 		},
 		{
 			name:        "No token provided",
@@ -158,70 +382,20 @@ func TestExtractTokenFromRequest(t *testing.T) {
 			wantToken:   "",
 			description: "Should return empty string when no token is provided",
 		},
-		{
-			name:        "Invalid Authorization header - no Bearer prefix",
-			formToken:   "",
-			authHeader:  "header.token.here",
-			wantToken:   "",
-			description: "Should return empty string when Authorization header has no Bearer prefix",
-		},
-		{
-			name:        "Invalid Authorization header - wrong prefix",
-			formToken:   "",
-			authHeader:  "Basic header.token.here",
-			wantToken:   "",
-			description: "Should return empty string when Authorization header has wrong prefix",
-		},
-		{
-			name:        "Invalid Authorization header - empty after Bearer",
-			formToken:   "",
-			authHeader:  "Bearer ",
-			wantToken:   "",
-			description: "Should return empty string when Authorization header has no token after Bearer",
-		},
-		{
-			name:        "Invalid Authorization header - Bearer with spaces",
-			formToken:   "",
-			authHeader:  "Bearer  header.token.here",
-			wantToken:   " header.token.here",
-			description: "Should preserve spaces after Bearer prefix",
-		},
-		{
-			name:        "Invalid Authorization header - Bearer with tabs",
-			formToken:   "",
-			authHeader:  "Bearer\theader.token.here",
-			wantToken:   "",
-			description: "Should return empty string when Bearer is followed by tab",
-		},
-		{
-			name:        "Invalid Authorization header - Bearer with newline",
-			formToken:   "",
-			authHeader:  "Bearer\nheader.token.here",
-			wantToken:   "",
-			description: "Should return empty string when Bearer is followed by newline",
-		},
-		{
-			name:        "Invalid Authorization header - Bearer with carriage return",
-			formToken:   "",
-			authHeader:  "Bearer\rheader.token.here",
-			wantToken:   "",
-			description: "Should return empty string when Bearer is followed by carriage return",
-		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			// Create a test request
-			req, err := http.NewRequest("POST", "/introspect", nil)
+			// Create a test request with the form encoded body
+			form := url.Values{}
+			if tt.formToken != "" {
+				form.Set("token", tt.formToken)
+			}
+			req, err := http.NewRequest("POST", "/introspect", strings.NewReader(form.Encode()))
 			if err != nil {
 				t.Fatalf("Failed to create test request: %v", err)
 			}
-
-			// Set form data if provided
-			if tt.formToken != "" {
-				req.Form = url.Values{}
-				req.Form.Set("token", tt.formToken)
-			}
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 
 			// Set Authorization header if provided
 			if tt.authHeader != "" {
@@ -238,8 +412,10 @@ func TestExtractTokenFromRequest(t *testing.T) {
 }
 
 func TestValidateSigningMethod(t *testing.T) {
-	// Setup test key pair
+	// Setup test key set with a previous signing key
 	keyPair := setupTestKeyPair(t)
+	previousKeyPair := setupTestKeyPair(t)
+	keys := setupTestKeySet(t, keyPair, previousKeyPair.PublicKey())
 
 	tests := []struct {
 		name      string
@@ -251,10 +427,38 @@ func TestValidateSigningMethod(t *testing.T) {
 			name: "Valid RSA token",
 			token: &jwt.Token{
 				Method: jwt.SigningMethodRS256,
+				Header: map[string]interface{}{"kid": KeyID(keyPair.PublicKey())},
+			},
+			wantKey:   keyPair.PublicKey(),
+			wantError: nil,
+		},
+		{
+			name: "Token signed with previous key",
+			token: &jwt.Token{
+				Method: jwt.SigningMethodRS256,
+				Header: map[string]interface{}{"kid": KeyID(previousKeyPair.PublicKey())},
+			},
+			wantKey:   previousKeyPair.PublicKey(),
+			wantError: nil,
+		},
+		{
+			name: "Token without kid uses signing key",
+			token: &jwt.Token{
+				Method: jwt.SigningMethodRS256,
+				Header: map[string]interface{}{},
 			},
 			wantKey:   keyPair.PublicKey(),
 			wantError: nil,
 		},
+		{
+			name: "Unknown kid",
+			token: &jwt.Token{
+				Method: jwt.SigningMethodRS256,
+				Header: map[string]interface{}{"kid": "unknown"},
+			},
+			wantKey:   nil,
+			wantError: jwt.ErrTokenUnverifiable,
+		},
 		{
 			name: "Invalid signing method - HMAC",
 			token: &jwt.Token{
@@ -275,10 +479,10 @@ func TestValidateSigningMethod(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			gotKey, gotErr := validateSigningMethod(tt.token, keyPair)
+			gotKey, gotErr := validateSigningMethod(tt.token, keys)
 
 			// Check error
-			if gotErr != tt.wantError {
+			if !errors.Is(gotErr, tt.wantError) {
 				t.Errorf("validateSigningMethod() error = %v, want %v", gotErr, tt.wantError)
 			}
 
@@ -294,7 +498,17 @@ func TestValidateSigningMethod(t *testing.T) {
 
 func TestValidateToken(t *testing.T) {
 	keyPair := setupTestKeyPair(t)
+	previousKeyPair := setupTestKeyPair(t)
+	retiredKeyPair := setupTestKeyPair(t)
+	keys := setupTestKeySet(t, keyPair, previousKeyPair.PublicKey())
 	now := time.Now()
+	registeredClaims := jwt.RegisteredClaims{
+		Issuer:    "test-issuer",
+		Subject:   "test-subject",
+		IssuedAt:  jwt.NewNumericDate(now),
+		NotBefore: jwt.NewNumericDate(now),
+		ExpiresAt: jwt.NewNumericDate(now.Add(time.Hour)),
+	}
 
 	// Create a valid token
 	validToken := createTestToken(t, keyPair, jwt.RegisteredClaims{
@@ -317,6 +531,18 @@ func TestValidateToken(t *testing.T) {
 			wantValid: true,
 			wantErr:   false,
 		},
+		{
+			name:      "Token signed with previous key",
+			token:     createTestToken(t, previousKeyPair, registeredClaims),
+			wantValid: true,
+			wantErr:   false,
+		},
+		{
+			name:      "Token signed with retired key",
+			token:     createTestToken(t, retiredKeyPair, registeredClaims),
+			wantValid: false,
+			wantErr:   true,
+		},
 		{
 			name:      "Invalid token - malformed",
 			token:     "invalid.token.string",
@@ -339,7 +565,7 @@ func TestValidateToken(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got, err := validateToken(tt.token, keyPair)
+			got, err := validateToken(tt.token, keys)
 
 			// Check error
 			if (err != nil) != tt.wantErr {
@@ -355,6 +581,137 @@ func TestValidateToken(t *testing.T) {
 	}
 }
 
+func TestValidateTokenAlgorithms(t *testing.T) {
+	p256Key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate EC key: %v", err)
+	}
+	p384Key, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate EC key: %v", err)
+	}
+	_, ed25519Key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate Ed25519 key: %v", err)
+	}
+
+	rsaKeyPair := setupTestKeyPair(t)
+	var keyPairs []KeyPair
+	for _, privateKey := range []crypto.Signer{p256Key, p384Key, ed25519Key} {
+		keyPair, err := NewKeyPair(privateKey)
+		if err != nil {
+			t.Fatalf("NewKeyPair() error = %v", err)
+		}
+		keyPairs = append(keyPairs, keyPair)
+	}
+	keys := setupTestKeySet(t, keyPairs[0], keyPairs[1].PublicKey(), keyPairs[2].PublicKey(), rsaKeyPair.PublicKey())
+
+	now := time.Now()
+	claims := jwt.RegisteredClaims{
+		Subject:   "test-subject",
+		ExpiresAt: jwt.NewNumericDate(now.Add(time.Hour)),
+	}
+
+	for _, keyPair := range append(keyPairs, rsaKeyPair) {
+		method, _ := SigningMethod(keyPair.PublicKey())
+		t.Run(method.Alg(), func(t *testing.T) {
+			got, err := validateToken(createTestToken(t, keyPair, claims), keys)
+			if err != nil || !got.Valid {
+				t.Errorf("validateToken() error = %v, want valid token", err)
+			}
+		})
+	}
+
+	t.Run("alg not matching the key", func(t *testing.T) {
+		// An RS256 signature presented under the kid of an EC key
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+		token.Header["typ"] = TokenTypeAccessToken
+		token.Header["kid"] = KeyID(keyPairs[0].PublicKey())
+		tokenString, err := token.SignedString(rsaKeyPair.PrivateKey())
+		if err != nil {
+			t.Fatalf("Failed to sign test token: %v", err)
+		}
+		if _, err := validateToken(tokenString, keys); !errors.Is(err, jwt.ErrSignatureInvalid) {
+			t.Errorf("validateToken() error = %v, want %v", err, jwt.ErrSignatureInvalid)
+		}
+	})
+}
+
+func TestValidateTokenAccessTokenOnly(t *testing.T) {
+	keyPair := setupTestKeyPair(t)
+	keys := setupTestKeySet(t, keyPair)
+	expiring := jwt.RegisteredClaims{Subject: "test-subject", ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour))}
+
+	tests := []struct {
+		name    string
+		typ     any
+		claims  jwt.RegisteredClaims
+		wantErr error
+	}{
+		{name: "access token", typ: TokenTypeAccessToken, claims: expiring},
+		{name: "media type", typ: "application/at+JWT", claims: expiring},
+		{name: "missing typ", claims: expiring, wantErr: jwt.ErrTokenUnverifiable},
+		{name: "introspection response", typ: TokenTypeIntrospectionJWT, claims: expiring, wantErr: jwt.ErrTokenUnverifiable},
+		{name: "missing exp", typ: TokenTypeAccessToken, claims: jwt.RegisteredClaims{Subject: "test-subject"}, wantErr: jwt.ErrTokenRequiredClaimMissing},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token := jwt.NewWithClaims(jwt.SigningMethodRS256, tt.claims)
+			if tt.typ == nil {
+				delete(token.Header, "typ")
+			} else {
+				token.Header["typ"] = tt.typ
+			}
+			token.Header["kid"] = KeyID(keyPair.PublicKey())
+			tokenString, err := token.SignedString(keyPair.PrivateKey())
+			if err != nil {
+				t.Fatalf("Failed to sign test token: %v", err)
+			}
+			if _, err := validateToken(tokenString, keys); !errors.Is(err, tt.wantErr) {
+				t.Errorf("validateToken() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateTokenRSAAlgorithm(t *testing.T) {
+	keyPair := setupTestKeyPair(t)
+	keys, err := setupTestKeySet(t, keyPair).WithRSAAlgorithm("PS256")
+	if err != nil {
+		t.Fatalf("WithRSAAlgorithm() error = %v", err)
+	}
+	claims := jwt.RegisteredClaims{
+		Subject:   "test-subject",
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+	}
+
+	tests := []struct {
+		name    string
+		method  jwt.SigningMethod
+		wantErr error
+	}{
+		{name: "registered algorithm", method: jwt.SigningMethodPS256},
+		{name: "other RSA algorithm", method: jwt.SigningMethodRS256, wantErr: jwt.ErrSignatureInvalid},
+		{name: "other PSS algorithm", method: jwt.SigningMethodPS512, wantErr: jwt.ErrSignatureInvalid},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token := jwt.NewWithClaims(tt.method, claims)
+			token.Header["typ"] = TokenTypeAccessToken
+			token.Header["kid"] = KeyID(keyPair.PublicKey())
+			tokenString, err := token.SignedString(keyPair.PrivateKey())
+			if err != nil {
+				t.Fatalf("Failed to sign test token: %v", err)
+			}
+			if _, err := validateToken(tokenString, keys); !errors.Is(err, tt.wantErr) {
+				t.Errorf("validateToken() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
 // mockResponseWriter is a simple mock of http.ResponseWriter.
 type mockResponseWriter struct {
 	headers    http.Header
@@ -381,82 +738,456 @@ func (m *mockResponseWriter) WriteHeader(statusCode int) {
 	m.statusCode = statusCode
 }
 
-// TestWriteIntrospectionError tests the error response writing functionality.
-// While it's a general test for writeIntrospectionError, it has two critical business requirements:
-//
-//  1. Status Code Preservation:
-//     The status code passed to writeIntrospectionError must be preserved in the response.
-//     This is important because different error scenarios require different HTTP status codes,
-//     and we must ensure they are correctly propagated to the client.
-//
-//  2. Token Validation Failure Response:
-//     When a token validation fails (message == "Token validation failed"),
-//     the response must always be IntrospectionResponse{Active: false}.
-//     This is a critical security requirement as per RFC 7662, ensuring that
-//     invalid tokens are properly marked as inactive without exposing internal details.
-func TestWriteIntrospectionError(t *testing.T) {
+// staticCaller is a CallerAuthenticator that authenticates every request as
+// the caller, or rejects every request with 401 if the caller has no client ID.
+type staticCaller Caller
+
+func (c staticCaller) AuthenticateCaller(w http.ResponseWriter, _ *http.Request) (Caller, bool) {
+	if c.ClientID == "" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return Caller{}, false
+	}
+	return Caller(c), true
+}
+
+// newIntrospectionRequest creates an introspection request for tokenString.
+func newIntrospectionRequest(t *testing.T, tokenString string) *http.Request {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodPost, "/introspect", strings.NewReader(url.Values{"token": {tokenString}}.Encode()))
+	if err != nil {
+		t.Fatalf("Failed to create request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return req
+}
+
+func TestCallerMayIntrospect(t *testing.T) {
+	caller := Caller{ClientID: "gateway", Audiences: []string{"https://api.example.com"}}
+
 	tests := []struct {
-		name          string
-		message       string
-		status        int
-		wantResponse  IntrospectionResponse
-		wantErrorResp ErrorResponse
-		wantStatus    int
+		name   string
+		claims *Claims
+		want   bool
 	}{
+		{name: "own token", claims: &Claims{ClientID: "gateway"}, want: true},
+		{name: "legacy own token", claims: &Claims{RegisteredClaims: jwt.RegisteredClaims{Subject: "gateway"}}, want: true},
 		{
-			name:    "Token validation failed",
-			message: "Token validation failed",
-			status:  http.StatusOK,
-			wantResponse: IntrospectionResponse{
-				Active: false,
-			},
-			wantStatus: http.StatusOK,
+			name:   "token for allowed audience",
+			claims: &Claims{ClientID: "billing", RegisteredClaims: jwt.RegisteredClaims{Audience: jwt.ClaimStrings{"https://other.example.com", "https://api.example.com"}}},
+			want:   true,
 		},
 		{
-			name:    "Other error",
-			message: "Some other error",
-			status:  http.StatusBadRequest,
-			wantErrorResp: ErrorResponse{
-				Error: "Some other error",
-			},
-			wantStatus: http.StatusBadRequest,
+			name:   "token for other audience",
+			claims: &Claims{ClientID: "billing", RegisteredClaims: jwt.RegisteredClaims{Audience: jwt.ClaimStrings{"https://other.example.com"}}},
+			want:   false,
 		},
+		{name: "token without audience", claims: &Claims{ClientID: "billing"}, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := caller.mayIntrospect(tt.claims); got != tt.want {
+				t.Errorf("mayIntrospect() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestHandleIntrospectionCaller tests that the endpoint requires an
+// authenticated caller and hides tokens the caller may not introspect.
+func TestHandleIntrospectionCaller(t *testing.T) {
+	keyPair := setupTestKeyPair(t)
+	keys := setupTestKeySet(t, keyPair)
+	generator := NewGenerator(keyPair.Signer(), KeyID(keyPair.PublicKey()), nil)
+	accessToken, _, err := generator.GenerateToken(Grant{Username: "billing", ClientID: "billing", Audience: []string{"https://api.example.com"}})
+	if err != nil {
+		t.Fatalf("Failed to generate token: %v", err)
+	}
+
+	t.Run("rejects unauthenticated callers", func(t *testing.T) {
+		w := newMockResponseWriter()
+		HandleIntrospection(keys, revocation.NewMemoryStore(), staticCaller{})(w, newIntrospectionRequest(t, accessToken))
+
+		if w.statusCode != http.StatusUnauthorized {
+			t.Errorf("Expected status %d, got %d", http.StatusUnauthorized, w.statusCode)
+		}
+		if len(w.body) != 0 {
+			t.Errorf("Expected no token information, got %s", w.body)
+		}
+	})
+
+	tests := []struct {
+		name       string
+		caller     Caller
+		wantActive bool
+	}{
+		{name: "caller for the token audience", caller: Caller{ClientID: "gateway", Audiences: []string{"https://api.example.com"}}, wantActive: true},
+		{name: "client the token was issued to", caller: Caller{ClientID: "billing"}, wantActive: true},
+		{name: "caller for another audience", caller: Caller{ClientID: "reports", Audiences: []string{"https://reports.example.com"}}, wantActive: false},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			w := newMockResponseWriter()
+			HandleIntrospection(keys, revocation.NewMemoryStore(), staticCaller(tt.caller))(w, newIntrospectionRequest(t, accessToken))
 
-			// Call the function
-			writeIntrospectionError(w, tt.status, tt.message)
+			if w.statusCode != http.StatusOK {
+				t.Fatalf("Expected status %d, got %d", http.StatusOK, w.statusCode)
+			}
+			var got IntrospectionResponse
+			if err := json.Unmarshal(w.body, &got); err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+			if got.Active != tt.wantActive {
+				t.Errorf("Expected active %v, got %v", tt.wantActive, got.Active)
+			}
+		})
+	}
+}
 
-			// Check status code
-			if w.statusCode != tt.wantStatus {
-				t.Errorf("writeIntrospectionError() status = %v, want %v", w.statusCode, tt.wantStatus)
+// TestHandleIntrospectionReportsClaims tests that the claims of an issued token
+// are reported so callers need not decode the token themselves.
+func TestHandleIntrospectionReportsClaims(t *testing.T) {
+	keyPair := setupTestKeyPair(t)
+	keys := setupTestKeySet(t, keyPair)
+	generator := NewGenerator(keyPair.Signer(), KeyID(keyPair.PublicKey()), nil)
+	accessToken, _, err := generator.GenerateToken(Grant{
+		Username: "billing",
+		ClientID: "billing",
+		Scope:    "read write",
+		Audience: []string{"https://api.example.com"},
+		Claims:   map[string]any{"tenant": "acme"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to generate token: %v", err)
+	}
+	claims, err := VerifyToken(accessToken, keys)
+	if err != nil {
+		t.Fatalf("VerifyToken() error = %v", err)
+	}
+
+	w := newMockResponseWriter()
+	HandleIntrospection(keys, revocation.NewMemoryStore(), staticCaller{ClientID: "billing"})(w, newIntrospectionRequest(t, accessToken))
+
+	if w.statusCode != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, w.statusCode)
+	}
+	var got map[string]any
+	if err := json.Unmarshal(w.body, &got); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	want := map[string]any{
+		"active":     true,
+		"scope":      "read write",
+		"client_id":  "billing",
+		"username":   "billing",
+		"sub":        "billing",
+		"aud":        "https://api.example.com",
+		"jti":        claims.ID,
+		"tenant":     "acme",
+		"token_type": "Bearer",
+	}
+	for name, value := range want {
+		if got[name] != value {
+			t.Errorf("Expected %s %v, got %v", name, value, got[name])
+		}
+	}
+}
+
+// TestHandleIntrospectionRevokedToken tests that tokens revoked through RFC 7009
+// are reported as inactive while other tokens stay active.
+func TestHandleIntrospectionRevokedToken(t *testing.T) {
+	keyPair := setupTestKeyPair(t)
+	keys := setupTestKeySet(t, keyPair)
+	revocations := revocation.NewMemoryStore()
+	handler := HandleIntrospection(keys, revocations, staticCaller{ClientID: "testuser"})
+
+	generator := NewGenerator(keyPair.Signer(), KeyID(keyPair.PublicKey()), nil)
+	revokedToken, expiresAt, err := generator.GenerateToken(Grant{Username: "testuser"})
+	if err != nil {
+		t.Fatalf("Failed to generate token: %v", err)
+	}
+	activeToken, _, err := generator.GenerateToken(Grant{Username: "testuser"})
+	if err != nil {
+		t.Fatalf("Failed to generate token: %v", err)
+	}
+	claims, err := VerifyToken(revokedToken, keys)
+	if err != nil {
+		t.Fatalf("VerifyToken() error = %v", err)
+	}
+	if err := revocations.Revoke(context.Background(), claims.ID, expiresAt); err != nil {
+		t.Fatalf("Revoke() error = %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		token      string
+		wantActive bool
+	}{
+		{name: "revoked token is inactive", token: revokedToken, wantActive: false},
+		{name: "other token stays active", token: activeToken, wantActive: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := newMockResponseWriter()
+			handler(w, newIntrospectionRequest(t, tt.token))
+
+			if w.statusCode != http.StatusOK {
+				t.Fatalf("Expected status %d, got %d", http.StatusOK, w.statusCode)
+			}
+			var got IntrospectionResponse
+			if err := json.Unmarshal(w.body, &got); err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+			if got.Active != tt.wantActive {
+				t.Errorf("Expected active %v, got %v", tt.wantActive, got.Active)
 			}
+		})
+	}
+}
 
-			// Check content type
-			if w.headers.Get("Content-Type") != "application/json" {
-				t.Errorf("writeIntrospectionError() content-type = %v, want application/json", w.headers.Get("Content-Type"))
+// TestHandleIntrospectionForm tests that parameters are only accepted from an
+// application/x-www-form-urlencoded body as required by RFC 7662 Section 2.1.
+func TestHandleIntrospectionForm(t *testing.T) {
+	keyPair := setupTestKeyPair(t)
+	keys := setupTestKeySet(t, keyPair)
+	generator := NewGenerator(keyPair.Signer(), KeyID(keyPair.PublicKey()), nil)
+	accessToken, _, err := generator.GenerateToken(Grant{Username: "testuser"})
+	if err != nil {
+		t.Fatalf("Failed to generate token: %v", err)
+	}
+	handler := HandleIntrospection(keys, revocation.NewMemoryStore(), staticCaller{ClientID: "testuser"})
+
+	tests := []struct {
+		name        string
+		target      string
+		contentType string
+		body        string
+		wantStatus  int
+		wantActive  bool
+		wantError   string
+	}{
+		{
+			name:        "token in form body",
+			target:      "/introspect",
+			contentType: "application/x-www-form-urlencoded",
+			body:        url.Values{"token": {accessToken}}.Encode(),
+			wantStatus:  http.StatusOK,
+			wantActive:  true,
+		},
+		{
+			name:        "content type with charset",
+			target:      "/introspect",
+			contentType: "application/x-www-form-urlencoded; charset=UTF-8",
+			body:        url.Values{"token": {accessToken}}.Encode(),
+			wantStatus:  http.StatusOK,
+			wantActive:  true,
+		},
+		{
+			name:        "token in URL",
+			target:      "/introspect?" + url.Values{"token": {accessToken}}.Encode(),
+			contentType: "application/x-www-form-urlencoded",
+			wantStatus:  http.StatusBadRequest,
+			wantError:   "invalid_request",
+		},
+		{
+			name:        "token in URL and form body",
+			target:      "/introspect?" + url.Values{"token": {accessToken}}.Encode(),
+			contentType: "application/x-www-form-urlencoded",
+			body:        url.Values{"token": {accessToken}}.Encode(),
+			wantStatus:  http.StatusBadRequest,
+			wantError:   "invalid_request",
+		},
+		{
+			name:        "JSON body",
+			target:      "/introspect",
+			contentType: "application/json",
+			body:        `{"token":"` + accessToken + `"}`,
+			wantStatus:  http.StatusBadRequest,
+		},
+		{
+			name:       "missing content type",
+			target:     "/introspect",
+			body:       url.Values{"token": {accessToken}}.Encode(),
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:        "malformed form body",
+			target:      "/introspect",
+			contentType: "application/x-www-form-urlencoded",
+			body:        "token=%zz",
+			wantStatus:  http.StatusBadRequest,
+			wantError:   "invalid_request",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodPost, tt.target, strings.NewReader(tt.body))
+			if err != nil {
+				t.Fatalf("Failed to create request: %v", err)
 			}
+			if tt.contentType != "" {
+				req.Header.Set("Content-Type", tt.contentType)
+			}
+			w := newMockResponseWriter()
+			handler(w, req)
 
-			// Check response body
-			if tt.message == "Token validation failed" {
-				var got IntrospectionResponse
-				if err := json.Unmarshal(w.body, &got); err != nil {
-					t.Fatalf("Failed to decode response: %v", err)
-				}
-				if got != tt.wantResponse {
-					t.Errorf("writeIntrospectionError() response = %v, want %v", got, tt.wantResponse)
-				}
-			} else {
-				var got ErrorResponse
-				if err := json.Unmarshal(w.body, &got); err != nil {
-					t.Fatalf("Failed to decode response: %v", err)
+			if w.statusCode != tt.wantStatus {
+				t.Fatalf("Expected status %d, got %d", tt.wantStatus, w.statusCode)
+			}
+			if tt.wantStatus != http.StatusOK {
+				if strings.Contains(string(w.body), "testuser") {
+					t.Errorf("Expected no token information, got %s", w.body)
 				}
-				if got != tt.wantErrorResp {
-					t.Errorf("writeIntrospectionError() error response = %v, want %v", got, tt.wantErrorResp)
+				if tt.wantError != "" {
+					var got ErrorResponse
+					if err := json.Unmarshal(w.body, &got); err != nil || got.Error != tt.wantError || got.ErrorDescription == "" {
+						t.Errorf("Expected error %q with a description, got %s", tt.wantError, w.body)
+					}
 				}
+				return
+			}
+			var got IntrospectionResponse
+			if err := json.Unmarshal(w.body, &got); err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+			if got.Active != tt.wantActive {
+				t.Errorf("Expected active %v, got %v", tt.wantActive, got.Active)
+			}
+		})
+	}
+}
+
+// TestHandleIntrospectionTokenTypeHint tests that access tokens are found
+// whatever token_type_hint the caller sends (RFC 7662 Section 2.1).
+func TestHandleIntrospectionTokenTypeHint(t *testing.T) {
+	keyPair := setupTestKeyPair(t)
+	keys := setupTestKeySet(t, keyPair)
+	generator := NewGenerator(keyPair.Signer(), KeyID(keyPair.PublicKey()), nil)
+	accessToken, _, err := generator.GenerateToken(Grant{Username: "testuser"})
+	if err != nil {
+		t.Fatalf("Failed to generate token: %v", err)
+	}
+	handler := HandleIntrospection(keys, revocation.NewMemoryStore(), staticCaller{ClientID: "testuser"})
+
+	for _, hint := range []string{"", TokenTypeHintAccessToken, "refresh_token", "unknown"} {
+		t.Run("hint "+hint, func(t *testing.T) {
+			form := url.Values{"token": {accessToken}}
+			if hint != "" {
+				form.Set("token_type_hint", hint)
+			}
+			req, err := http.NewRequest(http.MethodPost, "/introspect", strings.NewReader(form.Encode()))
+			if err != nil {
+				t.Fatalf("Failed to create request: %v", err)
+			}
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			w := newMockResponseWriter()
+			handler(w, req)
+
+			if w.statusCode != http.StatusOK {
+				t.Fatalf("Expected status %d, got %d", http.StatusOK, w.statusCode)
+			}
+			var got IntrospectionResponse
+			if err := json.Unmarshal(w.body, &got); err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+			if !got.Active {
+				t.Error("Expected token to be active")
+			}
+		})
+	}
+}
+
+func TestSearchOrder(t *testing.T) {
+	lookups := tokenLookups
+	t.Cleanup(func() { tokenLookups = lookups })
+	tokenLookups = []tokenLookup{{hint: TokenTypeHintAccessToken}, {hint: "refresh_token"}}
+
+	tests := []struct {
+		hint string
+		want []string
+	}{
+		{hint: "", want: []string{TokenTypeHintAccessToken, "refresh_token"}},
+		{hint: TokenTypeHintAccessToken, want: []string{TokenTypeHintAccessToken, "refresh_token"}},
+		{hint: "refresh_token", want: []string{"refresh_token", TokenTypeHintAccessToken}},
+		{hint: "unknown", want: []string{TokenTypeHintAccessToken, "refresh_token"}},
+	}
+
+	for _, tt := range tests {
+		t.Run("hint "+tt.hint, func(t *testing.T) {
+			var got []string
+			for _, l := range searchOrder(tt.hint) {
+				got = append(got, l.hint)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("searchOrder(%q) = %v, want %v", tt.hint, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLookupTokenFallsBackToOtherTypes(t *testing.T) {
+	lookups := tokenLookups
+	t.Cleanup(func() { tokenLookups = lookups })
+	errUnknown := errors.New("unknown token")
+	var tried []string
+	lookup := func(hint string, found bool) tokenLookup {
+		return tokenLookup{hint: hint, lookup: func(string, *KeySet) (*jwt.Token, error) {
+			tried = append(tried, hint)
+			if !found {
+				return nil, errUnknown
+			}
+			return &jwt.Token{Valid: true}, nil
+		}}
+	}
+	tokenLookups = []tokenLookup{lookup(TokenTypeHintAccessToken, true), lookup("refresh_token", false)}
+
+	// A wrong hint costs one extra lookup but still finds the token
+	if _, err := lookupToken("token", "refresh_token", nil); err != nil {
+		t.Fatalf("lookupToken() error = %v", err)
+	}
+	if want := []string{"refresh_token", TokenTypeHintAccessToken}; !reflect.DeepEqual(tried, want) {
+		t.Errorf("lookupToken() tried %v, want %v", tried, want)
+	}
+
+	tokenLookups = []tokenLookup{lookup("refresh_token", false)}
+	if _, err := lookupToken("token", TokenTypeHintAccessToken, nil); !errors.Is(err, errUnknown) {
+		t.Errorf("lookupToken() error = %v, want %v", err, errUnknown)
+	}
+}
+
+// TestWriteIntrospectionError tests that errors are reported with the status
+// code and an RFC 6749 error code and description.
+func TestWriteIntrospectionError(t *testing.T) {
+	tests := []struct {
+		name        string
+		status      int
+		code        string
+		description string
+	}{
+		{name: "invalid request", status: http.StatusBadRequest, code: "invalid_request", description: "Missing token parameter"},
+		{name: "server error", status: http.StatusInternalServerError, code: "server_error", description: "Failed to check token revocation"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := newMockResponseWriter()
+			writeIntrospectionError(w, tt.status, tt.code, tt.description)
+
+			if w.statusCode != tt.status {
+				t.Errorf("writeIntrospectionError() status = %v, want %v", w.statusCode, tt.status)
+			}
+			if w.headers.Get("Content-Type") != "application/json" {
+				t.Errorf("writeIntrospectionError() content-type = %v, want application/json", w.headers.Get("Content-Type"))
+			}
+			var got ErrorResponse
+			if err := json.Unmarshal(w.body, &got); err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+			if want := (ErrorResponse{Error: tt.code, ErrorDescription: tt.description}); got != want {
+				t.Errorf("writeIntrospectionError() error response = %v, want %v", got, want)
 			}
 		})
 	}
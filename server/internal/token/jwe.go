@@ -0,0 +1,163 @@
+package token
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// JWE algorithms supported for encrypted responses (RFC 7518 Sections 4.3, 5.2 and 5.3).
+const (
+	// KeyEncryptionRSAOAEP256 encrypts the content encryption key with RSAES-OAEP using SHA-256.
+	KeyEncryptionRSAOAEP256 = "RSA-OAEP-256"
+	// ContentEncryptionA128CBCHS256 encrypts the content with AES-128-CBC and HMAC-SHA-256.
+	// It is the default content encryption algorithm of RFC 9701 Section 6.
+	ContentEncryptionA128CBCHS256 = "A128CBC-HS256"
+	// ContentEncryptionA256GCM encrypts the content with AES-256-GCM.
+	ContentEncryptionA256GCM = "A256GCM"
+)
+
+// ErrUnsupportedEncryption is returned when a response is to be encrypted with
+// an unsupported JWE algorithm.
+var ErrUnsupportedEncryption = errors.New("unsupported JWE algorithm")
+
+// contentEncryptions holds the supported content encryption algorithms, which
+// encrypt plaintext under a 256 bit content encryption key.
+var contentEncryptions = map[string]func(cek, plaintext, aad []byte) (iv, ciphertext, tag []byte, err error){
+	ContentEncryptionA128CBCHS256: encryptAESCBCHMAC,
+	ContentEncryptionA256GCM:      encryptAESGCM,
+}
+
+// ValidateEncryption checks that the key encryption algorithm alg and the
+// content encryption algorithm enc are supported.
+func ValidateEncryption(alg, enc string) error {
+	if alg != KeyEncryptionRSAOAEP256 {
+		return fmt.Errorf("%w: alg %q", ErrUnsupportedEncryption, alg)
+	}
+	if _, ok := contentEncryptions[enc]; !ok {
+		return fmt.Errorf("%w: enc %q", ErrUnsupportedEncryption, enc)
+	}
+	return nil
+}
+
+// Encryption describes how to encrypt responses to a client with the
+// RSA key it registered for encryption.
+type Encryption struct {
+	// KeyID is the "kid" of Key in the client's JWKS. Empty omits the kid header.
+	KeyID string
+	Key   *rsa.PublicKey
+	// Alg is the key encryption algorithm, KeyEncryptionRSAOAEP256.
+	Alg string
+	// Enc is the content encryption algorithm, ContentEncryptionA128CBCHS256 or ContentEncryptionA256GCM.
+	Enc string
+}
+
+// encryptJWE encrypts plaintext to the key of e and returns the JWE compact
+// serialization (RFC 7516 Section 7.1). The header records cty as the content
+// type of plaintext, "JWT" for a nested JWT.
+func encryptJWE(plaintext []byte, e Encryption, cty string) (string, error) {
+	if err := ValidateEncryption(e.Alg, e.Enc); err != nil {
+		return "", err
+	}
+	if e.Key == nil {
+		return "", errors.New("encryption key cannot be nil")
+	}
+
+	cek := make([]byte, 32)
+	if _, err := rand.Read(cek); err != nil {
+		return "", err
+	}
+	encryptedKey, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, e.Key, cek, nil)
+	if err != nil {
+		return "", err
+	}
+
+	header := map[string]string{"alg": e.Alg, "enc": e.Enc, "cty": cty}
+	if e.KeyID != "" {
+		header["kid"] = e.KeyID
+	}
+	encodedHeader, err := json.Marshal(header)
+	if err != nil {
+		return "", err
+	}
+	protected := base64.RawURLEncoding.EncodeToString(encodedHeader)
+
+	// The protected header is the additional authenticated data (RFC 7516 Section 5.1)
+	iv, ciphertext, tag, err := contentEncryptions[e.Enc](cek, plaintext, []byte(protected))
+	if err != nil {
+		return "", err
+	}
+	return strings.Join([]string{
+		protected,
+		base64.RawURLEncoding.EncodeToString(encryptedKey),
+		base64.RawURLEncoding.EncodeToString(iv),
+		base64.RawURLEncoding.EncodeToString(ciphertext),
+		base64.RawURLEncoding.EncodeToString(tag),
+	}, "."), nil
+}
+
+// encryptAESGCM encrypts plaintext with AES-GCM under a random 96 bit IV (RFC 7518 Section 5.3).
+func encryptAESGCM(cek, plaintext, aad []byte) (iv, ciphertext, tag []byte, err error) {
+	block, err := aes.NewCipher(cek)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	iv = make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(iv); err != nil {
+		return nil, nil, nil, err
+	}
+	sealed := gcm.Seal(nil, iv, plaintext, aad)
+	split := len(sealed) - gcm.Overhead()
+	return iv, sealed[:split], sealed[split:], nil
+}
+
+// encryptAESCBCHMAC encrypts plaintext with AES-128-CBC and HMAC-SHA-256 under
+// a random IV (RFC 7518 Section 5.2.3).
+func encryptAESCBCHMAC(cek, plaintext, aad []byte) (iv, ciphertext, tag []byte, err error) {
+	iv = make([]byte, aes.BlockSize)
+	if _, err := rand.Read(iv); err != nil {
+		return nil, nil, nil, err
+	}
+	ciphertext, tag, err = sealAESCBCHMAC(cek, iv, plaintext, aad)
+	return iv, ciphertext, tag, err
+}
+
+// sealAESCBCHMAC implements AES_CBC_HMAC_SHA2 encryption of RFC 7518 Section 5.2.2.1.
+// The first half of key is the MAC key and the second half the encryption key.
+func sealAESCBCHMAC(key, iv, plaintext, aad []byte) (ciphertext, tag []byte, err error) {
+	macKey, encKey := key[:len(key)/2], key[len(key)/2:]
+	block, err := aes.NewCipher(encKey)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// PKCS #7 padding always adds between one and a full block of padding
+	padding := aes.BlockSize - len(plaintext)%aes.BlockSize
+	padded := append(append([]byte(nil), plaintext...), make([]byte, padding)...)
+	for i := len(plaintext); i < len(padded); i++ {
+		padded[i] = byte(padding)
+	}
+	ciphertext = make([]byte, len(padded))
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(ciphertext, padded)
+
+	// The MAC covers the AAD, IV, ciphertext and the AAD length in bits
+	mac := hmac.New(sha256.New, macKey)
+	mac.Write(aad)
+	mac.Write(iv)
+	mac.Write(ciphertext)
+	_ = binary.Write(mac, binary.BigEndian, uint64(len(aad))*8)
+	return ciphertext, mac.Sum(nil)[:len(macKey)], nil
+}
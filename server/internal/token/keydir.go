@@ -0,0 +1,303 @@
+package token
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrInvalidKeyDirectory is returned when a key directory contains no usable signing key or malformed key files.
+var ErrInvalidKeyDirectory = errors.New("invalid key directory")
+
+const (
+	privateKeySuffix = ".private.pem"
+	publicKeySuffix  = ".public.pem"
+	addedSuffix      = ".added"
+)
+
+// RotationPolicy controls the lifecycle of the keys in a KeyDirectory.
+type RotationPolicy struct {
+	// PublishGrace is how long a new key is published in the JWKS before it
+	// starts signing tokens, so verifiers caching the JWKS learn it in time.
+	PublishGrace time.Duration
+	// RetireAfter is how long a key stays published after its successor
+	// started signing. It must cover the lifetime of the tokens it signed.
+	RetireAfter time.Duration
+}
+
+// KeyDirectory is a KeySource backed by a directory in the keytool layout,
+// holding <kid>.private.pem, <kid>.public.pem and <kid>.added files. The
+// directory is re-read by Watch and the key set is swapped atomically. Keys
+// move through a rotation lifecycle based on when they were added:
+//   - pending: added less than PublishGrace ago, published for verification only
+//   - signing: the newest key with a private key that is no longer pending
+//   - previous: older keys, published until RetireAfter has passed since their successor started signing
+//   - retired: no longer published; their files may be deleted
+//
+// The time a key was added is read from its <kid>.added file, holding an RFC
+// 3339 timestamp, rather than from file modification times, which Kubernetes
+// resets for every file of a Secret on each update. Every replica thus orders
+// the keys the same way, also after a restart. Keys whose private key file has
+// been deleted are only used for verification. Private key files readable by
+// others are refused like malformed ones.
+type KeyDirectory struct {
+	dir          string
+	policy       RotationPolicy
+	rsaAlgorithm string
+	now          func() time.Time
+	current      atomic.Pointer[KeySet]
+
+	mu sync.Mutex
+}
+
+// directoryKey is a key read from a key directory.
+type directoryKey struct {
+	kid       string
+	keyPair   KeyPair
+	publicKey PublicKey
+	added     time.Time
+}
+
+// NewKeyDirectory loads the keys in dir and applies the rotation policy.
+// RSA keys sign with rsaAlgorithm, see KeySet.WithRSAAlgorithm.
+func NewKeyDirectory(dir string, policy RotationPolicy, rsaAlgorithm string) (*KeyDirectory, error) {
+	if rsaAlgorithm != "" {
+		if _, err := ParseRSASigningMethod(rsaAlgorithm); err != nil {
+			return nil, err
+		}
+	}
+	d := &KeyDirectory{dir: dir, policy: policy, rsaAlgorithm: rsaAlgorithm, now: time.Now}
+	if _, err := d.Reload(); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// KeySet returns the current key set.
+func (d *KeyDirectory) KeySet() *KeySet {
+	return d.current.Load()
+}
+
+// Reload re-reads the key directory and re-evaluates the rotation lifecycle.
+// It reports whether the signing key or the set of published keys changed.
+// On error the current key set stays in place.
+func (d *KeyDirectory) Reload() (bool, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	keys, err := d.readKeys()
+	if err != nil {
+		return false, err
+	}
+	set, err := d.selectKeys(keys)
+	if err != nil {
+		return false, err
+	}
+
+	if previous := d.current.Load(); previous != nil && sameKeys(previous, set) {
+		return false, nil
+	}
+	d.current.Store(set)
+
+	signingKeyID, _ := set.SigningKey()
+	published := make([]string, 0, len(set.VerificationKeys()))
+	for _, key := range set.VerificationKeys() {
+		published = append(published, key.KeyID)
+	}
+	slog.Info("Loaded signing keys", "dir", d.dir, "signing_kid", signingKeyID, "published", published)
+	return true, nil
+}
+
+// Watch polls the key directory every interval and reloads it until ctx is
+// cancelled. Besides picking up added and removed keys this advances the
+// rotation lifecycle as grace periods elapse. Polling rather than relying on
+// file system events keeps it working with the symlink swaps Kubernetes uses
+// to update mounted Secrets.
+func (d *KeyDirectory) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := d.Reload(); err != nil {
+				slog.Error("Failed to reload key directory, keeping previous keys", "dir", d.dir, "error", err)
+			}
+		}
+	}
+}
+
+// readKeys reads every key in the directory, oldest first.
+func (d *KeyDirectory) readKeys() ([]directoryKey, error) {
+	entries, err := os.ReadDir(d.dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key directory: %w", err)
+	}
+
+	kids := make(map[string]bool)
+	for _, entry := range entries {
+		// Kubernetes keeps the actual files of a mounted Secret in hidden directories
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		if kid, ok := strings.CutSuffix(entry.Name(), privateKeySuffix); ok {
+			kids[kid] = true
+		} else if kid, ok := strings.CutSuffix(entry.Name(), publicKeySuffix); ok {
+			kids[kid] = true
+		}
+	}
+
+	keys := make([]directoryKey, 0, len(kids))
+	for kid := range kids {
+		key, err := d.readKey(kid)
+		if err != nil {
+			return nil, err
+		}
+		if key.added, err = d.readAdded(kid); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	slices.SortFunc(keys, func(a, b directoryKey) int {
+		if c := a.added.Compare(b.added); c != 0 {
+			return c
+		}
+		return strings.Compare(a.kid, b.kid)
+	})
+	return keys, nil
+}
+
+// readKey reads the key with the given key ID from its private key file, or
+// from its public key file if the private key has been removed.
+func (d *KeyDirectory) readKey(kid string) (directoryKey, error) {
+	path := filepath.Join(d.dir, kid+privateKeySuffix)
+	info, err := os.Stat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return d.readPublicKey(kid)
+	}
+	if err != nil {
+		return directoryKey{}, fmt.Errorf("%w: %v", ErrInvalidKeyDirectory, err)
+	}
+	if err := checkKeyFileMode(path, info); err != nil {
+		return directoryKey{}, fmt.Errorf("%w: %w", ErrInvalidKeyDirectory, err)
+	}
+
+	content, err := os.ReadFile(filepath.Clean(path))
+	if err != nil {
+		return directoryKey{}, fmt.Errorf("%w: %v", ErrInvalidKeyDirectory, err)
+	}
+	keyPair, _, err := ParsePEMPrivateKey(content, nil)
+	if err != nil {
+		return directoryKey{}, fmt.Errorf("%w: %s: %v", ErrInvalidKeyDirectory, path, err)
+	}
+	if KeyID(keyPair.PublicKey()) != kid {
+		return directoryKey{}, fmt.Errorf("%w: %s does not hold key %s, run keytool migrate to rename legacy key files", ErrInvalidKeyDirectory, path, kid)
+	}
+	return directoryKey{kid: kid, keyPair: keyPair, publicKey: keyPair.PublicKey()}, nil
+}
+
+// readPublicKey reads the public key file of the key with the given key ID.
+func (d *KeyDirectory) readPublicKey(kid string) (directoryKey, error) {
+	path := filepath.Join(d.dir, kid+publicKeySuffix)
+	content, err := os.ReadFile(filepath.Clean(path))
+	if err != nil {
+		return directoryKey{}, fmt.Errorf("%w: %v", ErrInvalidKeyDirectory, err)
+	}
+	publicKeys, err := ParseVerificationKeys(content)
+	if err != nil {
+		return directoryKey{}, fmt.Errorf("%w: %s: %v", ErrInvalidKeyDirectory, path, err)
+	}
+	if len(publicKeys) != 1 || KeyID(publicKeys[0]) != kid {
+		return directoryKey{}, fmt.Errorf("%w: %s does not hold key %s, run keytool migrate to rename legacy key files", ErrInvalidKeyDirectory, path, kid)
+	}
+	return directoryKey{kid: kid, publicKey: publicKeys[0]}, nil
+}
+
+// readAdded reads the time the key with the given key ID was added from its
+// <kid>.added file.
+func (d *KeyDirectory) readAdded(kid string) (time.Time, error) {
+	path := filepath.Join(d.dir, kid+addedSuffix)
+	content, err := os.ReadFile(filepath.Clean(path))
+	if errors.Is(err, os.ErrNotExist) {
+		return time.Time{}, fmt.Errorf("%w: %s is missing, it must hold the time the key was added in RFC 3339 format", ErrInvalidKeyDirectory, path)
+	}
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%w: %v", ErrInvalidKeyDirectory, err)
+	}
+	added, err := time.Parse(time.RFC3339, strings.TrimSpace(string(content)))
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%w: %s: %v", ErrInvalidKeyDirectory, path, err)
+	}
+	return added, nil
+}
+
+// selectKeys applies the rotation policy to keys, sorted oldest first, and
+// returns the resulting key set.
+func (d *KeyDirectory) selectKeys(keys []directoryKey) (*KeySet, error) {
+	now := d.now()
+
+	// The newest key past its grace period signs; on a fresh directory where
+	// every key is still pending the oldest one signs right away
+	signing := -1
+	for i, key := range keys {
+		if key.keyPair == nil {
+			continue
+		}
+		if signing == -1 || !now.Before(key.added.Add(d.policy.PublishGrace)) {
+			signing = i
+		}
+	}
+	if signing == -1 {
+		return nil, fmt.Errorf("%w: no private key found in %s", ErrInvalidKeyDirectory, d.dir)
+	}
+
+	var published []PublicKey
+	for i, key := range keys {
+		switch {
+		case i == signing:
+			continue
+		case i > signing:
+			// Pending keys are published ahead of signing
+			published = append(published, key.publicKey)
+		default:
+			// A previous key was replaced when the next key with a private key
+			// started signing, once its grace period elapsed
+			successor := i + 1 + slices.IndexFunc(keys[i+1:], func(key directoryKey) bool { return key.keyPair != nil })
+			activated := keys[successor].added.Add(d.policy.PublishGrace)
+			if now.Before(activated.Add(d.policy.RetireAfter)) {
+				published = append(published, key.publicKey)
+			}
+		}
+	}
+
+	set, err := NewKeySet(keys[signing].keyPair, published...)
+	if err != nil {
+		return nil, err
+	}
+	return set.WithRSAAlgorithm(d.rsaAlgorithm)
+}
+
+// sameKeys reports whether a and b have the same signing key and publish the same keys.
+func sameKeys(a, b *KeySet) bool {
+	aKeys, bKeys := a.VerificationKeys(), b.VerificationKeys()
+	if len(aKeys) != len(bKeys) {
+		return false
+	}
+	for i := range aKeys {
+		if aKeys[i].KeyID != bKeys[i].KeyID {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,387 @@
+package token
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// writeKeyFiles writes keyPair to dir in the keytool layout, recording added as
+// the time it was added and as the modification time of its files. It returns
+// the key ID.
+func writeKeyFiles(t *testing.T, dir string, keyPair KeyPair, added time.Time) string {
+	t.Helper()
+	kid := KeyID(keyPair.PublicKey())
+	var privateBlock, publicBlock *pem.Block
+	if privateKey, ok := keyPair.PrivateKey().(*rsa.PrivateKey); ok {
+		privateBlock = &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(privateKey)}
+		publicBlock = &pem.Block{Type: "RSA PUBLIC KEY", Bytes: x509.MarshalPKCS1PublicKey(&privateKey.PublicKey)}
+	} else {
+		privateDER, err := x509.MarshalPKCS8PrivateKey(keyPair.PrivateKey())
+		if err != nil {
+			t.Fatalf("Failed to marshal private key: %v", err)
+		}
+		publicDER, err := x509.MarshalPKIXPublicKey(keyPair.PublicKey())
+		if err != nil {
+			t.Fatalf("Failed to marshal public key: %v", err)
+		}
+		privateBlock = &pem.Block{Type: "PRIVATE KEY", Bytes: privateDER}
+		publicBlock = &pem.Block{Type: "PUBLIC KEY", Bytes: publicDER}
+	}
+	files := map[string][]byte{
+		kid + privateKeySuffix: pem.EncodeToMemory(privateBlock),
+		kid + publicKeySuffix:  pem.EncodeToMemory(publicBlock),
+		kid + addedSuffix:      []byte(added.Format(time.RFC3339) + "\n"),
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, content, 0o600); err != nil {
+			t.Fatalf("Failed to write key file: %v", err)
+		}
+		if err := os.Chtimes(path, added, added); err != nil {
+			t.Fatalf("Failed to set key file time: %v", err)
+		}
+	}
+	return kid
+}
+
+// publishedKeyIDs returns the key IDs of the key set, the signing key first.
+func publishedKeyIDs(keys *KeySet) []string {
+	var kids []string
+	for _, key := range keys.VerificationKeys() {
+		kids = append(kids, key.KeyID)
+	}
+	return kids
+}
+
+func TestKeyDirectoryRotation(t *testing.T) {
+	policy := RotationPolicy{PublishGrace: time.Hour, RetireAfter: 2 * time.Hour}
+	start := time.Now().Add(-24 * time.Hour).Truncate(time.Second)
+	dir := t.TempDir()
+	oldKID := writeKeyFiles(t, dir, setupTestKeyPair(t), start)
+
+	clock := start.Add(12 * time.Hour)
+	d := &KeyDirectory{dir: dir, policy: policy, now: func() time.Time { return clock }}
+	if _, err := d.Reload(); err != nil {
+		t.Fatalf("Reload() error = %v", err)
+	}
+
+	assertKeys := func(t *testing.T, want ...string) {
+		t.Helper()
+		if got := publishedKeyIDs(d.KeySet()); !slices.Equal(got, want) {
+			t.Errorf("Published keys = %v, want %v", got, want)
+		}
+	}
+
+	t.Run("single key signs", func(t *testing.T) {
+		assertKeys(t, oldKID)
+	})
+
+	newAdded := clock
+	newKID := writeKeyFiles(t, dir, setupTestKeyPair(t), newAdded)
+
+	t.Run("new key is pre-published", func(t *testing.T) {
+		swapped, err := d.Reload()
+		if err != nil || !swapped {
+			t.Fatalf("Reload() = %v, %v; want true, nil", swapped, err)
+		}
+		assertKeys(t, oldKID, newKID)
+	})
+
+	t.Run("unchanged keys are not swapped", func(t *testing.T) {
+		clock = newAdded.Add(30 * time.Minute)
+		swapped, err := d.Reload()
+		if err != nil || swapped {
+			t.Errorf("Reload() = %v, %v; want false, nil", swapped, err)
+		}
+	})
+
+	t.Run("new key signs after grace period", func(t *testing.T) {
+		clock = newAdded.Add(policy.PublishGrace)
+		if _, err := d.Reload(); err != nil {
+			t.Fatalf("Reload() error = %v", err)
+		}
+		assertKeys(t, newKID, oldKID)
+	})
+
+	t.Run("old key is retired after its tokens expired", func(t *testing.T) {
+		clock = newAdded.Add(policy.PublishGrace + policy.RetireAfter - time.Second)
+		if _, err := d.Reload(); err != nil {
+			t.Fatalf("Reload() error = %v", err)
+		}
+		assertKeys(t, newKID, oldKID)
+
+		clock = newAdded.Add(policy.PublishGrace + policy.RetireAfter)
+		if _, err := d.Reload(); err != nil {
+			t.Fatalf("Reload() error = %v", err)
+		}
+		assertKeys(t, newKID)
+	})
+}
+
+func TestKeyDirectorySharedModTime(t *testing.T) {
+	// Kubernetes gives every file of a Secret the time of the last update, so
+	// replicas must agree on the keys from the recorded times alone
+	policy := RotationPolicy{PublishGrace: time.Hour, RetireAfter: 2 * time.Hour}
+	dir := t.TempDir()
+	now := time.Now().Truncate(time.Second)
+	oldKID := writeKeyFiles(t, dir, setupTestKeyPair(t), now.Add(-3*time.Hour))
+	newKID := writeKeyFiles(t, dir, setupTestKeyPair(t), now.Add(-90*time.Minute))
+	pendingKID := writeKeyFiles(t, dir, setupTestKeyPair(t), now.Add(-time.Minute))
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("Failed to read key directory: %v", err)
+	}
+	for _, entry := range entries {
+		if err := os.Chtimes(filepath.Join(dir, entry.Name()), now, now); err != nil {
+			t.Fatalf("Failed to set key file time: %v", err)
+		}
+	}
+
+	// Each directory stands for a replica started at a different time
+	want := []string{newKID, oldKID, pendingKID}
+	for _, started := range []time.Time{now, now.Add(time.Minute)} {
+		d := &KeyDirectory{dir: dir, policy: policy, now: func() time.Time { return started }}
+		if _, err := d.Reload(); err != nil {
+			t.Fatalf("Reload() error = %v", err)
+		}
+		if got := publishedKeyIDs(d.KeySet()); !slices.Equal(got, want) {
+			t.Errorf("Published keys = %v, want %v", got, want)
+		}
+	}
+}
+
+func TestKeyDirectoryRetiresAfterSigningSuccessor(t *testing.T) {
+	// A verification-only key between a previous key and the signing key never
+	// replaced the previous key, so it does not start its retirement
+	policy := RotationPolicy{PublishGrace: time.Hour, RetireAfter: 2 * time.Hour}
+	dir := t.TempDir()
+	now := time.Now().Truncate(time.Second)
+	previousKID := writeKeyFiles(t, dir, setupTestKeyPair(t), now.Add(-5*time.Hour))
+	verifyOnlyKID := writeKeyFiles(t, dir, setupTestKeyPair(t), now.Add(-4*time.Hour))
+	if err := os.Remove(filepath.Join(dir, verifyOnlyKID+privateKeySuffix)); err != nil {
+		t.Fatalf("Failed to remove private key: %v", err)
+	}
+	signingKID := writeKeyFiles(t, dir, setupTestKeyPair(t), now.Add(-2*time.Hour))
+
+	d := &KeyDirectory{dir: dir, policy: policy, now: func() time.Time { return now }}
+	if _, err := d.Reload(); err != nil {
+		t.Fatalf("Reload() error = %v", err)
+	}
+	if got, want := publishedKeyIDs(d.KeySet()), []string{signingKID, previousKID, verifyOnlyKID}; !slices.Equal(got, want) {
+		t.Errorf("Published keys = %v, want %v", got, want)
+	}
+}
+
+func TestKeyDirectoryPublicKeyOnly(t *testing.T) {
+	policy := RotationPolicy{PublishGrace: time.Hour, RetireAfter: time.Hour}
+	dir := t.TempDir()
+	now := time.Now().Truncate(time.Second)
+	signingKID := writeKeyFiles(t, dir, setupTestKeyPair(t), now.Add(-2*time.Hour))
+	verifyOnlyKID := writeKeyFiles(t, dir, setupTestKeyPair(t), now.Add(-time.Hour))
+	if err := os.Remove(filepath.Join(dir, verifyOnlyKID+privateKeySuffix)); err != nil {
+		t.Fatalf("Failed to remove private key: %v", err)
+	}
+
+	d, err := NewKeyDirectory(dir, policy, "")
+	if err != nil {
+		t.Fatalf("NewKeyDirectory() error = %v", err)
+	}
+	if got := publishedKeyIDs(d.KeySet()); !slices.Equal(got, []string{signingKID, verifyOnlyKID}) {
+		t.Errorf("Published keys = %v, want %v", got, []string{signingKID, verifyOnlyKID})
+	}
+}
+
+func TestKeyDirectoryMixedKeyTypes(t *testing.T) {
+	policy := RotationPolicy{PublishGrace: time.Hour, RetireAfter: time.Hour}
+	dir := t.TempDir()
+	now := time.Now().Truncate(time.Second)
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate EC key: %v", err)
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate Ed25519 key: %v", err)
+	}
+	ecKeyPair, err := NewKeyPair(ecKey)
+	if err != nil {
+		t.Fatalf("NewKeyPair() error = %v", err)
+	}
+	edKeyPair, err := NewKeyPair(edKey)
+	if err != nil {
+		t.Fatalf("NewKeyPair() error = %v", err)
+	}
+
+	// The RSA key is rotated out in favour of the EC key; the Ed25519 key is pending
+	rsaKID := writeKeyFiles(t, dir, setupTestKeyPair(t), now.Add(-3*time.Hour))
+	ecKID := writeKeyFiles(t, dir, ecKeyPair, now.Add(-90*time.Minute))
+	edKID := writeKeyFiles(t, dir, edKeyPair, now.Add(-time.Minute))
+	if err := os.Remove(filepath.Join(dir, edKID+privateKeySuffix)); err != nil {
+		t.Fatalf("Failed to remove private key: %v", err)
+	}
+
+	d, err := NewKeyDirectory(dir, policy, "")
+	if err != nil {
+		t.Fatalf("NewKeyDirectory() error = %v", err)
+	}
+	if got := publishedKeyIDs(d.KeySet()); !slices.Equal(got, []string{ecKID, rsaKID, edKID}) {
+		t.Errorf("Published keys = %v, want %v", got, []string{ecKID, rsaKID, edKID})
+	}
+	if _, signingKey := d.KeySet().SigningKey(); !signingKey.PublicKey().Equal(ecKey.Public()) {
+		t.Error("Expected the EC key to sign")
+	}
+
+	t.Run("RSA algorithm applies to RSA keys only", func(t *testing.T) {
+		d, err := NewKeyDirectory(dir, policy, "PS384")
+		if err != nil {
+			t.Fatalf("NewKeyDirectory() error = %v", err)
+		}
+		var algs []string
+		for _, key := range d.KeySet().VerificationKeys() {
+			algs = append(algs, key.Method.Alg())
+		}
+		if want := []string{"ES256", "PS384", "EdDSA"}; !slices.Equal(algs, want) {
+			t.Errorf("Key algorithms = %v, want %v", algs, want)
+		}
+	})
+}
+
+func TestKeyDirectoryErrors(t *testing.T) {
+	policy := RotationPolicy{PublishGrace: time.Hour, RetireAfter: time.Hour}
+
+	t.Run("unsupported RSA algorithm", func(t *testing.T) {
+		dir := t.TempDir()
+		writeKeyFiles(t, dir, setupTestKeyPair(t), time.Now())
+		if _, err := NewKeyDirectory(dir, policy, "ES256"); !errors.Is(err, ErrUnsupportedAlgorithm) {
+			t.Errorf("NewKeyDirectory() error = %v, want %v", err, ErrUnsupportedAlgorithm)
+		}
+	})
+
+	t.Run("missing directory", func(t *testing.T) {
+		if _, err := NewKeyDirectory(filepath.Join(t.TempDir(), "missing"), policy, ""); err == nil {
+			t.Error("Expected error for missing directory")
+		}
+	})
+
+	t.Run("no private key", func(t *testing.T) {
+		dir := t.TempDir()
+		kid := writeKeyFiles(t, dir, setupTestKeyPair(t), time.Now())
+		if err := os.Remove(filepath.Join(dir, kid+privateKeySuffix)); err != nil {
+			t.Fatalf("Failed to remove private key: %v", err)
+		}
+		if _, err := NewKeyDirectory(dir, policy, ""); !errors.Is(err, ErrInvalidKeyDirectory) {
+			t.Errorf("NewKeyDirectory() error = %v, want %v", err, ErrInvalidKeyDirectory)
+		}
+	})
+
+	t.Run("missing added time", func(t *testing.T) {
+		dir := t.TempDir()
+		kid := writeKeyFiles(t, dir, setupTestKeyPair(t), time.Now())
+		if err := os.Remove(filepath.Join(dir, kid+addedSuffix)); err != nil {
+			t.Fatalf("Failed to remove added time: %v", err)
+		}
+		if _, err := NewKeyDirectory(dir, policy, ""); !errors.Is(err, ErrInvalidKeyDirectory) {
+			t.Errorf("NewKeyDirectory() error = %v, want %v", err, ErrInvalidKeyDirectory)
+		}
+	})
+
+	t.Run("malformed added time", func(t *testing.T) {
+		dir := t.TempDir()
+		kid := writeKeyFiles(t, dir, setupTestKeyPair(t), time.Now())
+		if err := os.WriteFile(filepath.Join(dir, kid+addedSuffix), []byte("yesterday"), 0o600); err != nil {
+			t.Fatalf("Failed to write added time: %v", err)
+		}
+		if _, err := NewKeyDirectory(dir, policy, ""); !errors.Is(err, ErrInvalidKeyDirectory) {
+			t.Errorf("NewKeyDirectory() error = %v, want %v", err, ErrInvalidKeyDirectory)
+		}
+	})
+
+	t.Run("file name does not match key", func(t *testing.T) {
+		dir := t.TempDir()
+		kid := writeKeyFiles(t, dir, setupTestKeyPair(t), time.Now())
+		if err := os.Rename(filepath.Join(dir, kid+privateKeySuffix), filepath.Join(dir, "0000000000000000"+privateKeySuffix)); err != nil {
+			t.Fatalf("Failed to rename key file: %v", err)
+		}
+		if _, err := NewKeyDirectory(dir, policy, ""); !errors.Is(err, ErrInvalidKeyDirectory) {
+			t.Errorf("NewKeyDirectory() error = %v, want %v", err, ErrInvalidKeyDirectory)
+		}
+	})
+
+	t.Run("world-readable private key", func(t *testing.T) {
+		dir := t.TempDir()
+		kid := writeKeyFiles(t, dir, setupTestKeyPair(t), time.Now())
+		if err := os.Chmod(filepath.Join(dir, kid+privateKeySuffix), 0o644); err != nil {
+			t.Fatalf("Failed to change key file mode: %v", err)
+		}
+		if _, err := NewKeyDirectory(dir, policy, ""); !errors.Is(err, ErrInsecureKeyFile) {
+			t.Errorf("NewKeyDirectory() error = %v, want %v", err, ErrInsecureKeyFile)
+		}
+	})
+
+	t.Run("malformed key keeps previous keys", func(t *testing.T) {
+		dir := t.TempDir()
+		kid := writeKeyFiles(t, dir, setupTestKeyPair(t), time.Now())
+		d, err := NewKeyDirectory(dir, policy, "")
+		if err != nil {
+			t.Fatalf("NewKeyDirectory() error = %v", err)
+		}
+
+		if err := os.WriteFile(filepath.Join(dir, "broken"+privateKeySuffix), []byte("not a key"), 0o600); err != nil {
+			t.Fatalf("Failed to write key file: %v", err)
+		}
+		if _, err := d.Reload(); !errors.Is(err, ErrInvalidKeyDirectory) {
+			t.Errorf("Reload() error = %v, want %v", err, ErrInvalidKeyDirectory)
+		}
+		if got := publishedKeyIDs(d.KeySet()); !slices.Equal(got, []string{kid}) {
+			t.Errorf("Expected previous keys to remain, got %v", got)
+		}
+	})
+}
+
+func TestKeyDirectoryWatch(t *testing.T) {
+	dir := t.TempDir()
+	oldKID := writeKeyFiles(t, dir, setupTestKeyPair(t), time.Now().Add(-time.Hour))
+
+	d, err := NewKeyDirectory(dir, RotationPolicy{RetireAfter: time.Hour}, "")
+	if err != nil {
+		t.Fatalf("NewKeyDirectory() error = %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		d.Watch(ctx, 10*time.Millisecond)
+		close(done)
+	}()
+
+	newKID := writeKeyFiles(t, dir, setupTestKeyPair(t), time.Now())
+
+	deadline := time.Now().Add(2 * time.Second)
+	for !slices.Equal(publishedKeyIDs(d.KeySet()), []string{newKID, oldKID}) {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected watcher to switch to the new key, got %v", publishedKeyIDs(d.KeySet()))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected watcher to stop after context cancellation")
+	}
+}
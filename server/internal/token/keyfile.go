@@ -0,0 +1,46 @@
+package token
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+)
+
+// ErrInsecureKeyFile is returned when a private key file is readable by every user of the system.
+var ErrInsecureKeyFile = errors.New("private key file is world-readable")
+
+// ReadPrivateKeyFile reads the PEM encoded private key in path and reports the
+// format it was stored in, see ParsePEMPrivateKey. Files that every user of the
+// system may read are refused, restrict them with chmod 600 (or 640 for a
+// dedicated group) first.
+func ReadPrivateKeyFile(path string, passphrase []byte) (KeyPair, KeyFormat, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, "", err
+	}
+	if err := checkKeyFileMode(path, info); err != nil {
+		return nil, "", err
+	}
+	content, err := os.ReadFile(filepath.Clean(path))
+	if err != nil {
+		return nil, "", err
+	}
+	return ParsePEMPrivateKey(content, passphrase)
+}
+
+// checkKeyFileMode refuses private key files with permission bits for others.
+// info must describe the file the path resolves to, so that the symlinks of a
+// Kubernetes Secret volume are checked by the mode of their target. Windows
+// does not map ACLs to permission bits, so the check is skipped there.
+func checkKeyFileMode(path string, info fs.FileInfo) error {
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+	if mode := info.Mode().Perm(); mode&0o007 != 0 {
+		return fmt.Errorf("%w: %s has mode %04o, remove the permissions for others", ErrInsecureKeyFile, path, mode)
+	}
+	return nil
+}
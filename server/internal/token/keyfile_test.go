@@ -0,0 +1,83 @@
+package token
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestReadPrivateKeyFile(t *testing.T) {
+	keyPair := setupTestKeyPair(t)
+	der, err := x509.MarshalPKCS8PrivateKey(keyPair.PrivateKey())
+	if err != nil {
+		t.Fatalf("Failed to marshal private key: %v", err)
+	}
+	content := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+
+	writeFile := func(t *testing.T, content []byte, mode os.FileMode) string {
+		t.Helper()
+		path := filepath.Join(t.TempDir(), "private-key")
+		if err := os.WriteFile(path, content, mode); err != nil {
+			t.Fatalf("Failed to write key file: %v", err)
+		}
+		// WriteFile applies the umask
+		if err := os.Chmod(path, mode); err != nil {
+			t.Fatalf("Failed to change key file mode: %v", err)
+		}
+		return path
+	}
+
+	t.Run("owner and group readable", func(t *testing.T) {
+		for _, mode := range []os.FileMode{0o400, 0o600, 0o440, 0o640} {
+			loaded, format, err := ReadPrivateKeyFile(writeFile(t, content, mode), nil)
+			if err != nil {
+				t.Fatalf("ReadPrivateKeyFile(mode %04o) error = %v", mode, err)
+			}
+			if format != KeyFormatPKCS8 {
+				t.Errorf("format = %q, want %q", format, KeyFormatPKCS8)
+			}
+			if !loaded.PublicKey().Equal(keyPair.PublicKey()) {
+				t.Error("Public key mismatch")
+			}
+		}
+	})
+
+	t.Run("encrypted key", func(t *testing.T) {
+		path := writeFile(t, []byte(encryptedEd25519Key), 0o600)
+		if _, format, err := ReadPrivateKeyFile(path, []byte("test-passphrase")); err != nil || format != KeyFormatEncryptedPKCS8 {
+			t.Errorf("ReadPrivateKeyFile() = %q, %v, want %q", format, err, KeyFormatEncryptedPKCS8)
+		}
+	})
+
+	t.Run("world-readable key", func(t *testing.T) {
+		if runtime.GOOS == "windows" {
+			t.Skip("file modes are not checked on Windows")
+		}
+		for _, mode := range []os.FileMode{0o604, 0o644, 0o666} {
+			if _, _, err := ReadPrivateKeyFile(writeFile(t, content, mode), nil); !errors.Is(err, ErrInsecureKeyFile) {
+				t.Errorf("ReadPrivateKeyFile(mode %04o) error = %v, want %v", mode, err, ErrInsecureKeyFile)
+			}
+		}
+	})
+
+	t.Run("symlinked key is checked by its target", func(t *testing.T) {
+		target := writeFile(t, content, 0o600)
+		link := filepath.Join(t.TempDir(), "private-key")
+		if err := os.Symlink(target, link); err != nil {
+			t.Skipf("symlinks not supported: %v", err)
+		}
+		if _, _, err := ReadPrivateKeyFile(link, nil); err != nil {
+			t.Errorf("ReadPrivateKeyFile() error = %v", err)
+		}
+	})
+
+	t.Run("missing file", func(t *testing.T) {
+		if _, _, err := ReadPrivateKeyFile(filepath.Join(t.TempDir(), "missing"), nil); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("ReadPrivateKeyFile() error = %v, want %v", err, os.ErrNotExist)
+		}
+	})
+}
@@ -1,46 +1,171 @@
 package token
 
 import (
-	"crypto/rsa"
+	"crypto"
 	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
 	"log/slog"
 )
 
 // KeyPair defines the interface for a pair of cryptographic keys.
 type KeyPair interface {
 	// PrivateKey returns the private key.
-	PrivateKey() *rsa.PrivateKey
+	PrivateKey() crypto.Signer
 	// PublicKey returns the public key.
-	PublicKey() *rsa.PublicKey
+	PublicKey() PublicKey
+	// Signer returns the signer issuing tokens with the private key.
+	Signer() Signer
 }
 
-// rsaKeyPair implements KeyPair for RSA keys.
-type rsaKeyPair struct {
-	privateKey *rsa.PrivateKey
-	publicKey  *rsa.PublicKey
+// signerKeyPair implements KeyPair for RSA, ECDSA and Ed25519 keys.
+type signerKeyPair struct {
+	privateKey crypto.Signer
+	publicKey  PublicKey
 }
 
-// PrivateKey returns the RSA private key.
-func (k *rsaKeyPair) PrivateKey() *rsa.PrivateKey {
+// PrivateKey returns the private key.
+func (k *signerKeyPair) PrivateKey() crypto.Signer {
 	return k.privateKey
 }
 
-// PublicKey returns the RSA public key.
-func (k *rsaKeyPair) PublicKey() *rsa.PublicKey {
+// PublicKey returns the public key.
+func (k *signerKeyPair) PublicKey() PublicKey {
 	return k.publicKey
 }
 
-// ParsePrivateKey parses an RSA private key from its ASN.1 DER encoding.
-// The keyBytes parameter should be the decoded content of a PEM block of type "RSA PRIVATE KEY".
-// PEM decoding should be handled by the caller.
+// Signer returns the private key itself if it implements Signer, as keys held
+// in an HSM do, and a LocalSigner otherwise.
+func (k *signerKeyPair) Signer() Signer {
+	if signer, ok := k.privateKey.(Signer); ok {
+		return signer
+	}
+	return NewLocalSigner(k.privateKey)
+}
+
+// NewKeyPair creates a key pair from privateKey, which must be an
+// *rsa.PrivateKey, an *ecdsa.PrivateKey on the P-256 or P-384 curve, an
+// ed25519.PrivateKey, or a key held outside the process that implements both
+// crypto.Signer and Signer.
+func NewKeyPair(privateKey crypto.Signer) (KeyPair, error) {
+	publicKey, err := toPublicKey(privateKey.Public())
+	if err != nil {
+		return nil, err
+	}
+	return &signerKeyPair{privateKey: privateKey, publicKey: publicKey}, nil
+}
+
+// KeyFormat names the encoding a private key was stored in.
+type KeyFormat string
+
+// Supported private key encodings.
+const (
+	KeyFormatPKCS1          KeyFormat = "PKCS#1"
+	KeyFormatPKCS8          KeyFormat = "PKCS#8"
+	KeyFormatSEC1           KeyFormat = "SEC1"
+	KeyFormatEncryptedPKCS8 KeyFormat = "encrypted PKCS#8"
+)
+
+var (
+	// ErrPassphraseRequired is returned when an encrypted private key is parsed without a passphrase.
+	ErrPassphraseRequired = errors.New("private key is encrypted, a passphrase is required")
+	// ErrInvalidPrivateKey is returned when PEM data does not hold a supported private key.
+	ErrInvalidPrivateKey = errors.New("invalid private key")
+)
+
+// ParsePrivateKey parses a private key from its ASN.1 DER encoding.
+// The keyBytes parameter should be the decoded content of a PEM block of type
+// "RSA PRIVATE KEY" (PKCS#1), "PRIVATE KEY" (PKCS#8) or "EC PRIVATE KEY"
+// (SEC1). keytool stores RSA keys as PKCS#1 and ECDSA and Ed25519 keys as
+// PKCS#8. PEM decoding should be handled by the caller, use ParsePEMPrivateKey
+// to also learn the format and to decrypt encrypted keys.
 func ParsePrivateKey(keyBytes []byte) (KeyPair, error) {
-	privateKey, err := x509.ParsePKCS1PrivateKey(keyBytes)
+	var parsed any
+	var err error
+	if parsed, err = x509.ParsePKCS1PrivateKey(keyBytes); err != nil {
+		if parsed, err = x509.ParsePKCS8PrivateKey(keyBytes); err != nil {
+			if parsed, err = x509.ParseECPrivateKey(keyBytes); err != nil {
+				err = errors.New("x509: failed to parse private key as PKCS#1, PKCS#8 or SEC1")
+				slog.Error("Failed to parse private key", "error", err)
+				return nil, err
+			}
+		}
+	}
+	keyPair, err := newParsedKeyPair(parsed)
 	if err != nil {
 		slog.Error("Failed to parse private key", "error", err)
 		return nil, err
 	}
-	return &rsaKeyPair{
-		privateKey: privateKey,
-		publicKey:  &privateKey.PublicKey,
-	}, nil
+	return keyPair, nil
+}
+
+// ParsePEMPrivateKey parses the first PEM block of encoded as a private key and
+// reports the format it was stored in. PKCS#1 ("RSA PRIVATE KEY"), PKCS#8
+// ("PRIVATE KEY"), SEC1 ("EC PRIVATE KEY") and PBES2 encrypted PKCS#8
+// ("ENCRYPTED PRIVATE KEY") keys are accepted; passphrase is only used for the
+// latter. Errors name the detected format so a misconfigured key can be told
+// apart from a corrupt one.
+func ParsePEMPrivateKey(encoded, passphrase []byte) (KeyPair, KeyFormat, error) {
+	block, _ := pem.Decode(encoded)
+	if block == nil {
+		return nil, "", fmt.Errorf("%w: no PEM block found", ErrInvalidPrivateKey)
+	}
+	return parsePrivateKeyBlock(block, passphrase)
+}
+
+// parsePrivateKeyBlock parses a private key PEM block according to its type.
+func parsePrivateKeyBlock(block *pem.Block, passphrase []byte) (KeyPair, KeyFormat, error) {
+	var format KeyFormat
+	var parsed any
+	var err error
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		format = KeyFormatPKCS1
+		// Legacy OpenSSL encryption relies on an unauthenticated MD5 based key derivation
+		if _, encrypted := block.Headers["DEK-Info"]; encrypted {
+			return nil, format, fmt.Errorf("%w: legacy encrypted %s key is not supported, convert it with 'openssl pkcs8 -topk8 -v2 aes-256-cbc'", ErrInvalidPrivateKey, format)
+		}
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		format = KeyFormatPKCS8
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		format = KeyFormatSEC1
+		parsed, err = x509.ParseECPrivateKey(block.Bytes)
+	case "ENCRYPTED PRIVATE KEY":
+		format = KeyFormatEncryptedPKCS8
+		if len(passphrase) == 0 {
+			return nil, format, fmt.Errorf("%s key: %w", format, ErrPassphraseRequired)
+		}
+		var der []byte
+		if der, err = decryptPKCS8(block.Bytes, passphrase); err == nil {
+			parsed, err = x509.ParsePKCS8PrivateKey(der)
+		}
+	case "PUBLIC KEY", "RSA PUBLIC KEY":
+		return nil, "", fmt.Errorf("%w: PEM block %q holds a public key, the private key is required", ErrInvalidPrivateKey, block.Type)
+	default:
+		return nil, "", fmt.Errorf("%w: unsupported PEM block type %q", ErrInvalidPrivateKey, block.Type)
+	}
+	if err != nil {
+		if errors.Is(err, ErrIncorrectPassphrase) {
+			return nil, format, fmt.Errorf("%s key: %w", format, err)
+		}
+		return nil, format, fmt.Errorf("%w: malformed %s key: %v", ErrInvalidPrivateKey, format, err)
+	}
+
+	keyPair, err := newParsedKeyPair(parsed)
+	if err != nil {
+		return nil, format, fmt.Errorf("%s key: %w", format, err)
+	}
+	return keyPair, format, nil
+}
+
+// newParsedKeyPair creates a key pair from a private key returned by the x509 parsers.
+func newParsedKeyPair(parsed any) (KeyPair, error) {
+	privateKey, ok := parsed.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("%w: key type %T", ErrUnsupportedKey, parsed)
+	}
+	return NewKeyPair(privateKey)
 }
@@ -0,0 +1,212 @@
+package token
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var (
+	// ErrNoSigningKey is returned when a key set is created without a signing key.
+	ErrNoSigningKey = errors.New("signing key cannot be nil")
+	// ErrDuplicateKeyID is returned when two keys of a key set share a key ID.
+	ErrDuplicateKeyID = errors.New("duplicate key ID")
+	// ErrInvalidVerificationKey is returned when a verification key cannot be parsed.
+	ErrInvalidVerificationKey = errors.New("invalid verification key")
+)
+
+// VerificationKey is a public key tokens issued by this server are verified with.
+type VerificationKey struct {
+	// KeyID is the "kid" of the key, stamped into the header of the tokens it signs.
+	KeyID     string
+	PublicKey PublicKey
+	// Method is the algorithm the key signs with. Tokens presenting the key's
+	// kid with any other "alg" header are rejected.
+	Method jwt.SigningMethod
+}
+
+// KeySource provides the key set the server currently signs and verifies tokens with.
+// Handlers ask for the key set on every request so key rotations take effect immediately.
+type KeySource interface {
+	KeySet() *KeySet
+}
+
+// KeySet holds the keys of the server: the signing key issues new tokens, and
+// every key of the set, including the keys previously used for signing,
+// verifies tokens until they are removed from the set.
+type KeySet struct {
+	signingKeyID string
+	signingKey   KeyPair
+	keys         []VerificationKey
+}
+
+// NewKeySet creates a key set that signs with signingKey and additionally
+// verifies tokens signed by the previous keys. Every key signs with the
+// default algorithm of its type, see SigningMethod.
+func NewKeySet(signingKey KeyPair, previous ...PublicKey) (*KeySet, error) {
+	if signingKey == nil {
+		return nil, ErrNoSigningKey
+	}
+
+	set := &KeySet{signingKeyID: KeyID(signingKey.PublicKey()), signingKey: signingKey}
+	seen := make(map[string]bool)
+	for _, key := range append([]PublicKey{signingKey.PublicKey()}, previous...) {
+		method, err := SigningMethod(key)
+		if err != nil {
+			return nil, err
+		}
+		kid := KeyID(key)
+		if seen[kid] {
+			return nil, fmt.Errorf("%w %q", ErrDuplicateKeyID, kid)
+		}
+		seen[kid] = true
+		set.keys = append(set.keys, VerificationKey{KeyID: kid, PublicKey: key, Method: method})
+	}
+	return set, nil
+}
+
+// WithRSAAlgorithm returns a copy of s in which the RSA keys sign with the
+// JWS algorithm alg instead of RS256, see ParseRSASigningMethod. An empty alg
+// keeps RS256. The algorithm applies to every RSA key of the set, so changing
+// it makes tokens signed before the change fail verification.
+func (s *KeySet) WithRSAAlgorithm(alg string) (*KeySet, error) {
+	if alg == "" {
+		return s, nil
+	}
+	method, err := ParseRSASigningMethod(alg)
+	if err != nil {
+		return nil, err
+	}
+
+	set := &KeySet{signingKeyID: s.signingKeyID, signingKey: s.signingKey, keys: make([]VerificationKey, len(s.keys))}
+	for i, key := range s.keys {
+		if _, ok := key.PublicKey.(*rsa.PublicKey); ok {
+			key.Method = method
+		}
+		set.keys[i] = key
+	}
+	return set, nil
+}
+
+// KeySet returns s itself, so a fixed key set is a KeySource.
+func (s *KeySet) KeySet() *KeySet {
+	return s
+}
+
+// SigningKey returns the key ID and key pair new tokens are signed with.
+func (s *KeySet) SigningKey() (string, KeyPair) {
+	return s.signingKeyID, s.signingKey
+}
+
+// SigningMethod returns the algorithm new tokens are signed with.
+func (s *KeySet) SigningMethod() jwt.SigningMethod {
+	return s.keys[0].Method
+}
+
+// VerificationKeys returns all keys of the set, the signing key first.
+func (s *KeySet) VerificationKeys() []VerificationKey {
+	return s.keys
+}
+
+// Lookup returns the verification key with the given key ID.
+func (s *KeySet) Lookup(kid string) (VerificationKey, bool) {
+	for _, key := range s.keys {
+		if key.KeyID == kid {
+			return key, true
+		}
+	}
+	return VerificationKey{}, false
+}
+
+// KeyID derives the key ID of a public key as its JWK thumbprint (RFC 7638):
+// the base64url encoded SHA-256 hash of the key's required JWK members in
+// lexicographic order. keytool derives the same ID for its file names.
+func KeyID(publicKey PublicKey) string {
+	// encoding/json sorts map keys, and the members contain only base64url
+	// characters and curve names, so no escaping changes the canonical form
+	canonical, _ := json.Marshal(thumbprintMembers(publicKey))
+	sum := sha256.Sum256(canonical)
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// thumbprintMembers returns the required JWK members of publicKey (RFC 7638 Section 3.2).
+// EC coordinates are padded to the size of the curve (RFC 7518 Section 6.2.1.2).
+func thumbprintMembers(publicKey PublicKey) map[string]string {
+	switch key := publicKey.(type) {
+	case *rsa.PublicKey:
+		return map[string]string{
+			"kty": "RSA",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}
+	case *ecdsa.PublicKey:
+		size := (key.Curve.Params().BitSize + 7) / 8
+		return map[string]string{
+			"kty": "EC",
+			"crv": key.Curve.Params().Name,
+			"x":   base64.RawURLEncoding.EncodeToString(key.X.FillBytes(make([]byte, size))),
+			"y":   base64.RawURLEncoding.EncodeToString(key.Y.FillBytes(make([]byte, size))),
+		}
+	case ed25519.PublicKey:
+		return map[string]string{
+			"kty": "OKP",
+			"crv": "Ed25519",
+			"x":   base64.RawURLEncoding.EncodeToString(key),
+		}
+	default:
+		return nil
+	}
+}
+
+// ParseVerificationKeys parses the public keys from a sequence of PEM blocks.
+// PKIX ("PUBLIC KEY") public keys of any supported type and PKCS#1
+// ("RSA PUBLIC KEY") public keys are accepted, as well as unencrypted PKCS#1,
+// PKCS#8 and SEC1 private keys of which only the public half is kept.
+func ParseVerificationKeys(encoded []byte) ([]PublicKey, error) {
+	var keys []PublicKey
+	for {
+		var block *pem.Block
+		block, encoded = pem.Decode(encoded)
+		if block == nil {
+			break
+		}
+
+		var key PublicKey
+		switch block.Type {
+		case "PUBLIC KEY":
+			parsed, err := x509.ParsePKIXPublicKey(block.Bytes)
+			if err != nil {
+				return nil, fmt.Errorf("%w: %v", ErrInvalidVerificationKey, err)
+			}
+			if key, err = toPublicKey(parsed); err != nil {
+				return nil, fmt.Errorf("%w: %v", ErrInvalidVerificationKey, err)
+			}
+		case "RSA PUBLIC KEY":
+			parsed, err := x509.ParsePKCS1PublicKey(block.Bytes)
+			if err != nil {
+				return nil, fmt.Errorf("%w: %v", ErrInvalidVerificationKey, err)
+			}
+			key = parsed
+		case "RSA PRIVATE KEY", "PRIVATE KEY", "EC PRIVATE KEY":
+			keyPair, _, err := parsePrivateKeyBlock(block, nil)
+			if err != nil {
+				return nil, fmt.Errorf("%w: %v", ErrInvalidVerificationKey, err)
+			}
+			key = keyPair.PublicKey()
+		default:
+			return nil, fmt.Errorf("%w: unsupported PEM block type %q", ErrInvalidVerificationKey, block.Type)
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
@@ -0,0 +1,242 @@
+package token
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"testing"
+)
+
+func TestNewKeySet(t *testing.T) {
+	signingKey := setupTestKeyPair(t)
+	previousKey := setupTestKeyPair(t)
+
+	t.Run("signing key and previous keys", func(t *testing.T) {
+		keys, err := NewKeySet(signingKey, previousKey.PublicKey())
+		if err != nil {
+			t.Fatalf("NewKeySet() error = %v", err)
+		}
+
+		kid, key := keys.SigningKey()
+		if kid != KeyID(signingKey.PublicKey()) || key != signingKey {
+			t.Errorf("SigningKey() = %s, want %s", kid, KeyID(signingKey.PublicKey()))
+		}
+
+		verificationKeys := keys.VerificationKeys()
+		if len(verificationKeys) != 2 {
+			t.Fatalf("Expected 2 verification keys, got %d", len(verificationKeys))
+		}
+		if verificationKeys[0].KeyID != kid {
+			t.Errorf("Expected signing key first, got %s", verificationKeys[0].KeyID)
+		}
+
+		got, ok := keys.Lookup(KeyID(previousKey.PublicKey()))
+		if !ok || !got.PublicKey.Equal(previousKey.PublicKey()) {
+			t.Error("Expected previous key to be found by its key ID")
+		}
+		if _, ok := keys.Lookup("unknown"); ok {
+			t.Error("Expected unknown key ID not to be found")
+		}
+	})
+
+	t.Run("nil signing key", func(t *testing.T) {
+		if _, err := NewKeySet(nil); !errors.Is(err, ErrNoSigningKey) {
+			t.Errorf("NewKeySet() error = %v, want %v", err, ErrNoSigningKey)
+		}
+	})
+
+	t.Run("previous key equal to signing key", func(t *testing.T) {
+		if _, err := NewKeySet(signingKey, signingKey.PublicKey()); !errors.Is(err, ErrDuplicateKeyID) {
+			t.Errorf("NewKeySet() error = %v, want %v", err, ErrDuplicateKeyID)
+		}
+	})
+}
+
+func TestKeySetWithRSAAlgorithm(t *testing.T) {
+	rsaKey := setupTestKeyPair(t)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate EC key: %v", err)
+	}
+	keys, err := NewKeySet(rsaKey, &ecKey.PublicKey)
+	if err != nil {
+		t.Fatalf("NewKeySet() error = %v", err)
+	}
+	if got := keys.SigningMethod().Alg(); got != "RS256" {
+		t.Errorf("SigningMethod() = %s, want RS256", got)
+	}
+
+	for _, alg := range []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512"} {
+		t.Run(alg, func(t *testing.T) {
+			configured, err := keys.WithRSAAlgorithm(alg)
+			if err != nil {
+				t.Fatalf("WithRSAAlgorithm() error = %v", err)
+			}
+			if got := configured.SigningMethod().Alg(); got != alg {
+				t.Errorf("SigningMethod() = %s, want %s", got, alg)
+			}
+			if got := configured.VerificationKeys()[1].Method.Alg(); got != "ES256" {
+				t.Errorf("EC key method = %s, want ES256", got)
+			}
+		})
+	}
+
+	t.Run("original set is unchanged", func(t *testing.T) {
+		if _, err := keys.WithRSAAlgorithm("PS256"); err != nil {
+			t.Fatalf("WithRSAAlgorithm() error = %v", err)
+		}
+		if got := keys.SigningMethod().Alg(); got != "RS256" {
+			t.Errorf("SigningMethod() = %s, want RS256", got)
+		}
+	})
+
+	t.Run("empty algorithm keeps RS256", func(t *testing.T) {
+		configured, err := keys.WithRSAAlgorithm("")
+		if err != nil || configured.SigningMethod().Alg() != "RS256" {
+			t.Errorf("WithRSAAlgorithm(\"\") = %v, %v; want RS256", configured, err)
+		}
+	})
+
+	for _, alg := range []string{"ES256", "HS256", "none", "rs256"} {
+		t.Run("rejects "+alg, func(t *testing.T) {
+			if _, err := keys.WithRSAAlgorithm(alg); !errors.Is(err, ErrUnsupportedAlgorithm) {
+				t.Errorf("WithRSAAlgorithm() error = %v, want %v", err, ErrUnsupportedAlgorithm)
+			}
+		})
+	}
+}
+
+func TestKeyID(t *testing.T) {
+	// Example key and thumbprint from RFC 7638 Section 3.1
+	n, err := base64.RawURLEncoding.DecodeString("0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc_BJECPebWKRXjBZCiFV4n3oknjhMstn64tZ_2W-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_FDW2QvzqY368QQMicAtaSqzs8KJZgnYb9c7d0zgdAZHzu6qMQvRL5hajrn1n91CbOpbISD08qNLyrdkt-bFTWhAI4vMQFh6WeZu0fM4lFd2NcRwr3XPksINHaQ-G_xBniIqbw0Ls1jF44-csFCur-kEgU8awapJzKnqDKgw")
+	if err != nil {
+		t.Fatalf("Failed to decode modulus: %v", err)
+	}
+	publicKey := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: 65537}
+
+	if got, want := KeyID(publicKey), "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs"; got != want {
+		t.Errorf("KeyID() = %s, want %s", got, want)
+	}
+	if KeyID(publicKey) == KeyID(setupTestKeyPair(t).PublicKey()) {
+		t.Error("Expected different key IDs for different keys")
+	}
+
+	t.Run("Ed25519", func(t *testing.T) {
+		// Example key and thumbprint from RFC 8037 Appendix A.3
+		x, err := base64.RawURLEncoding.DecodeString("11qYAYKxCrfVS_7TyWQHOg7hcvPapiMlrwIaaPcHURo")
+		if err != nil {
+			t.Fatalf("Failed to decode public key: %v", err)
+		}
+		if got, want := KeyID(ed25519.PublicKey(x)), "kPrK_qmxVWaYVA9wwBF6Iuo3vVzz7TxHCTwXBygrS4k"; got != want {
+			t.Errorf("KeyID() = %s, want %s", got, want)
+		}
+	})
+
+	t.Run("EC coordinates are padded", func(t *testing.T) {
+		ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatalf("Failed to generate EC key: %v", err)
+		}
+		members := thumbprintMembers(&ecKey.PublicKey)
+		if members["kty"] != "EC" || members["crv"] != "P-256" || len(members["x"]) != 43 || len(members["y"]) != 43 {
+			t.Errorf("thumbprintMembers() = %v, want P-256 members with 32 byte coordinates", members)
+		}
+	})
+}
+
+func TestParseVerificationKeys(t *testing.T) {
+	first := setupTestKeyPair(t)
+	second := setupTestKeyPair(t)
+	third := setupTestKeyPair(t)
+
+	pkix, err := x509.MarshalPKIXPublicKey(first.PublicKey())
+	if err != nil {
+		t.Fatalf("Failed to marshal public key: %v", err)
+	}
+	encoded := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pkix})
+	encoded = append(encoded, pem.EncodeToMemory(&pem.Block{Type: "RSA PUBLIC KEY", Bytes: x509.MarshalPKCS1PublicKey(second.PublicKey().(*rsa.PublicKey))})...)
+	encoded = append(encoded, pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(third.PrivateKey().(*rsa.PrivateKey))})...)
+
+	t.Run("parses all blocks", func(t *testing.T) {
+		keys, err := ParseVerificationKeys(encoded)
+		if err != nil {
+			t.Fatalf("ParseVerificationKeys() error = %v", err)
+		}
+		if len(keys) != 3 {
+			t.Fatalf("Expected 3 keys, got %d", len(keys))
+		}
+		for i, want := range []KeyPair{first, second, third} {
+			if !keys[i].Equal(want.PublicKey()) {
+				t.Errorf("Key %d does not match", i)
+			}
+		}
+	})
+
+	t.Run("empty input", func(t *testing.T) {
+		keys, err := ParseVerificationKeys(nil)
+		if err != nil || len(keys) != 0 {
+			t.Errorf("ParseVerificationKeys() = %v, %v; want no keys", keys, err)
+		}
+	})
+
+	t.Run("EC and Ed25519 keys", func(t *testing.T) {
+		ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatalf("Failed to generate EC key: %v", err)
+		}
+		edPublicKey, _, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatalf("Failed to generate Ed25519 key: %v", err)
+		}
+
+		var encoded []byte
+		for _, key := range []PublicKey{&ecKey.PublicKey, edPublicKey} {
+			der, err := x509.MarshalPKIXPublicKey(key)
+			if err != nil {
+				t.Fatalf("Failed to marshal public key: %v", err)
+			}
+			encoded = append(encoded, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})...)
+		}
+
+		keys, err := ParseVerificationKeys(encoded)
+		if err != nil {
+			t.Fatalf("ParseVerificationKeys() error = %v", err)
+		}
+		if len(keys) != 2 || !keys[0].Equal(&ecKey.PublicKey) || !keys[1].Equal(edPublicKey) {
+			t.Errorf("ParseVerificationKeys() = %v, want the EC and Ed25519 keys", keys)
+		}
+	})
+
+	p521Key, err := ecdsa.GenerateKey(elliptic.P521(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate EC key: %v", err)
+	}
+	p521PKIX, err := x509.MarshalPKIXPublicKey(&p521Key.PublicKey)
+	if err != nil {
+		t.Fatalf("Failed to marshal public key: %v", err)
+	}
+
+	invalidTests := []struct {
+		name    string
+		encoded []byte
+	}{
+		{name: "P-521 key", encoded: pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: p521PKIX})},
+		{name: "certificate", encoded: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: []byte{0}})},
+		{name: "malformed public key", encoded: pem.EncodeToMemory(&pem.Block{Type: "RSA PUBLIC KEY", Bytes: []byte{0}})},
+	}
+
+	for _, tt := range invalidTests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParseVerificationKeys(tt.encoded); !errors.Is(err, ErrInvalidVerificationKey) {
+				t.Errorf("ParseVerificationKeys() error = %v, want %v", err, ErrInvalidVerificationKey)
+			}
+		})
+	}
+}
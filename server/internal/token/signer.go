@@ -0,0 +1,66 @@
+package token
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"encoding/base64"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Signer signs tokens. Implementations may keep the private key outside the
+// process, in an HSM or a key management service, and only expose its public
+// half, which is published in the JWKS. The method is named SignJWS rather
+// than Sign so that a key can implement both Signer and crypto.Signer.
+type Signer interface {
+	// PublicKey returns the public key verifying the signatures.
+	PublicKey() PublicKey
+	// SignJWS returns the signature of the JWS signing input for the algorithm of
+	// method, encoded as defined in RFC 7518 Section 3 and RFC 8037 Section 3.1.
+	SignJWS(signingInput string, method jwt.SigningMethod) ([]byte, error)
+}
+
+// LocalSigner is a Signer holding its private key in process memory.
+type LocalSigner struct {
+	privateKey crypto.Signer
+}
+
+// NewLocalSigner creates a signer for an *rsa.PrivateKey, an *ecdsa.PrivateKey
+// or an ed25519.PrivateKey. Unsupported keys are reported when signing.
+func NewLocalSigner(privateKey crypto.Signer) *LocalSigner {
+	return &LocalSigner{privateKey: privateKey}
+}
+
+// PublicKey returns the public half of the private key.
+func (s *LocalSigner) PublicKey() PublicKey {
+	if s.privateKey == nil {
+		return nil
+	}
+	publicKey, _ := s.privateKey.Public().(PublicKey)
+	return publicKey
+}
+
+// SignJWS signs signingInput with the private key. RSA keys are validated first,
+// so a corrupt key fails instead of producing unverifiable signatures.
+func (s *LocalSigner) SignJWS(signingInput string, method jwt.SigningMethod) ([]byte, error) {
+	if rsaKey, ok := s.privateKey.(*rsa.PrivateKey); ok {
+		if err := rsaKey.Validate(); err != nil {
+			return nil, err
+		}
+	}
+	return method.Sign(signingInput, s.privateKey)
+}
+
+// signToken signs token with signer using the token's signing method and
+// returns the JWS compact serialization.
+func signToken(token *jwt.Token, signer Signer) (string, error) {
+	signingInput, err := token.SigningString()
+	if err != nil {
+		return "", err
+	}
+	signature, err := signer.SignJWS(signingInput, token.Method)
+	if err != nil {
+		return "", err
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
@@ -0,0 +1,91 @@
+package token
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// externalKey simulates a key held outside the process, which implements
+// crypto.Signer for the key pair and Signer for token signing.
+type externalKey struct {
+	key   *ecdsa.PrivateKey
+	signs int
+}
+
+func (k *externalKey) Public() crypto.PublicKey {
+	return k.key.Public()
+}
+
+func (k *externalKey) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	return k.key.Sign(rand, digest, opts)
+}
+
+func (k *externalKey) PublicKey() PublicKey {
+	return &k.key.PublicKey
+}
+
+func (k *externalKey) SignJWS(signingInput string, method jwt.SigningMethod) ([]byte, error) {
+	k.signs++
+	return method.Sign(signingInput, k.key)
+}
+
+func TestKeyPairSigner(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate EC key: %v", err)
+	}
+
+	t.Run("in-memory key signs locally", func(t *testing.T) {
+		keyPair, err := NewKeyPair(ecKey)
+		if err != nil {
+			t.Fatalf("NewKeyPair() error = %v", err)
+		}
+		signer, ok := keyPair.Signer().(*LocalSigner)
+		if !ok {
+			t.Fatalf("Expected *LocalSigner, got %T", keyPair.Signer())
+		}
+		if !signer.PublicKey().Equal(ecKey.Public()) {
+			t.Error("Public key mismatch")
+		}
+	})
+
+	t.Run("external key signs itself", func(t *testing.T) {
+		external := &externalKey{key: ecKey}
+		keyPair, err := NewKeyPair(external)
+		if err != nil {
+			t.Fatalf("NewKeyPair() error = %v", err)
+		}
+		if keyPair.Signer() != Signer(external) {
+			t.Fatalf("Expected the external key as signer, got %T", keyPair.Signer())
+		}
+
+		signed, _, err := NewGenerator(keyPair.Signer(), KeyID(keyPair.PublicKey()), nil).GenerateToken(Grant{Username: "testuser"})
+		if err != nil {
+			t.Fatalf("GenerateToken() error = %v", err)
+		}
+		if external.signs != 1 {
+			t.Errorf("Expected 1 signature by the external key, got %d", external.signs)
+		}
+		if _, err := jwt.Parse(signed, func(_ *jwt.Token) (interface{}, error) {
+			return &ecKey.PublicKey, nil
+		}, jwt.WithValidMethods([]string{"ES256"})); err != nil {
+			t.Errorf("Failed to verify token: %v", err)
+		}
+	})
+}
+
+func TestLocalSignerNilKey(t *testing.T) {
+	if NewLocalSigner(nil).PublicKey() != nil {
+		t.Error("Expected nil public key without private key")
+	}
+	if _, _, err := NewGenerator(NewLocalSigner(nil), "", nil).GenerateToken(Grant{Username: "testuser"}); !errors.Is(err, ErrNilPrivateKey) {
+		t.Errorf("GenerateToken() error = %v, want %v", err, ErrNilPrivateKey)
+	}
+}
@@ -0,0 +1,231 @@
+package userpool
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"oauth2-task/internal/token"
+)
+
+// ErrScopeNotAllowed is returned when a client requests a scope outside of its allowed scopes.
+var ErrScopeNotAllowed = errors.New("requested scope is not allowed for client")
+
+// Client authentication methods as registered in the OAuth Token Endpoint
+// Authentication Methods registry (RFC 7591 Section 2).
+const (
+	// AuthMethodClientSecretBasic sends the credentials in the HTTP Basic Authorization header (RFC 6749 Section 2.3.1).
+	AuthMethodClientSecretBasic = "client_secret_basic"
+	// AuthMethodClientSecretPost sends client_id and client_secret in the request body (RFC 6749 Section 2.3.1).
+	AuthMethodClientSecretPost = "client_secret_post"
+	// AuthMethodPrivateKeyJWT sends a client assertion signed with the client's private key (RFC 7523 Section 2.2).
+	AuthMethodPrivateKeyJWT = "private_key_jwt"
+	// AuthMethodTLSClientAuth authenticates with a CA-issued client certificate over mutual TLS (RFC 8705 Section 2.1).
+	AuthMethodTLSClientAuth = "tls_client_auth"
+	// AuthMethodSelfSignedTLSClientAuth authenticates with a self-signed client certificate
+	// whose public key is registered for the client (RFC 8705 Section 2.2).
+	AuthMethodSelfSignedTLSClientAuth = "self_signed_tls_client_auth"
+)
+
+// Client represents a registered OAuth2 client and the scopes it may be granted.
+type Client struct {
+	// ID is the client identifier as defined in RFC 6749 Section 2.2.
+	ID string
+	// SecretHash is the client secret hashed in PHC string format (argon2id or bcrypt).
+	// Plaintext secrets are never stored.
+	SecretHash string
+	// AllowedScopes lists every scope the client may request.
+	AllowedScopes []string
+	// DefaultScopes are granted when the client omits the scope parameter.
+	// RFC 6749 Section 3.3 allows the server to fall back to a pre-defined default.
+	DefaultScopes []string
+	// Audiences lists the resource servers tokens issued to the client are intended for.
+	Audiences []string
+	// TokenTTL overrides the lifetime of tokens issued to the client. Zero means the server default.
+	TokenTTL time.Duration
+	// Enabled reports whether the client may authenticate. Disabled clients are rejected.
+	Enabled bool
+	// AuthMethods lists the token endpoint authentication methods the client may use.
+	// Empty means AuthMethodClientSecretBasic only.
+	AuthMethods []string
+	// PublicKey is a PEM encoded public key used to verify private_key_jwt client assertions.
+	PublicKey string
+	// JWKS is a JSON Web Key Set (RFC 7517) with the keys used to verify private_key_jwt client assertions.
+	// PublicKey and JWKS also hold the certificate keys of self_signed_tls_client_auth clients.
+	JWKS string
+	// TLSClientAuthSubjectDN is the expected subject distinguished name of the client
+	// certificate for tls_client_auth, e.g. "CN=billing,O=Example" (RFC 8705 Section 2.1.2).
+	TLSClientAuthSubjectDN string
+	// TLSClientAuthSANDNS is the expected dNSName SAN entry of the client certificate for tls_client_auth.
+	TLSClientAuthSANDNS string
+	// IntrospectAudiences lists the audiences of the tokens of other clients the client may introspect.
+	// Resource servers list their own audience here; any client may introspect the tokens issued to itself.
+	IntrospectAudiences []string
+	// Claims are static claims added to every access token issued to the client, e.g. a tenant or roles.
+	// Names of claims set by the server itself are reserved, see validateClaims.
+	Claims map[string]any
+	// IntrospectionEncryptedResponseAlg is the JWE algorithm JWT introspection responses to the client are
+	// encrypted with, using the encryption key of its JWKS (RFC 9701 Section 6). Empty leaves them unencrypted.
+	IntrospectionEncryptedResponseAlg string
+	// IntrospectionEncryptedResponseEnc is the JWE content encryption algorithm of encrypted introspection
+	// responses. Empty selects token.ContentEncryptionA128CBCHS256.
+	IntrospectionEncryptedResponseEnc string
+}
+
+// validateClaims checks that the client's static claims do not use the name
+// of a claim the server sets on access tokens itself.
+func (c Client) validateClaims() error {
+	for name := range c.Claims {
+		if name == "" || slices.Contains(token.RegisteredClaimNames, name) {
+			return fmt.Errorf("claim %q is reserved", name)
+		}
+	}
+	return nil
+}
+
+// IntrospectionEncryptionEnc returns the content encryption algorithm of
+// encrypted introspection responses to the client.
+func (c Client) IntrospectionEncryptionEnc() string {
+	if c.IntrospectionEncryptedResponseEnc == "" {
+		return token.ContentEncryptionA128CBCHS256
+	}
+	return c.IntrospectionEncryptedResponseEnc
+}
+
+// validateIntrospectionEncryption checks that encrypted introspection responses
+// use supported algorithms and that the client registered a key to encrypt them to.
+func (c Client) validateIntrospectionEncryption() error {
+	if c.IntrospectionEncryptedResponseAlg == "" {
+		if c.IntrospectionEncryptedResponseEnc != "" {
+			return errors.New("introspection_encrypted_response_enc requires introspection_encrypted_response_alg")
+		}
+		return nil
+	}
+	if err := token.ValidateEncryption(c.IntrospectionEncryptedResponseAlg, c.IntrospectionEncryptionEnc()); err != nil {
+		return fmt.Errorf("invalid introspection response encryption: %w", err)
+	}
+	if _, err := c.EncryptionKey(); err != nil {
+		return fmt.Errorf("introspection_encrypted_response_alg: %w", err)
+	}
+	return nil
+}
+
+// AllowsAuthMethod reports whether the client may authenticate with method.
+func (c Client) AllowsAuthMethod(method string) bool {
+	if len(c.AuthMethods) == 0 {
+		return method == AuthMethodClientSecretBasic
+	}
+	for _, allowed := range c.AuthMethods {
+		if allowed == method {
+			return true
+		}
+	}
+	return false
+}
+
+// isKnownAuthMethod reports whether method is a supported client authentication method.
+func isKnownAuthMethod(method string) bool {
+	switch method {
+	case AuthMethodClientSecretBasic, AuthMethodClientSecretPost, AuthMethodPrivateKeyJWT,
+		AuthMethodTLSClientAuth, AuthMethodSelfSignedTLSClientAuth:
+		return true
+	default:
+		return false
+	}
+}
+
+// usesSecret reports whether the client authenticates with a client secret.
+func (c Client) usesSecret() bool {
+	return c.AllowsAuthMethod(AuthMethodClientSecretBasic) || c.AllowsAuthMethod(AuthMethodClientSecretPost)
+}
+
+// validateCredentials checks that the client's authentication methods are
+// supported and that the secret hash, public keys or certificate subject they
+// need are valid. Clients not using a secret based method may omit the secret hash.
+func (c Client) validateCredentials() error {
+	for _, method := range c.AuthMethods {
+		if !isKnownAuthMethod(method) {
+			return fmt.Errorf("unsupported auth method %q", method)
+		}
+	}
+
+	if c.SecretHash != "" || c.usesSecret() {
+		if err := validateHash(c.SecretHash); err != nil {
+			return err
+		}
+	}
+
+	keys, err := c.AssertionKeys()
+	if err != nil {
+		return err
+	}
+	for _, method := range []string{AuthMethodPrivateKeyJWT, AuthMethodSelfSignedTLSClientAuth} {
+		if c.AllowsAuthMethod(method) && len(keys) == 0 {
+			return fmt.Errorf("%s requires a public_key or jwks", method)
+		}
+	}
+
+	// RFC 8705 Section 2.1.2 requires exactly one certificate subject to match
+	subjects := 0
+	for _, subject := range []string{c.TLSClientAuthSubjectDN, c.TLSClientAuthSANDNS} {
+		if subject != "" {
+			subjects++
+		}
+	}
+	if c.AllowsAuthMethod(AuthMethodTLSClientAuth) && subjects != 1 {
+		return fmt.Errorf("%s requires exactly one of tls_client_auth_subject_dn or tls_client_auth_san_dns", AuthMethodTLSClientAuth)
+	}
+	return nil
+}
+
+// ResolveTokenTTL determines the lifetime of tokens issued to the client.
+// Clients without a TokenTTL override get defaultTTL, and overrides longer
+// than maxTTL are capped to it. A zero maxTTL imposes no cap.
+func (c Client) ResolveTokenTTL(defaultTTL, maxTTL time.Duration) time.Duration {
+	ttl := c.TokenTTL
+	if ttl <= 0 {
+		ttl = defaultTTL
+	}
+	if maxTTL > 0 && ttl > maxTTL {
+		ttl = maxTTL
+	}
+	return ttl
+}
+
+// validateScopes checks that the default scopes are a subset of the allowed
+// scopes, so clients omitting the scope parameter are never granted more than
+// they could request.
+func (c Client) validateScopes() error {
+	allowed := make(map[string]bool, len(c.AllowedScopes))
+	for _, scope := range c.AllowedScopes {
+		allowed[scope] = true
+	}
+	for _, scope := range c.DefaultScopes {
+		if !allowed[scope] {
+			return fmt.Errorf("default scope %q is not an allowed scope", scope)
+		}
+	}
+	return nil
+}
+
+// ResolveScopes determines the scopes to grant for a token request.
+// An empty request falls back to the client's default scopes. Otherwise every
+// requested scope must be part of the client's allowed scopes, which lets a
+// client downscope by asking for a subset of what it is allowed.
+func (c Client) ResolveScopes(requested []string) ([]string, error) {
+	if len(requested) == 0 {
+		return append([]string(nil), c.DefaultScopes...), nil
+	}
+
+	allowed := make(map[string]bool, len(c.AllowedScopes))
+	for _, scope := range c.AllowedScopes {
+		allowed[scope] = true
+	}
+	for _, scope := range requested {
+		if !allowed[scope] {
+			return nil, ErrScopeNotAllowed
+		}
+	}
+	return append([]string(nil), requested...), nil
+}
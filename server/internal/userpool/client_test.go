@@ -0,0 +1,197 @@
+package userpool
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"reflect"
+	"testing"
+	"time"
+
+	"oauth2-task/internal/token"
+)
+
+func TestClientResolveScopes(t *testing.T) {
+	client := Client{
+		ID:            "client",
+		AllowedScopes: []string{"read", "write", "admin"},
+		DefaultScopes: []string{"read"},
+	}
+
+	tests := []struct {
+		name      string
+		client    Client
+		requested []string
+		want      []string
+		wantErr   error
+	}{
+		{
+			name:      "No scope requested falls back to defaults",
+			client:    client,
+			requested: nil,
+			want:      []string{"read"},
+		},
+		{
+			name:      "All allowed scopes",
+			client:    client,
+			requested: []string{"read", "write", "admin"},
+			want:      []string{"read", "write", "admin"},
+		},
+		{
+			name:      "Downscoped request",
+			client:    client,
+			requested: []string{"write"},
+			want:      []string{"write"},
+		},
+		{
+			name:      "Scope outside allowed set",
+			client:    client,
+			requested: []string{"read", "delete"},
+			wantErr:   ErrScopeNotAllowed,
+		},
+		{
+			name:      "Client without allowed scopes",
+			client:    Client{ID: "client"},
+			requested: []string{"read"},
+			wantErr:   ErrScopeNotAllowed,
+		},
+		{
+			name:      "Client without default scopes",
+			client:    Client{ID: "client", AllowedScopes: []string{"read"}},
+			requested: nil,
+			want:      nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.client.ResolveScopes(tt.requested)
+			if err != tt.wantErr {
+				t.Fatalf("ResolveScopes() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ResolveScopes() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("returned slice does not alias the client defaults", func(t *testing.T) {
+		got, err := client.ResolveScopes(nil)
+		if err != nil {
+			t.Fatalf("ResolveScopes() error = %v", err)
+		}
+		got[0] = "modified"
+		if client.DefaultScopes[0] != "read" {
+			t.Error("Expected ResolveScopes() to return a copy of the default scopes")
+		}
+	})
+}
+
+func TestClientAllowsAuthMethod(t *testing.T) {
+	tests := []struct {
+		name   string
+		client Client
+		method string
+		want   bool
+	}{
+		{name: "default allows basic", client: Client{}, method: AuthMethodClientSecretBasic, want: true},
+		{name: "default rejects post", client: Client{}, method: AuthMethodClientSecretPost, want: false},
+		{
+			name:   "post only rejects basic",
+			client: Client{AuthMethods: []string{AuthMethodClientSecretPost}},
+			method: AuthMethodClientSecretBasic,
+			want:   false,
+		},
+		{
+			name:   "post only allows post",
+			client: Client{AuthMethods: []string{AuthMethodClientSecretPost}},
+			method: AuthMethodClientSecretPost,
+			want:   true,
+		},
+		{
+			name:   "both methods allow basic",
+			client: Client{AuthMethods: []string{AuthMethodClientSecretPost, AuthMethodClientSecretBasic}},
+			method: AuthMethodClientSecretBasic,
+			want:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.client.AllowsAuthMethod(tt.method); got != tt.want {
+				t.Errorf("AllowsAuthMethod(%q) = %v, want %v", tt.method, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClientResolveTokenTTL(t *testing.T) {
+	tests := []struct {
+		name   string
+		client Client
+		maxTTL time.Duration
+		want   time.Duration
+	}{
+		{name: "default without override", client: Client{}, maxTTL: 24 * time.Hour, want: time.Hour},
+		{name: "shorter override", client: Client{TokenTTL: 5 * time.Minute}, maxTTL: 24 * time.Hour, want: 5 * time.Minute},
+		{name: "longer override", client: Client{TokenTTL: 8 * time.Hour}, maxTTL: 24 * time.Hour, want: 8 * time.Hour},
+		{name: "override capped to maximum", client: Client{TokenTTL: 48 * time.Hour}, maxTTL: 24 * time.Hour, want: 24 * time.Hour},
+		{name: "no maximum", client: Client{TokenTTL: 48 * time.Hour}, want: 48 * time.Hour},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.client.ResolveTokenTTL(time.Hour, tt.maxTTL); got != tt.want {
+				t.Errorf("ResolveTokenTTL() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClientValidateIntrospectionEncryption(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate RSA key: %v", err)
+	}
+	jwks := testEncryptionJWKS(t, "enc-1", &rsaKey.PublicKey)
+
+	tests := []struct {
+		name    string
+		client  Client
+		wantEnc string
+		wantErr bool
+	}{
+		{name: "unencrypted", client: Client{}},
+		{
+			name:    "default content encryption",
+			client:  Client{JWKS: jwks, IntrospectionEncryptedResponseAlg: token.KeyEncryptionRSAOAEP256},
+			wantEnc: token.ContentEncryptionA128CBCHS256,
+		},
+		{
+			name: "AES-GCM content encryption",
+			client: Client{JWKS: jwks, IntrospectionEncryptedResponseAlg: token.KeyEncryptionRSAOAEP256,
+				IntrospectionEncryptedResponseEnc: token.ContentEncryptionA256GCM},
+			wantEnc: token.ContentEncryptionA256GCM,
+		},
+		{name: "enc without alg", client: Client{JWKS: jwks, IntrospectionEncryptedResponseEnc: token.ContentEncryptionA256GCM}, wantErr: true},
+		{name: "unsupported alg", client: Client{JWKS: jwks, IntrospectionEncryptedResponseAlg: "RSA1_5"}, wantErr: true},
+		{
+			name: "unsupported enc",
+			client: Client{JWKS: jwks, IntrospectionEncryptedResponseAlg: token.KeyEncryptionRSAOAEP256,
+				IntrospectionEncryptedResponseEnc: "A128GCM"},
+			wantErr: true,
+		},
+		{name: "no encryption key", client: Client{IntrospectionEncryptedResponseAlg: token.KeyEncryptionRSAOAEP256}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.client.validateIntrospectionEncryption()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("validateIntrospectionEncryption() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantEnc != "" && tt.client.IntrospectionEncryptionEnc() != tt.wantEnc {
+				t.Errorf("IntrospectionEncryptionEnc() = %q, want %q", tt.client.IntrospectionEncryptionEnc(), tt.wantEnc)
+			}
+		})
+	}
+}
@@ -1,14 +1,21 @@
 // Package userpool provides client credential management for OAuth2 authentication.
-// It implements a simple in-memory storage for client credentials (client_id/client_secret pairs)
-// used in the OAuth2 Client Credentials Grant flow. The package is designed to be
-// easily extensible for different storage backends in production environments.
+// It defines the ClientStore interface used to look up and authenticate clients
+// (client_id/client_secret pairs together with the scopes a client may request)
+// in the OAuth2 Client Credentials Grant flow, and ships an in-memory
+// implementation. Production deployments can plug in their own backend by
+// implementing ClientStore.
 package userpool
 
 // Default returns a user pool with default test users.
+// The test user "sho" authenticates with the secret "test123".
 // This function is intended for development and testing purposes only.
 // In production, implement a proper credential storage solution.
-func Default() map[string]string {
-	return map[string]string{
-		"sho": "test123",
-	}
+func Default(hasher Hasher) *MemoryStore {
+	return NewMemoryStore(hasher, Client{
+		ID:            "sho",
+		SecretHash:    "$argon2id$v=19$m=65536,t=3,p=4$mFnZ/26ggwYOgXbb3Pq58Q$iitcI6f7u0ccFyb3jC/+KUSDYuipcLwx9p4JNOKAx5Q",
+		AllowedScopes: []string{"read", "write"},
+		DefaultScopes: []string{"read"},
+		Enabled:       true,
+	})
 }
@@ -1,48 +1,59 @@
 package userpool
 
 import (
+	"context"
+	"reflect"
 	"testing"
 )
 
 func TestDefault(t *testing.T) {
 	t.Run("returns expected test user", func(t *testing.T) {
-		pool := Default()
+		pool := Default(DefaultHasher())
 		if pool == nil {
 			t.Fatal("Expected non-nil user pool")
 		}
 
-		password, exists := pool["sho"]
-		if !exists {
-			t.Fatal("Default test user 'sho' not found")
+		client, err := pool.Lookup(context.Background(), "sho")
+		if err != nil {
+			t.Fatalf("Default test user 'sho' not found: %v", err)
 		}
-		if password != "test123" {
-			t.Errorf("Expected password 'test123', got '%s'", password)
+		if client.ID != "sho" {
+			t.Errorf("Expected client ID 'sho', got '%s'", client.ID)
+		}
+		if !reflect.DeepEqual(client.AllowedScopes, []string{"read", "write"}) {
+			t.Errorf("Expected allowed scopes [read write], got %v", client.AllowedScopes)
+		}
+		if !reflect.DeepEqual(client.DefaultScopes, []string{"read"}) {
+			t.Errorf("Expected default scopes [read], got %v", client.DefaultScopes)
 		}
 	})
 
-	t.Run("returns maps with same content", func(t *testing.T) {
-		pool1 := Default()
-		pool2 := Default()
+	t.Run("authenticates test user", func(t *testing.T) {
+		if _, err := Default(DefaultHasher()).VerifySecret(context.Background(), "sho", "test123"); err != nil {
+			t.Errorf("Expected default test user to authenticate, got %v", err)
+		}
+	})
 
-		if len(pool1) != len(pool2) {
-			t.Errorf("Expected same map size, got %d and %d", len(pool1), len(pool2))
+	t.Run("stores only hashed secrets", func(t *testing.T) {
+		client, _ := Default(DefaultHasher()).Lookup(context.Background(), "sho")
+		if client.SecretHash == "test123" {
+			t.Error("Expected the default test user secret to be hashed")
 		}
-		if pool1["sho"] != pool2["sho"] {
-			t.Error("Expected same content in both maps")
+		if DefaultHasher().NeedsRehash(client.SecretHash) {
+			t.Error("Expected the default test user hash to use the default parameters")
 		}
 	})
 
-	t.Run("returns independent map instances", func(t *testing.T) {
-		pool1 := Default()
+	t.Run("returns independent store instances", func(t *testing.T) {
+		pool1 := Default(DefaultHasher())
 		// Modify pool1 before getting pool2
-		pool1["sho"] = "modified"
+		client := pool1.clients["sho"]
+		client.SecretHash = "modified"
+		pool1.clients["sho"] = client
 
-		pool2 := Default()
-		if pool2["sho"] == "modified" {
-			t.Error("Expected Default() to return a new map instance")
-		}
-		if pool2["sho"] != "test123" {
-			t.Error("Expected Default() to return map with original content")
+		pool2 := Default(DefaultHasher())
+		if _, err := pool2.VerifySecret(context.Background(), "sho", "test123"); err != nil {
+			t.Error("Expected Default() to return a new store instance")
 		}
 	})
 }
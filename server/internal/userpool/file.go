@@ -0,0 +1,218 @@
+package userpool
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ErrInvalidClientsFile is returned when a clients file cannot be parsed or contains invalid client definitions.
+var ErrInvalidClientsFile = errors.New("invalid clients file")
+
+// clientsFile is the on-disk representation of the client definitions.
+// JSON documents are accepted as well since YAML is a superset of JSON.
+type clientsFile struct {
+	Clients []fileClient `yaml:"clients"`
+}
+
+// fileClient is a single client definition in a clients file.
+type fileClient struct {
+	ID            string   `yaml:"id"`
+	SecretHash    string   `yaml:"secret_hash"`
+	AllowedScopes []string `yaml:"allowed_scopes"`
+	DefaultScopes []string `yaml:"default_scopes"`
+	Audiences     []string `yaml:"audiences"`
+	TokenTTL      string   `yaml:"token_ttl"`
+	// Enabled defaults to true when omitted.
+	Enabled     *bool    `yaml:"enabled"`
+	AuthMethods []string `yaml:"auth_methods"`
+	PublicKey   string   `yaml:"public_key"`
+	// JWKS is written inline as a YAML or JSON object and stored as JSON.
+	JWKS                   map[string]interface{} `yaml:"jwks"`
+	TLSClientAuthSubjectDN string                 `yaml:"tls_client_auth_subject_dn"`
+	TLSClientAuthSANDNS    string                 `yaml:"tls_client_auth_san_dns"`
+	IntrospectAudiences    []string               `yaml:"introspect_audiences"`
+	// Claims are written inline as a YAML or JSON object.
+	Claims map[string]interface{} `yaml:"claims"`
+
+	IntrospectionEncryptedResponseAlg string `yaml:"introspection_encrypted_response_alg"`
+	IntrospectionEncryptedResponseEnc string `yaml:"introspection_encrypted_response_enc"`
+}
+
+// FileStore is a ClientStore backed by a YAML or JSON file, for example a
+// mounted Kubernetes Secret. The file is re-read by Watch and the set of
+// clients is swapped atomically, so requests in flight keep using the
+// snapshot they started with.
+//
+// Secret hashes upgraded on login only live in memory; they are replaced by
+// the hashes from the file on the next reload.
+type FileStore struct {
+	path    string
+	hasher  Hasher
+	current atomic.Pointer[MemoryStore]
+
+	mu     sync.Mutex
+	digest [sha256.Size]byte
+}
+
+// NewFileStore loads the clients defined in the file at path.
+func NewFileStore(path string, hasher Hasher) (*FileStore, error) {
+	store := &FileStore{path: path, hasher: hasher}
+	if _, err := store.Reload(); err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+// Lookup returns the client registered under clientID.
+func (s *FileStore) Lookup(ctx context.Context, clientID string) (Client, error) {
+	return s.current.Load().Lookup(ctx, clientID)
+}
+
+// VerifySecret authenticates clientID with secret and returns the client on success.
+func (s *FileStore) VerifySecret(ctx context.Context, clientID, secret string) (Client, error) {
+	return s.current.Load().VerifySecret(ctx, clientID, secret)
+}
+
+// Reload re-reads the clients file and swaps in the new client set if the
+// content changed. It reports whether a swap happened. On error the current
+// client set stays in place.
+func (s *FileStore) Reload() (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	content, err := os.ReadFile(filepath.Clean(s.path))
+	if err != nil {
+		return false, fmt.Errorf("failed to read clients file: %w", err)
+	}
+
+	digest := sha256.Sum256(content)
+	if s.current.Load() != nil && digest == s.digest {
+		return false, nil
+	}
+
+	clients, err := parseClientsFile(content)
+	if err != nil {
+		return false, err
+	}
+
+	s.current.Store(NewMemoryStore(s.hasher, clients...))
+	s.digest = digest
+	slog.Info("Loaded clients file", "path", s.path, "clients", len(clients))
+	return true, nil
+}
+
+// Watch polls the clients file every interval and reloads it on change until
+// ctx is cancelled. Polling the content rather than relying on file system
+// events keeps it working with the symlink swaps Kubernetes uses to update
+// mounted Secrets.
+func (s *FileStore) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := s.Reload(); err != nil {
+				slog.Error("Failed to reload clients file, keeping previous clients", "path", s.path, "error", err)
+			}
+		}
+	}
+}
+
+// parseClientsFile decodes and validates the client definitions in content.
+func parseClientsFile(content []byte) ([]Client, error) {
+	var file clientsFile
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&file); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidClientsFile, err)
+	}
+
+	clients := make([]Client, 0, len(file.Clients))
+	seen := make(map[string]bool, len(file.Clients))
+	for i, definition := range file.Clients {
+		client, err := definition.toClient()
+		if err != nil {
+			return nil, fmt.Errorf("%w: client %d: %v", ErrInvalidClientsFile, i, err)
+		}
+		if seen[client.ID] {
+			return nil, fmt.Errorf("%w: duplicate client id %q", ErrInvalidClientsFile, client.ID)
+		}
+		seen[client.ID] = true
+		clients = append(clients, client)
+	}
+	return clients, nil
+}
+
+// toClient validates the definition and converts it into a Client.
+func (f fileClient) toClient() (Client, error) {
+	if f.ID == "" {
+		return Client{}, errors.New("id is required")
+	}
+
+	var ttl time.Duration
+	if f.TokenTTL != "" {
+		var err error
+		ttl, err = time.ParseDuration(f.TokenTTL)
+		if err != nil || ttl < 0 {
+			return Client{}, fmt.Errorf("client %q: invalid token_ttl %q", f.ID, f.TokenTTL)
+		}
+	}
+
+	var jwks string
+	if f.JWKS != nil {
+		encoded, err := json.Marshal(f.JWKS)
+		if err != nil {
+			return Client{}, fmt.Errorf("client %q: invalid jwks: %v", f.ID, err)
+		}
+		jwks = string(encoded)
+	}
+
+	client := Client{
+		ID:            f.ID,
+		SecretHash:    f.SecretHash,
+		AllowedScopes: f.AllowedScopes,
+		DefaultScopes: f.DefaultScopes,
+		Audiences:     f.Audiences,
+		TokenTTL:      ttl,
+		Enabled:       f.Enabled == nil || *f.Enabled,
+		AuthMethods:   f.AuthMethods,
+		PublicKey:     f.PublicKey,
+		JWKS:          jwks,
+
+		TLSClientAuthSubjectDN: f.TLSClientAuthSubjectDN,
+		TLSClientAuthSANDNS:    f.TLSClientAuthSANDNS,
+		IntrospectAudiences:    f.IntrospectAudiences,
+		Claims:                 f.Claims,
+
+		IntrospectionEncryptedResponseAlg: f.IntrospectionEncryptedResponseAlg,
+		IntrospectionEncryptedResponseEnc: f.IntrospectionEncryptedResponseEnc,
+	}
+	if err := client.validateScopes(); err != nil {
+		return Client{}, fmt.Errorf("client %q: %w", f.ID, err)
+	}
+	if err := client.validateCredentials(); err != nil {
+		return Client{}, fmt.Errorf("client %q: %w", f.ID, err)
+	}
+	if err := client.validateClaims(); err != nil {
+		return Client{}, fmt.Errorf("client %q: %w", f.ID, err)
+	}
+	if err := client.validateIntrospectionEncryption(); err != nil {
+		return Client{}, fmt.Errorf("client %q: %w", f.ID, err)
+	}
+	return client, nil
+}
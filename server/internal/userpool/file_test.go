@@ -0,0 +1,303 @@
+package userpool
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"oauth2-task/internal/token"
+)
+
+// Compile-time check that FileStore implements ClientStore.
+var _ ClientStore = (*FileStore)(nil)
+
+// writeClientsFile writes content to a clients file in dir and returns its path.
+func writeClientsFile(t *testing.T, dir, name, content string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatalf("Failed to write clients file: %v", err)
+	}
+	return path
+}
+
+func TestNewFileStore(t *testing.T) {
+	hasher := testHasher()
+	hash := mustHash(t, hasher, "secret")
+
+	t.Run("loads YAML clients file", func(t *testing.T) {
+		path := writeClientsFile(t, t.TempDir(), "clients.yaml", fmt.Sprintf(`
+clients:
+  - id: service-a
+    secret_hash: %q
+    allowed_scopes: [read, write]
+    default_scopes: [read]
+    audiences: [https://api.example.com]
+    token_ttl: 30m
+    auth_methods: [client_secret_basic, client_secret_post, tls_client_auth]
+    tls_client_auth_san_dns: service-a.example.com
+    introspect_audiences: [https://api.example.com]
+    claims:
+      tenant: acme
+      roles: [admin, auditor]
+  - id: service-b
+    secret_hash: %q
+    enabled: false
+`, hash, hash))
+
+		store, err := NewFileStore(path, hasher)
+		if err != nil {
+			t.Fatalf("NewFileStore() error = %v", err)
+		}
+
+		got, err := store.Lookup(context.Background(), "service-a")
+		if err != nil {
+			t.Fatalf("Lookup() error = %v", err)
+		}
+		want := Client{
+			ID:            "service-a",
+			SecretHash:    hash,
+			AllowedScopes: []string{"read", "write"},
+			DefaultScopes: []string{"read"},
+			Audiences:     []string{"https://api.example.com"},
+			TokenTTL:      30 * time.Minute,
+			Enabled:       true,
+			AuthMethods:   []string{AuthMethodClientSecretBasic, AuthMethodClientSecretPost, AuthMethodTLSClientAuth},
+
+			TLSClientAuthSANDNS: "service-a.example.com",
+			IntrospectAudiences: []string{"https://api.example.com"},
+			Claims:              map[string]any{"tenant": "acme", "roles": []any{"admin", "auditor"}},
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("Lookup() = %+v, want %+v", got, want)
+		}
+
+		if _, err := store.VerifySecret(context.Background(), "service-a", "secret"); err != nil {
+			t.Errorf("VerifySecret() error = %v", err)
+		}
+		if _, err := store.VerifySecret(context.Background(), "service-b", "secret"); err != ErrClientDisabled {
+			t.Errorf("VerifySecret() error = %v, want %v", err, ErrClientDisabled)
+		}
+	})
+
+	t.Run("loads JSON clients file", func(t *testing.T) {
+		path := writeClientsFile(t, t.TempDir(), "clients.json", fmt.Sprintf(
+			`{"clients": [{"id": "service-a", "secret_hash": %q, "allowed_scopes": ["read"], "enabled": true}]}`, hash))
+
+		store, err := NewFileStore(path, hasher)
+		if err != nil {
+			t.Fatalf("NewFileStore() error = %v", err)
+		}
+		if _, err := store.VerifySecret(context.Background(), "service-a", "secret"); err != nil {
+			t.Errorf("VerifySecret() error = %v", err)
+		}
+	})
+
+	t.Run("loads private_key_jwt client with inline JWKS", func(t *testing.T) {
+		rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			t.Fatalf("Failed to generate RSA key: %v", err)
+		}
+		path := writeClientsFile(t, t.TempDir(), "clients.yaml", fmt.Sprintf(`
+clients:
+  - id: service-jwt
+    auth_methods: [private_key_jwt]
+    jwks: %s
+`, testJWKS(t, map[string]interface{}{"key-1": &rsaKey.PublicKey})))
+
+		store, err := NewFileStore(path, hasher)
+		if err != nil {
+			t.Fatalf("NewFileStore() error = %v", err)
+		}
+		client, err := store.Lookup(context.Background(), "service-jwt")
+		if err != nil {
+			t.Fatalf("Lookup() error = %v", err)
+		}
+		keys, err := client.AssertionKeys()
+		if err != nil {
+			t.Fatalf("AssertionKeys() error = %v", err)
+		}
+		if len(keys) != 1 || keys[0].KeyID != "key-1" || !rsaKey.PublicKey.Equal(keys[0].Key) {
+			t.Errorf("AssertionKeys() = %+v, want the registered key", keys)
+		}
+		if _, err := store.VerifySecret(context.Background(), "service-jwt", ""); err != ErrInvalidSecret {
+			t.Errorf("VerifySecret() error = %v, want %v", err, ErrInvalidSecret)
+		}
+	})
+
+	t.Run("loads client with encrypted introspection responses", func(t *testing.T) {
+		rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			t.Fatalf("Failed to generate RSA key: %v", err)
+		}
+		path := writeClientsFile(t, t.TempDir(), "clients.yaml", fmt.Sprintf(`
+clients:
+  - id: gateway
+    secret_hash: %q
+    jwks: %s
+    introspection_encrypted_response_alg: RSA-OAEP-256
+`, hash, testEncryptionJWKS(t, "enc-1", &rsaKey.PublicKey)))
+
+		store, err := NewFileStore(path, hasher)
+		if err != nil {
+			t.Fatalf("NewFileStore() error = %v", err)
+		}
+		client, err := store.Lookup(context.Background(), "gateway")
+		if err != nil {
+			t.Fatalf("Lookup() error = %v", err)
+		}
+		if client.IntrospectionEncryptedResponseAlg != token.KeyEncryptionRSAOAEP256 {
+			t.Errorf("IntrospectionEncryptedResponseAlg = %q, want %q", client.IntrospectionEncryptedResponseAlg, token.KeyEncryptionRSAOAEP256)
+		}
+		key, err := client.EncryptionKey()
+		if err != nil {
+			t.Fatalf("EncryptionKey() error = %v", err)
+		}
+		if key.KeyID != "enc-1" || !rsaKey.PublicKey.Equal(key.Key) {
+			t.Errorf("EncryptionKey() = %+v, want the registered key", key)
+		}
+	})
+
+	t.Run("missing file", func(t *testing.T) {
+		if _, err := NewFileStore(filepath.Join(t.TempDir(), "missing.yaml"), hasher); err == nil {
+			t.Error("Expected error for missing clients file")
+		}
+	})
+
+	invalidTests := []struct {
+		name    string
+		content string
+	}{
+		{name: "malformed YAML", content: "clients: ["},
+		{name: "unknown field", content: fmt.Sprintf("clients:\n  - id: a\n    secret_hash: %q\n    secret: plain\n", hash)},
+		{name: "missing id", content: fmt.Sprintf("clients:\n  - secret_hash: %q\n", hash)},
+		{name: "plaintext secret", content: "clients:\n  - id: a\n    secret_hash: test123\n"},
+		{name: "malformed hash", content: "clients:\n  - id: a\n    secret_hash: \"$argon2id$v=19$m=0,t=1,p=1$c2FsdA$aGFzaA\"\n"},
+		{name: "default scope not allowed", content: fmt.Sprintf("clients:\n  - id: a\n    secret_hash: %q\n    default_scopes: [read]\n", hash)},
+		{name: "invalid token TTL", content: fmt.Sprintf("clients:\n  - id: a\n    secret_hash: %q\n    token_ttl: soon\n", hash)},
+		{name: "negative token TTL", content: fmt.Sprintf("clients:\n  - id: a\n    secret_hash: %q\n    token_ttl: -1m\n", hash)},
+		{name: "unknown auth method", content: fmt.Sprintf("clients:\n  - id: a\n    secret_hash: %q\n    auth_methods: [private_key_jwt]\n", hash)},
+		{name: "private_key_jwt without keys", content: "clients:\n  - id: a\n    auth_methods: [private_key_jwt]\n"},
+		{name: "invalid public key", content: "clients:\n  - id: a\n    auth_methods: [private_key_jwt]\n    public_key: not-a-key\n"},
+		{name: "unsupported introspection encryption", content: fmt.Sprintf("clients:\n  - id: a\n    secret_hash: %q\n    introspection_encrypted_response_alg: RSA1_5\n", hash)},
+		{name: "introspection encryption without key", content: fmt.Sprintf("clients:\n  - id: a\n    secret_hash: %q\n    introspection_encrypted_response_alg: RSA-OAEP-256\n", hash)},
+		{name: "reserved claim", content: fmt.Sprintf("clients:\n  - id: a\n    secret_hash: %q\n    claims:\n      sub: admin\n", hash)},
+		{name: "duplicate client", content: fmt.Sprintf("clients:\n  - id: a\n    secret_hash: %q\n  - id: a\n    secret_hash: %q\n", hash, hash)},
+	}
+
+	for _, tt := range invalidTests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeClientsFile(t, t.TempDir(), "clients.yaml", tt.content)
+			if _, err := NewFileStore(path, hasher); !errors.Is(err, ErrInvalidClientsFile) {
+				t.Errorf("NewFileStore() error = %v, want %v", err, ErrInvalidClientsFile)
+			}
+		})
+	}
+}
+
+func TestFileStoreReload(t *testing.T) {
+	hasher := testHasher()
+	dir := t.TempDir()
+	path := writeClientsFile(t, dir, "clients.yaml", fmt.Sprintf("clients:\n  - id: old\n    secret_hash: %q\n", mustHash(t, hasher, "old-secret")))
+
+	store, err := NewFileStore(path, hasher)
+	if err != nil {
+		t.Fatalf("NewFileStore() error = %v", err)
+	}
+
+	t.Run("unchanged file is not swapped", func(t *testing.T) {
+		swapped, err := store.Reload()
+		if err != nil || swapped {
+			t.Errorf("Reload() = %v, %v; want false, nil", swapped, err)
+		}
+	})
+
+	t.Run("changed file is swapped", func(t *testing.T) {
+		snapshot := store.current.Load()
+		writeClientsFile(t, dir, "clients.yaml", fmt.Sprintf("clients:\n  - id: new\n    secret_hash: %q\n", mustHash(t, hasher, "new-secret")))
+
+		swapped, err := store.Reload()
+		if err != nil || !swapped {
+			t.Fatalf("Reload() = %v, %v; want true, nil", swapped, err)
+		}
+		if _, err := store.Lookup(context.Background(), "old"); err != ErrClientNotFound {
+			t.Errorf("Expected removed client to be gone, got %v", err)
+		}
+		if _, err := store.VerifySecret(context.Background(), "new", "new-secret"); err != nil {
+			t.Errorf("Expected added client to authenticate, got %v", err)
+		}
+		// Requests in flight keep the snapshot they started with
+		if _, err := snapshot.Lookup(context.Background(), "old"); err != nil {
+			t.Errorf("Expected previous snapshot to stay intact, got %v", err)
+		}
+	})
+
+	t.Run("invalid file keeps previous clients", func(t *testing.T) {
+		writeClientsFile(t, dir, "clients.yaml", "clients: [")
+
+		if _, err := store.Reload(); !errors.Is(err, ErrInvalidClientsFile) {
+			t.Errorf("Reload() error = %v, want %v", err, ErrInvalidClientsFile)
+		}
+		if _, err := store.Lookup(context.Background(), "new"); err != nil {
+			t.Errorf("Expected previous clients to remain, got %v", err)
+		}
+	})
+
+	t.Run("removed file keeps previous clients", func(t *testing.T) {
+		if err := os.Remove(path); err != nil {
+			t.Fatalf("Failed to remove clients file: %v", err)
+		}
+
+		if _, err := store.Reload(); err == nil {
+			t.Error("Expected error for removed clients file")
+		}
+		if _, err := store.Lookup(context.Background(), "new"); err != nil {
+			t.Errorf("Expected previous clients to remain, got %v", err)
+		}
+	})
+}
+
+func TestFileStoreWatch(t *testing.T) {
+	hasher := testHasher()
+	dir := t.TempDir()
+	path := writeClientsFile(t, dir, "clients.yaml", fmt.Sprintf("clients:\n  - id: old\n    secret_hash: %q\n", mustHash(t, hasher, "secret")))
+
+	store, err := NewFileStore(path, hasher)
+	if err != nil {
+		t.Fatalf("NewFileStore() error = %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		store.Watch(ctx, 10*time.Millisecond)
+		close(done)
+	}()
+
+	writeClientsFile(t, dir, "clients.yaml", fmt.Sprintf("clients:\n  - id: new\n    secret_hash: %q\n", mustHash(t, hasher, "secret")))
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if _, err := store.Lookup(context.Background(), "new"); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Expected watcher to pick up the changed clients file")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected watcher to stop after context cancellation")
+	}
+}
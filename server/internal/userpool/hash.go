@@ -0,0 +1,312 @@
+package userpool
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Supported secret hashing algorithms.
+const (
+	AlgorithmArgon2id = "argon2id"
+	AlgorithmBcrypt   = "bcrypt"
+)
+
+var (
+	// ErrUnsupportedHash is returned when a stored hash uses an unknown algorithm.
+	ErrUnsupportedHash = errors.New("unsupported secret hash format")
+	// ErrMalformedHash is returned when a stored hash cannot be decoded.
+	ErrMalformedHash = errors.New("malformed secret hash")
+	// ErrInvalidHasher is returned when a hasher specification cannot be parsed.
+	ErrInvalidHasher = errors.New("invalid secret hasher specification")
+)
+
+// dummyHash is verified against when a client does not exist so that unknown
+// client IDs take as long to reject as wrong secrets. It is the argon2id hash
+// of a random value with the default parameters.
+const dummyHash = "$argon2id$v=19$m=65536,t=3,p=4$5ekhkjA4F4qxcaPvT94alg$kBXqhVgfp0wgsakjpyuWf4LBZ0rHImIkBLaFrxS8/kQ"
+
+// Upper bounds of the argon2id cost parameters. Hashes and hasher specifications
+// above them are rejected, since every verification, including the dummy hash
+// verified for unknown clients, would allocate the memory and spend the passes.
+const (
+	// maxArgon2Memory is the largest accepted memory cost in KiB (1 GiB).
+	maxArgon2Memory = 1024 * 1024
+	// maxArgon2Iterations is the largest accepted number of passes.
+	maxArgon2Iterations = 32
+)
+
+// maxConcurrentArgon2 bounds the argon2id keys derived at the same time, so the
+// memory spent on hashing and verifying secrets stays below maxConcurrentArgon2
+// times the memory cost however many clients authenticate at once.
+const maxConcurrentArgon2 = 2
+
+var (
+	// argon2Slots holds a token for every argon2id key being derived.
+	argon2Slots = make(chan struct{}, maxConcurrentArgon2)
+	// argon2IDKey derives argon2id keys. Tests replace it to observe concurrency.
+	argon2IDKey = argon2.IDKey
+)
+
+// deriveArgon2idKey derives an argon2id key once fewer than maxConcurrentArgon2
+// other keys are being derived.
+func deriveArgon2idKey(secret, salt []byte, params Argon2Params) []byte {
+	argon2Slots <- struct{}{}
+	defer func() { <-argon2Slots }()
+	return argon2IDKey(secret, salt, params.Iterations, params.Memory, params.Parallelism, params.KeyLength)
+}
+
+// Argon2Params holds the cost parameters of argon2id as defined in RFC 9106.
+type Argon2Params struct {
+	// Memory is the memory cost in KiB.
+	Memory uint32
+	// Iterations is the number of passes over the memory.
+	Iterations uint32
+	// Parallelism is the number of lanes.
+	Parallelism uint8
+	// SaltLength is the length of the random salt in bytes.
+	SaltLength uint32
+	// KeyLength is the length of the derived key in bytes.
+	KeyLength uint32
+}
+
+// Hasher creates client secret hashes in PHC string format and decides
+// whether an existing hash has to be upgraded to the configured parameters.
+type Hasher struct {
+	// Algorithm is either AlgorithmArgon2id or AlgorithmBcrypt.
+	Algorithm string
+	// Argon2 holds the parameters used when Algorithm is AlgorithmArgon2id.
+	Argon2 Argon2Params
+	// BcryptCost is the cost used when Algorithm is AlgorithmBcrypt.
+	BcryptCost int
+}
+
+// DefaultHasher returns an argon2id hasher using the second recommended
+// option of RFC 9106 Section 4 (64 MiB, 3 passes, 4 lanes).
+func DefaultHasher() Hasher {
+	return Hasher{
+		Algorithm: AlgorithmArgon2id,
+		Argon2: Argon2Params{
+			Memory:      64 * 1024,
+			Iterations:  3,
+			Parallelism: 4,
+			SaltLength:  16,
+			KeyLength:   32,
+		},
+		BcryptCost: 12,
+	}
+}
+
+// ParseHasher parses a hasher specification of the form "<algorithm>" or
+// "<algorithm>$<param>=<value>,..." on top of the defaults, for example
+// "argon2id$m=65536,t=3,p=4" or "bcrypt$cost=12".
+func ParseHasher(spec string) (Hasher, error) {
+	hasher := DefaultHasher()
+	algorithm, params, _ := strings.Cut(spec, "$")
+	hasher.Algorithm = algorithm
+
+	values, err := parsePHCParams(params)
+	if err != nil {
+		return Hasher{}, fmt.Errorf("%w: %v", ErrInvalidHasher, err)
+	}
+
+	switch algorithm {
+	case AlgorithmArgon2id:
+		for key, value := range values {
+			switch key {
+			case "m":
+				hasher.Argon2.Memory = value
+			case "t":
+				hasher.Argon2.Iterations = value
+			case "p":
+				if value > 255 {
+					return Hasher{}, fmt.Errorf("%w: parallelism %d out of range", ErrInvalidHasher, value)
+				}
+				hasher.Argon2.Parallelism = uint8(value) // #nosec G115 -- range checked above
+			default:
+				return Hasher{}, fmt.Errorf("%w: unknown argon2id parameter %q", ErrInvalidHasher, key)
+			}
+		}
+		if hasher.Argon2.Memory == 0 || hasher.Argon2.Iterations == 0 || hasher.Argon2.Parallelism == 0 {
+			return Hasher{}, fmt.Errorf("%w: argon2id parameters must be positive", ErrInvalidHasher)
+		}
+		if hasher.Argon2.Memory > maxArgon2Memory || hasher.Argon2.Iterations > maxArgon2Iterations {
+			return Hasher{}, fmt.Errorf("%w: argon2id parameters exceed m=%d,t=%d", ErrInvalidHasher, maxArgon2Memory, maxArgon2Iterations)
+		}
+	case AlgorithmBcrypt:
+		for key, value := range values {
+			if key != "cost" {
+				return Hasher{}, fmt.Errorf("%w: unknown bcrypt parameter %q", ErrInvalidHasher, key)
+			}
+			hasher.BcryptCost = int(value)
+		}
+		if hasher.BcryptCost < bcrypt.MinCost || hasher.BcryptCost > bcrypt.MaxCost {
+			return Hasher{}, fmt.Errorf("%w: bcrypt cost %d out of range", ErrInvalidHasher, hasher.BcryptCost)
+		}
+	default:
+		return Hasher{}, fmt.Errorf("%w: unknown algorithm %q", ErrInvalidHasher, algorithm)
+	}
+
+	return hasher, nil
+}
+
+// Hash hashes the secret with the configured algorithm and parameters.
+func (h Hasher) Hash(secret string) (string, error) {
+	switch h.Algorithm {
+	case AlgorithmArgon2id:
+		salt := make([]byte, h.Argon2.SaltLength)
+		if _, err := rand.Read(salt); err != nil {
+			return "", err
+		}
+		key := deriveArgon2idKey([]byte(secret), salt, h.Argon2)
+		return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+			argon2.Version, h.Argon2.Memory, h.Argon2.Iterations, h.Argon2.Parallelism,
+			base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+	case AlgorithmBcrypt:
+		hash, err := bcrypt.GenerateFromPassword([]byte(secret), h.BcryptCost)
+		if err != nil {
+			return "", err
+		}
+		return string(hash), nil
+	default:
+		return "", fmt.Errorf("%w: unknown algorithm %q", ErrInvalidHasher, h.Algorithm)
+	}
+}
+
+// NeedsRehash reports whether the encoded hash was produced with a different
+// algorithm or different cost parameters than the hasher is configured with.
+func (h Hasher) NeedsRehash(encoded string) bool {
+	switch h.Algorithm {
+	case AlgorithmArgon2id:
+		hash, err := decodeArgon2id(encoded)
+		if err != nil {
+			return true
+		}
+		return hash.params.Memory != h.Argon2.Memory ||
+			hash.params.Iterations != h.Argon2.Iterations ||
+			hash.params.Parallelism != h.Argon2.Parallelism ||
+			hash.params.KeyLength != h.Argon2.KeyLength
+	case AlgorithmBcrypt:
+		cost, err := bcrypt.Cost([]byte(encoded))
+		return err != nil || cost != h.BcryptCost
+	default:
+		return false
+	}
+}
+
+// VerifyHash checks the secret against a PHC formatted argon2id hash or a
+// bcrypt hash. The comparison of derived keys runs in constant time. At most
+// maxConcurrentArgon2 argon2id verifications run at once, further ones wait.
+func VerifyHash(encoded, secret string) (bool, error) {
+	switch {
+	case strings.HasPrefix(encoded, "$argon2id$"):
+		hash, err := decodeArgon2id(encoded)
+		if err != nil {
+			return false, err
+		}
+		key := deriveArgon2idKey([]byte(secret), hash.salt, hash.params)
+		return subtle.ConstantTimeCompare(key, hash.key) == 1, nil
+	case strings.HasPrefix(encoded, "$2a$"), strings.HasPrefix(encoded, "$2b$"), strings.HasPrefix(encoded, "$2y$"):
+		err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(secret))
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return false, nil
+		}
+		if err != nil {
+			return false, fmt.Errorf("%w: %v", ErrMalformedHash, err)
+		}
+		return true, nil
+	default:
+		return false, ErrUnsupportedHash
+	}
+}
+
+// validateHash checks that encoded is a well-formed argon2id or bcrypt hash
+// without verifying any secret against it.
+func validateHash(encoded string) error {
+	switch {
+	case strings.HasPrefix(encoded, "$argon2id$"):
+		_, err := decodeArgon2id(encoded)
+		return err
+	case strings.HasPrefix(encoded, "$2a$"), strings.HasPrefix(encoded, "$2b$"), strings.HasPrefix(encoded, "$2y$"):
+		if _, err := bcrypt.Cost([]byte(encoded)); err != nil || len(encoded) != 60 {
+			return ErrMalformedHash
+		}
+		return nil
+	default:
+		return ErrUnsupportedHash
+	}
+}
+
+// argon2idHash is a decoded argon2id PHC string.
+type argon2idHash struct {
+	params Argon2Params
+	salt   []byte
+	key    []byte
+}
+
+// decodeArgon2id decodes "$argon2id$v=19$m=<m>,t=<t>,p=<p>$<salt>$<key>".
+func decodeArgon2id(encoded string) (argon2idHash, error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[1] != AlgorithmArgon2id {
+		return argon2idHash{}, ErrMalformedHash
+	}
+	if parts[2] != fmt.Sprintf("v=%d", argon2.Version) {
+		return argon2idHash{}, fmt.Errorf("%w: unsupported argon2 version %q", ErrMalformedHash, parts[2])
+	}
+
+	values, err := parsePHCParams(parts[3])
+	if err != nil || len(values) != 3 || values["m"] == 0 || values["t"] == 0 || values["p"] == 0 || values["p"] > 255 {
+		return argon2idHash{}, fmt.Errorf("%w: invalid argon2id parameters", ErrMalformedHash)
+	}
+	if values["m"] > maxArgon2Memory || values["t"] > maxArgon2Iterations {
+		return argon2idHash{}, fmt.Errorf("%w: argon2id parameters exceed m=%d,t=%d", ErrMalformedHash, maxArgon2Memory, maxArgon2Iterations)
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return argon2idHash{}, fmt.Errorf("%w: invalid salt encoding", ErrMalformedHash)
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return argon2idHash{}, fmt.Errorf("%w: invalid key encoding", ErrMalformedHash)
+	}
+
+	return argon2idHash{
+		params: Argon2Params{
+			Memory:      values["m"],
+			Iterations:  values["t"],
+			Parallelism: uint8(values["p"]), // #nosec G115 -- range checked above
+			SaltLength:  uint32(len(salt)),  // #nosec G115 -- bounded by the length of the encoded hash
+			KeyLength:   uint32(len(key)),   // #nosec G115 -- bounded by the length of the encoded hash
+		},
+		salt: salt,
+		key:  key,
+	}, nil
+}
+
+// parsePHCParams parses a comma separated list of name=value pairs with unsigned 32-bit integer values.
+func parsePHCParams(params string) (map[string]uint32, error) {
+	values := make(map[string]uint32)
+	if params == "" {
+		return values, nil
+	}
+	for _, param := range strings.Split(params, ",") {
+		key, value, found := strings.Cut(param, "=")
+		if !found || key == "" {
+			return nil, fmt.Errorf("malformed parameter %q", param)
+		}
+		n, err := strconv.ParseUint(value, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("malformed value for parameter %q", key)
+		}
+		values[key] = uint32(n) // #nosec G115 -- ParseUint limits n to 32 bits
+	}
+	return values, nil
+}
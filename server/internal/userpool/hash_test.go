@@ -0,0 +1,287 @@
+package userpool
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// testHasher returns an argon2id hasher with minimal cost parameters to keep tests fast.
+func testHasher() Hasher {
+	hasher := DefaultHasher()
+	hasher.Argon2.Memory = 1024
+	hasher.Argon2.Iterations = 1
+	hasher.Argon2.Parallelism = 1
+	hasher.BcryptCost = bcrypt.MinCost
+	return hasher
+}
+
+// mustHash hashes secret with hasher and fails the test on error.
+func mustHash(t *testing.T, hasher Hasher, secret string) string {
+	t.Helper()
+	hash, err := hasher.Hash(secret)
+	if err != nil {
+		t.Fatalf("Failed to hash secret: %v", err)
+	}
+	return hash
+}
+
+func TestParseHasher(t *testing.T) {
+	tests := []struct {
+		name    string
+		spec    string
+		check   func(t *testing.T, h Hasher)
+		wantErr bool
+	}{
+		{
+			name: "argon2id with defaults",
+			spec: "argon2id",
+			check: func(t *testing.T, h Hasher) {
+				if h.Argon2 != DefaultHasher().Argon2 {
+					t.Errorf("Expected default argon2id parameters, got %+v", h.Argon2)
+				}
+			},
+		},
+		{
+			name: "argon2id with parameters",
+			spec: "argon2id$m=19456,t=2,p=1",
+			check: func(t *testing.T, h Hasher) {
+				if h.Algorithm != AlgorithmArgon2id {
+					t.Errorf("Expected algorithm argon2id, got %s", h.Algorithm)
+				}
+				if h.Argon2.Memory != 19456 || h.Argon2.Iterations != 2 || h.Argon2.Parallelism != 1 {
+					t.Errorf("Unexpected argon2id parameters %+v", h.Argon2)
+				}
+			},
+		},
+		{
+			name: "bcrypt with cost",
+			spec: "bcrypt$cost=10",
+			check: func(t *testing.T, h Hasher) {
+				if h.Algorithm != AlgorithmBcrypt || h.BcryptCost != 10 {
+					t.Errorf("Unexpected bcrypt hasher %+v", h)
+				}
+			},
+		},
+		{name: "unknown algorithm", spec: "md5", wantErr: true},
+		{name: "empty specification", spec: "", wantErr: true},
+		{name: "unknown argon2id parameter", spec: "argon2id$x=1", wantErr: true},
+		{name: "zero argon2id parameter", spec: "argon2id$t=0", wantErr: true},
+		{name: "parallelism out of range", spec: "argon2id$p=256", wantErr: true},
+		{name: "memory above limit", spec: "argon2id$m=4294967295", wantErr: true},
+		{name: "iterations above limit", spec: "argon2id$t=33", wantErr: true},
+		{name: "malformed parameter", spec: "argon2id$m", wantErr: true},
+		{name: "non-numeric parameter", spec: "argon2id$m=lots", wantErr: true},
+		{name: "unknown bcrypt parameter", spec: "bcrypt$rounds=10", wantErr: true},
+		{name: "bcrypt cost too low", spec: "bcrypt$cost=3", wantErr: true},
+		{name: "bcrypt cost too high", spec: "bcrypt$cost=32", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseHasher(tt.spec)
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidHasher) {
+					t.Errorf("ParseHasher() error = %v, want %v", err, ErrInvalidHasher)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseHasher() unexpected error = %v", err)
+			}
+			tt.check(t, got)
+		})
+	}
+}
+
+func TestHasherHashAndVerify(t *testing.T) {
+	argon := testHasher()
+	bcryptHasher := testHasher()
+	bcryptHasher.Algorithm = AlgorithmBcrypt
+
+	for _, hasher := range []Hasher{argon, bcryptHasher} {
+		t.Run(hasher.Algorithm, func(t *testing.T) {
+			hash := mustHash(t, hasher, "s3cr3t")
+
+			if strings.Contains(hash, "s3cr3t") {
+				t.Fatal("Hash must not contain the plaintext secret")
+			}
+
+			ok, err := VerifyHash(hash, "s3cr3t")
+			if err != nil || !ok {
+				t.Errorf("VerifyHash() = %v, %v; want true, nil", ok, err)
+			}
+
+			ok, err = VerifyHash(hash, "wrong")
+			if err != nil || ok {
+				t.Errorf("VerifyHash() with wrong secret = %v, %v; want false, nil", ok, err)
+			}
+
+			if other := mustHash(t, hasher, "s3cr3t"); other == hash {
+				t.Error("Expected hashes of the same secret to use different salts")
+			}
+		})
+	}
+
+	t.Run("argon2id PHC format", func(t *testing.T) {
+		hash := mustHash(t, argon, "s3cr3t")
+		if !strings.HasPrefix(hash, "$argon2id$v=19$m=1024,t=1,p=1$") {
+			t.Errorf("Unexpected argon2id hash format: %s", hash)
+		}
+	})
+
+	t.Run("unknown algorithm", func(t *testing.T) {
+		if _, err := (Hasher{Algorithm: "md5"}).Hash("s3cr3t"); !errors.Is(err, ErrInvalidHasher) {
+			t.Errorf("Hash() error = %v, want %v", err, ErrInvalidHasher)
+		}
+	})
+}
+
+func TestVerifyHashMalformed(t *testing.T) {
+	tests := []struct {
+		name    string
+		encoded string
+		wantErr error
+	}{
+		{name: "plaintext", encoded: "test123", wantErr: ErrUnsupportedHash},
+		{name: "empty", encoded: "", wantErr: ErrUnsupportedHash},
+		{name: "unknown PHC algorithm", encoded: "$scrypt$ln=15,r=8,p=1$c2FsdA$aGFzaA", wantErr: ErrUnsupportedHash},
+		{name: "missing segments", encoded: "$argon2id$v=19$m=1024,t=1,p=1$c2FsdA", wantErr: ErrMalformedHash},
+		{name: "wrong version", encoded: "$argon2id$v=16$m=1024,t=1,p=1$c2FsdA$aGFzaA", wantErr: ErrMalformedHash},
+		{name: "missing parameter", encoded: "$argon2id$v=19$m=1024,t=1$c2FsdA$aGFzaA", wantErr: ErrMalformedHash},
+		{name: "zero parameter", encoded: "$argon2id$v=19$m=0,t=1,p=1$c2FsdA$aGFzaA", wantErr: ErrMalformedHash},
+		{name: "memory above limit", encoded: "$argon2id$v=19$m=4294967295,t=1,p=1$c2FsdA$aGFzaA", wantErr: ErrMalformedHash},
+		{name: "iterations above limit", encoded: "$argon2id$v=19$m=1024,t=4294967295,p=1$c2FsdA$aGFzaA", wantErr: ErrMalformedHash},
+		{name: "invalid salt", encoded: "$argon2id$v=19$m=1024,t=1,p=1$!!!$aGFzaA", wantErr: ErrMalformedHash},
+		{name: "invalid key", encoded: "$argon2id$v=19$m=1024,t=1,p=1$c2FsdA$!!!", wantErr: ErrMalformedHash},
+		{name: "truncated bcrypt", encoded: "$2a$10$abc", wantErr: ErrMalformedHash},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ok, err := VerifyHash(tt.encoded, "test123")
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("VerifyHash() error = %v, want %v", err, tt.wantErr)
+			}
+			if ok {
+				t.Error("VerifyHash() must not succeed for malformed hashes")
+			}
+		})
+	}
+}
+
+func TestHasherNeedsRehash(t *testing.T) {
+	argon := testHasher()
+	stronger := testHasher()
+	stronger.Argon2.Iterations = 2
+	bcryptHasher := testHasher()
+	bcryptHasher.Algorithm = AlgorithmBcrypt
+	bcryptStronger := bcryptHasher
+	bcryptStronger.BcryptCost = bcrypt.MinCost + 1
+
+	argonHash := mustHash(t, argon, "s3cr3t")
+	bcryptHash := mustHash(t, bcryptHasher, "s3cr3t")
+
+	tests := []struct {
+		name   string
+		hasher Hasher
+		hash   string
+		want   bool
+	}{
+		{name: "argon2id same parameters", hasher: argon, hash: argonHash, want: false},
+		{name: "argon2id changed parameters", hasher: stronger, hash: argonHash, want: true},
+		{name: "argon2id hasher with bcrypt hash", hasher: argon, hash: bcryptHash, want: true},
+		{name: "bcrypt same cost", hasher: bcryptHasher, hash: bcryptHash, want: false},
+		{name: "bcrypt changed cost", hasher: bcryptStronger, hash: bcryptHash, want: true},
+		{name: "bcrypt hasher with argon2id hash", hasher: bcryptHasher, hash: argonHash, want: true},
+		{name: "unconfigured hasher", hasher: Hasher{}, hash: argonHash, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.hasher.NeedsRehash(tt.hash); got != tt.want {
+				t.Errorf("NeedsRehash() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDummyHash(t *testing.T) {
+	if _, err := decodeArgon2id(dummyHash); err != nil {
+		t.Fatalf("dummyHash must be a valid argon2id hash: %v", err)
+	}
+	if DefaultHasher().NeedsRehash(dummyHash) {
+		t.Error("dummyHash must use the default parameters to match the timing of real hashes")
+	}
+}
+
+func TestVerifyHashBoundsConcurrency(t *testing.T) {
+	hash, err := testHasher().Hash("secret")
+	if err != nil {
+		t.Fatalf("Hash() error = %v", err)
+	}
+
+	var running, peak atomic.Int32
+	argon2IDKey = func(password, salt []byte, iterations, memory uint32, threads uint8, keyLen uint32) []byte {
+		n := running.Add(1)
+		defer running.Add(-1)
+		for {
+			current := peak.Load()
+			if n <= current || peak.CompareAndSwap(current, n) {
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+		return argon2.IDKey(password, salt, iterations, memory, threads, keyLen)
+	}
+	defer func() { argon2IDKey = argon2.IDKey }()
+
+	var wg sync.WaitGroup
+	for range 4 * maxConcurrentArgon2 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if ok, err := VerifyHash(hash, "secret"); !ok || err != nil {
+				t.Errorf("VerifyHash() = %v, %v; want true, nil", ok, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got := peak.Load(); got > maxConcurrentArgon2 {
+		t.Errorf("%d argon2id keys derived at once, want at most %d", got, maxConcurrentArgon2)
+	}
+}
+
+func TestValidateHash(t *testing.T) {
+	argon := testHasher()
+	bcryptHasher := testHasher()
+	bcryptHasher.Algorithm = AlgorithmBcrypt
+
+	tests := []struct {
+		name    string
+		encoded string
+		wantErr error
+	}{
+		{name: "argon2id", encoded: mustHash(t, argon, "s3cr3t")},
+		{name: "bcrypt", encoded: mustHash(t, bcryptHasher, "s3cr3t")},
+		{name: "plaintext", encoded: "s3cr3t", wantErr: ErrUnsupportedHash},
+		{name: "malformed argon2id", encoded: "$argon2id$v=19$m=1024$c2FsdA$aGFzaA", wantErr: ErrMalformedHash},
+		{name: "argon2id memory above limit", encoded: "$argon2id$v=19$m=2097152,t=1,p=1$c2FsdA$aGFzaA", wantErr: ErrMalformedHash},
+		{name: "truncated bcrypt", encoded: "$2a$10$abc", wantErr: ErrMalformedHash},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validateHash(tt.encoded); !errors.Is(err, tt.wantErr) {
+				t.Errorf("validateHash() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,11 @@
+-- Registered OAuth2 clients. Scope and audience lists are stored space-delimited
+-- like the OAuth2 scope parameter (RFC 6749 Section 3.3).
+CREATE TABLE clients (
+    id                TEXT PRIMARY KEY,
+    secret_hash       TEXT NOT NULL,
+    allowed_scopes    TEXT NOT NULL DEFAULT '',
+    default_scopes    TEXT NOT NULL DEFAULT '',
+    audiences         TEXT NOT NULL DEFAULT '',
+    token_ttl_seconds BIGINT NOT NULL DEFAULT 0,
+    enabled           BOOLEAN NOT NULL DEFAULT TRUE
+);
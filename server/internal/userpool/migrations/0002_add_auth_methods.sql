@@ -0,0 +1,3 @@
+-- Token endpoint authentication methods a client may use, space-delimited.
+-- Empty means client_secret_basic only.
+ALTER TABLE clients ADD COLUMN auth_methods TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,4 @@
+-- Public keys used to verify private_key_jwt client assertions (RFC 7523):
+-- a PEM encoded public key and/or a JSON Web Key Set document.
+ALTER TABLE clients ADD COLUMN public_key TEXT NOT NULL DEFAULT '';
+ALTER TABLE clients ADD COLUMN jwks TEXT NOT NULL DEFAULT '';
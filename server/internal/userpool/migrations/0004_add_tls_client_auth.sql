@@ -0,0 +1,4 @@
+-- Expected client certificate subject for tls_client_auth (RFC 8705 Section 2.1.2).
+-- Exactly one of the columns is set for clients using the method.
+ALTER TABLE clients ADD COLUMN tls_client_auth_subject_dn TEXT NOT NULL DEFAULT '';
+ALTER TABLE clients ADD COLUMN tls_client_auth_san_dns TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,3 @@
+-- Static claims added to the access tokens issued to the client (RFC 9068 Section 2.2.3),
+-- stored as a JSON object.
+ALTER TABLE clients ADD COLUMN claims TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,3 @@
+-- Audiences of the tokens of other clients the client may introspect (RFC 7662 Section 4),
+-- stored space-delimited like the audiences column.
+ALTER TABLE clients ADD COLUMN introspect_audiences TEXT NOT NULL DEFAULT '';
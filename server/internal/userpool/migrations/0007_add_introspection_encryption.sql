@@ -0,0 +1,4 @@
+-- JWE algorithms of encrypted JWT introspection responses to the client (RFC 9701 Section 6).
+-- Empty alg leaves responses unencrypted.
+ALTER TABLE clients ADD COLUMN introspection_encrypted_response_alg TEXT NOT NULL DEFAULT '';
+ALTER TABLE clients ADD COLUMN introspection_encrypted_response_enc TEXT NOT NULL DEFAULT '';
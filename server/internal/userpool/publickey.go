@@ -0,0 +1,224 @@
+package userpool
+
+import (
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// ErrInvalidPublicKey is returned when a client's registered public key or JWKS cannot be parsed.
+var ErrInvalidPublicKey = errors.New("invalid client public key")
+
+// ErrNoEncryptionKey is returned when a client's JWKS has no RSA key for encryption.
+var ErrNoEncryptionKey = errors.New("no RSA encryption key in client jwks")
+
+// AssertionKey is a public key registered by a client to verify its signed
+// client assertions (private_key_jwt, RFC 7523) or its self-signed TLS client
+// certificate (self_signed_tls_client_auth, RFC 8705).
+type AssertionKey struct {
+	// KeyID is the "kid" of the key in the client's JWKS. Keys registered as PEM have no key ID.
+	KeyID string
+	// Key is an *rsa.PublicKey, *ecdsa.PublicKey or ed25519.PublicKey.
+	Key crypto.PublicKey
+}
+
+// jwk is the subset of a JSON Web Key (RFC 7517) needed to verify client assertions
+// and encrypt responses to the client.
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// AssertionKeys returns the public keys registered for the client from its PublicKey and JWKS.
+func (c Client) AssertionKeys() ([]AssertionKey, error) {
+	return parseAssertionKeys(c.PublicKey, c.JWKS)
+}
+
+// EncryptionKey is an RSA public key registered by a client in its JWKS to
+// encrypt responses to the client, such as JWT introspection responses (RFC 9701).
+type EncryptionKey struct {
+	// KeyID is the "kid" of the key in the client's JWKS.
+	KeyID string
+	Key   *rsa.PublicKey
+}
+
+// EncryptionKey returns the first RSA key of the client's JWKS published for
+// encryption ("use": "enc"). Keys without a use are reserved for signatures.
+func (c Client) EncryptionKey() (EncryptionKey, error) {
+	if c.JWKS == "" {
+		return EncryptionKey{}, ErrNoEncryptionKey
+	}
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal([]byte(c.JWKS), &set); err != nil {
+		return EncryptionKey{}, fmt.Errorf("%w: %v", ErrInvalidPublicKey, err)
+	}
+	for i, k := range set.Keys {
+		if k.Use != "enc" || k.Kty != "RSA" {
+			continue
+		}
+		key, err := k.publicKey()
+		if err != nil {
+			return EncryptionKey{}, fmt.Errorf("%w: key %d: %v", ErrInvalidPublicKey, i, err)
+		}
+		return EncryptionKey{KeyID: k.Kid, Key: key.(*rsa.PublicKey)}, nil
+	}
+	return EncryptionKey{}, ErrNoEncryptionKey
+}
+
+// parseAssertionKeys parses a PEM encoded public key and a JSON Web Key Set. Either may be empty.
+func parseAssertionKeys(pemKey, jwks string) ([]AssertionKey, error) {
+	var keys []AssertionKey
+
+	if pemKey != "" {
+		key, err := parsePEMPublicKey(pemKey)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, AssertionKey{Key: key})
+	}
+
+	if jwks != "" {
+		var set struct {
+			Keys []jwk `json:"keys"`
+		}
+		if err := json.Unmarshal([]byte(jwks), &set); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidPublicKey, err)
+		}
+		for i, k := range set.Keys {
+			// Keys published for encryption cannot verify signatures
+			if k.Use != "" && k.Use != "sig" {
+				continue
+			}
+			key, err := k.publicKey()
+			if err != nil {
+				return nil, fmt.Errorf("%w: key %d: %v", ErrInvalidPublicKey, i, err)
+			}
+			keys = append(keys, AssertionKey{KeyID: k.Kid, Key: key})
+		}
+	}
+
+	return keys, nil
+}
+
+// parsePEMPublicKey parses a PKIX ("PUBLIC KEY") or PKCS#1 ("RSA PUBLIC KEY") encoded public key.
+func parsePEMPublicKey(encoded string) (crypto.PublicKey, error) {
+	block, _ := pem.Decode([]byte(encoded))
+	if block == nil {
+		return nil, fmt.Errorf("%w: no PEM block found", ErrInvalidPublicKey)
+	}
+
+	switch block.Type {
+	case "PUBLIC KEY":
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidPublicKey, err)
+		}
+		switch key.(type) {
+		case *rsa.PublicKey, *ecdsa.PublicKey, ed25519.PublicKey:
+			return key, nil
+		default:
+			return nil, fmt.Errorf("%w: unsupported key type %T", ErrInvalidPublicKey, key)
+		}
+	case "RSA PUBLIC KEY":
+		key, err := x509.ParsePKCS1PublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidPublicKey, err)
+		}
+		return key, nil
+	default:
+		return nil, fmt.Errorf("%w: unsupported PEM block type %q", ErrInvalidPublicKey, block.Type)
+	}
+}
+
+// publicKey converts the JWK into a public key.
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("invalid modulus: %w", err)
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil || !e.IsInt64() || e.Int64() < 3 || e.Int64() > 1<<31-1 {
+			return nil, errors.New("invalid exponent")
+		}
+		if n.BitLen() < 2048 {
+			return nil, fmt.Errorf("RSA key too small: %d bits", n.BitLen())
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+
+	case "EC":
+		var curve elliptic.Curve
+		var ecdhCurve ecdh.Curve
+		switch k.Crv {
+		case "P-256":
+			curve, ecdhCurve = elliptic.P256(), ecdh.P256()
+		case "P-384":
+			curve, ecdhCurve = elliptic.P384(), ecdh.P384()
+		case "P-521":
+			curve, ecdhCurve = elliptic.P521(), ecdh.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, fmt.Errorf("invalid x coordinate: %w", err)
+		}
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, fmt.Errorf("invalid y coordinate: %w", err)
+		}
+		size := (curve.Params().BitSize + 7) / 8
+		if len(x) != size || len(y) != size {
+			return nil, errors.New("invalid coordinate length")
+		}
+		// crypto/ecdh rejects points that are not on the curve
+		if _, err := ecdhCurve.NewPublicKey(append(append([]byte{4}, x...), y...)); err != nil {
+			return nil, fmt.Errorf("invalid point: %w", err)
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid Ed25519 public key")
+		}
+		return ed25519.PublicKey(x), nil
+
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+	}
+}
+
+// decodeBigInt decodes a base64url encoded unsigned big-endian integer.
+func decodeBigInt(encoded string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) == 0 {
+		return nil, errors.New("empty value")
+	}
+	return new(big.Int).SetBytes(b), nil
+}
@@ -0,0 +1,390 @@
+package userpool
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	// Register the Postgres driver as "pgx".
+	_ "github.com/jackc/pgx/v5/stdlib"
+	// Register the pure Go SQLite driver as "sqlite".
+	_ "modernc.org/sqlite"
+)
+
+// Supported SQL drivers.
+const (
+	DriverSQLite   = "sqlite"
+	DriverPostgres = "postgres"
+)
+
+// ErrUnsupportedDriver is returned when an unknown SQL driver is configured.
+var ErrUnsupportedDriver = errors.New("unsupported SQL driver")
+
+//go:embed migrations/*.sql
+var migrations embed.FS
+
+// migrationLockID is the Postgres advisory lock key taken while migrating so
+// that replicas starting at the same time do not race on the schema.
+const migrationLockID = 727_001
+
+// SQLConfig configures the database connection of a SQLStore.
+type SQLConfig struct {
+	// Driver is either DriverSQLite or DriverPostgres.
+	Driver string
+	// DSN is the driver specific data source name, e.g. a file path for SQLite
+	// or a postgres:// URL for Postgres.
+	DSN string
+	// MaxOpenConns limits the number of open connections. Zero means unlimited.
+	MaxOpenConns int
+	// MaxIdleConns limits the number of idle connections kept in the pool.
+	MaxIdleConns int
+	// ConnMaxLifetime closes connections after they have been open this long. Zero means no limit.
+	ConnMaxLifetime time.Duration
+	// ConnMaxIdleTime closes connections after they have been idle this long. Zero means no limit.
+	ConnMaxIdleTime time.Duration
+}
+
+// SQLStore is a ClientStore backed by a SQL database. SQLite is supported as
+// a zero-dependency default and Postgres for shared, persistent deployments.
+type SQLStore struct {
+	db       *sql.DB
+	postgres bool
+	hasher   Hasher
+}
+
+// OpenSQLStore connects to the database described by cfg, applies the
+// connection pool settings and runs all pending schema migrations.
+func OpenSQLStore(ctx context.Context, cfg SQLConfig, hasher Hasher) (*SQLStore, error) {
+	var driverName string
+	switch cfg.Driver {
+	case DriverSQLite:
+		driverName = "sqlite"
+	case DriverPostgres:
+		driverName = "pgx"
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnsupportedDriver, cfg.Driver)
+	}
+
+	db, err := sql.Open(driverName, cfg.DSN)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open client database: %w", err)
+	}
+	db.SetMaxOpenConns(cfg.MaxOpenConns)
+	db.SetMaxIdleConns(cfg.MaxIdleConns)
+	db.SetConnMaxLifetime(cfg.ConnMaxLifetime)
+	db.SetConnMaxIdleTime(cfg.ConnMaxIdleTime)
+
+	store := &SQLStore{db: db, postgres: cfg.Driver == DriverPostgres, hasher: hasher}
+	if err := db.PingContext(ctx); err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("failed to connect to client database: %w", err)
+	}
+	if err := store.Migrate(ctx); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	return store, nil
+}
+
+// Close closes the underlying database connection pool.
+func (s *SQLStore) Close() error {
+	return s.db.Close()
+}
+
+// Migrate applies all embedded migrations that have not been applied yet.
+// Each migration runs in its own transaction and is recorded in schema_migrations.
+func (s *SQLStore) Migrate(ctx context.Context) error {
+	if err := s.createMigrationsTable(ctx); err != nil {
+		return fmt.Errorf("failed to create schema_migrations table: %w", err)
+	}
+
+	names, err := fs.Glob(migrations, "migrations/*.sql")
+	if err != nil {
+		return err
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		version, err := migrationVersion(name)
+		if err != nil {
+			return err
+		}
+		if err := s.applyMigration(ctx, name, version); err != nil {
+			return fmt.Errorf("failed to apply migration %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// createMigrationsTable creates the schema_migrations table under the migration
+// lock. Concurrent CREATE TABLE IF NOT EXISTS statements of replicas starting
+// together can otherwise fail in Postgres with a unique violation on pg_type.
+func (s *SQLStore) createMigrationsTable(ctx context.Context) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if err := s.lockMigrations(ctx, tx); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		applied_at TIMESTAMP NOT NULL
+	)`); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// lockMigrations serializes migrations of replicas sharing a Postgres database
+// until tx ends. SQLite transactions are serialized by the database file lock.
+func (s *SQLStore) lockMigrations(ctx context.Context, tx *sql.Tx) error {
+	if !s.postgres {
+		return nil
+	}
+	_, err := tx.ExecContext(ctx, "SELECT pg_advisory_xact_lock($1)", migrationLockID)
+	return err
+}
+
+// applyMigration runs the migration file name unless version was already applied.
+func (s *SQLStore) applyMigration(ctx context.Context, name string, version int) error {
+	statements, err := migrations.ReadFile(name)
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if err := s.lockMigrations(ctx, tx); err != nil {
+		return err
+	}
+
+	var applied int
+	if err := tx.QueryRowContext(ctx, s.rebind("SELECT COUNT(*) FROM schema_migrations WHERE version = ?"), version).Scan(&applied); err != nil {
+		return err
+	}
+	if applied > 0 {
+		return nil
+	}
+
+	if _, err := tx.ExecContext(ctx, string(statements)); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, s.rebind("INSERT INTO schema_migrations (version, applied_at) VALUES (?, ?)"), version, time.Now().UTC()); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	slog.Info("Applied client database migration", "migration", name)
+	return nil
+}
+
+// migrationVersion extracts the numeric prefix of a migration file name such as "migrations/0001_create_clients.sql".
+func migrationVersion(name string) (int, error) {
+	base := strings.TrimPrefix(name, "migrations/")
+	prefix, _, found := strings.Cut(base, "_")
+	version, err := strconv.Atoi(prefix)
+	if !found || err != nil {
+		return 0, fmt.Errorf("invalid migration file name %q", name)
+	}
+	return version, nil
+}
+
+// Lookup returns the client registered under clientID.
+func (s *SQLStore) Lookup(ctx context.Context, clientID string) (Client, error) {
+	var (
+		client                                                                          Client
+		allowedScopes, defaultScopes, audience, authMethods, introspectAudience, claims string
+		ttlSeconds                                                                      int64
+	)
+	err := s.db.QueryRowContext(ctx, s.rebind(`SELECT id, secret_hash, allowed_scopes, default_scopes, audiences, token_ttl_seconds, enabled, auth_methods, public_key, jwks,
+			tls_client_auth_subject_dn, tls_client_auth_san_dns, introspect_audiences, claims,
+			introspection_encrypted_response_alg, introspection_encrypted_response_enc
+		FROM clients WHERE id = ?`), clientID).
+		Scan(&client.ID, &client.SecretHash, &allowedScopes, &defaultScopes, &audience, &ttlSeconds, &client.Enabled, &authMethods,
+			&client.PublicKey, &client.JWKS, &client.TLSClientAuthSubjectDN, &client.TLSClientAuthSANDNS, &introspectAudience, &claims,
+			&client.IntrospectionEncryptedResponseAlg, &client.IntrospectionEncryptedResponseEnc)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Client{}, ErrClientNotFound
+	}
+	if err != nil {
+		return Client{}, fmt.Errorf("failed to look up client: %w", err)
+	}
+
+	client.AllowedScopes = splitList(allowedScopes)
+	client.DefaultScopes = splitList(defaultScopes)
+	client.Audiences = splitList(audience)
+	client.TokenTTL = time.Duration(ttlSeconds) * time.Second
+	client.AuthMethods = splitList(authMethods)
+	client.IntrospectAudiences = splitList(introspectAudience)
+	if claims != "" {
+		if err := json.Unmarshal([]byte(claims), &client.Claims); err != nil {
+			return Client{}, fmt.Errorf("failed to decode claims of client %q: %w", clientID, err)
+		}
+	}
+	return client, nil
+}
+
+// VerifySecret authenticates clientID with secret and returns the client on success.
+// Outdated secret hashes are replaced in the database after a successful verification.
+func (s *SQLStore) VerifySecret(ctx context.Context, clientID, secret string) (Client, error) {
+	client, err := s.Lookup(ctx, clientID)
+	if errors.Is(err, ErrClientNotFound) {
+		_, _ = VerifyHash(dummyHash, secret)
+		return Client{}, err
+	}
+	if err != nil {
+		return Client{}, err
+	}
+	if client.SecretHash == "" {
+		// Clients without a secret authenticate with private_key_jwt only
+		_, _ = VerifyHash(dummyHash, secret)
+		return Client{}, ErrInvalidSecret
+	}
+
+	ok, err := VerifyHash(client.SecretHash, secret)
+	if err != nil {
+		return Client{}, err
+	}
+	if !ok {
+		return Client{}, ErrInvalidSecret
+	}
+	if !client.Enabled {
+		return Client{}, ErrClientDisabled
+	}
+
+	s.rehash(ctx, client, secret)
+	return client, nil
+}
+
+// rehash replaces the stored secret hash of client if it does not match the
+// configured hasher parameters. Failures are logged and leave the old hash in place.
+func (s *SQLStore) rehash(ctx context.Context, client Client, secret string) {
+	if !s.hasher.NeedsRehash(client.SecretHash) {
+		return
+	}
+
+	hash, err := s.hasher.Hash(secret)
+	if err != nil {
+		slog.Error("Failed to rehash client secret", "client_id", client.ID, "error", err)
+		return
+	}
+
+	// Only replace the hash we verified against; it may have been rotated meanwhile.
+	if _, err := s.db.ExecContext(ctx, s.rebind("UPDATE clients SET secret_hash = ? WHERE id = ? AND secret_hash = ?"),
+		hash, client.ID, client.SecretHash); err != nil {
+		slog.Error("Failed to store rehashed client secret", "client_id", client.ID, "error", err)
+		return
+	}
+	slog.Info("Rehashed client secret", "client_id", client.ID, "algorithm", s.hasher.Algorithm)
+}
+
+// SaveClient inserts the client or replaces an existing client with the same ID.
+func (s *SQLStore) SaveClient(ctx context.Context, client Client) error {
+	if client.ID == "" {
+		return errors.New("client id is required")
+	}
+	if err := client.validateScopes(); err != nil {
+		return fmt.Errorf("client %q: %w", client.ID, err)
+	}
+	if err := client.validateCredentials(); err != nil {
+		return fmt.Errorf("client %q: %w", client.ID, err)
+	}
+	if err := client.validateClaims(); err != nil {
+		return fmt.Errorf("client %q: %w", client.ID, err)
+	}
+	if err := client.validateIntrospectionEncryption(); err != nil {
+		return fmt.Errorf("client %q: %w", client.ID, err)
+	}
+
+	var claims string
+	if len(client.Claims) > 0 {
+		encoded, err := json.Marshal(client.Claims)
+		if err != nil {
+			return fmt.Errorf("client %q: invalid claims: %w", client.ID, err)
+		}
+		claims = string(encoded)
+	}
+
+	_, err := s.db.ExecContext(ctx, s.rebind(`INSERT INTO clients (id, secret_hash, allowed_scopes, default_scopes, audiences, token_ttl_seconds, enabled, auth_methods, public_key, jwks,
+			tls_client_auth_subject_dn, tls_client_auth_san_dns, introspect_audiences, claims,
+			introspection_encrypted_response_alg, introspection_encrypted_response_enc)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET
+			secret_hash = excluded.secret_hash,
+			allowed_scopes = excluded.allowed_scopes,
+			default_scopes = excluded.default_scopes,
+			audiences = excluded.audiences,
+			token_ttl_seconds = excluded.token_ttl_seconds,
+			enabled = excluded.enabled,
+			auth_methods = excluded.auth_methods,
+			public_key = excluded.public_key,
+			jwks = excluded.jwks,
+			tls_client_auth_subject_dn = excluded.tls_client_auth_subject_dn,
+			tls_client_auth_san_dns = excluded.tls_client_auth_san_dns,
+			introspect_audiences = excluded.introspect_audiences,
+			claims = excluded.claims,
+			introspection_encrypted_response_alg = excluded.introspection_encrypted_response_alg,
+			introspection_encrypted_response_enc = excluded.introspection_encrypted_response_enc`),
+		client.ID, client.SecretHash,
+		strings.Join(client.AllowedScopes, " "), strings.Join(client.DefaultScopes, " "), strings.Join(client.Audiences, " "),
+		int64(client.TokenTTL/time.Second), client.Enabled, strings.Join(client.AuthMethods, " "),
+		client.PublicKey, client.JWKS, client.TLSClientAuthSubjectDN, client.TLSClientAuthSANDNS,
+		strings.Join(client.IntrospectAudiences, " "), claims,
+		client.IntrospectionEncryptedResponseAlg, client.IntrospectionEncryptedResponseEnc)
+	if err != nil {
+		return fmt.Errorf("failed to save client: %w", err)
+	}
+	return nil
+}
+
+// DeleteClient removes the client registered under clientID.
+func (s *SQLStore) DeleteClient(ctx context.Context, clientID string) error {
+	result, err := s.db.ExecContext(ctx, s.rebind("DELETE FROM clients WHERE id = ?"), clientID)
+	if err != nil {
+		return fmt.Errorf("failed to delete client: %w", err)
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return ErrClientNotFound
+	}
+	return nil
+}
+
+// rebind rewrites ? placeholders into the $n form expected by Postgres.
+func (s *SQLStore) rebind(query string) string {
+	if !s.postgres {
+		return query
+	}
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// splitList splits a space-delimited list into its elements.
+func splitList(list string) []string {
+	return strings.Fields(list)
+}
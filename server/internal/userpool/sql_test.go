@@ -0,0 +1,390 @@
+package userpool
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"oauth2-task/internal/token"
+)
+
+// Compile-time check that SQLStore implements ClientStore.
+var _ ClientStore = (*SQLStore)(nil)
+
+// openTestSQLStore opens a SQLStore on a fresh SQLite database in a temporary directory.
+// Setting POSTGRES_TEST_DSN runs the tests against Postgres instead.
+func openTestSQLStore(t *testing.T, hasher Hasher) *SQLStore {
+	t.Helper()
+	cfg := SQLConfig{Driver: DriverSQLite, DSN: filepath.Join(t.TempDir(), "clients.db")}
+	if dsn := os.Getenv("POSTGRES_TEST_DSN"); dsn != "" {
+		cfg = SQLConfig{Driver: DriverPostgres, DSN: dsn}
+	}
+
+	store, err := OpenSQLStore(context.Background(), cfg, hasher)
+	if err != nil {
+		t.Fatalf("OpenSQLStore() error = %v", err)
+	}
+	t.Cleanup(func() {
+		if cfg.Driver == DriverPostgres {
+			_, _ = store.db.Exec("DELETE FROM clients")
+		}
+		_ = store.Close()
+	})
+	return store
+}
+
+func TestOpenSQLStore(t *testing.T) {
+	t.Run("unsupported driver", func(t *testing.T) {
+		_, err := OpenSQLStore(context.Background(), SQLConfig{Driver: "mysql"}, testHasher())
+		if !errors.Is(err, ErrUnsupportedDriver) {
+			t.Errorf("OpenSQLStore() error = %v, want %v", err, ErrUnsupportedDriver)
+		}
+	})
+
+	t.Run("migrations are applied once", func(t *testing.T) {
+		store := openTestSQLStore(t, testHasher())
+
+		if err := store.Migrate(context.Background()); err != nil {
+			t.Fatalf("Migrate() second run error = %v", err)
+		}
+
+		names, err := fs.Glob(migrations, "migrations/*.sql")
+		if err != nil {
+			t.Fatalf("Failed to list migrations: %v", err)
+		}
+		var applied int
+		if err := store.db.QueryRow("SELECT COUNT(*) FROM schema_migrations").Scan(&applied); err != nil {
+			t.Fatalf("Failed to count migrations: %v", err)
+		}
+		if applied != len(names) {
+			t.Errorf("Expected %d applied migrations, got %d", len(names), applied)
+		}
+	})
+
+	t.Run("concurrent replicas migrate a fresh Postgres schema", func(t *testing.T) {
+		dsn := os.Getenv("POSTGRES_TEST_DSN")
+		if dsn == "" {
+			t.Skip("POSTGRES_TEST_DSN not set")
+		}
+		admin, err := sql.Open("pgx", dsn)
+		if err != nil {
+			t.Fatalf("Failed to open Postgres: %v", err)
+		}
+		defer admin.Close()
+		schema := fmt.Sprintf("migrate_test_%d", time.Now().UnixNano())
+		if _, err := admin.Exec("CREATE SCHEMA " + schema); err != nil {
+			t.Fatalf("Failed to create schema: %v", err)
+		}
+		t.Cleanup(func() { _, _ = admin.Exec("DROP SCHEMA " + schema + " CASCADE") })
+
+		separator := "?"
+		if strings.Contains(dsn, "?") {
+			separator = "&"
+		}
+		cfg := SQLConfig{Driver: DriverPostgres, DSN: dsn + separator + "search_path=" + schema}
+
+		const replicas = 8
+		errs := make(chan error, replicas)
+		for range replicas {
+			go func() {
+				store, err := OpenSQLStore(context.Background(), cfg, testHasher())
+				if err == nil {
+					err = store.Close()
+				}
+				errs <- err
+			}()
+		}
+		for range replicas {
+			if err := <-errs; err != nil {
+				t.Errorf("OpenSQLStore() error = %v", err)
+			}
+		}
+	})
+
+	t.Run("clients survive reopening", func(t *testing.T) {
+		hasher := testHasher()
+		cfg := SQLConfig{Driver: DriverSQLite, DSN: filepath.Join(t.TempDir(), "clients.db"), MaxOpenConns: 1}
+
+		store, err := OpenSQLStore(context.Background(), cfg, hasher)
+		if err != nil {
+			t.Fatalf("OpenSQLStore() error = %v", err)
+		}
+		if err := store.SaveClient(context.Background(), Client{ID: "a", SecretHash: mustHash(t, hasher, "secret"), Enabled: true}); err != nil {
+			t.Fatalf("SaveClient() error = %v", err)
+		}
+		_ = store.Close()
+
+		reopened, err := OpenSQLStore(context.Background(), cfg, hasher)
+		if err != nil {
+			t.Fatalf("OpenSQLStore() reopen error = %v", err)
+		}
+		defer reopened.Close()
+		if _, err := reopened.VerifySecret(context.Background(), "a", "secret"); err != nil {
+			t.Errorf("VerifySecret() after reopen error = %v", err)
+		}
+	})
+}
+
+func TestSQLStoreLookup(t *testing.T) {
+	hasher := testHasher()
+	store := openTestSQLStore(t, hasher)
+	ctx := context.Background()
+
+	want := Client{
+		ID:            "service-a",
+		SecretHash:    mustHash(t, hasher, "secret"),
+		AllowedScopes: []string{"read", "write"},
+		DefaultScopes: []string{"read"},
+		Audiences:     []string{"https://api.example.com"},
+		TokenTTL:      30 * time.Minute,
+		Enabled:       true,
+		AuthMethods:   []string{AuthMethodClientSecretPost, AuthMethodTLSClientAuth},
+
+		TLSClientAuthSubjectDN: "CN=service-a,O=Example",
+		IntrospectAudiences:    []string{"https://api.example.com"},
+		Claims:                 map[string]any{"tenant": "acme", "roles": []any{"admin", "auditor"}},
+	}
+	if err := store.SaveClient(ctx, want); err != nil {
+		t.Fatalf("SaveClient() error = %v", err)
+	}
+
+	got, err := store.Lookup(ctx, "service-a")
+	if err != nil {
+		t.Fatalf("Lookup() error = %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Lookup() = %+v, want %+v", got, want)
+	}
+
+	t.Run("update replaces client", func(t *testing.T) {
+		updated := want
+		updated.AllowedScopes = []string{"read"}
+		updated.Enabled = false
+		if err := store.SaveClient(ctx, updated); err != nil {
+			t.Fatalf("SaveClient() error = %v", err)
+		}
+		got, err := store.Lookup(ctx, "service-a")
+		if err != nil {
+			t.Fatalf("Lookup() error = %v", err)
+		}
+		if !reflect.DeepEqual(got, updated) {
+			t.Errorf("Lookup() = %+v, want %+v", got, updated)
+		}
+	})
+
+	t.Run("private_key_jwt client without secret", func(t *testing.T) {
+		rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			t.Fatalf("Failed to generate RSA key: %v", err)
+		}
+		client := Client{
+			ID:          "service-jwt",
+			Enabled:     true,
+			AuthMethods: []string{AuthMethodPrivateKeyJWT},
+			JWKS:        testJWKS(t, map[string]interface{}{"key-1": &rsaKey.PublicKey}),
+		}
+		if err := store.SaveClient(ctx, client); err != nil {
+			t.Fatalf("SaveClient() error = %v", err)
+		}
+		got, err := store.Lookup(ctx, "service-jwt")
+		if err != nil {
+			t.Fatalf("Lookup() error = %v", err)
+		}
+		if got.JWKS != client.JWKS {
+			t.Errorf("Lookup() JWKS = %q, want %q", got.JWKS, client.JWKS)
+		}
+		if _, err := store.VerifySecret(ctx, "service-jwt", ""); err != ErrInvalidSecret {
+			t.Errorf("VerifySecret() error = %v, want %v", err, ErrInvalidSecret)
+		}
+	})
+
+	t.Run("encrypted introspection responses", func(t *testing.T) {
+		rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			t.Fatalf("Failed to generate RSA key: %v", err)
+		}
+		client := Client{
+			ID:         "gateway",
+			SecretHash: mustHash(t, hasher, "secret"),
+			Enabled:    true,
+			JWKS:       testEncryptionJWKS(t, "enc-1", &rsaKey.PublicKey),
+
+			IntrospectionEncryptedResponseAlg: token.KeyEncryptionRSAOAEP256,
+			IntrospectionEncryptedResponseEnc: token.ContentEncryptionA256GCM,
+		}
+		if err := store.SaveClient(ctx, client); err != nil {
+			t.Fatalf("SaveClient() error = %v", err)
+		}
+		got, err := store.Lookup(ctx, "gateway")
+		if err != nil {
+			t.Fatalf("Lookup() error = %v", err)
+		}
+		if got.IntrospectionEncryptedResponseAlg != client.IntrospectionEncryptedResponseAlg ||
+			got.IntrospectionEncryptedResponseEnc != client.IntrospectionEncryptedResponseEnc {
+			t.Errorf("Lookup() encryption = %q/%q, want %q/%q", got.IntrospectionEncryptedResponseAlg, got.IntrospectionEncryptedResponseEnc,
+				client.IntrospectionEncryptedResponseAlg, client.IntrospectionEncryptedResponseEnc)
+		}
+	})
+
+	t.Run("unknown client", func(t *testing.T) {
+		if _, err := store.Lookup(ctx, "unknown"); err != ErrClientNotFound {
+			t.Errorf("Lookup() error = %v, want %v", err, ErrClientNotFound)
+		}
+	})
+
+	t.Run("delete client", func(t *testing.T) {
+		if err := store.DeleteClient(ctx, "service-a"); err != nil {
+			t.Fatalf("DeleteClient() error = %v", err)
+		}
+		if _, err := store.Lookup(ctx, "service-a"); err != ErrClientNotFound {
+			t.Errorf("Lookup() after delete error = %v, want %v", err, ErrClientNotFound)
+		}
+		if err := store.DeleteClient(ctx, "service-a"); err != ErrClientNotFound {
+			t.Errorf("DeleteClient() twice error = %v, want %v", err, ErrClientNotFound)
+		}
+	})
+}
+
+func TestSQLStoreSaveClientValidation(t *testing.T) {
+	store := openTestSQLStore(t, testHasher())
+
+	tests := []struct {
+		name    string
+		client  Client
+		wantErr error
+	}{
+		{name: "missing id", client: Client{SecretHash: mustHash(t, testHasher(), "secret")}},
+		{name: "plaintext secret", client: Client{ID: "a", SecretHash: "secret"}, wantErr: ErrUnsupportedHash},
+		{name: "unknown auth method", client: Client{ID: "a", SecretHash: mustHash(t, testHasher(), "secret"), AuthMethods: []string{"none"}}},
+		{name: "reserved claim", client: Client{ID: "a", SecretHash: mustHash(t, testHasher(), "secret"), Claims: map[string]any{"scope": "admin"}}},
+		{name: "default scope not allowed", client: Client{ID: "a", SecretHash: mustHash(t, testHasher(), "secret"), AllowedScopes: []string{"read"}, DefaultScopes: []string{"write"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := store.SaveClient(context.Background(), tt.client)
+			if err == nil {
+				t.Fatal("Expected SaveClient() to fail")
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("SaveClient() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSQLStoreVerifySecret(t *testing.T) {
+	hasher := testHasher()
+	store := openTestSQLStore(t, hasher)
+	ctx := context.Background()
+
+	for _, client := range []Client{
+		{ID: "enabled", SecretHash: mustHash(t, hasher, "secret"), Enabled: true},
+		{ID: "disabled", SecretHash: mustHash(t, hasher, "secret"), Enabled: false},
+	} {
+		if err := store.SaveClient(ctx, client); err != nil {
+			t.Fatalf("SaveClient() error = %v", err)
+		}
+	}
+
+	tests := []struct {
+		name     string
+		clientID string
+		secret   string
+		wantErr  error
+	}{
+		{name: "valid secret", clientID: "enabled", secret: "secret"},
+		{name: "wrong secret", clientID: "enabled", secret: "wrong", wantErr: ErrInvalidSecret},
+		{name: "unknown client", clientID: "unknown", secret: "secret", wantErr: ErrClientNotFound},
+		{name: "disabled client", clientID: "disabled", secret: "secret", wantErr: ErrClientDisabled},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, err := store.VerifySecret(ctx, tt.clientID, tt.secret)
+			if err != tt.wantErr {
+				t.Fatalf("VerifySecret() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr == nil && client.ID != tt.clientID {
+				t.Errorf("VerifySecret() client = %q, want %q", client.ID, tt.clientID)
+			}
+		})
+	}
+}
+
+func TestSQLStoreRehash(t *testing.T) {
+	oldHasher := testHasher()
+	oldHasher.Algorithm = AlgorithmBcrypt
+	store := openTestSQLStore(t, testHasher())
+	ctx := context.Background()
+
+	oldHash := mustHash(t, oldHasher, "secret")
+	if err := store.SaveClient(ctx, Client{ID: "a", SecretHash: oldHash, Enabled: true}); err != nil {
+		t.Fatalf("SaveClient() error = %v", err)
+	}
+
+	if _, err := store.VerifySecret(ctx, "a", "secret"); err != nil {
+		t.Fatalf("VerifySecret() error = %v", err)
+	}
+
+	client, err := store.Lookup(ctx, "a")
+	if err != nil {
+		t.Fatalf("Lookup() error = %v", err)
+	}
+	if client.SecretHash == oldHash {
+		t.Fatal("Expected secret hash to be upgraded after login")
+	}
+	if store.hasher.NeedsRehash(client.SecretHash) {
+		t.Errorf("Expected upgraded hash to use the configured hasher, got %s", client.SecretHash)
+	}
+	if _, err := store.VerifySecret(ctx, "a", "secret"); err != nil {
+		t.Errorf("VerifySecret() with upgraded hash error = %v", err)
+	}
+}
+
+func TestSQLStoreRebind(t *testing.T) {
+	query := "SELECT * FROM clients WHERE id = ? AND secret_hash = ?"
+
+	if got := (&SQLStore{}).rebind(query); got != query {
+		t.Errorf("rebind() for SQLite = %q, want unchanged", got)
+	}
+	want := "SELECT * FROM clients WHERE id = $1 AND secret_hash = $2"
+	if got := (&SQLStore{postgres: true}).rebind(query); got != want {
+		t.Errorf("rebind() for Postgres = %q, want %q", got, want)
+	}
+}
+
+func TestMigrationVersion(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    int
+		wantErr bool
+	}{
+		{name: "migrations/0001_create_clients.sql", want: 1},
+		{name: "migrations/0012_add_column.sql", want: 12},
+		{name: "migrations/create_clients.sql", wantErr: true},
+		{name: "migrations/0001.sql", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := migrationVersion(tt.name)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("migrationVersion() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("migrationVersion() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
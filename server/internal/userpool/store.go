@@ -0,0 +1,121 @@
+package userpool
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+)
+
+var (
+	// ErrClientNotFound is returned when no client is registered under the given client ID.
+	ErrClientNotFound = errors.New("client not found")
+	// ErrInvalidSecret is returned when the presented client secret does not match the stored one.
+	ErrInvalidSecret = errors.New("invalid client secret")
+	// ErrClientDisabled is returned when a disabled client attempts to authenticate.
+	ErrClientDisabled = errors.New("client disabled")
+)
+
+// ClientStore provides access to registered OAuth2 clients.
+// Implementations must be safe for concurrent use. Errors other than
+// ErrClientNotFound, ErrInvalidSecret and ErrClientDisabled are treated as
+// backend failures.
+type ClientStore interface {
+	// Lookup returns the client registered under clientID.
+	Lookup(ctx context.Context, clientID string) (Client, error)
+	// VerifySecret authenticates clientID with secret and returns the client on success.
+	VerifySecret(ctx context.Context, clientID, secret string) (Client, error)
+}
+
+// MemoryStore is an in-memory ClientStore backed by a map keyed by client ID.
+// It is intended for development and testing purposes.
+type MemoryStore struct {
+	mu      sync.RWMutex
+	clients map[string]Client
+	hasher  Hasher
+}
+
+// NewMemoryStore creates a MemoryStore holding the given clients.
+// The hasher defines the parameters stored secret hashes are upgraded to
+// after a successful authentication.
+func NewMemoryStore(hasher Hasher, clients ...Client) *MemoryStore {
+	store := &MemoryStore{
+		clients: make(map[string]Client, len(clients)),
+		hasher:  hasher,
+	}
+	for _, client := range clients {
+		store.clients[client.ID] = client
+	}
+	return store
+}
+
+// Lookup returns the client registered under clientID.
+func (s *MemoryStore) Lookup(ctx context.Context, clientID string) (Client, error) {
+	if err := ctx.Err(); err != nil {
+		return Client{}, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	client, exists := s.clients[clientID]
+	if !exists {
+		return Client{}, ErrClientNotFound
+	}
+	return client, nil
+}
+
+// VerifySecret authenticates clientID with secret and returns the client on success.
+// Unknown clients are verified against a dummy hash so they cannot be told apart
+// from wrong secrets by timing. Hashes created with outdated parameters are
+// transparently replaced after a successful verification.
+func (s *MemoryStore) VerifySecret(ctx context.Context, clientID, secret string) (Client, error) {
+	client, err := s.Lookup(ctx, clientID)
+	if errors.Is(err, ErrClientNotFound) {
+		_, _ = VerifyHash(dummyHash, secret)
+		return Client{}, err
+	}
+	if err != nil {
+		return Client{}, err
+	}
+	if client.SecretHash == "" {
+		// Clients without a secret authenticate with private_key_jwt only
+		_, _ = VerifyHash(dummyHash, secret)
+		return Client{}, ErrInvalidSecret
+	}
+
+	ok, err := VerifyHash(client.SecretHash, secret)
+	if err != nil {
+		return Client{}, err
+	}
+	if !ok {
+		return Client{}, ErrInvalidSecret
+	}
+	if !client.Enabled {
+		return Client{}, ErrClientDisabled
+	}
+
+	s.rehash(client, secret)
+	return client, nil
+}
+
+// rehash replaces the stored secret hash of client if it does not match the
+// configured hasher parameters. Failures are logged and leave the old hash in place.
+func (s *MemoryStore) rehash(client Client, secret string) {
+	if !s.hasher.NeedsRehash(client.SecretHash) {
+		return
+	}
+
+	hash, err := s.hasher.Hash(secret)
+	if err != nil {
+		slog.Error("Failed to rehash client secret", "client_id", client.ID, "error", err)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// Only replace the hash we verified against; it may have been rotated meanwhile.
+	if stored, exists := s.clients[client.ID]; exists && stored.SecretHash == client.SecretHash {
+		stored.SecretHash = hash
+		s.clients[client.ID] = stored
+		slog.Info("Rehashed client secret", "client_id", client.ID, "algorithm", s.hasher.Algorithm)
+	}
+}
@@ -0,0 +1,157 @@
+package userpool
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+// Compile-time check that MemoryStore implements ClientStore.
+var _ ClientStore = (*MemoryStore)(nil)
+
+func TestMemoryStore(t *testing.T) {
+	hasher := testHasher()
+	store := NewMemoryStore(hasher,
+		Client{ID: "client-a", SecretHash: mustHash(t, hasher, "secret-a"), Enabled: true, AllowedScopes: []string{"read"}},
+		Client{ID: "client-b", SecretHash: mustHash(t, hasher, "secret-b"), Enabled: true},
+		Client{ID: "client-disabled", SecretHash: mustHash(t, hasher, "secret-d")},
+		Client{ID: "client-keys-only", Enabled: true, AuthMethods: []string{AuthMethodPrivateKeyJWT}},
+	)
+
+	t.Run("Lookup", func(t *testing.T) {
+		tests := []struct {
+			name     string
+			clientID string
+			wantErr  error
+		}{
+			{name: "Registered client", clientID: "client-a"},
+			{name: "Second registered client", clientID: "client-b"},
+			{name: "Unknown client", clientID: "client-c", wantErr: ErrClientNotFound},
+			{name: "Empty client ID", clientID: "", wantErr: ErrClientNotFound},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				client, err := store.Lookup(context.Background(), tt.clientID)
+				if err != tt.wantErr {
+					t.Fatalf("Lookup() error = %v, wantErr %v", err, tt.wantErr)
+				}
+				if err == nil && client.ID != tt.clientID {
+					t.Errorf("Lookup() client = %v, want %v", client.ID, tt.clientID)
+				}
+			})
+		}
+	})
+
+	t.Run("VerifySecret", func(t *testing.T) {
+		tests := []struct {
+			name     string
+			clientID string
+			secret   string
+			wantErr  error
+		}{
+			{name: "Valid secret", clientID: "client-a", secret: "secret-a"},
+			{name: "Wrong secret", clientID: "client-a", secret: "secret-b", wantErr: ErrInvalidSecret},
+			{name: "Empty secret", clientID: "client-a", secret: "", wantErr: ErrInvalidSecret},
+			{name: "Unknown client", clientID: "client-c", secret: "secret-a", wantErr: ErrClientNotFound},
+			{name: "Disabled client", clientID: "client-disabled", secret: "secret-d", wantErr: ErrClientDisabled},
+			{name: "Client without secret", clientID: "client-keys-only", secret: "", wantErr: ErrInvalidSecret},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				client, err := store.VerifySecret(context.Background(), tt.clientID, tt.secret)
+				if err != tt.wantErr {
+					t.Fatalf("VerifySecret() error = %v, wantErr %v", err, tt.wantErr)
+				}
+				if err == nil && client.ID != tt.clientID {
+					t.Errorf("VerifySecret() client = %v, want %v", client.ID, tt.clientID)
+				}
+			})
+		}
+	})
+
+	t.Run("honours cancelled context", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		if _, err := store.Lookup(ctx, "client-a"); !errors.Is(err, context.Canceled) {
+			t.Errorf("Lookup() error = %v, want %v", err, context.Canceled)
+		}
+		if _, err := store.VerifySecret(ctx, "client-a", "secret-a"); !errors.Is(err, context.Canceled) {
+			t.Errorf("VerifySecret() error = %v, want %v", err, context.Canceled)
+		}
+	})
+
+	t.Run("empty store", func(t *testing.T) {
+		if _, err := NewMemoryStore(testHasher()).Lookup(context.Background(), "client-a"); err != ErrClientNotFound {
+			t.Errorf("Lookup() error = %v, want %v", err, ErrClientNotFound)
+		}
+	})
+}
+
+func TestMemoryStoreRehash(t *testing.T) {
+	oldHasher := testHasher()
+	oldHasher.Algorithm = AlgorithmBcrypt
+	newHasher := testHasher()
+
+	t.Run("upgrades outdated hash after successful login", func(t *testing.T) {
+		oldHash := mustHash(t, oldHasher, "secret")
+		store := NewMemoryStore(newHasher, Client{ID: "client", SecretHash: oldHash, Enabled: true})
+
+		if _, err := store.VerifySecret(context.Background(), "client", "secret"); err != nil {
+			t.Fatalf("VerifySecret() error = %v", err)
+		}
+
+		client, err := store.Lookup(context.Background(), "client")
+		if err != nil {
+			t.Fatalf("Lookup() error = %v", err)
+		}
+		if client.SecretHash == oldHash {
+			t.Fatal("Expected secret hash to be upgraded")
+		}
+		if newHasher.NeedsRehash(client.SecretHash) {
+			t.Errorf("Expected upgraded hash to match hasher parameters, got %s", client.SecretHash)
+		}
+		if _, err := store.VerifySecret(context.Background(), "client", "secret"); err != nil {
+			t.Errorf("Expected secret to verify against upgraded hash, got %v", err)
+		}
+	})
+
+	t.Run("keeps hash after failed login", func(t *testing.T) {
+		oldHash := mustHash(t, oldHasher, "secret")
+		store := NewMemoryStore(newHasher, Client{ID: "client", SecretHash: oldHash, Enabled: true})
+
+		if _, err := store.VerifySecret(context.Background(), "client", "wrong"); err != ErrInvalidSecret {
+			t.Fatalf("VerifySecret() error = %v, want %v", err, ErrInvalidSecret)
+		}
+
+		client, _ := store.Lookup(context.Background(), "client")
+		if client.SecretHash != oldHash {
+			t.Error("Expected secret hash to remain unchanged after failed login")
+		}
+	})
+
+	t.Run("keeps hash with current parameters", func(t *testing.T) {
+		hash := mustHash(t, newHasher, "secret")
+		store := NewMemoryStore(newHasher, Client{ID: "client", SecretHash: hash, Enabled: true})
+
+		if _, err := store.VerifySecret(context.Background(), "client", "secret"); err != nil {
+			t.Fatalf("VerifySecret() error = %v", err)
+		}
+
+		client, _ := store.Lookup(context.Background(), "client")
+		if client.SecretHash != hash {
+			t.Error("Expected secret hash with current parameters to remain unchanged")
+		}
+	})
+}
+
+func TestMemoryStoreMalformedHash(t *testing.T) {
+	store := NewMemoryStore(testHasher(), Client{ID: "client", SecretHash: "plaintext"})
+
+	_, err := store.VerifySecret(context.Background(), "client", "plaintext")
+	if !errors.Is(err, ErrUnsupportedHash) {
+		t.Errorf("VerifySecret() error = %v, want %v", err, ErrUnsupportedHash)
+	}
+}
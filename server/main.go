@@ -1,49 +1,444 @@
 // Package main implements an OAuth2 server that supports the Client Credentials Grant flow.
-// It provides JWT token issuance, token introspection, and JWKS endpoints.
+// It provides JWT token issuance, token revocation, token introspection, and JWKS endpoints.
 package main
 
 import (
-	"encoding/pem"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
 	"log/slog"
 	"net/http"
 	"oauth2-task/internal/auth"
+	"oauth2-task/internal/hsm"
+	"oauth2-task/internal/revocation"
+	"oauth2-task/internal/tlsconfig"
 	"oauth2-task/internal/token"
 	"oauth2-task/internal/userpool"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"time"
 )
 
 var (
-	keyPair  token.KeyPair
-	userPool map[string]string
+	keySource         token.KeySource
+	userPool          userpool.ClientStore
+	assertionVerifier *auth.AssertionVerifier
+	tokenLifetime     auth.TokenLifetime
+	revocations       revocation.Store
+	tlsConfig         *tls.Config
+	clientCAs         *x509.CertPool
 )
 
 func setup() {
-	// Get key content from environment variable
-	keyContent := os.Getenv("JWT_SIGNATURE_KEY")
-	if keyContent == "" {
-		slog.Error("Mandatory JWT_SIGNATURE_KEY environment variable is not set")
+	var err error
+	tokenLifetime, err = newTokenLifetime()
+	if err != nil {
+		slog.Error("Failed to set up token lifetime", "error", err)
 		os.Exit(1)
 	}
 
-	// Parse the PEM block
-	block, _ := pem.Decode([]byte(keyContent))
-	if block == nil {
-		slog.Error("Failed to decode PEM block from JWT_SIGNATURE_KEY")
+	keySource, err = newKeySource(tokenLifetime)
+	if err != nil {
+		slog.Error("Failed to set up signing keys", "error", err)
 		os.Exit(1)
 	}
 
-	// Load the private key
-	var err error
-	keyPair, err = token.ParsePrivateKey(block.Bytes)
+	// Configure the parameters client secret hashes are upgraded to on login
+	hasher := userpool.DefaultHasher()
+	if spec := os.Getenv("CLIENT_SECRET_HASHER"); spec != "" {
+		hasher, err = userpool.ParseHasher(spec)
+		if err != nil {
+			slog.Error("Failed to parse CLIENT_SECRET_HASHER", "error", err)
+			os.Exit(1)
+		}
+	}
+
+	userPool, err = newClientStore(hasher)
+	if err != nil {
+		slog.Error("Failed to set up client store", "error", err)
+		os.Exit(1)
+	}
+
+	// Client assertions (private_key_jwt) must be issued for the public URL of the token endpoint
+	tokenEndpointURL := os.Getenv("TOKEN_ENDPOINT_URL")
+	if tokenEndpointURL == "" {
+		tokenEndpointURL = "http://localhost:8080/token"
+	}
+	assertionVerifier = auth.NewAssertionVerifier(tokenEndpointURL)
+
+	revocations, err = newRevocationStore()
+	if err != nil {
+		slog.Error("Failed to set up revocation store", "error", err)
+		os.Exit(1)
+	}
+
+	tlsConfig, clientCAs, err = newTLSConfig()
 	if err != nil {
-		slog.Error("Failed to parse private key", "error", err)
+		slog.Error("Failed to set up TLS", "error", err)
 		os.Exit(1)
 	}
-	slog.Info("Private key loaded successfully from environment variable")
+}
+
+// newTokenLifetime reads the default and maximum access token lifetime from
+// ACCESS_TOKEN_TTL and ACCESS_TOKEN_MAX_TTL. The maximum caps the token TTL
+// overrides of clients; zero disables the cap.
+func newTokenLifetime() (auth.TokenLifetime, error) {
+	var (
+		lifetime auth.TokenLifetime
+		err      error
+	)
+	if lifetime.Default, err = durationEnv("ACCESS_TOKEN_TTL", token.DefaultTokenLifetime); err != nil {
+		return auth.TokenLifetime{}, err
+	}
+	if lifetime.Max, err = durationEnv("ACCESS_TOKEN_MAX_TTL", 24*time.Hour); err != nil {
+		return auth.TokenLifetime{}, err
+	}
+	if lifetime.Default == 0 {
+		return auth.TokenLifetime{}, errors.New("ACCESS_TOKEN_TTL must be positive")
+	}
+	if lifetime.Max > 0 && lifetime.Default > lifetime.Max {
+		return auth.TokenLifetime{}, fmt.Errorf("ACCESS_TOKEN_TTL %s exceeds ACCESS_TOKEN_MAX_TTL %s", lifetime.Default, lifetime.Max)
+	}
+	return lifetime, nil
+}
+
+// newRevocationStore creates the store of revoked tokens. Revocations are kept
+// in memory unless REVOCATION_DB_DRIVER selects a SQL database.
+func newRevocationStore() (revocation.Store, error) {
+	driver := os.Getenv("REVOCATION_DB_DRIVER")
+	if driver == "" {
+		slog.Info("Using in-memory revocation store")
+		return revocation.NewMemoryStore(), nil
+	}
+
+	dsn := os.Getenv("REVOCATION_DB_DSN")
+	if dsn == "" && driver == revocation.DriverSQLite {
+		dsn = "revocations.db"
+	}
+	if dsn == "" {
+		return nil, errors.New("REVOCATION_DB_DSN is required")
+	}
+	store, err := revocation.OpenSQLStore(context.Background(), driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+	slog.Info("Using SQL revocation store", "driver", driver)
+	return store, nil
+}
+
+// newTLSConfig creates the TLS configuration of the server from the TLS_*
+// environment variables. It returns a nil config if TLS is not configured.
+// The certificate is reloaded every TLS_CERT_POLL_INTERVAL so rotated
+// certificates are served without a restart.
+func newTLSConfig() (*tls.Config, *x509.CertPool, error) {
+	certFile := os.Getenv("TLS_CERT_FILE")
+	keyFile := os.Getenv("TLS_KEY_FILE")
+	caFile := os.Getenv("TLS_CLIENT_CA_FILE")
+	if certFile == "" && keyFile == "" {
+		if caFile != "" {
+			return nil, nil, errors.New("TLS_CLIENT_CA_FILE requires TLS_CERT_FILE and TLS_KEY_FILE")
+		}
+		return nil, nil, nil
+	}
+	if certFile == "" || keyFile == "" {
+		return nil, nil, errors.New("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	}
 
-	// Initialize user pool with default test users
-	userPool = userpool.Default()
+	var cfg tlsconfig.Config
+	var err error
+	if version := os.Getenv("TLS_MIN_VERSION"); version != "" {
+		if cfg.MinVersion, err = tlsconfig.ParseMinVersion(version); err != nil {
+			return nil, nil, err
+		}
+	}
+	if suites := os.Getenv("TLS_CIPHER_SUITES"); suites != "" {
+		if cfg.CipherSuites, err = tlsconfig.ParseCipherSuites(suites); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	pollInterval, err := durationEnv("TLS_CERT_POLL_INTERVAL", time.Minute)
+	if err != nil || pollInterval <= 0 {
+		return nil, nil, fmt.Errorf("invalid TLS_CERT_POLL_INTERVAL %q", os.Getenv("TLS_CERT_POLL_INTERVAL"))
+	}
+
+	reloader, err := tlsconfig.NewCertificateReloader(certFile, keyFile)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load server certificate: %w", err)
+	}
+	go reloader.Watch(context.Background(), pollInterval)
+
+	var roots *x509.CertPool
+	if caFile != "" {
+		if roots, err = tlsconfig.LoadClientCAs(caFile); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return tlsconfig.ServerConfig(cfg, reloader), roots, nil
+}
+
+// newKeySource loads the signing keys from the key directory in
+// JWT_SIGNATURE_KEY_DIR, which is watched for rotations, or from the signing
+// key together with the previous keys in JWT_VERIFICATION_KEYS. The signing
+// key stays on the PKCS#11 token in PKCS11_MODULE, or is read from
+// JWT_SIGNATURE_KEY_FILE or JWT_SIGNATURE_KEY.
+// RSA keys sign with the algorithm in JWT_RSA_ALGORITHM, RS256 by default.
+// Keys of the key directory stay published for the maximum token lifetime.
+func newKeySource(lifetime auth.TokenLifetime) (token.KeySource, error) {
+	keyDir := os.Getenv("JWT_SIGNATURE_KEY_DIR")
+	keyFile := os.Getenv("JWT_SIGNATURE_KEY_FILE")
+	keyContent := os.Getenv("JWT_SIGNATURE_KEY")
+	module := os.Getenv("PKCS11_MODULE")
+	configured := 0
+	for _, value := range []string{keyDir, keyFile, keyContent, module} {
+		if value != "" {
+			configured++
+		}
+	}
+	if configured > 1 {
+		return nil, errors.New("JWT_SIGNATURE_KEY_DIR, JWT_SIGNATURE_KEY_FILE, JWT_SIGNATURE_KEY and PKCS11_MODULE are mutually exclusive")
+	}
+	if keyDir != "" {
+		return newKeyDirectory(keyDir, lifetime)
+	}
+	if configured == 0 {
+		return nil, errors.New("mandatory JWT_SIGNATURE_KEY_FILE, JWT_SIGNATURE_KEY_DIR, JWT_SIGNATURE_KEY or PKCS11_MODULE environment variable is not set")
+	}
+
+	var keyPair token.KeyPair
+	var err error
+	if module != "" {
+		keyPair, err = openHSMKey(module)
+	} else {
+		keyPair, err = loadSigningKey(keyFile, keyContent)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Previous signing keys stay published and verify tokens until they expire
+	previousKeys, err := token.ParseVerificationKeys([]byte(os.Getenv("JWT_VERIFICATION_KEYS")))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse JWT_VERIFICATION_KEYS: %w", err)
+	}
+	keySet, err := token.NewKeySet(keyPair, previousKeys...)
+	if err != nil {
+		return nil, err
+	}
+	if keySet, err = keySet.WithRSAAlgorithm(os.Getenv("JWT_RSA_ALGORITHM")); err != nil {
+		return nil, fmt.Errorf("invalid JWT_RSA_ALGORITHM: %w", err)
+	}
+	signingKeyID, _ := keySet.SigningKey()
+	slog.Info("Signing keys loaded", "signing_kid", signingKeyID, "keys", len(keySet.VerificationKeys()))
+	return keySet, nil
+}
+
+// loadSigningKey loads the private key from keyFile or keyContent. An encrypted
+// key is decrypted with the passphrase in JWT_SIGNATURE_KEY_PASSPHRASE_FILE or
+// JWT_SIGNATURE_KEY_PASSPHRASE.
+func loadSigningKey(keyFile, keyContent string) (token.KeyPair, error) {
+	// The passphrase of an encrypted key is kept in a separate secret
+	passphrase, err := secretEnv("JWT_SIGNATURE_KEY_PASSPHRASE")
+	if err != nil {
+		return nil, err
+	}
+	var keyPair token.KeyPair
+	var format token.KeyFormat
+	if keyFile != "" {
+		if keyPair, format, err = token.ReadPrivateKeyFile(keyFile, []byte(passphrase)); err != nil {
+			return nil, fmt.Errorf("failed to load private key from JWT_SIGNATURE_KEY_FILE: %w", err)
+		}
+		slog.Info("Private key loaded successfully from file", "path", keyFile, "format", format)
+	} else {
+		slog.Warn("JWT_SIGNATURE_KEY exposes the private key in the process environment, prefer JWT_SIGNATURE_KEY_FILE")
+		if keyPair, format, err = token.ParsePEMPrivateKey([]byte(keyContent), []byte(passphrase)); err != nil {
+			return nil, fmt.Errorf("failed to parse private key from JWT_SIGNATURE_KEY: %w", err)
+		}
+		slog.Info("Private key loaded successfully from environment variable", "format", format)
+	}
+	if passphrase != "" && format != token.KeyFormatEncryptedPKCS8 {
+		slog.Warn("A signing key passphrase is set but the signing key is not encrypted", "format", format)
+	}
+	return keyPair, nil
+}
+
+// openHSMKey opens the signing key on the PKCS#11 token configured by the
+// PKCS11_* environment variables; the private key never leaves the token.
+func openHSMKey(module string) (token.KeyPair, error) {
+	pin, err := secretEnv("PKCS11_PIN")
+	if err != nil {
+		return nil, err
+	}
+	keyID, err := hex.DecodeString(os.Getenv("PKCS11_KEY_ID"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid PKCS11_KEY_ID: %w", err)
+	}
+	cfg := hsm.Config{
+		Module:     module,
+		TokenLabel: os.Getenv("PKCS11_TOKEN_LABEL"),
+		PIN:        pin,
+		KeyLabel:   os.Getenv("PKCS11_KEY_LABEL"),
+		KeyID:      keyID,
+	}
+	keyPair, err := hsm.Open(cfg)
+	if errors.Is(err, hsm.ErrUnavailable) {
+		return nil, fmt.Errorf("PKCS11_MODULE is set but this binary was built without cgo, rebuild it with CGO_ENABLED=1: %w", err)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open PKCS#11 signing key: %w", err)
+	}
+	slog.Info("Signing key opened on PKCS#11 token", "module", module, "token", cfg.TokenLabel, "key_label", cfg.KeyLabel)
+	return keyPair, nil
+}
+
+// newKeyDirectory loads the signing keys from keyDir and watches it for rotations
+// according to the JWT_KEY_* environment variables. Replaced keys stay published
+// for ACCESS_TOKEN_MAX_TTL unless JWT_KEY_RETIRE_AFTER sets a longer time, so
+// no token outlives the key that signed it.
+func newKeyDirectory(keyDir string, lifetime auth.TokenLifetime) (*token.KeyDirectory, error) {
+	if lifetime.Max == 0 {
+		return nil, errors.New("JWT_SIGNATURE_KEY_DIR requires ACCESS_TOKEN_MAX_TTL to bound the lifetime of tokens signed by replaced keys")
+	}
+
+	var policy token.RotationPolicy
+	var err error
+	if policy.PublishGrace, err = durationEnv("JWT_KEY_PUBLISH_GRACE", time.Hour); err != nil {
+		return nil, err
+	}
+	if policy.RetireAfter, err = durationEnv("JWT_KEY_RETIRE_AFTER", lifetime.Max); err != nil {
+		return nil, err
+	}
+	if policy.RetireAfter < lifetime.Max {
+		return nil, fmt.Errorf("JWT_KEY_RETIRE_AFTER %s is shorter than ACCESS_TOKEN_MAX_TTL %s, tokens would outlive the key that signed them", policy.RetireAfter, lifetime.Max)
+	}
+	pollInterval, err := durationEnv("JWT_KEY_DIR_POLL_INTERVAL", time.Minute)
+	if err != nil || pollInterval <= 0 {
+		return nil, fmt.Errorf("invalid JWT_KEY_DIR_POLL_INTERVAL %q", os.Getenv("JWT_KEY_DIR_POLL_INTERVAL"))
+	}
+
+	keyDirectory, err := token.NewKeyDirectory(keyDir, policy, os.Getenv("JWT_RSA_ALGORITHM"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load key directory %s: %w", keyDir, err)
+	}
+	go keyDirectory.Watch(context.Background(), pollInterval)
+	return keyDirectory, nil
+}
+
+// newClientStore creates the client store selected by the environment: a SQL
+// database if CLIENTS_DB_DRIVER is set, a clients file if CLIENTS_FILE is set,
+// and the default test users otherwise.
+func newClientStore(hasher userpool.Hasher) (userpool.ClientStore, error) {
+	driver := os.Getenv("CLIENTS_DB_DRIVER")
+	clientsFile := os.Getenv("CLIENTS_FILE")
+	if driver != "" && clientsFile != "" {
+		return nil, errors.New("CLIENTS_DB_DRIVER and CLIENTS_FILE are mutually exclusive")
+	}
+
+	if driver != "" {
+		cfg, err := sqlConfig(driver)
+		if err != nil {
+			return nil, err
+		}
+		store, err := userpool.OpenSQLStore(context.Background(), cfg, hasher)
+		if err != nil {
+			return nil, err
+		}
+		slog.Info("Using SQL client store", "driver", driver)
+		return store, nil
+	}
+
+	if clientsFile == "" {
+		slog.Warn("Neither CLIENTS_DB_DRIVER nor CLIENTS_FILE is set, using default test users")
+		return userpool.Default(hasher), nil
+	}
+
+	pollInterval, err := durationEnv("CLIENTS_FILE_POLL_INTERVAL", 30*time.Second)
+	if err != nil || pollInterval <= 0 {
+		return nil, fmt.Errorf("invalid CLIENTS_FILE_POLL_INTERVAL %q", os.Getenv("CLIENTS_FILE_POLL_INTERVAL"))
+	}
+
+	fileStore, err := userpool.NewFileStore(clientsFile, hasher)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load clients file %s: %w", clientsFile, err)
+	}
+	go fileStore.Watch(context.Background(), pollInterval)
+	return fileStore, nil
+}
+
+// sqlConfig reads the database connection and pool settings from the CLIENTS_DB_* environment variables.
+func sqlConfig(driver string) (userpool.SQLConfig, error) {
+	cfg := userpool.SQLConfig{Driver: driver, DSN: os.Getenv("CLIENTS_DB_DSN")}
+	if cfg.DSN == "" && driver == userpool.DriverSQLite {
+		cfg.DSN = "clients.db"
+	}
+	if cfg.DSN == "" {
+		return cfg, errors.New("CLIENTS_DB_DSN is required")
+	}
+
+	var err error
+	if cfg.MaxOpenConns, err = intEnv("CLIENTS_DB_MAX_OPEN_CONNS", 10); err != nil {
+		return cfg, err
+	}
+	if cfg.MaxIdleConns, err = intEnv("CLIENTS_DB_MAX_IDLE_CONNS", 5); err != nil {
+		return cfg, err
+	}
+	if cfg.ConnMaxLifetime, err = durationEnv("CLIENTS_DB_CONN_MAX_LIFETIME", 30*time.Minute); err != nil {
+		return cfg, err
+	}
+	if cfg.ConnMaxIdleTime, err = durationEnv("CLIENTS_DB_CONN_MAX_IDLE_TIME", 5*time.Minute); err != nil {
+		return cfg, err
+	}
+	return cfg, nil
+}
+
+// intEnv returns the non-negative integer in the environment variable name, or fallback if it is unset.
+func intEnv(name string, fallback int) (int, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid %s %q", name, value)
+	}
+	return n, nil
+}
+
+// durationEnv returns the non-negative duration in the environment variable name, or fallback if it is unset.
+func durationEnv(name string, fallback time.Duration) (time.Duration, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid %s %q", name, value)
+	}
+	return d, nil
+}
+
+// secretEnv returns the secret in the environment variable name, or the content
+// of the file named by name_FILE without its trailing newline.
+func secretEnv(name string) (string, error) {
+	value := os.Getenv(name)
+	file := os.Getenv(name + "_FILE")
+	if file == "" {
+		return value, nil
+	}
+	if value != "" {
+		return "", fmt.Errorf("%s and %s_FILE are mutually exclusive", name, name)
+	}
+	content, err := os.ReadFile(filepath.Clean(file))
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s_FILE: %w", name, err)
+	}
+	return strings.TrimRight(string(content), "\r\n"), nil
 }
 
 func main() {
@@ -51,12 +446,22 @@ func main() {
 	server := &http.Server{
 		Addr:              ":8080",
 		ReadHeaderTimeout: 10 * time.Second,
+		TLSConfig:         tlsConfig,
+	}
+	slog.Info("Starting server", "port", 8080, "tls", tlsConfig != nil)
+	http.HandleFunc("/token", auth.HandleToken(keySource, userPool, assertionVerifier, clientCAs, tokenLifetime))
+	http.HandleFunc("/.well-known/jwks.json", auth.HandleJWKS(keySource))
+	http.HandleFunc("/revoke", auth.HandleRevoke(keySource, userPool, assertionVerifier, clientCAs, revocations))
+	introspectionCallers := auth.NewIntrospectionAuthenticator(keySource, userPool, assertionVerifier, clientCAs, revocations)
+	http.HandleFunc("/introspect", token.HandleIntrospection(keySource, revocations, introspectionCallers))
+	var err error
+	if tlsConfig != nil {
+		// The certificate is served by the TLS config's GetCertificate
+		err = server.ListenAndServeTLS("", "")
+	} else {
+		err = server.ListenAndServe()
 	}
-	slog.Info("Starting server", "port", 8080)
-	http.HandleFunc("/token", auth.HandleToken(keyPair, userPool))
-	http.HandleFunc("/.well-known/jwks.json", auth.HandleJWKS(keyPair))
-	http.HandleFunc("/introspect", token.HandleIntrospection(keyPair))
-	if err := server.ListenAndServe(); err != nil {
+	if err != nil {
 		slog.Error("Failed to start server", "error", err)
 	}
 }
@@ -1,2 +1,2 @@
 #! /usr/bin/env bash
-JWT_SIGNATURE_KEY=$(cat ../keytool/keys/cddcbf9fe23b31ad.private.pem) go run main.go
+JWT_SIGNATURE_KEY=$(cat ../keytool/keys/Hxzq2KXstQ3f3GgCe6xSweHOzSNuXdL6EqCLdZ4v7LU.private.pem) go run main.go
@@ -5,11 +5,15 @@ RED='\033[0;31m'
 GREEN='\033[0;32m'
 NC='\033[0m' # No Color
 
+# Callers authenticate with client credentials (RFC 7662 Section 2.1)
+auth_header="Authorization: Basic $(echo -n 'sho:test123' | base64)"
+
 echo "Testing /introspect endpoint..."
 
 # Test 1: Missing token
 echo -e "\n${GREEN}Test 1: Missing token${NC}"
-response=$(curl -s -w "\n%{http_code}" -X POST http://localhost:8080/introspect)
+response=$(curl -s -w "\n%{http_code}" -X POST http://localhost:8080/introspect \
+  -H "$auth_header")
 status_code=$(echo "$response" | tail -n1)
 body=$(echo "$response" | sed '$d')
 if [ "$status_code" != "400" ]; then
@@ -36,6 +40,7 @@ fi
 # Test 3: Invalid token
 echo -e "\n\n${GREEN}Test 3: Invalid token${NC}"
 response=$(curl -s -w "\n%{http_code}" -X POST http://localhost:8080/introspect \
+  -H "$auth_header" \
   -H "Content-Type: application/x-www-form-urlencoded" \
   -d "token=invalid.token.here")
 status_code=$(echo "$response" | tail -n1)
@@ -56,11 +61,13 @@ echo -e "\n\n${GREEN}Test 4: Valid token${NC}"
 # First get a valid token
 token_response=$(curl -s -X POST http://localhost:8080/token \
   -H "Authorization: Basic $(echo -n 'sho:test123' | base64)" \
-  -H "Content-Type: application/json")
+  -H "Content-Type: application/x-www-form-urlencoded" \
+  -d "grant_type=client_credentials")
 access_token=$(echo "$token_response" | jq -r '.access_token')
 
 # Now test introspection with the valid token
 response=$(curl -s -w "\n%{http_code}" -X POST http://localhost:8080/introspect \
+  -H "$auth_header" \
   -H "Content-Type: application/x-www-form-urlencoded" \
   -d "token=$access_token")
 status_code=$(echo "$response" | tail -n1)
@@ -83,4 +90,19 @@ else
     done
 fi
 
+# Test 5: Unauthenticated caller
+echo -e "\n\n${GREEN}Test 5: Unauthenticated caller${NC}"
+response=$(curl -s -w "\n%{http_code}" -X POST http://localhost:8080/introspect \
+  -H "Content-Type: application/x-www-form-urlencoded" \
+  -d "token=$access_token")
+status_code=$(echo "$response" | tail -n1)
+body=$(echo "$response" | sed '$d')
+if [ "$status_code" != "401" ]; then
+    echo -e "${RED}Unexpected status code: $status_code${NC}"
+    echo -e "${RED}Response: $body${NC}"
+else
+    echo "Response:"
+    echo "$body"
+fi
+
 echo -e "\n\nTests completed!"
@@ -50,7 +50,8 @@ fi
 echo -e "\n\n${GREEN}Test 4: Valid request${NC}"
 response=$(curl -s -w "\n%{http_code}" -X POST http://localhost:8080/token \
   -H "Authorization: Basic $(echo -n 'sho:test123' | base64)" \
-  -H "Content-Type: application/json")
+  -H "Content-Type: application/x-www-form-urlencoded" \
+  -d "grant_type=client_credentials")
 status_code=$(echo "$response" | tail -n1)
 body=$(echo "$response" | sed '$d')
 if [ "$status_code" != "200" ]; then
@@ -61,4 +62,52 @@ else
     echo "$body" | jq '.'
 fi
 
+# Test 5: Unsupported grant type
+echo -e "\n\n${GREEN}Test 5: Unsupported grant type${NC}"
+response=$(curl -s -w "\n%{http_code}" -X POST http://localhost:8080/token \
+  -H "Authorization: Basic $(echo -n 'sho:test123' | base64)" \
+  -H "Content-Type: application/x-www-form-urlencoded" \
+  -d "grant_type=password")
+status_code=$(echo "$response" | tail -n1)
+body=$(echo "$response" | sed '$d')
+if [ "$status_code" != "400" ]; then
+    echo -e "${RED}Unexpected status code: $status_code${NC}"
+    echo -e "${RED}Response: $body${NC}"
+else
+    echo "Response:"
+    echo "$body" | jq '.'
+fi
+
+# Test 6: Valid request with scope
+echo -e "\n\n${GREEN}Test 6: Valid request with scope${NC}"
+response=$(curl -s -w "\n%{http_code}" -X POST http://localhost:8080/token \
+  -H "Authorization: Basic $(echo -n 'sho:test123' | base64)" \
+  -H "Content-Type: application/x-www-form-urlencoded" \
+  -d "grant_type=client_credentials&scope=read%20write")
+status_code=$(echo "$response" | tail -n1)
+body=$(echo "$response" | sed '$d')
+if [ "$status_code" != "200" ]; then
+    echo -e "${RED}Unexpected status code: $status_code${NC}"
+    echo -e "${RED}Response: $body${NC}"
+else
+    echo "Response:"
+    echo "$body" | jq '.'
+fi
+
+# Test 7: Credentials in both the header and the body
+echo -e "\n\n${GREEN}Test 7: Credentials in both the header and the body${NC}"
+response=$(curl -s -w "\n%{http_code}" -X POST http://localhost:8080/token \
+  -H "Authorization: Basic $(echo -n 'sho:test123' | base64)" \
+  -H "Content-Type: application/x-www-form-urlencoded" \
+  -d "grant_type=client_credentials&client_id=sho&client_secret=test123")
+status_code=$(echo "$response" | tail -n1)
+body=$(echo "$response" | sed '$d')
+if [ "$status_code" != "400" ]; then
+    echo -e "${RED}Unexpected status code: $status_code${NC}"
+    echo -e "${RED}Response: $body${NC}"
+else
+    echo "Response:"
+    echo "$body" | jq '.'
+fi
+
 echo -e "\n\nTests completed!"